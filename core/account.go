@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"pixie/auth"
+	"pixie/mail"
+	"pixie/user"
+)
+
+// ProfileUpdateRequest represents the changes users can make to their own profile
+type ProfileUpdateRequest struct {
+	FullName *string `json:"full_name"`
+}
+
+// ChangePasswordRequest represents a user changing their own password
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// EmailChangeRequest represents a user asking to change their email address
+type EmailChangeRequest struct {
+	Email string `json:"email"`
+}
+
+// LinkTokenRequest represents the token of a link sent by email, with the new
+// password for a password reset
+type LinkTokenRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password,omitempty"`
+}
+
+// linkKey returns the key that signs password reset and email verification
+// links. Without LINK_SIGNING_KEY, links only work on this replica until it
+// restarts.
+func linkKey() []byte {
+	if key := os.Getenv("LINK_SIGNING_KEY"); key != "" {
+		return []byte(key)
+	}
+
+	log.Println("LINK_SIGNING_KEY is not set; password reset and email links will stop working when core restarts")
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatalf("Failed to generate link signing key: %v", err)
+	}
+	return key
+}
+
+// accountLink returns the link to the UI that hands a token to it in the
+// fragment, which is never sent to a server
+func (app *App) accountLink(name, token string) string {
+	return strings.TrimRight(app.Config.AppURL, "/") + "/#" + url.Values{name: {token}}.Encode()
+}
+
+// getMeHandler handles getting the profile of the current user
+func (app *App) getMeHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value("user_id").(string)
+
+	u, err := app.UserMgr.GetUser(r.Context(), userID)
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(u)
+}
+
+// updateMeHandler handles users updating their own profile. The email address
+// is changed through a verification link instead.
+func (app *App) updateMeHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value("user_id").(string)
+
+	var req ProfileUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	u, err := app.UserMgr.UpdateUser(r.Context(), userID, user.UpdateUserRequest{FullName: req.FullName})
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(u)
+}
+
+// changePasswordHandler handles users changing their own password. Every other
+// session of the user is ended, so whoever knew the old password is logged out.
+func (app *App) changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value("user_id").(string)
+	customClaims, _ := r.Context().Value("custom_claims").(map[string]interface{})
+	currentID, _ := customClaims[auth.SessionClaim].(string)
+
+	var req ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.CurrentPassword == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Guessing the current password is throttled like logging in
+	u, err := app.UserMgr.GetUser(r.Context(), userID)
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	if !app.allowLogin(w, r, loginMethodPassword, u.Username) {
+		return
+	}
+
+	if _, err := app.UserMgr.ChangePassword(r.Context(), userID, req.CurrentPassword, req.NewPassword); err != nil {
+		var locked *user.LockedError
+		if errors.Is(err, user.ErrInvalidCredentials) || errors.As(err, &locked) {
+			app.LoginThrottle.Failure(clientIP(r), u.Username)
+		}
+		if locked != nil {
+			tooManyLogins(w, time.Until(locked.Until))
+			return
+		}
+		writeAccountError(w, err)
+		return
+	}
+
+	n, err := app.Auth.RevokeOtherSessions(r.Context(), userID, currentID)
+	if err != nil {
+		log.Printf("Failed to revoke sessions of user %s: %v", userID, err)
+	}
+	log.Printf("User %s changed their password, %d other sessions ended", u.Username, n)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":          "Password changed",
+		"sessions_revoked": n,
+	})
+}
+
+// requestEmailChangeHandler handles users asking to change their email address.
+// The address changes once the link sent to it is opened.
+func (app *App) requestEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value("user_id").(string)
+
+	var req EmailChangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	email, expiresAt, err := app.sendEmailVerification(r.Context(), userID, req.Email)
+	if errors.Is(err, errMailFailed) {
+		http.Error(w, "Failed to send verification email", http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":    "Verification email sent",
+		"email":      email,
+		"expires_at": expiresAt,
+	})
+}
+
+// errMailFailed is returned by sendEmailVerification when the email could not
+// be sent
+var errMailFailed = errors.New("failed to send email")
+
+// sendEmailVerification emails a user a link that sets their address to email
+// once opened, and returns the address in canonical form
+func (app *App) sendEmailVerification(ctx context.Context, userID, email string) (string, time.Time, error) {
+	token, email, expiresAt, err := app.UserMgr.EmailChangeToken(ctx, userID, email)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	err = app.Mail.Send(ctx, mail.Message{
+		To:      email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Open this link to use this address for your Pixie account:\n\n%s\n\nThe link expires at %s. If you did not ask for this, ignore this email.\n",
+			app.accountLink("verify_email", token), expiresAt.UTC().Format(time.RFC1123)),
+	})
+	if err != nil {
+		log.Printf("Failed to send verification email: %v", err)
+		return "", time.Time{}, errMailFailed
+	}
+	return email, expiresAt, nil
+}
+
+// verifyEmailHandler handles the link that confirms a new email address. It
+// needs no login, since the link may be opened on another device.
+func (app *App) verifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var req LinkTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	u, err := app.UserMgr.VerifyEmail(r.Context(), req.Token)
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	log.Printf("User %s verified their new email address", u.Username)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Email address changed",
+		"email":   u.Email,
+	})
+}
+
+// createPasswordResetHandler handles admins sending a user a link to choose a
+// new password. The link is also returned, for users without an email address.
+func (app *App) createPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	u, err := app.UserMgr.GetUser(r.Context(), id)
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	token, expiresAt, err := app.UserMgr.PasswordResetToken(r.Context(), u.ID)
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	link := app.accountLink("reset_password", token)
+
+	emailed := false
+	if u.Email != "" {
+		err := app.Mail.Send(r.Context(), mail.Message{
+			To:      u.Email,
+			Subject: "Reset your Pixie password",
+			Body: fmt.Sprintf("An administrator asked for your Pixie password to be reset. Open this link to choose a new password for %s:\n\n%s\n\nThe link works once and expires at %s.\n",
+				u.Username, link, expiresAt.UTC().Format(time.RFC1123)),
+		})
+		if err != nil {
+			log.Printf("Failed to send password reset email: %v", err)
+		} else {
+			emailed = true
+		}
+	}
+	log.Printf("Password reset link created for user %s (emailed: %t)", u.Username, emailed)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"reset_url":  link,
+		"expires_at": expiresAt,
+		"emailed":    emailed,
+	})
+}
+
+// resetPasswordHandler handles choosing a new password with a reset link. The
+// user is logged out everywhere.
+func (app *App) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var req LinkTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	u, err := app.UserMgr.ResetPassword(r.Context(), req.Token, req.NewPassword)
+	if err != nil {
+		writeAccountError(w, err)
+		return
+	}
+	app.revokeUserSessions(r, u.ID)
+	log.Printf("User %s reset their password", u.Username)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  "Password changed",
+		"username": u.Username,
+	})
+}
+
+// writeAccountError responds to a failed change of a user's own account
+func writeAccountError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, user.ErrInvalidCredentials):
+		// Not 401, which would log the client out
+		http.Error(w, "Current password is incorrect", http.StatusForbidden)
+	case errors.Is(err, user.ErrWeakPassword), errors.Is(err, user.ErrPasswordReused), errors.Is(err, user.ErrInvalidEmail):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, user.ErrEmailTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, user.ErrInvalidLink):
+		http.Error(w, "This link is invalid or has expired", http.StatusBadRequest)
+	case errors.Is(err, user.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	default:
+		log.Printf("Account request failed: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"pixie/plugin/loader"
+	"pixie/plugin/manifest"
+)
+
+// listPluginsHandler handles listing all plugins
+func (app *App) listPluginsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"plugins": loader.List(),
+	})
+}
+
+// getPluginHandler handles getting a single plugin
+func (app *App) getPluginHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	info, err := loader.Get(name)
+	if err != nil {
+		writePluginError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// enablePluginHandler handles enabling a plugin
+func (app *App) enablePluginHandler(w http.ResponseWriter, r *http.Request) {
+	app.pluginAction(w, r, "enable", loader.Enable)
+}
+
+// disablePluginHandler handles disabling a plugin
+func (app *App) disablePluginHandler(w http.ResponseWriter, r *http.Request) {
+	app.pluginAction(w, r, "disable", loader.Disable)
+}
+
+// reloadPluginHandler handles reloading a plugin
+func (app *App) reloadPluginHandler(w http.ResponseWriter, r *http.Request) {
+	app.pluginAction(w, r, "reload", loader.Reload)
+}
+
+// pluginAction runs a plugin management action and returns the plugin's new state
+func (app *App) pluginAction(w http.ResponseWriter, r *http.Request, action string, fn func(ctx context.Context, name string) error) {
+	name := mux.Vars(r)["name"]
+
+	if err := fn(r.Context(), name); err != nil {
+		log.Printf("Failed to %s plugin %s: %v", action, name, err)
+		writePluginError(w, err)
+		return
+	}
+
+	info, err := loader.Get(name)
+	if err != nil {
+		writePluginError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// getPluginSettingsHandler handles getting a plugin's settings and their schema
+func (app *App) getPluginSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	schema, settings, err := loader.Settings(name)
+	if err != nil {
+		writePluginError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"schema":   schema,
+		"settings": settings,
+	})
+}
+
+// updatePluginSettingsHandler handles replacing a plugin's settings
+func (app *App) updatePluginSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	var settings map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := loader.Configure(r.Context(), name, settings); err != nil {
+		log.Printf("Failed to configure plugin %s: %v", name, err)
+		writePluginError(w, err)
+		return
+	}
+
+	app.getPluginSettingsHandler(w, r)
+}
+
+// writePluginError maps plugin management errors to HTTP responses
+func writePluginError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, loader.ErrPluginNotFound):
+		http.Error(w, "Plugin not found", http.StatusNotFound)
+	case errors.Is(err, loader.ErrInvalidSettings), errors.Is(err, manifest.ErrInvalidManifest):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Plugin operation failed", http.StatusInternalServerError)
+	}
+}
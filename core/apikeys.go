@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"pixie/auth"
+	"pixie/db"
+)
+
+// maxAPIKeyNameLength limits the name of an API key
+const maxAPIKeyNameLength = 100
+
+// CreateAPIKeyRequest represents a request to create an API key
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// createAPIKeyHandler handles creating an API key for the current user
+func (app *App) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value("user_id").(string)
+
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > maxAPIKeyNameLength {
+		http.Error(w, "Name is required and must be at most 100 characters", http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+		http.Error(w, "Expiry must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	// Keys are made by logged-in users only, so a leaked key cannot make one
+	// that survives its revocation
+	customClaims, _ := r.Context().Value("custom_claims").(map[string]interface{})
+	if _, ok := customClaims[auth.APIKeyClaim]; ok {
+		http.Error(w, "Forbidden: API keys cannot create API keys", http.StatusForbidden)
+		return
+	}
+
+	// A key may not do more than the token that creates it
+	for _, scope := range auth.ExpandScopes(req.Scopes) {
+		if auth.IsScope(scope) && !auth.HasScope(customClaims, scope) {
+			http.Error(w, "Forbidden: the "+scope+" scope cannot be granted", http.StatusForbidden)
+			return
+		}
+	}
+
+	key, secret, err := app.Auth.CreateAPIKey(r.Context(), userID, req.Name, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidScope) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			log.Printf("Failed to create API key: %v", err)
+			http.Error(w, "Failed to create API key", http.StatusInternalServerError)
+		}
+		return
+	}
+	log.Printf("User %s created API key %s (%s)", userID, key.ID, key.Prefix)
+
+	// The secret is only ever shown in this response
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"key":    key,
+		"secret": secret,
+	})
+}
+
+// listAPIKeysHandler handles listing the API keys of the current user
+func (app *App) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value("user_id").(string)
+
+	keys, err := app.Auth.ListAPIKeys(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to list API keys: %v", err)
+		http.Error(w, "Failed to list API keys", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": keys,
+	})
+}
+
+// revokeAPIKeyHandler handles revoking an API key of the current user
+func (app *App) revokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value("user_id").(string)
+	id := mux.Vars(r)["id"]
+
+	if err := app.Auth.RevokeAPIKey(r.Context(), userID, id); err != nil {
+		if errors.Is(err, db.ErrAPIKeyNotFound) {
+			http.Error(w, "API key not found", http.StatusNotFound)
+		} else {
+			log.Printf("Failed to revoke API key: %v", err)
+			http.Error(w, "Failed to revoke API key", http.StatusInternalServerError)
+		}
+		return
+	}
+	log.Printf("User %s revoked API key %s", userID, id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"pixie/db"
+)
+
+var (
+	// ErrInvalidAPIKey is returned when an API key is unknown, expired or its
+	// user is inactive
+	ErrInvalidAPIKey = errors.New("invalid API key")
+
+	// ErrInvalidScope is returned when an API key is created without scopes or
+	// with an unknown one
+	ErrInvalidScope = errors.New("invalid scope")
+
+	// ErrNoAPIKeyStore is returned when API keys are used without a store
+	ErrNoAPIKeyStore = errors.New("no API key store configured")
+)
+
+// APIKeyPrefix starts every API key, so they are recognized without a lookup
+const APIKeyPrefix = "pxk_"
+
+// APIKeyClaim holds the ID of the API key a request was authenticated with
+const APIKeyClaim = "api_key"
+
+// apiKeyTouchInterval is how often the last use of an API key is recorded
+const apiKeyTouchInterval = time.Minute
+
+// apiKeyPrefixLength is how much of a key is kept to tell keys apart in lists
+const apiKeyPrefixLength = len(APIKeyPrefix) + 8
+
+// APIKeyStore persists API keys by the hash of their secret
+type APIKeyStore interface {
+	CreateAPIKey(ctx context.Context, k *db.APIKey) error
+	GetAPIKeyByHash(ctx context.Context, hash string) (*db.APIKey, error)
+	ListAPIKeys(ctx context.Context, userID string) ([]db.APIKey, error)
+	DeleteAPIKey(ctx context.Context, userID, id string) error
+	TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error
+}
+
+// SetAPIKeyStore configures where API keys are persisted. Without one, bearer
+// tokens are never treated as API keys.
+func (s *Service) SetAPIKeyStore(store APIKeyStore) {
+	s.apiKeys = store
+}
+
+// CreateAPIKey creates an API key for a user and returns it with its secret,
+// which cannot be retrieved again. A nil expiresAt creates a key that does not
+// expire.
+func (s *Service) CreateAPIKey(ctx context.Context, userID, name string, scopes []string, expiresAt *time.Time) (*db.APIKey, string, error) {
+	if s.apiKeys == nil {
+		return nil, "", ErrNoAPIKeyStore
+	}
+	if len(scopes) == 0 {
+		return nil, "", fmt.Errorf("%w: at least one scope is required", ErrInvalidScope)
+	}
+	for _, scope := range scopes {
+		if !IsScope(scope) {
+			return nil, "", fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+		}
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	secret := APIKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	key := &db.APIKey{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Name:      name,
+		Prefix:    secret[:apiKeyPrefixLength],
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+		Hash:      hashAPIKey(secret),
+	}
+	if err := s.apiKeys.CreateAPIKey(ctx, key); err != nil {
+		return nil, "", err
+	}
+
+	return key, secret, nil
+}
+
+// ListAPIKeys returns the API keys of a user
+func (s *Service) ListAPIKeys(ctx context.Context, userID string) ([]db.APIKey, error) {
+	if s.apiKeys == nil {
+		return nil, ErrNoAPIKeyStore
+	}
+	return s.apiKeys.ListAPIKeys(ctx, userID)
+}
+
+// RevokeAPIKey deletes an API key of a user. It stops working immediately.
+func (s *Service) RevokeAPIKey(ctx context.Context, userID, id string) error {
+	if s.apiKeys == nil {
+		return ErrNoAPIKeyStore
+	}
+	return s.apiKeys.DeleteAPIKey(ctx, userID, id)
+}
+
+// validateAPIKey authenticates a request made with an API key. The claims carry
+// the current role of the key's user and the key's scopes, which are narrowed
+// to the role's permissions like those of any other token.
+func (s *Service) validateAPIKey(ctx context.Context, secret string) (string, map[string]interface{}, error) {
+	key, err := s.apiKeys.GetAPIKeyByHash(ctx, hashAPIKey(secret))
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	if key.ExpiresAt != nil && now.After(*key.ExpiresAt) {
+		return "", nil, fmt.Errorf("%w: expired", ErrInvalidAPIKey)
+	}
+	if !key.UserActive {
+		return "", nil, fmt.Errorf("%w: user is inactive", ErrInvalidAPIKey)
+	}
+
+	// A key posted to the revoke endpoint is rejected like any other token
+	revoked, err := s.revoked(s.revocationID(secret))
+	if err != nil {
+		return "", nil, err
+	}
+	if revoked {
+		return "", nil, ErrInvalidToken
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > apiKeyTouchInterval {
+		if err := s.apiKeys.TouchAPIKey(ctx, key.ID, now); err != nil {
+			log.Printf("Failed to record use of API key %s: %v", key.ID, err)
+		}
+	}
+
+	return key.UserID, map[string]interface{}{
+		"role":      key.Role,
+		ScopesClaim: key.Scopes,
+		APIKeyClaim: key.ID,
+	}, nil
+}
+
+// isAPIKey reports whether a bearer token looks like an API key
+func isAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// hashAPIKey hashes an API key for storage. Keys are random, so a plain SHA-256
+// is enough.
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
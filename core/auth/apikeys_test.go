@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"pixie/db"
+)
+
+// memAPIKeys is an in-memory APIKeyStore. Users are active with the role in
+// roles unless listed in inactive.
+type memAPIKeys struct {
+	mu       sync.Mutex
+	keys     map[string]*db.APIKey
+	roles    map[string]string
+	inactive map[string]bool
+	touches  int
+}
+
+func newMemAPIKeys() *memAPIKeys {
+	return &memAPIKeys{
+		keys:     make(map[string]*db.APIKey),
+		roles:    make(map[string]string),
+		inactive: make(map[string]bool),
+	}
+}
+
+func (m *memAPIKeys) CreateAPIKey(ctx context.Context, k *db.APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := *k
+	m.keys[k.ID] = &c
+	return nil
+}
+
+func (m *memAPIKeys) GetAPIKeyByHash(ctx context.Context, hash string) (*db.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, k := range m.keys {
+		if k.Hash == hash {
+			c := *k
+			c.Role = m.roles[k.UserID]
+			c.UserActive = !m.inactive[k.UserID]
+			return &c, nil
+		}
+	}
+	return nil, db.ErrAPIKeyNotFound
+}
+
+func (m *memAPIKeys) ListAPIKeys(ctx context.Context, userID string) ([]db.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := []db.APIKey{}
+	for _, k := range m.keys {
+		if k.UserID == userID {
+			keys = append(keys, *k)
+		}
+	}
+	return keys, nil
+}
+
+func (m *memAPIKeys) DeleteAPIKey(ctx context.Context, userID, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k, ok := m.keys[id]
+	if !ok || k.UserID != userID {
+		return db.ErrAPIKeyNotFound
+	}
+	delete(m.keys, id)
+	return nil
+}
+
+func (m *memAPIKeys) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if k, ok := m.keys[id]; ok {
+		k.LastUsedAt = &usedAt
+		m.touches++
+	}
+	return nil
+}
+
+func newAPIKeyService(t *testing.T, delegation string) (*Service, *memAPIKeys, *fakeDelegate) {
+	s, d := newTestService(t, delegation)
+	store := newMemAPIKeys()
+	s.SetAPIKeyStore(store)
+	return s, store, d
+}
+
+func TestAPIKeyAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	s, store, _ := newAPIKeyService(t, DelegationOff)
+	store.roles["user-1"] = "admin"
+
+	key, secret, err := s.CreateAPIKey(ctx, "user-1", "backup", []string{ScopeRead, ScopeUpload}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(secret, APIKeyPrefix) || !strings.HasPrefix(secret, key.Prefix) {
+		t.Errorf("unexpected secret %q for prefix %q", secret, key.Prefix)
+	}
+	if store.keys[key.ID].Hash == secret || strings.Contains(store.keys[key.ID].Hash, secret[len(APIKeyPrefix):]) {
+		t.Error("API key was stored in plain text")
+	}
+
+	userID, claims, err := s.Authenticate(ctx, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID != "user-1" || claims["role"] != "admin" || claims[APIKeyClaim] != key.ID {
+		t.Errorf("unexpected user %q and claims %v", userID, claims)
+	}
+	granted, err := s.grantScopes(ctx, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !HasScope(granted, ScopePhotosWrite) || HasScope(granted, ScopePhotosDelete) {
+		t.Errorf("expected the key's scopes, got %v", granted[ScopesClaim])
+	}
+
+	// The last use is recorded, but not on every request
+	if _, _, err := s.Authenticate(ctx, secret); err != nil {
+		t.Fatal(err)
+	}
+	if store.touches != 1 || store.keys[key.ID].LastUsedAt == nil {
+		t.Errorf("expected one recorded use, got %d", store.touches)
+	}
+}
+
+func TestAPIKeyRejected(t *testing.T) {
+	ctx := context.Background()
+	s, store, _ := newAPIKeyService(t, DelegationOff)
+
+	// Expired keys
+	past := time.Now().Add(-time.Minute)
+	expired, expiredSecret, err := s.CreateAPIKey(ctx, "user-1", "old", []string{ScopeRead}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.keys[expired.ID].ExpiresAt = &past
+	if _, _, err := s.Authenticate(ctx, expiredSecret); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("expected an expired key to fail, got %v", err)
+	}
+
+	// Keys of inactive users
+	_, inactiveSecret, err := s.CreateAPIKey(ctx, "user-2", "sync", []string{ScopeRead}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.inactive["user-2"] = true
+	if _, _, err := s.Authenticate(ctx, inactiveSecret); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("expected the key of an inactive user to fail, got %v", err)
+	}
+
+	// Revoked keys, only by their own user
+	key, secret, err := s.CreateAPIKey(ctx, "user-3", "script", []string{ScopeAdmin}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RevokeAPIKey(ctx, "user-1", key.ID); !errors.Is(err, db.ErrAPIKeyNotFound) {
+		t.Errorf("expected another user's key not to be found, got %v", err)
+	}
+	if err := s.RevokeAPIKey(ctx, "user-3", key.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Authenticate(ctx, secret); err == nil {
+		t.Error("expected a revoked key to fail")
+	}
+
+	// Keys posted to the revoke endpoint
+	_, leaked, err := s.CreateAPIKey(ctx, "user-3", "leaked", []string{ScopeRead}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RevokeToken(ctx, leaked); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Authenticate(ctx, leaked); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected a revoked token to fail, got %v", err)
+	}
+}
+
+func TestCreateAPIKeyScopes(t *testing.T) {
+	ctx := context.Background()
+	s, _, _ := newAPIKeyService(t, DelegationOff)
+
+	for _, scopes := range [][]string{nil, {"write"}, {ScopeRead, "everything"}, {"photos:everything"}} {
+		if _, _, err := s.CreateAPIKey(ctx, "user-1", "key", scopes, nil); !errors.Is(err, ErrInvalidScope) {
+			t.Errorf("expected ErrInvalidScope for %v, got %v", scopes, err)
+		}
+	}
+}
+
+func TestUnknownAPIKeyDelegated(t *testing.T) {
+	ctx := context.Background()
+	s, _, d := newAPIKeyService(t, DelegationFallback)
+
+	// Keys core does not know may belong to an auth plugin
+	userID, _, err := s.Authenticate(ctx, "pxk_external")
+	if err != nil || userID != "external-user" {
+		t.Errorf("expected the delegate to accept the key, got %q: %v", userID, err)
+	}
+	if d.calls != 1 {
+		t.Errorf("expected one delegate call, got %d", d.calls)
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	tests := []struct {
+		claims map[string]interface{}
+		scope  string
+		want   bool
+	}{
+		{nil, ScopePhotosRead, false},
+		{map[string]interface{}{"role": "admin"}, ScopePhotosRead, false},
+		{map[string]interface{}{ScopesClaim: []string{ScopePhotosRead}}, ScopePhotosRead, true},
+		{map[string]interface{}{ScopesClaim: []string{ScopePhotosRead}}, ScopePhotosWrite, false},
+		{map[string]interface{}{ScopesClaim: []interface{}{ScopePhotosDelete}}, ScopePhotosDelete, true},
+		{map[string]interface{}{ScopesClaim: []interface{}{}}, ScopePhotosRead, false},
+	}
+	for _, tt := range tests {
+		if got := HasScope(tt.claims, tt.scope); got != tt.want {
+			t.Errorf("HasScope(%v, %s) = %v, want %v", tt.claims, tt.scope, got, tt.want)
+		}
+	}
+}
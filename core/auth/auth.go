@@ -2,18 +2,18 @@ package auth
 
 import (
 	"context"
-	"crypto/rsa"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/time/rate"
+	"github.com/google/uuid"
+
+	"pixie/db"
 )
 
 var (
@@ -26,15 +26,20 @@ var (
 	// ErrInvalidClaims is returned when the token claims are invalid
 	ErrInvalidClaims = errors.New("invalid claims")
 
-	// ErrRateLimitExceeded is returned when the rate limit is exceeded
-	ErrRateLimitExceeded = errors.New("rate limit exceeded")
+	// ErrInvalidDelegation is returned for an unknown delegation mode
+	ErrInvalidDelegation = errors.New("invalid auth delegation mode")
+)
+
+// Delegation modes decide when tokens are passed to a Delegate
+const (
+	// DelegationOff only accepts tokens signed by core
+	DelegationOff = "off"
 
-	// TokenBlacklist holds revoked tokens
-	tokenBlacklist = make(map[string]time.Time)
-	blacklistMutex sync.RWMutex
+	// DelegationFallback asks the delegate when local validation fails
+	DelegationFallback = "fallback"
 
-	// RateLimiter limits the number of authentication requests
-	rateLimiter = rate.NewLimiter(rate.Limit(10), 30) // 10 requests per second with burst of 30
+	// DelegationFirst asks the delegate before validating locally
+	DelegationFirst = "first"
 )
 
 // Config holds the authentication configuration
@@ -43,13 +48,50 @@ type Config struct {
 	JWTSecret         string
 	JWTPublicKeyFile  string
 	JWTPrivateKeyFile string
+
+	// JWTVerifyKeyFiles are public keys still accepted while tokens signed with
+	// them expire, such as the previous signing key during a rotation
+	JWTVerifyKeyFiles []string
+
+	// KeyReloadInterval is how often the key files are checked for changes
+	KeyReloadInterval time.Duration
+
 	TokenExpiration   time.Duration
+	RefreshExpiration time.Duration
+	Delegation        string
+
+	// RevocationCacheTTL is how long a token found not to be revoked is trusted
+	// before the revocation store is asked again
+	RevocationCacheTTL time.Duration
+}
+
+// Delegate validates tokens core did not issue, such as API keys or tokens of
+// an external identity provider
+type Delegate interface {
+	ValidateToken(ctx context.Context, token string) (string, map[string]interface{}, error)
+}
+
+// DelegateFunc adapts a function to a Delegate
+type DelegateFunc func(ctx context.Context, token string) (string, map[string]interface{}, error)
+
+// ValidateToken calls f
+func (f DelegateFunc) ValidateToken(ctx context.Context, token string) (string, map[string]interface{}, error) {
+	return f(ctx, token)
 }
 
 // Service provides authentication functionality
 type Service struct {
-	config Config
-	pubKey *rsa.PublicKey
+	config   Config
+	delegate Delegate
+	sessions SessionStore
+	apiKeys  APIKeyStore
+	roles    RoleStore
+
+	keysMu sync.RWMutex
+	keys   *keySet
+
+	revocations     RevocationStore
+	revocationCache *revocationCache
 }
 
 // Claims represents the JWT claims
@@ -61,27 +103,48 @@ type Claims struct {
 // NewService creates a new authentication service
 func NewService(config Config) (*Service, error) {
 	service := &Service{
-		config: config,
+		config:          config,
+		revocations:     newMemoryRevocations(),
+		revocationCache: newRevocationCache(revocationCacheSize),
 	}
 
-	// Load the public key if using RS256
-	if config.JWTAlgo == "RS256" && config.JWTPublicKeyFile != "" {
-		pubKeyBytes, err := os.ReadFile(config.JWTPublicKeyFile)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read public key file: %w", err)
-		}
+	switch config.Delegation {
+	case "":
+		service.config.Delegation = DelegationOff
+	case DelegationOff, DelegationFallback, DelegationFirst:
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrInvalidDelegation, config.Delegation)
+	}
 
-		pubKey, err := jwt.ParseRSAPublicKeyFromPEM(pubKeyBytes)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse public key: %w", err)
-		}
+	// Refresh tokens outlive access tokens by far
+	if service.config.RefreshExpiration == 0 {
+		service.config.RefreshExpiration = 30 * 24 * time.Hour
+	}
+	if service.config.RevocationCacheTTL == 0 {
+		service.config.RevocationCacheTTL = 10 * time.Second
+	}
 
-		service.pubKey = pubKey
-		log.Println("Successfully loaded RSA public key")
+	if service.config.KeyReloadInterval == 0 {
+		service.config.KeyReloadInterval = defaultKeyReloadInterval
 	}
 
-	// Start the token cleanup goroutine
-	go service.cleanupBlacklist()
+	// Load the signing and verification keys
+	keys, err := loadKeys(service.config)
+	if err != nil {
+		return nil, err
+	}
+	service.keys = keys
+	if keys.secret == nil {
+		log.Printf("Loaded %d token verification keys, signing with %s", len(keys.keys), config.JWTAlgo)
+	}
+
+	// Start the revocation cleanup goroutine
+	go service.cleanupRevocations()
+
+	// Pick up rotated keys
+	if keys.secret == nil {
+		go service.watchKeys()
+	}
 
 	return service, nil
 }
@@ -93,6 +156,7 @@ func (s *Service) GenerateToken(subject string, customClaims map[string]interfac
 
 	claims := Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
 			Subject:   subject,
 			IssuedAt:  jwt.NewNumericDate(now),
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
@@ -100,83 +164,35 @@ func (s *Service) GenerateToken(subject string, customClaims map[string]interfac
 		CustomClaims: customClaims,
 	}
 
-	var token *jwt.Token
-	switch s.config.JWTAlgo {
-	case "HS256":
-		token = jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-		tokenString, err := token.SignedString([]byte(s.config.JWTSecret))
+	keys := s.keySet()
+	if keys.secret != nil {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		tokenString, err := token.SignedString(keys.secret)
 		if err != nil {
 			return "", fmt.Errorf("failed to sign token with HS256: %w", err)
 		}
 		return tokenString, nil
-	case "RS256":
-		// For RS256, we need a private key
-		if s.config.JWTPrivateKeyFile == "" {
-			return "", errors.New("RS256 requires a private key file")
-		}
-		
-		// Load the private key
-		privateKeyBytes, err := os.ReadFile(s.config.JWTPrivateKeyFile)
-		if err != nil {
-			return "", fmt.Errorf("failed to read private key file: %w", err)
-		}
-		
-		privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(privateKeyBytes)
-		if err != nil {
-			return "", fmt.Errorf("failed to parse private key: %w", err)
-		}
-		
-		// Create the token
-		token = jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-		tokenString, err := token.SignedString(privateKey)
-		if err != nil {
-			return "", fmt.Errorf("failed to sign token with RS256: %w", err)
-		}
-		return tokenString, nil
-	default:
-		return "", fmt.Errorf("unsupported algorithm: %s", s.config.JWTAlgo)
 	}
-}
 
-// ValidateToken validates a JWT token
-func (s *Service) ValidateToken(tokenString string) (string, map[string]interface{}, error) {
-	// Check rate limit
-	if !rateLimiter.Allow() {
-		return "", nil, ErrRateLimitExceeded
+	// Replicas with only public keys can verify but not sign
+	if keys.signer == nil {
+		return "", fmt.Errorf("%s requires a private key file", s.config.JWTAlgo)
 	}
 
-	// Check if token is blacklisted
-	blacklistMutex.RLock()
-	if _, blacklisted := tokenBlacklist[tokenString]; blacklisted {
-		blacklistMutex.RUnlock()
-		return "", nil, ErrInvalidToken
+	// Name the key so verifiers can pick it from the key set
+	token := jwt.NewWithClaims(keys.signer.method, claims)
+	token.Header["kid"] = keys.signer.id
+	tokenString, err := token.SignedString(keys.private)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token with %s: %w", s.config.JWTAlgo, err)
 	}
-	blacklistMutex.RUnlock()
+	return tokenString, nil
+}
 
+// ValidateToken validates a JWT token
+func (s *Service) ValidateToken(tokenString string) (string, map[string]interface{}, error) {
 	// Parse the token
-	var token *jwt.Token
-	var err error
-
-	switch s.config.JWTAlgo {
-	case "HS256":
-		token, err = jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-			// Validate the alg is what we expect
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(s.config.JWTSecret), nil
-		})
-	case "RS256":
-		token, err = jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-			// Validate the alg is what we expect
-			if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return s.pubKey, nil
-		})
-	default:
-		return "", nil, fmt.Errorf("unsupported algorithm: %s", s.config.JWTAlgo)
-	}
+	token, err := s.parse(tokenString)
 
 	// Check for parsing errors
 	if err != nil {
@@ -208,51 +224,90 @@ func (s *Service) ValidateToken(tokenString string) (string, map[string]interfac
 		return "", nil, ErrInvalidClaims
 	}
 
+	// Reject revoked tokens and tokens of revoked sessions
+	id := claims.ID
+	if id == "" {
+		id = s.revocationID(tokenString)
+	}
+	sid, _ := claims.CustomClaims[SessionClaim].(string)
+	revoked, err := s.revoked(id, sid)
+	if err != nil {
+		return "", nil, err
+	}
+	if revoked {
+		return "", nil, ErrInvalidToken
+	}
+
 	// Return the subject and custom claims
 	return sub, claims.CustomClaims, nil
 }
 
-// RevokeToken adds a token to the blacklist
-func (s *Service) RevokeToken(tokenString string) {
-	blacklistMutex.Lock()
-	defer blacklistMutex.Unlock()
+// parse parses and verifies a token with the key named by its kid
+func (s *Service) parse(tokenString string, options ...jwt.ParserOption) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, &Claims{}, s.keyFunc, options...)
+}
 
-	// Parse the token to get the expiration time
-	token, _ := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(s.config.JWTSecret), nil
-	})
+// SetDelegate configures where tokens are validated that core did not sign. It is
+// only consulted if the delegation mode is not off.
+func (s *Service) SetDelegate(d Delegate) {
+	s.delegate = d
+}
 
-	var expirationTime time.Time
-	if token != nil {
-		if claims, ok := token.Claims.(*Claims); ok && claims.ExpiresAt != nil {
-			expirationTime = claims.ExpiresAt.Time
+// Authenticate validates a bearer token locally and, depending on the delegation
+// mode, with the delegate. It returns the user ID and custom claims.
+func (s *Service) Authenticate(ctx context.Context, token string) (string, map[string]interface{}, error) {
+	// API keys are looked up in the store; unknown ones may still belong to an
+	// auth plugin
+	if s.apiKeys != nil && isAPIKey(token) {
+		userID, claims, err := s.validateAPIKey(ctx, token)
+		if !errors.Is(err, db.ErrAPIKeyNotFound) {
+			return userID, claims, err
 		}
 	}
 
-	// If we couldn't get the expiration time, use a default
-	if expirationTime.IsZero() {
-		expirationTime = time.Now().Add(24 * time.Hour)
+	if s.delegate == nil || s.config.Delegation == DelegationOff {
+		return s.ValidateToken(token)
+	}
+
+	// Tokens revoked in core are never passed on
+	revoked, err := s.revoked(s.revocationID(token))
+	if err != nil {
+		return "", nil, err
+	}
+	if revoked {
+		return "", nil, ErrInvalidToken
+	}
+
+	if s.config.Delegation == DelegationFirst {
+		if userID, claims, err := s.delegate.ValidateToken(ctx, token); err == nil {
+			return userID, claims, nil
+		}
+		return s.ValidateToken(token)
 	}
 
-	// Add the token to the blacklist
-	tokenBlacklist[tokenString] = expirationTime
+	userID, claims, err := s.ValidateToken(token)
+	if err == nil {
+		return userID, claims, err
+	}
+	if delegatedID, delegatedClaims, derr := s.delegate.ValidateToken(ctx, token); derr == nil {
+		return delegatedID, delegatedClaims, nil
+	}
+	return "", nil, err
 }
 
-// cleanupBlacklist removes expired tokens from the blacklist
-func (s *Service) cleanupBlacklist() {
-	ticker := time.NewTicker(1 * time.Hour)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		now := time.Now()
-		blacklistMutex.Lock()
-		for token, expiry := range tokenBlacklist {
-			if now.After(expiry) {
-				delete(tokenBlacklist, token)
-			}
+// RevokeToken revokes a token until it expires. Tokens signed by core are revoked
+// by their jti; other tokens, such as those accepted by auth plugins, by their hash.
+func (s *Service) RevokeToken(ctx context.Context, tokenString string) error {
+	expiresAt := time.Now().Add(defaultRevocationExpiration)
+
+	// Only trust the expiry of tokens core has signed
+	if token, err := s.parse(tokenString, jwt.WithoutClaimsValidation()); err == nil {
+		if claims, ok := token.Claims.(*Claims); ok && claims.ExpiresAt != nil {
+			expiresAt = claims.ExpiresAt.Time
 		}
-		blacklistMutex.Unlock()
 	}
+
+	return s.revoke(ctx, s.revocationID(tokenString), expiresAt)
 }
 
 // Middleware is an HTTP middleware that validates JWT tokens
@@ -278,14 +333,22 @@ func (s *Service) Middleware(next http.Handler) http.Handler {
 			return
 		}
 
-		// Validate the token
-		userId, customClaims, err := s.ValidateToken(token)
+		// Validate the token, asking the delegate if configured
+		userId, customClaims, err := s.Authenticate(r.Context(), token)
 		if err != nil {
 			log.Printf("Token validation failed: %v", err)
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
+		// Resolve what the request may do from its role and token
+		customClaims, err = s.grantScopes(r.Context(), customClaims)
+		if err != nil {
+			log.Printf("Failed to resolve permissions: %v", err)
+			http.Error(w, "Failed to resolve permissions", http.StatusInternalServerError)
+			return
+		}
+
 		// Set the user ID in the request context
 		ctx := context.WithValue(r.Context(), "user_id", userId)
 		ctx = context.WithValue(ctx, "custom_claims", customClaims)
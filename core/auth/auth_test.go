@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeDelegate accepts a single token
+type fakeDelegate struct {
+	calls int
+}
+
+func (d *fakeDelegate) ValidateToken(ctx context.Context, token string) (string, map[string]interface{}, error) {
+	d.calls++
+	if token != "pxk_external" {
+		return "", nil, errors.New("unknown token")
+	}
+	return "external-user", map[string]interface{}{"role": "user"}, nil
+}
+
+func newTestService(t *testing.T, delegation string) (*Service, *fakeDelegate) {
+	s, err := NewService(Config{
+		JWTAlgo:         "HS256",
+		JWTSecret:       "test-secret",
+		TokenExpiration: time.Hour,
+		Delegation:      delegation,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &fakeDelegate{}
+	s.SetDelegate(d)
+	return s, d
+}
+
+func TestAuthenticateDelegation(t *testing.T) {
+	ctx := context.Background()
+
+	// Off only accepts tokens signed by core
+	s, d := newTestService(t, DelegationOff)
+	if _, _, err := s.Authenticate(ctx, "pxk_external"); err == nil {
+		t.Error("expected an external token to be rejected with delegation off")
+	}
+	if d.calls != 0 {
+		t.Errorf("delegate was called with delegation off")
+	}
+
+	// Fallback keeps core tokens local and passes on the rest
+	s, d = newTestService(t, DelegationFallback)
+	local, err := s.GenerateToken("local-user", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub, _, err := s.Authenticate(ctx, local); err != nil || sub != "local-user" {
+		t.Errorf("expected local-user, got %q: %v", sub, err)
+	}
+	if d.calls != 0 {
+		t.Errorf("delegate was called for a valid core token")
+	}
+	sub, claims, err := s.Authenticate(ctx, "pxk_external")
+	if err != nil || sub != "external-user" || claims["role"] != "user" {
+		t.Errorf("unexpected delegated result %q %v: %v", sub, claims, err)
+	}
+	if _, _, err := s.Authenticate(ctx, "garbage"); err == nil {
+		t.Error("expected a token rejected by both to fail")
+	}
+
+	// Revoked core tokens are never passed on
+	if err := s.RevokeToken(ctx, local); err != nil {
+		t.Fatal(err)
+	}
+	calls := d.calls
+	if _, _, err := s.Authenticate(ctx, local); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken for a revoked token, got %v", err)
+	}
+	if d.calls != calls {
+		t.Errorf("delegate was called for a revoked token")
+	}
+
+	// First asks the delegate and still accepts core tokens
+	s, d = newTestService(t, DelegationFirst)
+	local, err = s.GenerateToken("other-user", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub, _, err := s.Authenticate(ctx, local); err != nil || sub != "other-user" {
+		t.Errorf("expected other-user, got %q: %v", sub, err)
+	}
+	if d.calls != 1 {
+		t.Errorf("expected the delegate to be asked first, got %d calls", d.calls)
+	}
+}
+
+func TestNewServiceRejectsUnknownDelegation(t *testing.T) {
+	_, err := NewService(Config{JWTAlgo: "HS256", JWTSecret: "x", Delegation: "always"})
+	if !errors.Is(err, ErrInvalidDelegation) {
+		t.Errorf("expected ErrInvalidDelegation, got %v", err)
+	}
+}
@@ -0,0 +1,342 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Signing algorithms
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgEdDSA = "EdDSA"
+)
+
+// defaultKeyReloadInterval is how often key files are checked for changes
+const defaultKeyReloadInterval = 30 * time.Second
+
+// verificationKey is a public key tokens are verified with, identified by its
+// JWK thumbprint (RFC 7638) so all replicas agree on the kid
+type verificationKey struct {
+	id     string
+	method jwt.SigningMethod
+	public crypto.PublicKey
+}
+
+// keySet holds the keys loaded from the configuration
+type keySet struct {
+	// secret signs and verifies HS256 tokens
+	secret []byte
+
+	// signer signs new tokens with the private key, if one is configured
+	signer  *verificationKey
+	private crypto.Signer
+
+	// keys verify tokens by kid: the signing key and those being rotated out
+	keys map[string]*verificationKey
+
+	// files are the modification times of the key files when they were read
+	files map[string]time.Time
+}
+
+// loadKeys reads the keys of the configuration. Asymmetric algorithms sign with
+// the private key file and verify with its public key and every public key file.
+func loadKeys(config Config) (*keySet, error) {
+	set := &keySet{
+		keys:  make(map[string]*verificationKey),
+		files: make(map[string]time.Time),
+	}
+
+	switch config.JWTAlgo {
+	case AlgHS256:
+		set.secret = []byte(config.JWTSecret)
+		return set, nil
+	case AlgRS256, AlgES256, AlgEdDSA:
+	default:
+		return nil, fmt.Errorf("unsupported algorithm: %s", config.JWTAlgo)
+	}
+
+	if config.JWTPrivateKeyFile != "" {
+		data, err := set.read(config.JWTPrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read private key file: %w", err)
+		}
+		private, err := parsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %w", err)
+		}
+		key, err := newVerificationKey(private.Public())
+		if err != nil {
+			return nil, err
+		}
+		if key.method.Alg() != config.JWTAlgo {
+			return nil, fmt.Errorf("private key is for %s, not %s", key.method.Alg(), config.JWTAlgo)
+		}
+		set.signer, set.private = key, private
+		set.keys[key.id] = key
+	}
+
+	files := config.JWTVerifyKeyFiles
+	if config.JWTPublicKeyFile != "" {
+		files = append([]string{config.JWTPublicKeyFile}, files...)
+	}
+	for _, file := range files {
+		data, err := set.read(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read public key file: %w", err)
+		}
+		public, err := parsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key %s: %w", file, err)
+		}
+		key, err := newVerificationKey(public)
+		if err != nil {
+			return nil, err
+		}
+		set.keys[key.id] = key
+	}
+
+	if len(set.keys) == 0 {
+		return nil, fmt.Errorf("%s requires a private or public key file", config.JWTAlgo)
+	}
+	return set, nil
+}
+
+// read reads a key file and remembers when it was last modified
+func (set *keySet) read(file string) ([]byte, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	set.files[file] = info.ModTime()
+	return os.ReadFile(file)
+}
+
+// changed reports whether any key file has been modified since it was read
+func (set *keySet) changed() bool {
+	for file, modTime := range set.files {
+		info, err := os.Stat(file)
+		if err != nil || !info.ModTime().Equal(modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// newVerificationKey identifies a public key and the algorithm it verifies
+func newVerificationKey(public crypto.PublicKey) (*verificationKey, error) {
+	var method jwt.SigningMethod
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		method = jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return nil, errors.New("ES256 requires a P-256 key")
+		}
+		method = jwt.SigningMethodES256
+	case ed25519.PublicKey:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", public)
+	}
+
+	thumbprint, err := json.Marshal(jwk(public))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode key: %w", err)
+	}
+	sum := sha256.Sum256(thumbprint)
+	return &verificationKey{
+		id:     base64.RawURLEncoding.EncodeToString(sum[:]),
+		method: method,
+		public: public,
+	}, nil
+}
+
+// jwk returns the required members of a public key's JWK. Marshalled, they are
+// in lexicographic order as the thumbprint requires.
+func jwk(public crypto.PublicKey) map[string]string {
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{
+			"kty": "RSA",
+			"n":   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		return map[string]string{
+			"kty": "EC",
+			"crv": k.Curve.Params().Name,
+			"x":   base64.RawURLEncoding.EncodeToString(k.X.FillBytes(make([]byte, size))),
+			"y":   base64.RawURLEncoding.EncodeToString(k.Y.FillBytes(make([]byte, size))),
+		}
+	case ed25519.PublicKey:
+		return map[string]string{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"x":   base64.RawURLEncoding.EncodeToString(k),
+		}
+	}
+	return nil
+}
+
+// parsePrivateKey parses a PEM encoded PKCS #1, PKCS #8 or SEC 1 private key
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("unsupported private key format")
+}
+
+// parsePublicKey parses a PEM encoded public key. A private key is accepted too,
+// so a key being rotated out can be listed by the file it was signed with.
+func parsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if private, err := parsePrivateKey(data); err == nil {
+		return private.Public(), nil
+	}
+	return nil, errors.New("unsupported public key format")
+}
+
+// keySet returns the current keys
+func (s *Service) keySet() *keySet {
+	s.keysMu.RLock()
+	defer s.keysMu.RUnlock()
+	return s.keys
+}
+
+// keyFunc returns the key to verify a token with. Tokens without a kid were
+// issued before keys had IDs and are tried with every key of their algorithm.
+func (s *Service) keyFunc(token *jwt.Token) (interface{}, error) {
+	keys := s.keySet()
+	if keys.secret != nil {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return keys.secret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		var set jwt.VerificationKeySet
+		for _, key := range keys.keys {
+			if key.method.Alg() == token.Method.Alg() {
+				set.Keys = append(set.Keys, key.public)
+			}
+		}
+		if len(set.Keys) == 0 {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return set, nil
+	}
+
+	key, ok := keys.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID: %s", kid)
+	}
+	if key.method.Alg() != token.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.public, nil
+}
+
+// ReloadKeys reads the key files again. The current keys stay in use if the
+// new ones cannot be loaded.
+func (s *Service) ReloadKeys() error {
+	keys, err := loadKeys(s.config)
+	if err != nil {
+		return err
+	}
+
+	s.keysMu.Lock()
+	s.keys = keys
+	s.keysMu.Unlock()
+	return nil
+}
+
+// watchKeys reloads the keys when their files change, so keys can be rotated
+// without a restart
+func (s *Service) watchKeys() {
+	ticker := time.NewTicker(s.config.KeyReloadInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !s.keySet().changed() {
+			continue
+		}
+		if err := s.ReloadKeys(); err != nil {
+			log.Printf("Failed to reload signing keys, keeping the current ones: %v", err)
+			continue
+		}
+		log.Printf("Reloaded signing keys")
+	}
+}
+
+// JWKS returns the public verification keys as a JSON Web Key Set. It is empty
+// for HS256, whose secret must not be published.
+func (s *Service) JWKS() map[string]interface{} {
+	keys := s.keySet()
+	jwks := make([]map[string]string, 0, len(keys.keys))
+	for _, key := range keys.keys {
+		k := jwk(key.public)
+		k["kid"] = key.id
+		k["alg"] = key.method.Alg()
+		k["use"] = "sig"
+		jwks = append(jwks, k)
+	}
+
+	// List the signing key first, the others in a stable order
+	signer := ""
+	if keys.signer != nil {
+		signer = keys.signer.id
+	}
+	sort.Slice(jwks, func(i, j int) bool {
+		if (jwks[i]["kid"] == signer) != (jwks[j]["kid"] == signer) {
+			return jwks[i]["kid"] == signer
+		}
+		return jwks[i]["kid"] < jwks[j]["kid"]
+	})
+	return map[string]interface{}{"keys": jwks}
+}
@@ -0,0 +1,330 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// writeKey writes a new private key for the algorithm as PKCS #8 PEM and
+// returns the file and the public key
+func writeKey(t *testing.T, dir, name, alg string) (string, crypto.PublicKey) {
+	t.Helper()
+	var key crypto.Signer
+	var err error
+	switch alg {
+	case AlgRS256:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgES256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgEdDSA:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, name)
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file, key.Public()
+}
+
+// writePublicKey writes a public key as PKIX PEM
+func writePublicKey(t *testing.T, dir, name string, public crypto.PublicKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, name)
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func newKeyService(t *testing.T, config Config) *Service {
+	t.Helper()
+	config.TokenExpiration = time.Hour
+	config.KeyReloadInterval = time.Hour
+	s, err := NewService(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// kid returns the kid header of a token
+func kid(t *testing.T, token string) string {
+	t.Helper()
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := parsed.Header["kid"].(string)
+	return id
+}
+
+func TestSigningAlgorithms(t *testing.T) {
+	for _, alg := range []string{AlgRS256, AlgES256, AlgEdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			file, _ := writeKey(t, t.TempDir(), "private.pem", alg)
+			s := newKeyService(t, Config{JWTAlgo: alg, JWTPrivateKeyFile: file})
+
+			token, err := s.GenerateToken("user-1", map[string]interface{}{"role": "user"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed.Method.Alg() != alg || kid(t, token) == "" {
+				t.Errorf("expected an %s token with a kid, got %v", alg, parsed.Header)
+			}
+
+			sub, claims, err := s.ValidateToken(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sub != "user-1" || claims["role"] != "user" {
+				t.Errorf("unexpected subject %q and claims %v", sub, claims)
+			}
+		})
+	}
+}
+
+func TestPrivateKeyMustMatchAlgorithm(t *testing.T) {
+	file, _ := writeKey(t, t.TempDir(), "private.pem", AlgES256)
+	if _, err := NewService(Config{JWTAlgo: AlgRS256, JWTPrivateKeyFile: file}); err == nil {
+		t.Error("expected an ES256 key to be rejected for RS256")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	oldFile, oldPublic := writeKey(t, dir, "old.pem", AlgRS256)
+	newFile, _ := writeKey(t, dir, "new.pem", AlgES256)
+	oldPublicFile := writePublicKey(t, dir, "old.pub.pem", oldPublic)
+
+	before := newKeyService(t, Config{JWTAlgo: AlgRS256, JWTPrivateKeyFile: oldFile})
+	oldToken, err := before.GenerateToken("user-1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// After the rotation new tokens are signed with the new key, and tokens of
+	// the old key stay valid as long as it is listed
+	after := newKeyService(t, Config{
+		JWTAlgo:           AlgES256,
+		JWTPrivateKeyFile: newFile,
+		JWTVerifyKeyFiles: []string{oldPublicFile},
+	})
+	newToken, err := after.GenerateToken("user-2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid(t, newToken) == kid(t, oldToken) {
+		t.Error("expected the new key to have another kid")
+	}
+	if _, _, err := after.ValidateToken(oldToken); err != nil {
+		t.Errorf("expected a token of the old key to be accepted: %v", err)
+	}
+	if _, _, err := after.ValidateToken(newToken); err != nil {
+		t.Errorf("expected a token of the new key to be accepted: %v", err)
+	}
+
+	// Once the old key is dropped its tokens are rejected
+	done := newKeyService(t, Config{JWTAlgo: AlgES256, JWTPrivateKeyFile: newFile})
+	if _, _, err := done.ValidateToken(oldToken); err == nil {
+		t.Error("expected a token of a dropped key to be rejected")
+	}
+}
+
+func TestVerifyOnlyReplica(t *testing.T) {
+	dir := t.TempDir()
+	file, public := writeKey(t, dir, "private.pem", AlgEdDSA)
+	signer := newKeyService(t, Config{JWTAlgo: AlgEdDSA, JWTPrivateKeyFile: file})
+	verifier := newKeyService(t, Config{JWTAlgo: AlgEdDSA, JWTPublicKeyFile: writePublicKey(t, dir, "public.pem", public)})
+
+	token, err := signer.GenerateToken("user-1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := verifier.ValidateToken(token); err != nil {
+		t.Errorf("expected the verifier to accept the token: %v", err)
+	}
+	if _, err := verifier.GenerateToken("user-1", nil); err == nil {
+		t.Error("expected a replica without a private key not to sign")
+	}
+}
+
+func TestTokenWithoutKid(t *testing.T) {
+	file, _ := writeKey(t, t.TempDir(), "private.pem", AlgRS256)
+	s := newKeyService(t, Config{JWTAlgo: AlgRS256, JWTPrivateKeyFile: file})
+	keys := s.keySet()
+
+	// Tokens issued before keys had IDs are still accepted
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodRS256, Claims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "user-1", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+	}).SignedString(keys.private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.ValidateToken(legacy); err != nil {
+		t.Errorf("expected a token without kid to be accepted: %v", err)
+	}
+
+	// An unknown kid is rejected
+	unknown := jwt.NewWithClaims(jwt.SigningMethodRS256, Claims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "user-1", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+	})
+	unknown.Header["kid"] = "unknown"
+	token, err := unknown.SignedString(keys.private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.ValidateToken(token); err == nil {
+		t.Error("expected a token with an unknown kid to be rejected")
+	}
+}
+
+func TestRejectsAlgorithmConfusion(t *testing.T) {
+	dir := t.TempDir()
+	file, public := writeKey(t, dir, "private.pem", AlgRS256)
+	s := newKeyService(t, Config{JWTAlgo: AlgRS256, JWTPrivateKeyFile: file})
+
+	// An HS256 token keyed with the public key must not pass as the RSA key's
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "admin", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+	})
+	forged.Header["kid"] = s.keySet().signer.id
+	token, err := forged.SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.ValidateToken(token); err == nil {
+		t.Error("expected an HS256 token to be rejected")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	dir := t.TempDir()
+	file, _ := writeKey(t, dir, "private.pem", AlgES256)
+	_, old := writeKey(t, dir, "old.pem", AlgRS256)
+	s := newKeyService(t, Config{
+		JWTAlgo:           AlgES256,
+		JWTPrivateKeyFile: file,
+		JWTVerifyKeyFiles: []string{writePublicKey(t, dir, "old.pub.pem", old)},
+	})
+
+	keys := s.JWKS()["keys"].([]map[string]string)
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 keys, got %v", keys)
+	}
+
+	// The signing key comes first and no private parts are published
+	token, err := s.GenerateToken("user-1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys[0]["kid"] != kid(t, token) || keys[0]["alg"] != AlgES256 || keys[0]["crv"] != "P-256" {
+		t.Errorf("expected the signing key first, got %v", keys[0])
+	}
+	if keys[1]["alg"] != AlgRS256 || keys[1]["kty"] != "RSA" {
+		t.Errorf("expected the old RSA key, got %v", keys[1])
+	}
+	for _, k := range keys {
+		if _, ok := k["d"]; ok {
+			t.Errorf("private key published: %v", k)
+		}
+	}
+
+	// The HS256 secret is never published
+	hs, _ := newTestService(t, DelegationOff)
+	if keys := hs.JWKS()["keys"].([]map[string]string); len(keys) != 0 {
+		t.Errorf("expected no keys for HS256, got %v", keys)
+	}
+}
+
+func TestJWKThumbprint(t *testing.T) {
+	// The example key of RFC 7638 section 3.1
+	n, _ := new(jwt.Parser).DecodeSegment("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}
+
+	k, err := newVerificationKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; k.id != want {
+		t.Errorf("expected kid %s, got %s", want, k.id)
+	}
+}
+
+func TestReloadKeys(t *testing.T) {
+	dir := t.TempDir()
+	file, _ := writeKey(t, dir, "private.pem", AlgEdDSA)
+	s := newKeyService(t, Config{JWTAlgo: AlgEdDSA, JWTPrivateKeyFile: file})
+	first, err := s.GenerateToken("user-1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.keySet().changed() {
+		t.Error("expected unchanged key files")
+	}
+
+	// Replace the key file, keeping its modification time distinct
+	writeKey(t, dir, "private.pem", AlgEdDSA)
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if !s.keySet().changed() {
+		t.Fatal("expected the replaced key file to be noticed")
+	}
+	if err := s.ReloadKeys(); err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.GenerateToken("user-1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid(t, first) == kid(t, second) {
+		t.Error("expected tokens to be signed with the new key")
+	}
+
+	// A broken key file leaves the current keys in place
+	if err := os.WriteFile(file, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReloadKeys(); err == nil {
+		t.Error("expected a broken key file to fail to load")
+	}
+	if _, _, err := s.ValidateToken(second); err != nil {
+		t.Errorf("expected the current key to stay in use: %v", err)
+	}
+	if _, _, err := s.ValidateToken(first); err == nil {
+		t.Error("expected a token of the replaced key to be rejected")
+	}
+}
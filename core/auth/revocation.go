@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// revocationCacheSize is how many revocation lookups are cached
+	revocationCacheSize = 10000
+
+	// revocationLookupTimeout bounds a lookup in the revocation store
+	revocationLookupTimeout = 2 * time.Second
+
+	// defaultRevocationExpiration is how long tokens whose expiry is unknown
+	// stay revoked
+	defaultRevocationExpiration = 24 * time.Hour
+)
+
+// RevocationStore persists the IDs of revoked tokens and sessions until the
+// tokens would have expired anyway, so revocations survive restarts and are
+// shared between replicas
+type RevocationStore interface {
+	RevokeToken(ctx context.Context, id string, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, id string) (bool, error)
+	DeleteExpiredRevocations(ctx context.Context) (int64, error)
+}
+
+// SetRevocationStore configures where revocations are persisted. Without one
+// they are kept in memory.
+func (s *Service) SetRevocationStore(store RevocationStore) {
+	s.revocations = store
+}
+
+// revocationID returns the ID a token is revoked under: the jti of tokens signed
+// by core, or a hash of the token for others, such as those of auth plugins. The
+// jti of a token with an invalid signature is never trusted.
+func (s *Service) revocationID(tokenString string) string {
+	if token, err := s.parse(tokenString, jwt.WithoutClaimsValidation()); err == nil {
+		if claims, ok := token.Claims.(*Claims); ok && claims.ID != "" {
+			return claims.ID
+		}
+	}
+	sum := sha256.Sum256([]byte(tokenString))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// revoke persists the revocation of an ID and remembers it locally
+func (s *Service) revoke(ctx context.Context, id string, expiresAt time.Time) error {
+	if err := s.revocations.RevokeToken(ctx, id, expiresAt); err != nil {
+		return err
+	}
+	s.revocationCache.put(id, true, expiresAt)
+	return nil
+}
+
+// revoked reports whether any of the IDs has been revoked. Lookups are cached;
+// an ID found not to be revoked is looked up again after the cache TTL, which
+// bounds how long a revocation on another replica takes to apply here.
+func (s *Service) revoked(ids ...string) (bool, error) {
+	for _, id := range ids {
+		if id == "" {
+			continue
+		}
+		if revoked, ok := s.revocationCache.get(id); ok {
+			if revoked {
+				return true, nil
+			}
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), revocationLookupTimeout)
+		revoked, err := s.revocations.IsTokenRevoked(ctx, id)
+		cancel()
+		if err != nil {
+			return false, fmt.Errorf("failed to check token revocation: %w", err)
+		}
+
+		if revoked {
+			// Kept until evicted; the token expires long before that matters
+			s.revocationCache.put(id, true, time.Now().Add(defaultRevocationExpiration))
+			return true, nil
+		}
+		s.revocationCache.put(id, false, time.Now().Add(s.config.RevocationCacheTTL))
+	}
+	return false, nil
+}
+
+// cleanupRevocations removes revocations of tokens that have expired
+func (s *Service) cleanupRevocations() {
+	ticker := time.NewTicker(1 * time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if n, err := s.revocations.DeleteExpiredRevocations(ctx); err != nil {
+			log.Printf("Failed to delete expired token revocations: %v", err)
+		} else if n > 0 {
+			log.Printf("Deleted %d expired token revocations", n)
+		}
+		cancel()
+	}
+}
+
+// memoryRevocations is a RevocationStore for a single process
+type memoryRevocations struct {
+	mu      sync.RWMutex
+	revoked map[string]time.Time
+}
+
+func newMemoryRevocations() *memoryRevocations {
+	return &memoryRevocations{revoked: make(map[string]time.Time)}
+}
+
+// RevokeToken implements RevocationStore
+func (m *memoryRevocations) RevokeToken(ctx context.Context, id string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if expiresAt.After(m.revoked[id]) {
+		m.revoked[id] = expiresAt
+	}
+	return nil
+}
+
+// IsTokenRevoked implements RevocationStore
+func (m *memoryRevocations) IsTokenRevoked(ctx context.Context, id string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.revoked[id]
+	return ok, nil
+}
+
+// DeleteExpiredRevocations implements RevocationStore
+func (m *memoryRevocations) DeleteExpiredRevocations(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	now := time.Now()
+	for id, expiresAt := range m.revoked {
+		if now.After(expiresAt) {
+			delete(m.revoked, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// revocationCache is an LRU cache of revocation lookups
+type revocationCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// cacheEntry is a cached lookup, valid until the given time
+type cacheEntry struct {
+	id      string
+	revoked bool
+	until   time.Time
+}
+
+func newRevocationCache(size int) *revocationCache {
+	return &revocationCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// get returns a cached lookup that is still valid
+func (c *revocationCache) get(id string) (bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[id]
+	if !ok {
+		return false, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if time.Now().After(entry.until) {
+		c.order.Remove(el)
+		delete(c.entries, id)
+		return false, false
+	}
+	c.order.MoveToFront(el)
+	return entry.revoked, true
+}
+
+// put caches a lookup, evicting the least recently used one if the cache is full
+func (c *revocationCache) put(id string, revoked bool, until time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[id]; ok {
+		entry := el.Value.(*cacheEntry)
+		entry.revoked, entry.until = revoked, until
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[id] = c.order.PushFront(&cacheEntry{id: id, revoked: revoked, until: until})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).id)
+	}
+}
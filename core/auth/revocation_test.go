@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// newReplica creates a service that shares its revocations with others
+func newReplica(t *testing.T, store RevocationStore, cacheTTL time.Duration) *Service {
+	s, err := NewService(Config{
+		JWTAlgo:            "HS256",
+		JWTSecret:          "test-secret",
+		TokenExpiration:    time.Hour,
+		RevocationCacheTTL: cacheTTL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetRevocationStore(store)
+	return s
+}
+
+func TestGenerateTokenAssignsJTI(t *testing.T) {
+	s, _ := newTestService(t, DelegationOff)
+
+	ids := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		token, err := s.GenerateToken("user-1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var claims Claims
+		if _, _, err := jwt.NewParser().ParseUnverified(token, &claims); err != nil {
+			t.Fatal(err)
+		}
+		if claims.ID == "" || ids[claims.ID] {
+			t.Errorf("expected a unique jti, got %q", claims.ID)
+		}
+		ids[claims.ID] = true
+	}
+}
+
+func TestRevocationSharedBetweenReplicas(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryRevocations()
+	a := newReplica(t, store, 20*time.Millisecond)
+	b := newReplica(t, store, 20*time.Millisecond)
+
+	token, err := a.GenerateToken("user-1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := b.ValidateToken(token); err != nil {
+		t.Fatal(err)
+	}
+
+	// The revoking replica rejects the token at once, the other one after its
+	// cached lookup has expired
+	if err := a.RevokeToken(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := a.ValidateToken(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken on the revoking replica, got %v", err)
+	}
+	time.Sleep(40 * time.Millisecond)
+	if _, _, err := b.ValidateToken(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken on the other replica, got %v", err)
+	}
+
+	// A restarted replica starts with an empty cache and still rejects it
+	restarted := newReplica(t, store, time.Minute)
+	if _, _, err := restarted.ValidateToken(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken after a restart, got %v", err)
+	}
+
+	// Other tokens of the same user are unaffected
+	other, err := a.GenerateToken("user-1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := b.ValidateToken(other); err != nil {
+		t.Errorf("expected another token to stay valid, got %v", err)
+	}
+}
+
+func TestRevokeTokenIgnoresForgedJTI(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestService(t, DelegationOff)
+
+	victim, err := s.GenerateToken("user-1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var claims Claims
+	if _, _, err := jwt.NewParser().ParseUnverified(victim, &claims); err != nil {
+		t.Fatal(err)
+	}
+
+	// A token with the victim's jti but a different key must not revoke it
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("other-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RevokeToken(ctx, forged); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.ValidateToken(victim); err != nil {
+		t.Errorf("forged token revoked the victim's token: %v", err)
+	}
+}
+
+func TestRevokeTokenRS256(t *testing.T) {
+	ctx := context.Background()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	privateFile := filepath.Join(dir, "private.pem")
+	publicFile := filepath.Join(dir, "public.pem")
+	privateDER := x509.MarshalPKCS1PrivateKey(key)
+	publicDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(privateFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: privateDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(publicFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewService(Config{
+		JWTAlgo:           "RS256",
+		JWTPublicKeyFile:  publicFile,
+		JWTPrivateKeyFile: privateFile,
+		TokenExpiration:   10 * time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := newMemoryRevocations()
+	s.SetRevocationStore(store)
+
+	token, err := s.GenerateToken("user-1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RevokeToken(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.ValidateToken(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken, got %v", err)
+	}
+
+	// The revocation lasts as long as the token, not the default for unknown tokens
+	if len(store.revoked) != 1 {
+		t.Fatalf("expected one revocation, got %v", store.revoked)
+	}
+	for id, expiresAt := range store.revoked {
+		if strings.HasPrefix(id, "sha256:") {
+			t.Errorf("expected the token to be revoked by jti, got %s", id)
+		}
+		if d := time.Until(expiresAt); d > 10*time.Minute || d < 9*time.Minute {
+			t.Errorf("expected the revocation to expire with the token, got %s", d)
+		}
+	}
+}
+
+func TestRevocationCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newRevocationCache(2)
+	until := time.Now().Add(time.Minute)
+
+	c.put("a", true, until)
+	c.put("b", false, until)
+	c.get("a")
+	c.put("c", true, until)
+
+	if _, ok := c.get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	if revoked, ok := c.get("a"); !ok || !revoked {
+		t.Error("expected a to be cached as revoked")
+	}
+
+	c.put("d", false, time.Now().Add(-time.Second))
+	if _, ok := c.get("d"); ok {
+		t.Error("expected an expired entry to be ignored")
+	}
+}
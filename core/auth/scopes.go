@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"sort"
+)
+
+// Scopes are the permissions a request may be granted. Roles grant a set of
+// them, and a token may narrow that set further.
+const (
+	// ScopePhotosRead allows listing, searching and viewing photos
+	ScopePhotosRead = "photos:read"
+
+	// ScopePhotosWrite allows uploading photos, and moving one's own photos to
+	// and from the trash and deleting them
+	ScopePhotosWrite = "photos:write"
+
+	// ScopePhotosDelete allows trashing, restoring and deleting anyone's photos
+	// and emptying the trash
+	ScopePhotosDelete = "photos:delete"
+
+	// ScopeAlbumsRead allows viewing albums
+	ScopeAlbumsRead = "albums:read"
+
+	// ScopeAlbumsWrite allows creating and changing albums
+	ScopeAlbumsWrite = "albums:write"
+
+	// ScopeAlbumsShare allows sharing albums with others
+	ScopeAlbumsShare = "albums:share"
+
+	// ScopeUsersAdmin allows managing users, roles and tokens of others
+	ScopeUsersAdmin = "users:admin"
+
+	// ScopePluginsAdmin allows managing plugins and using their admin routes
+	ScopePluginsAdmin = "plugins:admin"
+)
+
+// Coarse scopes of the first API keys, which still work as aliases
+const (
+	// ScopeRead allows reading photos and albums
+	ScopeRead = "read"
+
+	// ScopeUpload allows uploading photos
+	ScopeUpload = "upload"
+
+	// ScopeAdmin allows everything the user may do, including administration
+	ScopeAdmin = "admin"
+)
+
+// ScopesClaim lists the scopes of a token. After Middleware it holds the scopes
+// granted to the request.
+const ScopesClaim = "scopes"
+
+// Scopes lists every scope
+var Scopes = []string{
+	ScopePhotosRead,
+	ScopePhotosWrite,
+	ScopePhotosDelete,
+	ScopeAlbumsRead,
+	ScopeAlbumsWrite,
+	ScopeAlbumsShare,
+	ScopeUsersAdmin,
+	ScopePluginsAdmin,
+}
+
+// scopeAliases maps the coarse scopes to the scopes they stand for
+var scopeAliases = map[string][]string{
+	ScopeRead:   {ScopePhotosRead, ScopeAlbumsRead},
+	ScopeUpload: {ScopePhotosWrite},
+	ScopeAdmin:  Scopes,
+}
+
+// RoleStore resolves the scopes a role grants
+type RoleStore interface {
+	RolePermissions(ctx context.Context, role string) ([]string, error)
+}
+
+// SetRoleStore configures how the role of a token is turned into scopes.
+// Without one, requests are granted the scopes their token lists and nothing
+// else.
+func (s *Service) SetRoleStore(store RoleStore) {
+	s.roles = store
+}
+
+// IsScope reports whether scope is a known scope or an alias of some
+func IsScope(scope string) bool {
+	if _, ok := scopeAliases[scope]; ok {
+		return true
+	}
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpandScopes replaces aliases with the scopes they stand for and returns the
+// result sorted and without duplicates
+func ExpandScopes(scopes []string) []string {
+	set := make(map[string]bool)
+	for _, scope := range scopes {
+		if expanded, ok := scopeAliases[scope]; ok {
+			for _, s := range expanded {
+				set[s] = true
+			}
+		} else {
+			set[scope] = true
+		}
+	}
+
+	expanded := make([]string, 0, len(set))
+	for scope := range set {
+		expanded = append(expanded, scope)
+	}
+	sort.Strings(expanded)
+	return expanded
+}
+
+// grantScopes returns a copy of the claims whose scopes claim holds what the
+// request may do: the permissions of its role, narrowed to the token's own
+// scopes if it lists any
+func (s *Service) grantScopes(ctx context.Context, claims map[string]interface{}) (map[string]interface{}, error) {
+	raw, limited := claims[ScopesClaim]
+	tokenScopes := ExpandScopes(scopeList(raw))
+
+	granted := tokenScopes
+	if s.roles != nil {
+		role, _ := claims["role"].(string)
+		permissions, err := s.roles.RolePermissions(ctx, role)
+		if err != nil {
+			return nil, err
+		}
+		granted = ExpandScopes(permissions)
+		if limited {
+			granted = intersectScopes(granted, tokenScopes)
+		}
+	}
+
+	out := make(map[string]interface{}, len(claims)+1)
+	for k, v := range claims {
+		out[k] = v
+	}
+	out[ScopesClaim] = granted
+	return out, nil
+}
+
+// HasScope reports whether the claims of a request grant the scope
+func HasScope(claims map[string]interface{}, scope string) bool {
+	for _, s := range scopeList(claims[ScopesClaim]) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireScope returns middleware that rejects requests not granted the scope.
+// It must run after Middleware.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			customClaims, ok := r.Context().Value("custom_claims").(map[string]interface{})
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if !HasScope(customClaims, scope) {
+				http.Error(w, "Forbidden: the "+scope+" scope is required", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// scopeList reads a scopes claim, which is a []interface{} once it went
+// through JSON
+func scopeList(raw interface{}) []string {
+	switch v := raw.(type) {
+	case []string:
+		return v
+	case []interface{}:
+		scopes := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				scopes = append(scopes, s)
+			}
+		}
+		return scopes
+	}
+	return nil
+}
+
+// intersectScopes returns the scopes in both a and b
+func intersectScopes(a, b []string) []string {
+	in := make(map[string]bool, len(b))
+	for _, s := range b {
+		in[s] = true
+	}
+
+	both := []string{}
+	for _, s := range a {
+		if in[s] {
+			both = append(both, s)
+		}
+	}
+	return both
+}
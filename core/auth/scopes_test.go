@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// fakeRoles is a RoleStore of fixed roles. Unknown roles grant nothing.
+type fakeRoles map[string][]string
+
+func (f fakeRoles) RolePermissions(ctx context.Context, role string) ([]string, error) {
+	return f[role], nil
+}
+
+var testRoles = fakeRoles{
+	"admin":  Scopes,
+	"user":   {ScopePhotosRead, ScopePhotosWrite, ScopeAlbumsRead},
+	"viewer": {ScopePhotosRead, ScopeAlbumsRead},
+}
+
+func TestExpandScopes(t *testing.T) {
+	got := ExpandScopes([]string{ScopeUpload, ScopeRead, ScopePhotosRead})
+	want := []string{ScopeAlbumsRead, ScopePhotosRead, ScopePhotosWrite}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExpandScopes = %v, want %v", got, want)
+	}
+	if got := ExpandScopes([]string{ScopeAdmin}); len(got) != len(Scopes) {
+		t.Errorf("expected the admin alias to expand to every scope, got %v", got)
+	}
+}
+
+func TestGrantScopes(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestService(t, DelegationOff)
+	s.SetRoleStore(testRoles)
+
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		want   []string
+	}{
+		{"role", map[string]interface{}{"role": "viewer"}, []string{ScopeAlbumsRead, ScopePhotosRead}},
+		{"narrowed", map[string]interface{}{"role": "admin", ScopesClaim: []interface{}{ScopePhotosRead}}, []string{ScopePhotosRead}},
+		{"alias", map[string]interface{}{"role": "user", ScopesClaim: []string{ScopeAdmin}}, []string{ScopeAlbumsRead, ScopePhotosRead, ScopePhotosWrite}},
+		{"beyond role", map[string]interface{}{"role": "viewer", ScopesClaim: []interface{}{ScopePhotosDelete}}, []string{}},
+		{"unknown role", map[string]interface{}{"role": "removed"}, []string{}},
+	}
+	for _, tt := range tests {
+		granted, err := s.grantScopes(ctx, tt.claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := granted[ScopesClaim]; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: granted %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestService(t, DelegationOff)
+	s.SetRoleStore(testRoles)
+
+	handler := RequireScope(ScopePhotosDelete)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	for role, want := range map[string]int{"admin": http.StatusNoContent, "user": http.StatusForbidden, "viewer": http.StatusForbidden} {
+		claims, err := s.grantScopes(ctx, map[string]interface{}{"role": role})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest(http.MethodDelete, "/api/photos/trash", nil)
+		req = req.WithContext(context.WithValue(req.Context(), "custom_claims", claims))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("%s: got status %d, want %d", role, rec.Code, want)
+		}
+	}
+
+	// Requests that did not pass Middleware are not authenticated
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/api/photos/trash", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d without claims, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"pixie/db"
+)
+
+var (
+	// ErrInvalidRefreshToken is returned when a refresh token is unknown, expired
+	// or belongs to a revoked session
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+
+	// ErrRefreshTokenReused is returned when a refresh token is presented again
+	// after it was rotated. The session is revoked because the token has leaked.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+
+	// ErrNoSessionStore is returned when sessions are used without a store
+	ErrNoSessionStore = errors.New("no session store configured")
+)
+
+// SessionClaim is the custom claim holding the session an access token belongs to
+const SessionClaim = "sid"
+
+// keepRevokedSessions is how long revoked and expired sessions are kept, so a
+// reused refresh token is still recognized for a while
+const keepRevokedSessions = 7 * 24 * time.Hour
+
+// SessionStore persists sessions and their hashed refresh tokens
+type SessionStore interface {
+	CreateSession(ctx context.Context, s *db.Session) error
+	GetSessionByRefreshHash(ctx context.Context, hash string) (*db.Session, error)
+	RotateSession(ctx context.Context, id, oldHash, newHash, ip string, expiresAt time.Time) error
+	ListSessions(ctx context.Context, userID string) ([]db.Session, error)
+	RevokeSession(ctx context.Context, userID, id string) error
+	RevokeUserSessions(ctx context.Context, userID string) ([]string, error)
+	DeleteExpiredSessions(ctx context.Context, before time.Time) (int64, error)
+}
+
+// SetSessionStore configures where sessions are persisted and starts removing
+// old sessions from it
+func (s *Service) SetSessionStore(store SessionStore) {
+	s.sessions = store
+	go s.cleanupSessions()
+}
+
+// TokenExpiration returns how long access tokens are valid
+func (s *Service) TokenExpiration() time.Duration {
+	return s.config.TokenExpiration
+}
+
+// CreateSession starts a session for a user on a device and returns it with its
+// refresh token. Only the hash of the token is stored.
+func (s *Service) CreateSession(ctx context.Context, userID, device, ip string) (*db.Session, string, error) {
+	if s.sessions == nil {
+		return nil, "", ErrNoSessionStore
+	}
+
+	token, hash, err := newRefreshToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	session := &db.Session{
+		ID:          uuid.New().String(),
+		UserID:      userID,
+		Device:      device,
+		IP:          ip,
+		CreatedAt:   now,
+		LastSeenAt:  now,
+		ExpiresAt:   now.Add(s.config.RefreshExpiration),
+		RefreshHash: hash,
+	}
+	if err := s.sessions.CreateSession(ctx, session); err != nil {
+		return nil, "", err
+	}
+
+	return session, token, nil
+}
+
+// Refresh exchanges a refresh token for a new one. Each token can be used once;
+// presenting a rotated token again revokes the whole session.
+func (s *Service) Refresh(ctx context.Context, refreshToken, ip string) (*db.Session, string, error) {
+	if s.sessions == nil {
+		return nil, "", ErrNoSessionStore
+	}
+
+	hash := hashRefreshToken(refreshToken)
+	session, err := s.sessions.GetSessionByRefreshHash(ctx, hash)
+	if errors.Is(err, db.ErrSessionNotFound) {
+		return nil, "", ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	if session.RefreshHash != hash {
+		if session.RevokedAt == nil {
+			log.Printf("Refresh token of session %s was reused, revoking the session", session.ID)
+			if err := s.RevokeSession(ctx, session.UserID, session.ID); err != nil && !errors.Is(err, db.ErrSessionNotFound) {
+				return nil, "", err
+			}
+		}
+		return nil, "", ErrRefreshTokenReused
+	}
+	if session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+		return nil, "", ErrInvalidRefreshToken
+	}
+
+	token, newHash, err := newRefreshToken()
+	if err != nil {
+		return nil, "", err
+	}
+	expiresAt := time.Now().Add(s.config.RefreshExpiration)
+	err = s.sessions.RotateSession(ctx, session.ID, hash, newHash, ip, expiresAt)
+	if errors.Is(err, db.ErrSessionNotFound) {
+		// Another request rotated the token first
+		return nil, "", ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	session.PreviousHash = hash
+	session.RefreshHash = newHash
+	session.IP = ip
+	session.LastSeenAt = time.Now()
+	session.ExpiresAt = expiresAt
+	return session, token, nil
+}
+
+// ListSessions returns the active sessions of a user
+func (s *Service) ListSessions(ctx context.Context, userID string) ([]db.Session, error) {
+	if s.sessions == nil {
+		return nil, ErrNoSessionStore
+	}
+	return s.sessions.ListSessions(ctx, userID)
+}
+
+// RevokeSession ends a session of a user. Its refresh token and the access tokens
+// issued for it stop working immediately.
+func (s *Service) RevokeSession(ctx context.Context, userID, id string) error {
+	if s.sessions == nil {
+		return ErrNoSessionStore
+	}
+	if err := s.sessions.RevokeSession(ctx, userID, id); err != nil {
+		return err
+	}
+
+	return s.rejectSessions(ctx, id)
+}
+
+// RevokeUserSessions logs a user out everywhere and returns how many sessions
+// were ended
+func (s *Service) RevokeUserSessions(ctx context.Context, userID string) (int, error) {
+	if s.sessions == nil {
+		return 0, ErrNoSessionStore
+	}
+	ids, err := s.sessions.RevokeUserSessions(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.rejectSessions(ctx, ids...); err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// RevokeOtherSessions logs a user out everywhere but in the session keepID and
+// returns how many sessions were ended
+func (s *Service) RevokeOtherSessions(ctx context.Context, userID, keepID string) (int, error) {
+	sessions, err := s.ListSessions(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, session := range sessions {
+		if session.ID == keepID {
+			continue
+		}
+		err := s.RevokeSession(ctx, userID, session.ID)
+		if errors.Is(err, db.ErrSessionNotFound) {
+			// Ended concurrently
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// rejectSessions revokes the access tokens of sessions until they have expired
+// anyway
+func (s *Service) rejectSessions(ctx context.Context, ids ...string) error {
+	expiresAt := time.Now().Add(s.config.TokenExpiration)
+	for _, id := range ids {
+		if err := s.revoke(ctx, id, expiresAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cleanupSessions periodically removes old sessions from the store
+func (s *Service) cleanupSessions() {
+	ticker := time.NewTicker(1 * time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if n, err := s.sessions.DeleteExpiredSessions(ctx, now.Add(-keepRevokedSessions)); err != nil {
+			log.Printf("Failed to delete expired sessions: %v", err)
+		} else if n > 0 {
+			log.Printf("Deleted %d expired sessions", n)
+		}
+		cancel()
+	}
+}
+
+// newRefreshToken returns a random refresh token and its hash
+func newRefreshToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashRefreshToken(token), nil
+}
+
+// hashRefreshToken hashes a refresh token for storage. The token is random, so
+// a plain SHA-256 is enough.
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,247 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"pixie/db"
+)
+
+// memSessions is an in-memory SessionStore
+type memSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*db.Session
+}
+
+func newMemSessions() *memSessions {
+	return &memSessions{sessions: make(map[string]*db.Session)}
+}
+
+func (m *memSessions) CreateSession(ctx context.Context, s *db.Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := *s
+	m.sessions[s.ID] = &c
+	return nil
+}
+
+func (m *memSessions) GetSessionByRefreshHash(ctx context.Context, hash string) (*db.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range m.sessions {
+		if s.RefreshHash == hash || s.PreviousHash == hash {
+			c := *s
+			return &c, nil
+		}
+	}
+	return nil, db.ErrSessionNotFound
+}
+
+func (m *memSessions) RotateSession(ctx context.Context, id, oldHash, newHash, ip string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok || s.RefreshHash != oldHash || s.RevokedAt != nil {
+		return db.ErrSessionNotFound
+	}
+	s.PreviousHash, s.RefreshHash, s.IP, s.ExpiresAt = oldHash, newHash, ip, expiresAt
+	return nil
+}
+
+func (m *memSessions) ListSessions(ctx context.Context, userID string) ([]db.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var sessions []db.Session
+	for _, s := range m.sessions {
+		if s.UserID == userID && s.RevokedAt == nil {
+			sessions = append(sessions, *s)
+		}
+	}
+	return sessions, nil
+}
+
+func (m *memSessions) RevokeSession(ctx context.Context, userID, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok || s.UserID != userID || s.RevokedAt != nil {
+		return db.ErrSessionNotFound
+	}
+	now := time.Now()
+	s.RevokedAt = &now
+	return nil
+}
+
+func (m *memSessions) RevokeUserSessions(ctx context.Context, userID string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ids []string
+	now := time.Now()
+	for _, s := range m.sessions {
+		if s.UserID == userID && s.RevokedAt == nil {
+			s.RevokedAt = &now
+			ids = append(ids, s.ID)
+		}
+	}
+	return ids, nil
+}
+
+func (m *memSessions) DeleteExpiredSessions(ctx context.Context, before time.Time) (int64, error) {
+	return 0, nil
+}
+
+func newSessionService(t *testing.T) (*Service, *memSessions) {
+	s, _ := newTestService(t, DelegationOff)
+	store := newMemSessions()
+	s.SetSessionStore(store)
+	return s, store
+}
+
+// accessToken issues an access token for a session like the login handler
+func accessToken(t *testing.T, s *Service, session *db.Session) string {
+	t.Helper()
+	token, err := s.GenerateToken(session.UserID, map[string]interface{}{SessionClaim: session.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestRefreshRotation(t *testing.T) {
+	ctx := context.Background()
+	s, store := newSessionService(t)
+
+	session, first, err := s.CreateSession(ctx, "user-1", "curl/8.0", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.sessions[session.ID].RefreshHash == first {
+		t.Error("refresh token was stored in plain text")
+	}
+
+	// A refresh token is exchanged for a new one
+	refreshed, second, err := s.Refresh(ctx, first, "10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshed.ID != session.ID || second == first || refreshed.IP != "10.0.0.2" {
+		t.Errorf("unexpected refresh result %+v", refreshed)
+	}
+
+	// The new token works once more
+	_, third, err := s.Refresh(ctx, second, "10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	access := accessToken(t, s, session)
+	if _, _, err := s.ValidateToken(access); err != nil {
+		t.Fatalf("access token of an active session rejected: %v", err)
+	}
+
+	// Reusing a rotated token revokes the session and its access tokens
+	if _, _, err := s.Refresh(ctx, second, "10.0.0.3"); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Errorf("expected ErrRefreshTokenReused, got %v", err)
+	}
+	if _, _, err := s.Refresh(ctx, third, "10.0.0.2"); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("expected the current token of a revoked session to fail, got %v", err)
+	}
+	if _, _, err := s.ValidateToken(access); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected the access token of a revoked session to fail, got %v", err)
+	}
+
+	if _, _, err := s.Refresh(ctx, "unknown", "10.0.0.2"); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("expected ErrInvalidRefreshToken, got %v", err)
+	}
+}
+
+func TestRefreshExpired(t *testing.T) {
+	ctx := context.Background()
+	s, store := newSessionService(t)
+
+	session, token, err := s.CreateSession(ctx, "user-1", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.sessions[session.ID].ExpiresAt = time.Now().Add(-time.Minute)
+
+	if _, _, err := s.Refresh(ctx, token, ""); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("expected ErrInvalidRefreshToken, got %v", err)
+	}
+}
+
+func TestRevokeSessions(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newSessionService(t)
+
+	laptop, _, err := s.CreateSession(ctx, "user-1", "laptop", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	phone, phoneRefresh, err := s.CreateSession(ctx, "user-1", "phone", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := s.CreateSession(ctx, "user-2", "laptop", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Users can only revoke their own sessions
+	if err := s.RevokeSession(ctx, "user-2", laptop.ID); !errors.Is(err, db.ErrSessionNotFound) {
+		t.Errorf("expected ErrSessionNotFound, got %v", err)
+	}
+	if err := s.RevokeSession(ctx, "user-1", laptop.ID); err != nil {
+		t.Fatal(err)
+	}
+	sessions, _ := s.ListSessions(ctx, "user-1")
+	if len(sessions) != 1 || sessions[0].ID != phone.ID {
+		t.Errorf("expected only the phone session, got %+v", sessions)
+	}
+
+	// Logging out everywhere ends the remaining sessions of the user only
+	phoneAccess := accessToken(t, s, phone)
+	otherAccess := accessToken(t, s, other)
+	n, err := s.RevokeUserSessions(ctx, "user-1")
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 revoked session, got %d: %v", n, err)
+	}
+	if _, _, err := s.ValidateToken(phoneAccess); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected the phone's access token to fail, got %v", err)
+	}
+	if _, _, err := s.Refresh(ctx, phoneRefresh, ""); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("expected the phone's refresh token to fail, got %v", err)
+	}
+	if _, _, err := s.ValidateToken(otherAccess); err != nil {
+		t.Errorf("another user's session was affected: %v", err)
+	}
+}
+
+func TestRevokeOtherSessions(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newSessionService(t)
+
+	current, _, err := s.CreateSession(ctx, "user-1", "laptop", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	phone, _, err := s.CreateSession(ctx, "user-1", "phone", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	currentAccess := accessToken(t, s, current)
+	phoneAccess := accessToken(t, s, phone)
+
+	n, err := s.RevokeOtherSessions(ctx, "user-1", current.ID)
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 revoked session, got %d: %v", n, err)
+	}
+	if _, _, err := s.ValidateToken(currentAccess); err != nil {
+		t.Errorf("the kept session was revoked: %v", err)
+	}
+	if _, _, err := s.ValidateToken(phoneAccess); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected the phone's access token to fail, got %v", err)
+	}
+}
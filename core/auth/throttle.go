@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ThrottleConfig configures how fast logins may be attempted
+type ThrottleConfig struct {
+	// IPRate and IPBurst limit the login attempts from one address
+	IPRate  rate.Limit
+	IPBurst int
+
+	// UsernameRate and UsernameBurst limit the login attempts for one username,
+	// from any address
+	UsernameRate  rate.Limit
+	UsernameBurst int
+
+	// BaseDelay is how long the answer to the first failed attempt is held
+	// back. It doubles with every further failure, up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	// FailureWindow is how long failures are remembered for the delay
+	FailureWindow time.Duration
+}
+
+// DefaultThrottleConfig allows a person mistyping their password plenty of
+// attempts, but only a few hundred guesses an hour per address or account
+var DefaultThrottleConfig = ThrottleConfig{
+	IPRate:        rate.Every(6 * time.Second),
+	IPBurst:       20,
+	UsernameRate:  rate.Every(12 * time.Second),
+	UsernameBurst: 10,
+	BaseDelay:     250 * time.Millisecond,
+	MaxDelay:      5 * time.Second,
+	FailureWindow: 15 * time.Minute,
+}
+
+// LoginThrottle limits login attempts per address and per username, and
+// slows down the answers to repeated failures. It only knows the attempts seen
+// by this replica; accounts are locked across replicas by the user manager.
+type LoginThrottle struct {
+	config ThrottleConfig
+	now    func() time.Time
+
+	mu        sync.Mutex
+	ips       map[string]*throttleKey
+	usernames map[string]*throttleKey
+	lastSweep time.Time
+}
+
+// throttleKey tracks the attempts of one address or username
+type throttleKey struct {
+	limiter     *rate.Limiter
+	failures    int
+	lastFailure time.Time
+	lastSeen    time.Time
+}
+
+// NewLoginThrottle creates a login throttle
+func NewLoginThrottle(config ThrottleConfig) *LoginThrottle {
+	return &LoginThrottle{
+		config:    config,
+		now:       time.Now,
+		ips:       make(map[string]*throttleKey),
+		usernames: make(map[string]*throttleKey),
+	}
+}
+
+// Allow takes an attempt from the budgets of the address and the username.
+// Without budget left, it returns how long to wait before trying again. An
+// empty username only counts against the address.
+func (t *LoginThrottle) Allow(ip, username string) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	t.sweep(now)
+
+	keys := []*throttleKey{t.key(t.ips, ip, t.config.IPRate, t.config.IPBurst, now)}
+	if username != "" {
+		keys = append(keys, t.key(t.usernames, normalizeUsername(username), t.config.UsernameRate, t.config.UsernameBurst, now))
+	}
+
+	// Only use up budget if every key has some left
+	var wait time.Duration
+	reservations := make([]*rate.Reservation, 0, len(keys))
+	for _, k := range keys {
+		r := k.limiter.ReserveN(now, 1)
+		reservations = append(reservations, r)
+		if d := r.DelayFrom(now); d > wait {
+			wait = d
+		}
+	}
+	if wait > 0 {
+		for _, r := range reservations {
+			r.CancelAt(now)
+		}
+		return wait, false
+	}
+
+	return 0, true
+}
+
+// Failure records a failed attempt and returns how long to hold back the
+// answer to it
+func (t *LoginThrottle) Failure(ip, username string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	failures := t.fail(t.key(t.ips, ip, t.config.IPRate, t.config.IPBurst, now), now)
+	if username != "" {
+		k := t.key(t.usernames, normalizeUsername(username), t.config.UsernameRate, t.config.UsernameBurst, now)
+		if n := t.fail(k, now); n > failures {
+			failures = n
+		}
+	}
+
+	delay := t.config.BaseDelay
+	for i := 1; i < failures && delay < t.config.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > t.config.MaxDelay {
+		delay = t.config.MaxDelay
+	}
+	return delay
+}
+
+// Success forgets the failures of a username once its user has logged in. The
+// failures of the address are kept, so logging into one's own account does not
+// reset the delay for guessing others.
+func (t *LoginThrottle) Success(username string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if k, ok := t.usernames[normalizeUsername(username)]; ok {
+		k.failures = 0
+	}
+}
+
+// key returns the tracked attempts of a key, creating them if needed
+func (t *LoginThrottle) key(keys map[string]*throttleKey, name string, limit rate.Limit, burst int, now time.Time) *throttleKey {
+	k, ok := keys[name]
+	if !ok {
+		k = &throttleKey{limiter: rate.NewLimiter(limit, burst)}
+		keys[name] = k
+	}
+	k.lastSeen = now
+	return k
+}
+
+// fail counts a failure of a key, starting over once the last one is older
+// than the failure window
+func (t *LoginThrottle) fail(k *throttleKey, now time.Time) int {
+	if now.Sub(k.lastFailure) > t.config.FailureWindow {
+		k.failures = 0
+	}
+	k.failures++
+	k.lastFailure = now
+	return k.failures
+}
+
+// sweep forgets keys that have not been seen for a while, at most once a minute
+func (t *LoginThrottle) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < time.Minute {
+		return
+	}
+	t.lastSweep = now
+
+	// A key idle for the failure window with a full limiter is the same as a
+	// new one
+	for _, keys := range []map[string]*throttleKey{t.ips, t.usernames} {
+		for name, k := range keys {
+			if now.Sub(k.lastSeen) > t.config.FailureWindow && k.limiter.TokensAt(now) >= float64(k.limiter.Burst()) {
+				delete(keys, name)
+			}
+		}
+	}
+}
+
+// normalizeUsername makes usernames that only differ in case share a budget
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// newTestThrottle returns a throttle with a clock the test moves forward
+func newTestThrottle(config ThrottleConfig) (*LoginThrottle, *time.Time) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	t := NewLoginThrottle(config)
+	t.now = func() time.Time { return now }
+	return t, &now
+}
+
+func TestLoginThrottleLimitsUsername(t *testing.T) {
+	throttle, now := newTestThrottle(ThrottleConfig{
+		IPRate:        rate.Every(time.Second),
+		IPBurst:       100,
+		UsernameRate:  rate.Every(time.Minute),
+		UsernameBurst: 3,
+	})
+
+	for i := 0; i < 3; i++ {
+		if _, ok := throttle.Allow("10.0.0.1", "alice"); !ok {
+			t.Fatalf("attempt %d was throttled", i+1)
+		}
+	}
+
+	// Other addresses cannot continue guessing the same account, whatever the case
+	wait, ok := throttle.Allow("10.0.0.2", "ALICE")
+	if ok {
+		t.Fatal("expected the fourth attempt for alice to be throttled")
+	}
+	if wait != time.Minute {
+		t.Errorf("got wait %s, want 1m", wait)
+	}
+
+	// Other accounts are not affected, and the refused attempt used up nothing
+	if _, ok := throttle.Allow("10.0.0.2", "bob"); !ok {
+		t.Error("expected bob to be allowed")
+	}
+	*now = now.Add(time.Minute)
+	if _, ok := throttle.Allow("10.0.0.1", "alice"); !ok {
+		t.Error("expected alice to be allowed once the budget refilled")
+	}
+}
+
+func TestLoginThrottleLimitsIP(t *testing.T) {
+	throttle, _ := newTestThrottle(ThrottleConfig{
+		IPRate:        rate.Every(time.Minute),
+		IPBurst:       2,
+		UsernameRate:  rate.Every(time.Second),
+		UsernameBurst: 100,
+	})
+
+	throttle.Allow("10.0.0.1", "alice")
+	throttle.Allow("10.0.0.1", "bob")
+	if _, ok := throttle.Allow("10.0.0.1", "carol"); ok {
+		t.Error("expected the address to be throttled across usernames")
+	}
+	if _, ok := throttle.Allow("10.0.0.2", "carol"); !ok {
+		t.Error("expected another address to be allowed")
+	}
+}
+
+func TestLoginThrottleDelay(t *testing.T) {
+	throttle, now := newTestThrottle(ThrottleConfig{
+		IPRate:        rate.Inf,
+		UsernameRate:  rate.Inf,
+		BaseDelay:     100 * time.Millisecond,
+		MaxDelay:      time.Second,
+		FailureWindow: 10 * time.Minute,
+	})
+
+	// The delay doubles with every failure, up to the maximum
+	for _, want := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		if got := throttle.Failure("10.0.0.1", "alice"); got != want*time.Millisecond {
+			t.Errorf("got delay %s, want %s", got, want*time.Millisecond)
+		}
+	}
+
+	// Logging in forgets the failures of the account, but not of the address
+	throttle.Success("alice")
+	if got := throttle.Failure("10.0.0.2", "alice"); got != 100*time.Millisecond {
+		t.Errorf("got delay %s after a login, want 100ms", got)
+	}
+	if got := throttle.Failure("10.0.0.1", "bob"); got != time.Second {
+		t.Errorf("got delay %s for the failing address, want 1s", got)
+	}
+
+	// Failures older than the window no longer count
+	*now = now.Add(11 * time.Minute)
+	if got := throttle.Failure("10.0.0.1", "alice"); got != 100*time.Millisecond {
+		t.Errorf("got delay %s after the window, want 100ms", got)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"pixie/db"
+	"pixie/plugin/conformance"
+	"pixie/plugin/loader"
+	"pixie/storage"
+	"pixie/user"
+)
+
+// usage describes the subcommands of the core binary
+const usage = `Usage:
+  pixie-core                          Run the server
+  pixie-core plugin test [-v] <dir>   Check that the plugin in <dir> conforms to the plugin contract
+  pixie-core plugin cert <name> <dir> Write a certificate for the remote plugin <name> to <dir>
+  pixie-core admin reset [-username name]
+                                      Give a user the admin role and a one-time password, creating it if needed
+  pixie-core admin backfill-usage [-owner name]
+                                      Record the sizes of photos stored before quotas, give photos without an
+                                      owner to a user, and recount the storage each user uses
+`
+
+// commands are the first words of the subcommands
+var commands = map[string]bool{"plugin": true, "admin": true}
+
+// isCommand reports whether args name a subcommand rather than arguments of
+// the server, which ignores them
+func isCommand(args []string) bool {
+	return len(args) > 0 && commands[args[0]]
+}
+
+// runCommand runs the subcommand in args and returns the exit code
+func runCommand(args []string) int {
+	if len(args) >= 2 && args[0] == "plugin" && args[1] == "test" {
+		return pluginTestCommand(args[2:])
+	}
+	if len(args) >= 2 && args[0] == "plugin" && args[1] == "cert" {
+		return pluginCertCommand(args[2:])
+	}
+	if len(args) >= 2 && args[0] == "admin" && args[1] == "reset" {
+		return adminResetCommand(args[2:])
+	}
+	if len(args) >= 2 && args[0] == "admin" && args[1] == "backfill-usage" {
+		return backfillUsageCommand(args[2:])
+	}
+	fmt.Fprint(os.Stderr, usage)
+	return 2
+}
+
+// pluginTestCommand runs the conformance checks against a plugin directory
+func pluginTestCommand(args []string) int {
+	flags := flag.NewFlagSet("plugin test", flag.ContinueOnError)
+	verbose := flags.Bool("v", false, "Print core and plugin logs while testing")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	// Keep the plugin's working directory and socket out of the real ones
+	dir, err := os.MkdirTemp("", "pixie-plugin-test-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create temporary directory: %v\n", err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("PLUGIN_DATA_DIR", dir+"/data")
+	os.Setenv("PLUGIN_SOCKET_DIR", dir+"/sockets")
+
+	// Logs are only shown for failed runs unless asked for
+	var logs bytes.Buffer
+	if !*verbose {
+		log.SetOutput(&logs)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	report := conformance.Run(ctx, flags.Arg(0))
+	log.SetOutput(os.Stderr)
+
+	report.Write(os.Stdout)
+	if !report.Passed() {
+		if logs.Len() > 0 {
+			fmt.Printf("\nLogs:\n%s", logs.String())
+		}
+		return 1
+	}
+	return 0
+}
+
+// pluginCertCommand issues the certificate a plugin running outside core
+// registers and serves with, from the CA in PLUGIN_CA_CERT_FILE and PLUGIN_CA_KEY_FILE
+func pluginCertCommand(args []string) int {
+	if len(args) != 2 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	if err := loader.IssueRemoteCert(args[0], args[1]); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to issue plugin certificate: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Wrote ca.pem, cert.pem and key.pem for %s to %s\n", args[0], args[1])
+	return 0
+}
+
+// adminResetCommand regains access to a server whose admins are locked out. It
+// talks to the database directly, so only someone who can run commands next to
+// the server can use it.
+func adminResetCommand(args []string) int {
+	flags := flag.NewFlagSet("admin reset", flag.ContinueOnError)
+	username := flags.String("username", "admin", "User to make an admin")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	dbInstance, err := db.New(ctx, db.Config{URL: getEnv("DATABASE_URL", defaultDatabaseURL)})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to database: %v\n", err)
+		return 1
+	}
+	defer dbInstance.Close()
+
+	// The database may predate the columns the reset sets
+	userMgr := user.NewManager(dbInstance.Pool)
+	if err := dbInstance.InitSchema(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize database schema: %v\n", err)
+		return 1
+	}
+	if err := userMgr.InitSchema(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize user schema: %v\n", err)
+		return 1
+	}
+
+	reset, err := userMgr.ResetAdmin(ctx, *username)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to reset admin: %v\n", err)
+		return 1
+	}
+
+	// Whoever held the account's sessions loses them, along with their access
+	// tokens
+	ids, err := dbInstance.RevokeUserSessions(ctx, reset.User.ID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to revoke sessions: %v\n", err)
+		return 1
+	}
+	expiresAt := time.Now().Add(getDurationEnv("ACCESS_TOKEN_TTL", 15*time.Minute))
+	for _, id := range ids {
+		if err := dbInstance.RevokeToken(ctx, id, expiresAt); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to revoke session: %v\n", err)
+			return 1
+		}
+	}
+
+	if reset.Created {
+		fmt.Printf("Created admin user %s\n", reset.User.Username)
+	} else {
+		fmt.Printf("Reset admin user %s: reactivated, unlocked, second factors removed, %d sessions ended\n", reset.User.Username, len(ids))
+	}
+	fmt.Printf("One-time password: %s\n", reset.Password)
+	fmt.Println("The password must be changed at the next login.")
+	return 0
+}
+
+// backfillUsageCommand accounts for the photos uploaded before photos had
+// owners and sizes. Sizes are read from storage; the photos are given to the
+// user named by -owner, and stay unaccounted without it. Derivatives made
+// before then are not known and stay unaccounted either way.
+func backfillUsageCommand(args []string) int {
+	flags := flag.NewFlagSet("admin backfill-usage", flag.ContinueOnError)
+	owner := flags.String("owner", "", "User to give the photos without an owner to")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	dbInstance, err := db.New(ctx, db.Config{URL: getEnv("DATABASE_URL", defaultDatabaseURL)})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to database: %v\n", err)
+		return 1
+	}
+	defer dbInstance.Close()
+	if err := dbInstance.InitSchema(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize database schema: %v\n", err)
+		return 1
+	}
+
+	s3Storage, err := storage.New(ctx, storage.Config{
+		Endpoint:  getEnv("S3_ENDPOINT", "http://minio:9000"),
+		AccessKey: getEnv("S3_ACCESS_KEY", "minio"),
+		SecretKey: getEnv("S3_SECRET_KEY", "minio123"),
+		Bucket:    getEnv("S3_BUCKET", "pixie"),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to storage: %v\n", err)
+		return 1
+	}
+
+	updated, failed, err := dbInstance.BackfillPhotoSizes(ctx, s3Storage.ObjectSize)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to backfill photo sizes: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Recorded the size of %d photos\n", updated)
+	if failed > 0 {
+		fmt.Printf("Could not read the size of %d photos from storage, see the log\n", failed)
+	}
+
+	if *owner != "" {
+		userMgr := user.NewManager(dbInstance.Pool)
+		u, err := userMgr.GetUserByUsername(ctx, *owner)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to find user %s: %v\n", *owner, err)
+			return 1
+		}
+		n, err := dbInstance.AssignPhotoOwner(ctx, u.ID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to assign photos: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Gave %d photos without an owner to %s\n", n, u.Username)
+	}
+
+	if err := dbInstance.RecalculateUsage(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to recalculate storage usage: %v\n", err)
+		return 1
+	}
+	fmt.Println("Recalculated the storage usage of every user")
+	return 0
+}
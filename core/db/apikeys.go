@@ -0,0 +1,147 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrAPIKeyNotFound is returned when an API key does not exist
+var ErrAPIKeyNotFound = errors.New("API key not found")
+
+// APIKey is a long-lived credential a user created for a script or sync client
+type APIKey struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+
+	// Hash is the hash of the secret, which is never stored
+	Hash string `json:"-"`
+
+	// Role and UserActive are those of the key's user when the key was looked up
+	Role       string `json:"-"`
+	UserActive bool   `json:"-"`
+}
+
+// initAPIKeySchema creates the api_keys table
+func (db *DB) initAPIKeySchema(ctx context.Context) error {
+	_, err := db.Pool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS api_keys (
+			id UUID PRIMARY KEY,
+			user_id UUID NOT NULL,
+			name TEXT NOT NULL,
+			prefix TEXT NOT NULL,
+			key_hash TEXT UNIQUE NOT NULL,
+			scopes TEXT[] NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			expires_at TIMESTAMPTZ,
+			last_used_at TIMESTAMPTZ
+		);
+		CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create api_keys table: %w", err)
+	}
+
+	return nil
+}
+
+// CreateAPIKey inserts a new API key
+func (db *DB) CreateAPIKey(ctx context.Context, k *APIKey) error {
+	_, err := db.Pool.Exec(ctx, `
+		INSERT INTO api_keys (id, user_id, name, prefix, key_hash, scopes, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, k.ID, k.UserID, k.Name, k.Prefix, k.Hash, k.Scopes, k.CreatedAt, k.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert API key: %w", err)
+	}
+
+	return nil
+}
+
+// GetAPIKeyByHash retrieves the API key with the given hash together with the
+// role of its user. Keys of deleted users are not found.
+func (db *DB) GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error) {
+	var k APIKey
+	var active *bool
+	err := db.Pool.QueryRow(ctx, `
+		SELECT k.id, k.user_id, k.name, k.prefix, k.key_hash, k.scopes, k.created_at, k.expires_at, k.last_used_at,
+			u.role, u.active
+		FROM api_keys k JOIN users u ON u.id = k.user_id
+		WHERE k.key_hash = $1
+	`, hash).Scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, &k.Hash, &k.Scopes, &k.CreatedAt, &k.ExpiresAt, &k.LastUsedAt,
+		&k.Role, &active)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+
+	k.UserActive = active == nil || *active
+	return &k, nil
+}
+
+// ListAPIKeys retrieves the API keys of a user, newest first
+func (db *DB) ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT id, user_id, name, prefix, scopes, created_at, expires_at, last_used_at
+		FROM api_keys WHERE user_id = $1
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query API keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var k APIKey
+		if err := rows.Scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, &k.Scopes, &k.CreatedAt, &k.ExpiresAt, &k.LastUsedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan API key: %w", err)
+		}
+		keys = append(keys, k)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating API keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// DeleteAPIKey deletes an API key of a user
+func (db *DB) DeleteAPIKey(ctx context.Context, userID, id string) error {
+	result, err := db.Pool.Exec(ctx, `
+		DELETE FROM api_keys WHERE id = $1 AND user_id = $2
+	`, id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete API key: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+// TouchAPIKey records when an API key was last used
+func (db *DB) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	_, err := db.Pool.Exec(ctx, `
+		UPDATE api_keys SET last_used_at = $2 WHERE id = $1
+	`, id, usedAt)
+	if err != nil {
+		return fmt.Errorf("failed to update API key: %w", err)
+	}
+
+	return nil
+}
@@ -3,6 +3,7 @@ package db
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"time"
@@ -76,13 +77,41 @@ func (db *DB) InitSchema(ctx context.Context) error {
 	
 	// Note: User table creation is now handled by the user.Manager
 
+	// Create the plugin_settings table if it doesn't exist
+	_, err = db.Pool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS plugin_settings (
+			name TEXT PRIMARY KEY,
+			enabled BOOLEAN NOT NULL DEFAULT TRUE,
+			settings JSONB,
+			updated_at TIMESTAMPTZ DEFAULT NOW()
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create plugin_settings table: %w", err)
+	}
+
+	// Create the sessions table if it doesn't exist
+	if err := db.initSessionSchema(ctx); err != nil {
+		return err
+	}
+
+	// Create the revoked_tokens table if it doesn't exist
+	if err := db.initRevocationSchema(ctx); err != nil {
+		return err
+	}
+
+	// Create the api_keys table if it doesn't exist
+	if err := db.initAPIKeySchema(ctx); err != nil {
+		return err
+	}
+
 	// Check if deleted_at column exists, add if not
 	var columnExists bool
 	err = db.Pool.QueryRow(ctx, `
 		SELECT EXISTS (
 			SELECT 1 
 			FROM information_schema.columns 
-			WHERE table_name = 'photos' AND column_name = 'deleted_at'
+			WHERE table_schema = current_schema() AND table_name = 'photos' AND column_name = 'deleted_at'
 		);
 	`).Scan(&columnExists)
 
@@ -106,7 +135,7 @@ func (db *DB) InitSchema(ctx context.Context) error {
 		SELECT EXISTS (
 			SELECT 1 
 			FROM information_schema.columns 
-			WHERE table_name = 'photos' AND column_name = 'status'
+			WHERE table_schema = current_schema() AND table_name = 'photos' AND column_name = 'status'
 		);
 	`).Scan(&columnExists)
 
@@ -125,17 +154,9 @@ func (db *DB) InitSchema(ctx context.Context) error {
 		log.Println("Added status column to photos table")
 	}
 
-	return nil
-}
-
-// SavePhoto saves a photo's metadata to the database
-func (db *DB) SavePhoto(ctx context.Context, id, s3Key, filename, mime string) error {
-	_, err := db.Pool.Exec(ctx, `
-		INSERT INTO photos (id, s3_key, filename, mime)
-		VALUES ($1, $2, $3, $4)
-	`, id, s3Key, filename, mime)
-	if err != nil {
-		return fmt.Errorf("failed to insert photo: %w", err)
+	// Photo owners and the storage they use
+	if err := db.initUsageSchema(ctx); err != nil {
+		return err
 	}
 
 	return nil
@@ -154,17 +175,31 @@ func (db *DB) GetPhoto(ctx context.Context, id string) (string, string, error) {
 	return s3Key, mime, nil
 }
 
+// PhotoOwner returns the ID of the user who uploaded a photo, or an empty
+// string if the photo has no owner
+func (db *DB) PhotoOwner(ctx context.Context, id string) (string, error) {
+	var ownerID *string
+	err := db.Pool.QueryRow(ctx, `SELECT owner_id FROM photos WHERE id = $1`, id).Scan(&ownerID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrPhotoNotFound{ID: id}
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get photo owner: %w", err)
+	}
+	if ownerID == nil {
+		return "", nil
+	}
+	return *ownerID, nil
+}
+
 // DeletePhoto deletes a photo's metadata from the database
 func (db *DB) DeletePhoto(ctx context.Context, id string) error {
-	result, err := db.Pool.Exec(ctx, `
-		DELETE FROM photos WHERE id = $1
-	`, id)
+	rowsAffected, err := db.purgePhotos(ctx, `id = $1`, id)
 	if err != nil {
 		return fmt.Errorf("failed to delete photo: %w", err)
 	}
 
 	// Check if any rows were affected
-	rowsAffected := result.RowsAffected()
 	if rowsAffected == 0 {
 		return fmt.Errorf("photo not found: %s", id)
 	}
@@ -182,6 +217,10 @@ type Photo struct {
 	DeletedAt *time.Time             `json:"deleted_at,omitempty"`
 	Status    string                 `json:"status"`
 	Meta      map[string]interface{} `json:"meta,omitempty"`
+
+	// OwnerID is the user who uploaded the photo, empty for photos uploaded
+	// before photos had owners
+	OwnerID string `json:"owner_id,omitempty"`
 }
 
 // TrashPhoto moves a photo to trash by updating its status and setting deleted_at
@@ -224,32 +263,30 @@ func (db *DB) RestorePhoto(ctx context.Context, id string) error {
 	return nil
 }
 
-// EmptyTrash permanently deletes all photos in trash
-func (db *DB) EmptyTrash(ctx context.Context) (int64, error) {
-	result, err := db.Pool.Exec(ctx, `
-		DELETE FROM photos 
-		WHERE status = 'trashed'
-	`)
+// EmptyTrash permanently deletes the photos in the trash of ownerID, or of
+// every user if ownerID is empty
+func (db *DB) EmptyTrash(ctx context.Context, ownerID string) (int64, error) {
+	condition, args := `status = 'trashed'`, []interface{}{}
+	if ownerID != "" {
+		condition, args = `status = 'trashed' AND owner_id = $1`, append(args, ownerID)
+	}
+	count, err := db.purgePhotos(ctx, condition, args...)
 	if err != nil {
 		return 0, fmt.Errorf("failed to empty trash: %w", err)
 	}
 
 	// Return number of photos deleted
-	return result.RowsAffected(), nil
+	return count, nil
 }
 
 // PermanentlyDeletePhoto permanently deletes a specific photo from trash
 func (db *DB) PermanentlyDeletePhoto(ctx context.Context, id string) error {
-	result, err := db.Pool.Exec(ctx, `
-		DELETE FROM photos 
-		WHERE id = $1 AND status = 'trashed'
-	`, id)
+	rowsAffected, err := db.purgePhotos(ctx, `id = $1 AND status = 'trashed'`, id)
 	if err != nil {
 		return fmt.Errorf("failed to permanently delete photo: %w", err)
 	}
 
 	// Check if any rows were affected
-	rowsAffected := result.RowsAffected()
 	if rowsAffected == 0 {
 		return fmt.Errorf("photo not found in trash: %s", id)
 	}
@@ -257,12 +294,13 @@ func (db *DB) PermanentlyDeletePhoto(ctx context.Context, id string) error {
 	return nil
 }
 
-// ListTrashedPhotos retrieves all photos from trash
-func (db *DB) ListTrashedPhotos(ctx context.Context) ([]Photo, error) {
+// ListTrashedPhotos retrieves the photos in the trash of ownerID, or of every
+// user if ownerID is empty
+func (db *DB) ListTrashedPhotos(ctx context.Context, ownerID string) ([]Photo, error) {
 	// Get column information from the database
 	columnsInfo, err := db.Pool.Query(ctx, `
 		SELECT column_name FROM information_schema.columns 
-		WHERE table_name = 'photos'
+		WHERE table_schema = current_schema() AND table_name = 'photos'
 		ORDER BY ordinal_position
 	`)
 	if err != nil {
@@ -304,10 +342,15 @@ func (db *DB) ListTrashedPhotos(ctx context.Context) ([]Photo, error) {
 		sqlQuery += `, NULL as deleted_at`
 	}
 	
-	sqlQuery += `, status, meta
+	sqlQuery += `, status, meta, COALESCE(owner_id, '')
 		FROM photos
 		WHERE status = 'trashed'
 	`
+	var args []interface{}
+	if ownerID != "" {
+		sqlQuery += ` AND owner_id = $1`
+		args = append(args, ownerID)
+	}
 	
 	// Order by deleted_at if it exists, otherwise by created_at
 	if hasDeletedAt {
@@ -316,7 +359,7 @@ func (db *DB) ListTrashedPhotos(ctx context.Context) ([]Photo, error) {
 		sqlQuery += ` ORDER BY created_at DESC`
 	}
 
-	rows, err := db.Pool.Query(ctx, sqlQuery)
+	rows, err := db.Pool.Query(ctx, sqlQuery, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query trashed photos: %w", err)
 	}
@@ -327,7 +370,7 @@ func (db *DB) ListTrashedPhotos(ctx context.Context) ([]Photo, error) {
 		var photo Photo
 		var meta []byte
 		if err := rows.Scan(&photo.ID, &photo.S3Key, &photo.Filename, &photo.Mime, &photo.CreatedAt, 
-			&photo.DeletedAt, &photo.Status, &meta); err != nil {
+			&photo.DeletedAt, &photo.Status, &meta, &photo.OwnerID); err != nil {
 			return nil, fmt.Errorf("failed to scan photo: %w", err)
 		}
 		
@@ -353,7 +396,7 @@ func (db *DB) ListPhotos(ctx context.Context) ([]Photo, error) {
 	// Get column information from the database
 	columnsInfo, err := db.Pool.Query(ctx, `
 		SELECT column_name FROM information_schema.columns 
-		WHERE table_name = 'photos'
+		WHERE table_schema = current_schema() AND table_name = 'photos'
 		ORDER BY ordinal_position
 	`)
 	if err != nil {
@@ -392,7 +435,7 @@ func (db *DB) ListPhotos(ctx context.Context) ([]Photo, error) {
 			sqlQuery += `, NULL as deleted_at`
 		}
 		
-		sqlQuery += `, status, meta
+		sqlQuery += `, status, meta, COALESCE(owner_id, '')
 			FROM photos
 			WHERE status = 'active' OR status IS NULL
 			ORDER BY created_at DESC
@@ -400,7 +443,7 @@ func (db *DB) ListPhotos(ctx context.Context) ([]Photo, error) {
 	} else {
 		// If status column doesn't exist, select all photos
 		sqlQuery = `
-			SELECT id, s3_key, filename, mime, created_at, NULL as deleted_at, 'active' as status, meta
+			SELECT id, s3_key, filename, mime, created_at, NULL as deleted_at, 'active' as status, meta, COALESCE(owner_id, '')
 			FROM photos
 			ORDER BY created_at DESC
 		`
@@ -416,7 +459,7 @@ func (db *DB) ListPhotos(ctx context.Context) ([]Photo, error) {
 		var photo Photo
 		var meta []byte
 		if err := rows.Scan(&photo.ID, &photo.S3Key, &photo.Filename, &photo.Mime, &photo.CreatedAt, 
-			&photo.DeletedAt, &photo.Status, &meta); err != nil {
+			&photo.DeletedAt, &photo.Status, &meta, &photo.OwnerID); err != nil {
 			return nil, fmt.Errorf("failed to scan photo: %w", err)
 		}
 		
@@ -20,8 +20,8 @@ func NewMock() *MockDB {
 	}
 }
 
-// SavePhoto saves a photo's metadata to the mock database
-func (m *MockDB) SavePhoto(ctx context.Context, id, s3Key, filename, mime string) error {
+// SavePhoto saves a photo's metadata to the mock database; it has no quotas
+func (m *MockDB) SavePhoto(ctx context.Context, id, s3Key, filename, mime, ownerID string, size, quota int64) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
@@ -31,6 +31,7 @@ func (m *MockDB) SavePhoto(ctx context.Context, id, s3Key, filename, mime string
 		Filename:  filename,
 		Mime:      mime,
 		CreatedAt: time.Now(),
+		OwnerID:   ownerID,
 	}
 
 	return nil
@@ -49,6 +50,19 @@ func (m *MockDB) GetPhoto(ctx context.Context, id string) (string, string, error
 	return photo.S3Key, photo.Mime, nil
 }
 
+// PhotoOwner returns the ID of the user who uploaded a photo
+func (m *MockDB) PhotoOwner(ctx context.Context, id string) (string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	photo, ok := m.photos[id]
+	if !ok {
+		return "", ErrPhotoNotFound{ID: id}
+	}
+
+	return photo.OwnerID, nil
+}
+
 // DeletePhoto deletes a photo's metadata from the mock database
 func (m *MockDB) DeletePhoto(ctx context.Context, id string) error {
 	m.mutex.Lock()
@@ -75,6 +89,61 @@ func (m *MockDB) ListPhotos(ctx context.Context) ([]Photo, error) {
 	return photos, nil
 }
 
+// TrashPhoto moves a photo to the trash of the mock database
+func (m *MockDB) TrashPhoto(ctx context.Context, id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	photo, ok := m.photos[id]
+	if !ok || photo.Status == "trashed" {
+		return ErrPhotoNotFound{ID: id}
+	}
+
+	now := time.Now()
+	photo.Status = "trashed"
+	photo.DeletedAt = &now
+	return nil
+}
+
+// ListTrashedPhotos retrieves the photos in the trash of ownerID, or of every
+// user if ownerID is empty
+func (m *MockDB) ListTrashedPhotos(ctx context.Context, ownerID string) ([]Photo, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var photos []Photo
+	for _, photo := range m.photos {
+		if photo.Status == "trashed" && (ownerID == "" || photo.OwnerID == ownerID) {
+			photos = append(photos, *photo)
+		}
+	}
+
+	return photos, nil
+}
+
+// EmptyTrash deletes the photos in the trash of ownerID, or of every user if
+// ownerID is empty
+func (m *MockDB) EmptyTrash(ctx context.Context, ownerID string) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var count int64
+	for id, photo := range m.photos {
+		if photo.Status == "trashed" && (ownerID == "" || photo.OwnerID == ownerID) {
+			delete(m.photos, id)
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// DerivativeKeys returns the storage keys of a photo's derivatives, of which
+// the mock database records none
+func (m *MockDB) DerivativeKeys(ctx context.Context, photoID string) ([]string, error) {
+	return nil, nil
+}
+
 // ErrPhotoNotFound is returned when a photo is not found
 type ErrPhotoNotFound struct {
 	ID string
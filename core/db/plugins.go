@@ -0,0 +1,161 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// PluginState holds the persisted runtime state of a plugin
+type PluginState struct {
+	Name      string                 `json:"name"`
+	Enabled   bool                   `json:"enabled"`
+	Settings  map[string]interface{} `json:"settings"`
+	UpdatedAt time.Time              `json:"updated_at"`
+}
+
+// ListPluginStates retrieves the persisted state of all plugins, keyed by plugin name
+func (db *DB) ListPluginStates(ctx context.Context) (map[string]PluginState, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT name, enabled, settings, updated_at FROM plugin_settings
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query plugin settings: %w", err)
+	}
+	defer rows.Close()
+
+	states := make(map[string]PluginState)
+	for rows.Next() {
+		var state PluginState
+		var settings []byte
+		if err := rows.Scan(&state.Name, &state.Enabled, &settings, &state.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan plugin settings: %w", err)
+		}
+
+		// Parse the settings JSON if it's not null
+		if settings != nil {
+			if err := json.Unmarshal(settings, &state.Settings); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal plugin settings: %w", err)
+			}
+		}
+
+		states[state.Name] = state
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating plugin settings: %w", err)
+	}
+
+	return states, nil
+}
+
+// SetPluginEnabled persists whether a plugin is enabled
+func (db *DB) SetPluginEnabled(ctx context.Context, name string, enabled bool) error {
+	_, err := db.Pool.Exec(ctx, `
+		INSERT INTO plugin_settings (name, enabled, updated_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (name) DO UPDATE SET enabled = $2, updated_at = NOW()
+	`, name, enabled)
+	if err != nil {
+		return fmt.Errorf("failed to save plugin state: %w", err)
+	}
+
+	return nil
+}
+
+// SavePluginSettings persists the settings of a plugin
+func (db *DB) SavePluginSettings(ctx context.Context, name string, settings map[string]interface{}) error {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("failed to marshal plugin settings: %w", err)
+	}
+
+	_, err = db.Pool.Exec(ctx, `
+		INSERT INTO plugin_settings (name, settings, updated_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (name) DO UPDATE SET settings = $2, updated_at = NOW()
+	`, name, data)
+	if err != nil {
+		return fmt.Errorf("failed to save plugin settings: %w", err)
+	}
+
+	return nil
+}
+
+// PatchPluginMeta merges patch into meta.plugins.<plugin> of a photo using JSON
+// merge patch semantics: objects are merged and null removes a key
+func (db *DB) PatchPluginMeta(ctx context.Context, id, plugin string, patch map[string]interface{}) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock the row so concurrent patches are not lost
+	var data []byte
+	err = tx.QueryRow(ctx, `
+		SELECT meta FROM photos WHERE id = $1 FOR UPDATE
+	`, id).Scan(&data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrPhotoNotFound{ID: id}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get photo metadata: %w", err)
+	}
+
+	meta := make(map[string]interface{})
+	if data != nil {
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return fmt.Errorf("failed to unmarshal photo metadata: %w", err)
+		}
+	}
+
+	plugins, _ := meta["plugins"].(map[string]interface{})
+	if plugins == nil {
+		plugins = make(map[string]interface{})
+	}
+	plugins[plugin] = mergePatch(plugins[plugin], patch)
+	meta["plugins"] = plugins
+
+	data, err = json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("failed to marshal photo metadata: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE photos SET meta = $1 WHERE id = $2
+	`, data, id); err != nil {
+		return fmt.Errorf("failed to update photo metadata: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit photo metadata: %w", err)
+	}
+
+	return nil
+}
+
+// mergePatch applies an RFC 7386 JSON merge patch to target
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = make(map[string]interface{})
+	}
+	for k, v := range patchObj {
+		if v == nil {
+			delete(targetObj, k)
+		} else {
+			targetObj[k] = mergePatch(targetObj[k], v)
+		}
+	}
+	return targetObj
+}
@@ -0,0 +1,31 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	target := map[string]interface{}{
+		"thumbnails": map[string]interface{}{"256": "a", "512": "b"},
+		"faces":      float64(2),
+	}
+	patch := map[string]interface{}{
+		"thumbnails": map[string]interface{}{"512": "c", "1024": "d"},
+		"faces":      nil,
+		"labels":     []interface{}{"cat"},
+	}
+
+	got := mergePatch(target, patch)
+	want := map[string]interface{}{
+		"thumbnails": map[string]interface{}{"256": "a", "512": "c", "1024": "d"},
+		"labels":     []interface{}{"cat"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mergePatch = %v, want %v", got, want)
+	}
+
+	if got := mergePatch(nil, map[string]interface{}{"a": "b"}); !reflect.DeepEqual(got, map[string]interface{}{"a": "b"}) {
+		t.Errorf("mergePatch into nil = %v", got)
+	}
+}
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// initRevocationSchema creates the table of revoked tokens and sessions
+func (db *DB) initRevocationSchema(ctx context.Context) error {
+	_, err := db.Pool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS revoked_tokens (
+			id TEXT PRIMARY KEY,
+			expires_at TIMESTAMPTZ NOT NULL,
+			revoked_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS revoked_tokens_expires_at_idx ON revoked_tokens (expires_at);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create revoked_tokens table: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeToken records the jti of a revoked token, or the ID of a revoked session,
+// until the given expiry
+func (db *DB) RevokeToken(ctx context.Context, id string, expiresAt time.Time) error {
+	_, err := db.Pool.Exec(ctx, `
+		INSERT INTO revoked_tokens (id, expires_at)
+		VALUES ($1, $2)
+		ON CONFLICT (id) DO UPDATE SET expires_at = GREATEST(revoked_tokens.expires_at, $2)
+	`, id, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+
+	return nil
+}
+
+// IsTokenRevoked reports whether a token or session ID has been revoked
+func (db *DB) IsTokenRevoked(ctx context.Context, id string) (bool, error) {
+	var revoked bool
+	err := db.Pool.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE id = $1)
+	`, id).Scan(&revoked)
+	if err != nil {
+		return false, fmt.Errorf("failed to check revoked token: %w", err)
+	}
+
+	return revoked, nil
+}
+
+// DeleteExpiredRevocations removes revocations of tokens that have expired
+func (db *DB) DeleteExpiredRevocations(ctx context.Context) (int64, error) {
+	result, err := db.Pool.Exec(ctx, `
+		DELETE FROM revoked_tokens WHERE expires_at < NOW()
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired revocations: %w", err)
+	}
+
+	return result.RowsAffected(), nil
+}
@@ -0,0 +1,211 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrSessionNotFound is returned when a session does not exist, has been revoked
+// or has expired
+var ErrSessionNotFound = errors.New("session not found")
+
+// Session is a login of a user on a device, kept alive by its refresh token
+type Session struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id"`
+	Device     string     `json:"device"`
+	IP         string     `json:"ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+
+	// RefreshHash is the hash of the current refresh token and PreviousHash that
+	// of the token it replaced, which must never be presented again
+	RefreshHash  string `json:"-"`
+	PreviousHash string `json:"-"`
+}
+
+// initSessionSchema creates the sessions table. Sessions are not tied to the
+// users table with a foreign key because that is created later by user.Manager.
+func (db *DB) initSessionSchema(ctx context.Context) error {
+	_, err := db.Pool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS sessions (
+			id UUID PRIMARY KEY,
+			user_id UUID NOT NULL,
+			refresh_hash TEXT UNIQUE NOT NULL,
+			previous_hash TEXT,
+			device TEXT,
+			ip TEXT,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			expires_at TIMESTAMPTZ NOT NULL,
+			revoked_at TIMESTAMPTZ
+		);
+		CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
+		CREATE INDEX IF NOT EXISTS sessions_previous_hash_idx ON sessions (previous_hash);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create sessions table: %w", err)
+	}
+
+	return nil
+}
+
+// CreateSession inserts a new session
+func (db *DB) CreateSession(ctx context.Context, s *Session) error {
+	_, err := db.Pool.Exec(ctx, `
+		INSERT INTO sessions (id, user_id, refresh_hash, device, ip, created_at, last_seen_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, s.ID, s.UserID, s.RefreshHash, s.Device, s.IP, s.CreatedAt, s.LastSeenAt, s.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert session: %w", err)
+	}
+
+	return nil
+}
+
+// GetSessionByRefreshHash retrieves the session whose current or previous refresh
+// token has the given hash, including revoked and expired sessions
+func (db *DB) GetSessionByRefreshHash(ctx context.Context, hash string) (*Session, error) {
+	var s Session
+	var previous, device, ip *string
+	err := db.Pool.QueryRow(ctx, `
+		SELECT id, user_id, refresh_hash, previous_hash, device, ip, created_at, last_seen_at, expires_at, revoked_at
+		FROM sessions WHERE refresh_hash = $1 OR previous_hash = $1
+	`, hash).Scan(&s.ID, &s.UserID, &s.RefreshHash, &previous, &device, &ip,
+		&s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt, &s.RevokedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+
+	s.PreviousHash = deref(previous)
+	s.Device = deref(device)
+	s.IP = deref(ip)
+	return &s, nil
+}
+
+// RotateSession replaces the refresh token of an active session if oldHash is
+// still its current one. It returns ErrSessionNotFound if the token was rotated
+// concurrently or the session is no longer active.
+func (db *DB) RotateSession(ctx context.Context, id, oldHash, newHash, ip string, expiresAt time.Time) error {
+	result, err := db.Pool.Exec(ctx, `
+		UPDATE sessions
+		SET refresh_hash = $3, previous_hash = $2, ip = $4, last_seen_at = NOW(), expires_at = $5
+		WHERE id = $1 AND refresh_hash = $2 AND revoked_at IS NULL AND expires_at > NOW()
+	`, id, oldHash, newHash, ip, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to rotate session: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+// ListSessions retrieves the active sessions of a user, most recently used first
+func (db *DB) ListSessions(ctx context.Context, userID string) ([]Session, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT id, user_id, device, ip, created_at, last_seen_at, expires_at
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_seen_at DESC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var s Session
+		var device, ip *string
+		if err := rows.Scan(&s.ID, &s.UserID, &device, &ip, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		s.Device = deref(device)
+		s.IP = deref(ip)
+		sessions = append(sessions, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession revokes an active session of a user
+func (db *DB) RevokeSession(ctx context.Context, userID, id string) error {
+	result, err := db.Pool.Exec(ctx, `
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`, id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+// RevokeUserSessions revokes all active sessions of a user and returns their IDs
+func (db *DB) RevokeUserSessions(ctx context.Context, userID string) ([]string, error) {
+	rows, err := db.Pool.Query(ctx, `
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL
+		RETURNING id
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error revoking sessions: %w", err)
+	}
+
+	return ids, nil
+}
+
+// DeleteExpiredSessions removes sessions that expired or were revoked before the
+// given time
+func (db *DB) DeleteExpiredSessions(ctx context.Context, before time.Time) (int64, error) {
+	result, err := db.Pool.Exec(ctx, `
+		DELETE FROM sessions WHERE expires_at < $1 OR revoked_at < $1
+	`, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired sessions: %w", err)
+	}
+
+	return result.RowsAffected(), nil
+}
+
+// deref returns the value of a nullable column or the empty string
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
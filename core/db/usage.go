@@ -0,0 +1,402 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrQuotaExceeded is returned when saving a photo would take its owner over
+// their storage quota
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// UsageCount is an amount of stored data
+type UsageCount struct {
+	Bytes   int64 `json:"bytes"`
+	Objects int64 `json:"objects"`
+}
+
+// Usage is the storage used by an owner. Originals and derivatives count
+// until they are purged, so the photos in the trash are part of them.
+type Usage struct {
+	OwnerID     string     `json:"owner_id"`
+	Originals   UsageCount `json:"originals"`
+	Derivatives UsageCount `json:"derivatives"`
+	Total       UsageCount `json:"total"`
+	Trashed     UsageCount `json:"trashed"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+}
+
+// initUsageSchema adds the owner and size of photos, and the tables that
+// account for the storage each owner uses
+func (db *DB) initUsageSchema(ctx context.Context) error {
+	_, err := db.Pool.Exec(ctx, `
+		ALTER TABLE photos ADD COLUMN IF NOT EXISTS owner_id TEXT;
+		ALTER TABLE photos ADD COLUMN IF NOT EXISTS size BIGINT NOT NULL DEFAULT 0;
+		CREATE INDEX IF NOT EXISTS photos_owner_id_idx ON photos (owner_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to add photo owner columns: %w", err)
+	}
+
+	_, err = db.Pool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS photo_derivatives (
+			key TEXT PRIMARY KEY,
+			photo_id UUID NOT NULL REFERENCES photos(id) ON DELETE CASCADE,
+			size BIGINT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS photo_derivatives_photo_id_idx ON photo_derivatives (photo_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create photo_derivatives table: %w", err)
+	}
+
+	_, err = db.Pool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS storage_usage (
+			owner_id TEXT PRIMARY KEY,
+			original_bytes BIGINT NOT NULL DEFAULT 0,
+			original_objects BIGINT NOT NULL DEFAULT 0,
+			derivative_bytes BIGINT NOT NULL DEFAULT 0,
+			derivative_objects BIGINT NOT NULL DEFAULT 0,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create storage_usage table: %w", err)
+	}
+
+	return nil
+}
+
+// SavePhoto saves a photo's metadata and charges its size to its owner. A
+// quota above 0 is the most bytes the owner can use; ErrQuotaExceeded is
+// returned if the photo does not fit. Photos without an owner are not
+// accounted.
+func (db *DB) SavePhoto(ctx context.Context, id, s3Key, filename, mime, ownerID string, size, quota int64) error {
+	if quota > 0 && size > quota {
+		return ErrQuotaExceeded
+	}
+
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if ownerID != "" {
+		// The conditional update makes concurrent uploads of an owner wait for
+		// each other, so together they cannot exceed the quota
+		var charged string
+		err := tx.QueryRow(ctx, `
+			INSERT INTO storage_usage (owner_id, original_bytes, original_objects)
+			VALUES ($1, $2, 1)
+			ON CONFLICT (owner_id) DO UPDATE
+			SET original_bytes = storage_usage.original_bytes + $2,
+				original_objects = storage_usage.original_objects + 1,
+				updated_at = NOW()
+			WHERE $3 = 0 OR storage_usage.original_bytes + storage_usage.derivative_bytes + $2 <= $3
+			RETURNING owner_id
+		`, ownerID, size, quota).Scan(&charged)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrQuotaExceeded
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update storage usage: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO photos (id, s3_key, filename, mime, owner_id, size)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6)
+	`, id, s3Key, filename, mime, ownerID, size)
+	if err != nil {
+		return fmt.Errorf("failed to insert photo: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit photo: %w", err)
+	}
+	return nil
+}
+
+// RecordDerivative charges a derivative of a photo to the photo's owner.
+// Writing a derivative again replaces its size. Derivatives are made by
+// plugins, so they are not held to the quota.
+func (db *DB) RecordDerivative(ctx context.Context, photoID, key string, size int64) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var ownerID *string
+	err = tx.QueryRow(ctx, `SELECT owner_id FROM photos WHERE id = $1 FOR SHARE`, photoID).Scan(&ownerID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrPhotoNotFound{ID: photoID}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get photo owner: %w", err)
+	}
+
+	var previous int64
+	added := int64(1)
+	err = tx.QueryRow(ctx, `SELECT size FROM photo_derivatives WHERE key = $1 FOR UPDATE`, key).Scan(&previous)
+	switch {
+	case err == nil:
+		added = 0
+	case !errors.Is(err, pgx.ErrNoRows):
+		return fmt.Errorf("failed to get derivative: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO photo_derivatives (key, photo_id, size)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO UPDATE SET size = EXCLUDED.size
+	`, key, photoID, size)
+	if err != nil {
+		return fmt.Errorf("failed to record derivative: %w", err)
+	}
+
+	if ownerID != nil {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO storage_usage (owner_id, derivative_bytes, derivative_objects)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (owner_id) DO UPDATE
+			SET derivative_bytes = storage_usage.derivative_bytes + $2,
+				derivative_objects = storage_usage.derivative_objects + $3,
+				updated_at = NOW()
+		`, *ownerID, size-previous, added)
+		if err != nil {
+			return fmt.Errorf("failed to update storage usage: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit derivative: %w", err)
+	}
+	return nil
+}
+
+// DerivativeKeys returns the storage keys of the derivatives of a photo
+func (db *DB) DerivativeKeys(ctx context.Context, photoID string) ([]string, error) {
+	rows, err := db.Pool.Query(ctx, `SELECT key FROM photo_derivatives WHERE photo_id = $1`, photoID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list derivatives: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("failed to scan derivative: %w", err)
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating derivatives: %w", err)
+	}
+
+	return keys, nil
+}
+
+// GetUsage returns the storage used by an owner, with the part of it in the
+// trash
+func (db *DB) GetUsage(ctx context.Context, ownerID string) (*Usage, error) {
+	usage := &Usage{OwnerID: ownerID}
+	err := db.Pool.QueryRow(ctx, `
+		SELECT original_bytes, original_objects, derivative_bytes, derivative_objects, updated_at
+		FROM storage_usage WHERE owner_id = $1
+	`, ownerID).Scan(
+		&usage.Originals.Bytes, &usage.Originals.Objects,
+		&usage.Derivatives.Bytes, &usage.Derivatives.Objects, &usage.UpdatedAt,
+	)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get storage usage: %w", err)
+	}
+
+	err = db.Pool.QueryRow(ctx, `
+		SELECT COALESCE(SUM(size), 0), COUNT(*)
+		FROM photos WHERE owner_id = $1 AND status = 'trashed'
+	`, ownerID).Scan(&usage.Trashed.Bytes, &usage.Trashed.Objects)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trashed usage: %w", err)
+	}
+
+	usage.Total = usage.total()
+	return usage, nil
+}
+
+// ListUsage returns the storage used by every owner, largest first
+func (db *DB) ListUsage(ctx context.Context) ([]Usage, error) {
+	rows, err := db.Pool.Query(ctx, `
+		SELECT u.owner_id, u.original_bytes, u.original_objects, u.derivative_bytes, u.derivative_objects, u.updated_at,
+			COALESCE(t.bytes, 0), COALESCE(t.objects, 0)
+		FROM storage_usage u
+		LEFT JOIN (
+			SELECT owner_id, SUM(size) AS bytes, COUNT(*) AS objects
+			FROM photos WHERE status = 'trashed' GROUP BY owner_id
+		) t ON t.owner_id = u.owner_id
+		ORDER BY u.original_bytes + u.derivative_bytes DESC, u.owner_id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list storage usage: %w", err)
+	}
+	defer rows.Close()
+
+	report := []Usage{}
+	for rows.Next() {
+		var usage Usage
+		if err := rows.Scan(
+			&usage.OwnerID, &usage.Originals.Bytes, &usage.Originals.Objects,
+			&usage.Derivatives.Bytes, &usage.Derivatives.Objects, &usage.UpdatedAt,
+			&usage.Trashed.Bytes, &usage.Trashed.Objects,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan storage usage: %w", err)
+		}
+		usage.Total = usage.total()
+		report = append(report, usage)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating storage usage: %w", err)
+	}
+
+	return report, nil
+}
+
+// BackfillPhotoSizes sets the size of the photos stored before sizes were
+// recorded, from sizeOf of their storage key, and returns how many were set and
+// how many sizeOf failed for. Usage is not charged; call RecalculateUsage
+// afterwards.
+func (db *DB) BackfillPhotoSizes(ctx context.Context, sizeOf func(ctx context.Context, key string) (int64, error)) (int, int, error) {
+	rows, err := db.Pool.Query(ctx, `SELECT id, s3_key FROM photos WHERE size = 0`)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to list photos without a size: %w", err)
+	}
+	type photoKey struct{ id, key string }
+	var photos []photoKey
+	for rows.Next() {
+		var p photoKey
+		if err := rows.Scan(&p.id, &p.key); err != nil {
+			rows.Close()
+			return 0, 0, fmt.Errorf("failed to scan photo: %w", err)
+		}
+		photos = append(photos, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, fmt.Errorf("error iterating photos: %w", err)
+	}
+
+	updated, failed := 0, 0
+	for _, p := range photos {
+		size, err := sizeOf(ctx, p.key)
+		if err != nil {
+			log.Printf("Failed to get the size of photo %s: %v", p.id, err)
+			failed++
+			continue
+		}
+		if _, err := db.Pool.Exec(ctx, `UPDATE photos SET size = $1 WHERE id = $2 AND size = 0`, size, p.id); err != nil {
+			return updated, failed, fmt.Errorf("failed to set photo size: %w", err)
+		}
+		updated++
+	}
+
+	return updated, failed, nil
+}
+
+// AssignPhotoOwner gives the photos uploaded before photos had owners to an
+// owner and returns how many it got. Usage is not charged; call
+// RecalculateUsage afterwards.
+func (db *DB) AssignPhotoOwner(ctx context.Context, ownerID string) (int64, error) {
+	result, err := db.Pool.Exec(ctx, `UPDATE photos SET owner_id = $1 WHERE owner_id IS NULL`, ownerID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to assign photo owner: %w", err)
+	}
+
+	return result.RowsAffected(), nil
+}
+
+// RecalculateUsage rebuilds the storage used by every owner from their photos
+// and derivatives. Uploads and purges wait until it is done.
+func (db *DB) RecalculateUsage(ctx context.Context) error {
+	tx, err := db.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		LOCK TABLE storage_usage IN EXCLUSIVE MODE;
+		DELETE FROM storage_usage;
+		INSERT INTO storage_usage (owner_id, original_bytes, original_objects, derivative_bytes, derivative_objects)
+		SELECT p.owner_id, SUM(p.size), COUNT(*), COALESCE(SUM(d.bytes), 0), COALESCE(SUM(d.objects), 0)
+		FROM photos p
+		LEFT JOIN (
+			SELECT photo_id, SUM(size) AS bytes, COUNT(*) AS objects
+			FROM photo_derivatives GROUP BY photo_id
+		) d ON d.photo_id = p.id
+		WHERE p.owner_id IS NOT NULL
+		GROUP BY p.owner_id;
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to recalculate storage usage: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit storage usage: %w", err)
+	}
+	return nil
+}
+
+// total adds up the originals and derivatives
+func (u *Usage) total() UsageCount {
+	return UsageCount{
+		Bytes:   u.Originals.Bytes + u.Derivatives.Bytes,
+		Objects: u.Originals.Objects + u.Derivatives.Objects,
+	}
+}
+
+// purgePhotos deletes the photos matching condition with their derivatives,
+// releases the storage they used and returns how many were deleted.
+// Every part of the statement sees the derivatives before the cascade removes
+// them.
+func (db *DB) purgePhotos(ctx context.Context, condition string, args ...interface{}) (int64, error) {
+	var count int64
+	err := db.Pool.QueryRow(ctx, `
+		WITH purged AS (
+			DELETE FROM photos WHERE `+condition+` RETURNING id, owner_id, size
+		), derivatives AS (
+			SELECT photo_id, SUM(size) AS bytes, COUNT(*) AS objects
+			FROM photo_derivatives WHERE photo_id IN (SELECT id FROM purged)
+			GROUP BY photo_id
+		), freed AS (
+			SELECT p.owner_id, SUM(p.size) AS original_bytes, COUNT(*) AS original_objects,
+				COALESCE(SUM(d.bytes), 0) AS derivative_bytes, COALESCE(SUM(d.objects), 0) AS derivative_objects
+			FROM purged p LEFT JOIN derivatives d ON d.photo_id = p.id
+			WHERE p.owner_id IS NOT NULL
+			GROUP BY p.owner_id
+		), released AS (
+			UPDATE storage_usage u
+			SET original_bytes = GREATEST(u.original_bytes - f.original_bytes, 0),
+				original_objects = GREATEST(u.original_objects - f.original_objects, 0),
+				derivative_bytes = GREATEST(u.derivative_bytes - f.derivative_bytes, 0),
+				derivative_objects = GREATEST(u.derivative_objects - f.derivative_objects, 0),
+				updated_at = NOW()
+			FROM freed f
+			WHERE u.owner_id = f.owner_id
+		)
+		SELECT COUNT(*) FROM purged
+	`, args...).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
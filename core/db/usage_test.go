@@ -0,0 +1,292 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// newTestDB returns a database with its schema in a fresh Postgres schema of
+// the database in PIXIE_TEST_DATABASE_URL, and skips the test without one
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	url := os.Getenv("PIXIE_TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("PIXIE_TEST_DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+
+	conn, err := pgx.Connect(ctx, url)
+	if err != nil {
+		t.Fatalf("failed to connect to the test database: %v", err)
+	}
+	defer conn.Close(ctx)
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if _, err := conn.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ConnConfig.RuntimeParams["search_path"] = schema
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		pool.Close()
+		conn, err := pgx.Connect(ctx, url)
+		if err != nil {
+			t.Logf("failed to drop %s: %v", schema, err)
+			return
+		}
+		defer conn.Close(ctx)
+		if _, err := conn.Exec(ctx, "DROP SCHEMA "+schema+" CASCADE"); err != nil {
+			t.Logf("failed to drop %s: %v", schema, err)
+		}
+	})
+
+	db := &DB{Pool: pool}
+	if err := db.InitSchema(ctx); err != nil {
+		t.Fatalf("InitSchema: %v", err)
+	}
+	return db
+}
+
+// savePhoto saves a photo of size bytes without a quota and returns its ID
+func savePhoto(t *testing.T, db *DB, ownerID string, size int64) string {
+	t.Helper()
+	id := uuid.New().String()
+	if err := db.SavePhoto(context.Background(), id, "photos/"+id, id+".jpg", "image/jpeg", ownerID, size, 0); err != nil {
+		t.Fatalf("SavePhoto: %v", err)
+	}
+	return id
+}
+
+// checkUsage fails the test unless an owner uses the given originals and
+// derivatives
+func checkUsage(t *testing.T, db *DB, ownerID string, originals, derivatives UsageCount) {
+	t.Helper()
+	usage, err := db.GetUsage(context.Background(), ownerID)
+	if err != nil {
+		t.Fatalf("GetUsage: %v", err)
+	}
+	if usage.Originals != originals || usage.Derivatives != derivatives {
+		t.Errorf("expected originals %+v and derivatives %+v, got %+v and %+v",
+			originals, derivatives, usage.Originals, usage.Derivatives)
+	}
+}
+
+func TestPurgePhotosReleasesUsage(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	first := savePhoto(t, db, "alice", 100)
+	second := savePhoto(t, db, "alice", 200)
+	third := savePhoto(t, db, "alice", 400)
+	bobs := savePhoto(t, db, "bob", 1000)
+	savePhoto(t, db, "", 50)
+	for key, photoID := range map[string]string{"thumb/1a": first, "thumb/1b": first, "thumb/2": second, "thumb/bob": bobs} {
+		if err := db.RecordDerivative(ctx, photoID, key, 10); err != nil {
+			t.Fatalf("RecordDerivative: %v", err)
+		}
+	}
+	// Writing a derivative again replaces its size
+	if err := db.RecordDerivative(ctx, first, "thumb/1a", 30); err != nil {
+		t.Fatalf("RecordDerivative: %v", err)
+	}
+	checkUsage(t, db, "alice", UsageCount{700, 3}, UsageCount{50, 3})
+
+	// Trashed photos still count
+	if err := db.TrashPhoto(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	usage, err := db.GetUsage(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Trashed != (UsageCount{100, 1}) || usage.Total != (UsageCount{750, 6}) {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+
+	if err := db.PermanentlyDeletePhoto(ctx, first); err != nil {
+		t.Fatalf("PermanentlyDeletePhoto: %v", err)
+	}
+	checkUsage(t, db, "alice", UsageCount{600, 2}, UsageCount{10, 1})
+	if keys, err := db.DerivativeKeys(ctx, first); err != nil || len(keys) != 0 {
+		t.Errorf("expected the derivatives to be deleted, got %v, %v", keys, err)
+	}
+
+	// Only photos in the trash are emptied, including those without an owner
+	if err := db.TrashPhoto(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.TrashPhoto(ctx, bobs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Pool.Exec(ctx, `UPDATE photos SET status = 'trashed' WHERE owner_id IS NULL`); err != nil {
+		t.Fatal(err)
+	}
+	count, err := db.EmptyTrash(ctx, "")
+	if err != nil {
+		t.Fatalf("EmptyTrash: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("expected 3 photos to be purged, got %d", count)
+	}
+	checkUsage(t, db, "alice", UsageCount{400, 1}, UsageCount{0, 0})
+	checkUsage(t, db, "bob", UsageCount{0, 0}, UsageCount{0, 0})
+
+	if err := db.DeletePhoto(ctx, third); err != nil {
+		t.Fatalf("DeletePhoto: %v", err)
+	}
+	checkUsage(t, db, "alice", UsageCount{0, 0}, UsageCount{0, 0})
+}
+
+func TestEmptyTrashOfOneOwner(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	alices := savePhoto(t, db, "alice", 100)
+	bobs := savePhoto(t, db, "bob", 200)
+	for _, id := range []string{alices, bobs} {
+		if err := db.TrashPhoto(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	photos, err := db.ListTrashedPhotos(ctx, "alice")
+	if err != nil {
+		t.Fatalf("ListTrashedPhotos: %v", err)
+	}
+	if len(photos) != 1 || photos[0].ID != alices {
+		t.Errorf("expected only the photo of alice, got %+v", photos)
+	}
+
+	count, err := db.EmptyTrash(ctx, "alice")
+	if err != nil {
+		t.Fatalf("EmptyTrash: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("expected 1 photo to be purged, got %d", count)
+	}
+	checkUsage(t, db, "alice", UsageCount{0, 0}, UsageCount{0, 0})
+	checkUsage(t, db, "bob", UsageCount{200, 1}, UsageCount{0, 0})
+
+	// The trash of bob survives
+	photos, err = db.ListTrashedPhotos(ctx, "")
+	if err != nil {
+		t.Fatalf("ListTrashedPhotos: %v", err)
+	}
+	if len(photos) != 1 || photos[0].ID != bobs {
+		t.Errorf("expected only the photo of bob, got %+v", photos)
+	}
+}
+
+func TestConcurrentUploadsKeepToQuota(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	// Twenty uploads of 100 bytes race for a quota of 1000
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id := uuid.New().String()
+			errs <- db.SavePhoto(ctx, id, "photos/"+id, "photo.jpg", "image/jpeg", "alice", 100, 1000)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	saved := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			saved++
+		case !errors.Is(err, ErrQuotaExceeded):
+			t.Errorf("SavePhoto: %v", err)
+		}
+	}
+	if saved != 10 {
+		t.Errorf("expected 10 uploads to fit, got %d", saved)
+	}
+	checkUsage(t, db, "alice", UsageCount{1000, 10}, UsageCount{0, 0})
+
+	var photos int
+	if err := db.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM photos WHERE owner_id = 'alice'`).Scan(&photos); err != nil {
+		t.Fatal(err)
+	}
+	if photos != saved {
+		t.Errorf("expected %d photos, got %d", saved, photos)
+	}
+
+	// A photo larger than the quota never fits
+	if err := db.SavePhoto(ctx, uuid.New().String(), "big", "big.jpg", "image/jpeg", "bob", 2000, 1000); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected ErrQuotaExceeded, got %v", err)
+	}
+}
+
+func TestBackfillUsage(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	// Photos stored before photos had owners and sizes
+	sizes := map[string]int64{}
+	for i, size := range []int64{300, 500, -1} {
+		id := uuid.New().String()
+		key := fmt.Sprintf("old/%d", i)
+		if _, err := db.Pool.Exec(ctx, `INSERT INTO photos (id, s3_key, filename, mime) VALUES ($1, $2, 'old.jpg', 'image/jpeg')`, id, key); err != nil {
+			t.Fatal(err)
+		}
+		if size >= 0 {
+			sizes[key] = size
+		}
+	}
+	savePhoto(t, db, "bob", 100)
+
+	sizeOf := func(ctx context.Context, key string) (int64, error) {
+		size, ok := sizes[key]
+		if !ok {
+			return 0, fmt.Errorf("object not found: %s", key)
+		}
+		return size, nil
+	}
+	updated, failed, err := db.BackfillPhotoSizes(ctx, sizeOf)
+	if err != nil {
+		t.Fatalf("BackfillPhotoSizes: %v", err)
+	}
+	if updated != 2 || failed != 1 {
+		t.Errorf("expected 2 sizes set and 1 failure, got %d and %d", updated, failed)
+	}
+
+	n, err := db.AssignPhotoOwner(ctx, "alice")
+	if err != nil {
+		t.Fatalf("AssignPhotoOwner: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("expected 3 photos to be assigned, got %d", n)
+	}
+
+	// Usage is counted again from the photos, including the ones already charged
+	if _, err := db.Pool.Exec(ctx, `UPDATE storage_usage SET original_bytes = 12345`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RecalculateUsage(ctx); err != nil {
+		t.Fatalf("RecalculateUsage: %v", err)
+	}
+	checkUsage(t, db, "alice", UsageCount{800, 3}, UsageCount{0, 0})
+	checkUsage(t, db, "bob", UsageCount{100, 1}, UsageCount{0, 0})
+}
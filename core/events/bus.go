@@ -0,0 +1,78 @@
+package events
+
+import (
+	"context"
+	"fmt"
+)
+
+// Driver names accepted by New
+const (
+	DriverJetStream = "jetstream"
+	DriverNATS      = "nats"
+	DriverInProc    = "inproc"
+)
+
+// Message is a single event delivered to a subscriber
+type Message interface {
+	// Subject returns the subject the event was published on
+	Subject() string
+	// Data returns the event payload
+	Data() []byte
+	// Ack acknowledges that the event has been processed
+	Ack() error
+	// Nak asks for the event to be redelivered
+	Nak() error
+}
+
+// Handler processes messages delivered to a subscription
+type Handler func(msg Message)
+
+// Subscription is an active subscription on a Bus
+type Subscription interface {
+	Unsubscribe() error
+}
+
+// Bus publishes and delivers events
+type Bus interface {
+	// Publish publishes data on a subject
+	Publish(ctx context.Context, subj string, data []byte) error
+	// Subscribe delivers messages matching subj to handler. Subscribers that share
+	// a non-empty queue name split the messages between them.
+	Subscribe(subj, queue string, handler Handler) (Subscription, error)
+	// Close releases the resources held by the bus
+	Close() error
+}
+
+// StatusReporter is implemented by buses that can report the state of their streams
+type StatusReporter interface {
+	Status(ctx context.Context) ([]StreamStatus, error)
+}
+
+// Config holds the event bus configuration
+type Config struct {
+	Driver           string
+	URL              string
+	StreamConfigFile string
+}
+
+// New creates the event bus selected by config.Driver
+func New(config Config) (Bus, error) {
+	switch config.Driver {
+	case DriverJetStream, "":
+		bus, err := NewJetStream(config.URL, config.StreamConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		return bus, nil
+	case DriverNATS:
+		bus, err := NewNATS(config.URL)
+		if err != nil {
+			return nil, err
+		}
+		return bus, nil
+	case DriverInProc:
+		return NewInProc(), nil
+	default:
+		return nil, fmt.Errorf("unsupported event bus driver: %s", config.Driver)
+	}
+}
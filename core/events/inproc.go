@@ -0,0 +1,240 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// inProcBufferSize is the number of undelivered messages a subscription can hold
+	inProcBufferSize = 256
+
+	// inProcMaxDeliver is the number of times a message is delivered before it is dropped
+	inProcMaxDeliver = 5
+)
+
+// ErrBusClosed is returned when publishing or subscribing on a closed bus
+var ErrBusClosed = errors.New("event bus closed")
+
+// InProcBus is a Bus that delivers events over channels inside the current process.
+// It needs no external server, so single-binary deployments and tests can use it.
+type InProcBus struct {
+	mutex  sync.RWMutex
+	subs   []*inProcSubscription
+	next   map[string]int // round-robin position for each queue group
+	closed bool
+}
+
+// inProcSubscription is a single subscriber of an InProcBus
+type inProcSubscription struct {
+	bus      *InProcBus
+	subject  string
+	queue    string
+	handler  Handler
+	messages chan *inProcMessage
+	done     chan struct{}
+	once     sync.Once
+}
+
+// inProcMessage is a message delivered by an InProcBus
+type inProcMessage struct {
+	sub       *inProcSubscription
+	subject   string
+	data      []byte
+	delivered int
+	acked     atomic.Bool
+}
+
+// NewInProc creates a new in-process event bus
+func NewInProc() *InProcBus {
+	return &InProcBus{
+		next: make(map[string]int),
+	}
+}
+
+// Publish delivers data to every matching subscriber, and to one subscriber of each matching queue group
+func (b *InProcBus) Publish(ctx context.Context, subj string, data []byte) error {
+	b.mutex.Lock()
+	if b.closed {
+		b.mutex.Unlock()
+		return ErrBusClosed
+	}
+
+	// Pick the receivers while holding the lock, so queue groups rotate consistently
+	var targets []*inProcSubscription
+	groups := make(map[string][]*inProcSubscription)
+	var groupOrder []string
+	for _, sub := range b.subs {
+		if !MatchSubject(sub.subject, subj) {
+			continue
+		}
+		if sub.queue == "" {
+			targets = append(targets, sub)
+			continue
+		}
+		if _, ok := groups[sub.queue]; !ok {
+			groupOrder = append(groupOrder, sub.queue)
+		}
+		groups[sub.queue] = append(groups[sub.queue], sub)
+	}
+	for _, queue := range groupOrder {
+		members := groups[queue]
+		targets = append(targets, members[b.next[queue]%len(members)])
+		b.next[queue]++
+	}
+	b.mutex.Unlock()
+
+	for _, sub := range targets {
+		// Copy the payload so subscribers cannot modify each other's data
+		payload := append([]byte(nil), data...)
+		msg := &inProcMessage{sub: sub, subject: subj, data: payload}
+		if err := sub.deliver(ctx, msg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Subscribe registers handler for messages matching subj. Wildcards follow NATS rules.
+func (b *InProcBus) Subscribe(subj, queue string, handler Handler) (Subscription, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		return nil, ErrBusClosed
+	}
+
+	sub := &inProcSubscription{
+		bus:      b,
+		subject:  subj,
+		queue:    queue,
+		handler:  handler,
+		messages: make(chan *inProcMessage, inProcBufferSize),
+		done:     make(chan struct{}),
+	}
+	b.subs = append(b.subs, sub)
+
+	go sub.run()
+
+	return sub, nil
+}
+
+// Close stops every subscription and rejects further publishes
+func (b *InProcBus) Close() error {
+	b.mutex.Lock()
+	subs := b.subs
+	b.subs = nil
+	b.closed = true
+	b.mutex.Unlock()
+
+	for _, sub := range subs {
+		sub.stop()
+	}
+
+	return nil
+}
+
+// Unsubscribe removes the subscription from the bus
+func (s *inProcSubscription) Unsubscribe() error {
+	s.bus.mutex.Lock()
+	for i, sub := range s.bus.subs {
+		if sub == s {
+			s.bus.subs = append(s.bus.subs[:i], s.bus.subs[i+1:]...)
+			break
+		}
+	}
+	s.bus.mutex.Unlock()
+
+	s.stop()
+	return nil
+}
+
+// stop ends the delivery goroutine
+func (s *inProcSubscription) stop() {
+	s.once.Do(func() {
+		close(s.done)
+	})
+}
+
+// deliver queues a message for the subscription, blocking while its buffer is full
+func (s *inProcSubscription) deliver(ctx context.Context, msg *inProcMessage) error {
+	select {
+	case s.messages <- msg:
+		return nil
+	case <-s.done:
+		// The subscription went away; the message is dropped like on core NATS
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run calls the handler for each queued message
+func (s *inProcSubscription) run() {
+	for {
+		select {
+		case msg := <-s.messages:
+			msg.delivered++
+			s.handler(msg)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Subject returns the subject the message was published on
+func (m *inProcMessage) Subject() string {
+	return m.subject
+}
+
+// Data returns the message payload
+func (m *inProcMessage) Data() []byte {
+	return m.data
+}
+
+// Ack acknowledges the message
+func (m *inProcMessage) Ack() error {
+	m.acked.Store(true)
+	return nil
+}
+
+// Nak requeues the message on the same subscription, up to inProcMaxDeliver deliveries
+func (m *inProcMessage) Nak() error {
+	if m.acked.Load() {
+		return nil
+	}
+	if m.delivered >= inProcMaxDeliver {
+		log.Printf("Dropping message on %s after %d deliveries", m.subject, m.delivered)
+		return nil
+	}
+
+	// Requeue without blocking the handler goroutine, which is the one draining the channel
+	go m.sub.deliver(context.Background(), m)
+	return nil
+}
+
+// MatchSubject reports whether subject matches pattern, where "*" matches a single
+// token and a trailing ">" matches one or more tokens
+func MatchSubject(pattern, subject string) bool {
+	patternTokens := strings.Split(pattern, ".")
+	subjectTokens := strings.Split(subject, ".")
+
+	for i, token := range patternTokens {
+		if token == ">" {
+			return i == len(patternTokens)-1 && len(subjectTokens) > i
+		}
+		if i >= len(subjectTokens) {
+			return false
+		}
+		if token != "*" && token != subjectTokens[i] {
+			return false
+		}
+	}
+
+	return len(patternTokens) == len(subjectTokens)
+}
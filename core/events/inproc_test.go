@@ -0,0 +1,116 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMatchSubject(t *testing.T) {
+	tests := []struct {
+		pattern, subject string
+		want             bool
+	}{
+		{"photo.uploaded", "photo.uploaded", true},
+		{"photo.*", "photo.uploaded", true},
+		{"photo.*", "photo.album.added", false},
+		{"photo.>", "photo.album.added", true},
+		{"photo.>", "photo", false},
+		{"*.deleted", "photo.deleted", true},
+		{"photo.uploaded", "photo.deleted", false},
+	}
+	for _, tt := range tests {
+		if got := MatchSubject(tt.pattern, tt.subject); got != tt.want {
+			t.Errorf("MatchSubject(%q, %q) = %v, want %v", tt.pattern, tt.subject, got, tt.want)
+		}
+	}
+}
+
+func TestInProcBusQueueGroup(t *testing.T) {
+	t.Parallel()
+
+	bus := NewInProc()
+	defer bus.Close()
+
+	var mutex sync.Mutex
+	counts := make(map[string]int)
+	var wg sync.WaitGroup
+	handler := func(name string) Handler {
+		return func(msg Message) {
+			mutex.Lock()
+			counts[name]++
+			mutex.Unlock()
+			msg.Ack()
+			wg.Done()
+		}
+	}
+
+	// Two members of one queue group split messages, a plain subscriber sees all of them
+	for _, name := range []string{"a", "b"} {
+		if _, err := bus.Subscribe("photo.>", "workers", handler(name)); err != nil {
+			t.Fatalf("Subscribe: %v", err)
+		}
+	}
+	if _, err := bus.Subscribe("photo.uploaded", "", handler("all")); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	wg.Add(8)
+	for i := 0; i < 4; i++ {
+		if err := bus.Publish(context.Background(), "photo.uploaded", []byte("{}")); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+	waitGroup(t, &wg)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if counts["a"] != 2 || counts["b"] != 2 || counts["all"] != 4 {
+		t.Fatalf("unexpected delivery counts: %v", counts)
+	}
+}
+
+func TestInProcBusNakRedelivers(t *testing.T) {
+	t.Parallel()
+
+	bus := NewInProc()
+	defer bus.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	attempts := 0
+	_, err := bus.Subscribe("photo.uploaded", "", func(msg Message) {
+		attempts++
+		if attempts == 1 {
+			msg.Nak()
+		} else {
+			msg.Ack()
+		}
+		wg.Done()
+	})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	if err := bus.Publish(context.Background(), "photo.uploaded", []byte("{}")); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	waitGroup(t, &wg)
+}
+
+// waitGroup waits for wg or fails the test after a timeout
+func waitGroup(t *testing.T, wg *sync.WaitGroup) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for messages")
+	}
+}
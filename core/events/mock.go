@@ -3,23 +3,43 @@ package events
 import (
 	"context"
 	"log"
+	"sync"
 )
 
-// PublishFunc is the function type for publishing events
-type PublishFunc func(ctx context.Context, subj string, data []byte) error
+// PublishedMessage is a message recorded by MockBus
+type PublishedMessage struct {
+	Subject string
+	Data    []byte
+}
+
+// MockBus is an in-process Bus that also records every published message for testing
+type MockBus struct {
+	*InProcBus
+	published []PublishedMessage
+	mutex     sync.Mutex
+}
 
-// Publish is the function used to publish events
-var Publish PublishFunc
+// NewMock creates a new mock event bus
+func NewMock() *MockBus {
+	log.Println("Mock event bus initialized")
+	return &MockBus{
+		InProcBus: NewInProc(),
+	}
+}
+
+// Publish records the message and delivers it to any subscribers
+func (m *MockBus) Publish(ctx context.Context, subj string, data []byte) error {
+	m.mutex.Lock()
+	m.published = append(m.published, PublishedMessage{Subject: subj, Data: append([]byte(nil), data...)})
+	m.mutex.Unlock()
 
-// InitMock initializes a mock NATS connection
-func InitMock() {
-	log.Println("Mock NATS initialized")
-	// Replace the Publish function with our mock implementation
-	Publish = MockPublish
+	return m.InProcBus.Publish(ctx, subj, data)
 }
 
-// MockPublish is a mock implementation of the Publish function
-func MockPublish(ctx context.Context, subj string, data []byte) error {
-	log.Printf("Mock publish to %s: %s", subj, string(data))
-	return nil
+// Published returns the messages published so far
+func (m *MockBus) Published() []PublishedMessage {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return append([]PublishedMessage(nil), m.published...)
 }
@@ -4,94 +4,181 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"os"
 	"time"
 
 	"github.com/nats-io/nats.go"
 )
 
-var (
-	js nats.JetStreamContext
-	// DefaultPublish is the default implementation of the Publish function
-	DefaultPublish PublishFunc
-)
+// JetStreamBus is a Bus backed by NATS JetStream, with persistent streams and explicit acks
+type JetStreamBus struct {
+	nc      *nats.Conn
+	js      nats.JetStreamContext
+	streams []nats.StreamConfig
+}
 
-// Init initializes the NATS connection and JetStream
-func Init() error {
-	// Get NATS URL from environment or use default
-	natsURL := getEnv("NATS_URL", "nats://nats:4222")
+// NATSBus is a Bus backed by core NATS, without persistence or redelivery
+type NATSBus struct {
+	nc *nats.Conn
+}
 
+// NewJetStream connects to NATS and makes sure the streams defined in streamConfigFile exist
+func NewJetStream(url, streamConfigFile string) (*JetStreamBus, error) {
 	// Connect to NATS
-	nc, err := nats.Connect(natsURL)
+	nc, err := nats.Connect(url)
 	if err != nil {
-		return fmt.Errorf("failed to connect to NATS: %w", err)
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
 	}
 
 	// Create JetStream context
 	jsc, err := nc.JetStream()
 	if err != nil {
-		return fmt.Errorf("failed to create JetStream context: %w", err)
+		nc.Close()
+		return nil, fmt.Errorf("failed to create JetStream context: %w", err)
+	}
+
+	// Load the stream definitions
+	configs, err := LoadStreamConfigs(streamConfigFile)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	bus := &JetStreamBus{
+		nc:      nc,
+		js:      jsc,
+		streams: configs,
 	}
 
 	// Create a context with timeout for stream creation
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	// Ensure the PHOTO stream exists
-	cfg := &nats.StreamConfig{
-		Name:      "PHOTO",
-		Subjects:  []string{"photo.*"},
-		Storage:   nats.FileStorage,
-		Retention: nats.LimitsPolicy,
-		MaxAge:    7 * 24 * time.Hour, // 7 days
-	}
-
-	_, err = jsc.AddStream(cfg, nats.Context(ctx))
-	if err != nil {
-		return fmt.Errorf("failed to create or update stream: %w", err)
+	// Create or update the streams so they match their definitions. The bus is
+	// still returned on failure, so the health endpoint can report the drift.
+	if err := EnsureStreams(ctx, jsc, configs); err != nil {
+		log.Printf("Failed to create or update streams: %v", err)
 	}
 
-	// Store the JetStream context for later use
-	js = jsc
-
 	log.Println("NATS JetStream initialized successfully")
-	return nil
+	return bus, nil
 }
 
-// DefaultPublishImpl is the default implementation of the Publish function
-func DefaultPublishImpl(ctx context.Context, subj string, data []byte) error {
+// Publish publishes a message to JetStream and waits for the server to store it
+func (b *JetStreamBus) Publish(ctx context.Context, subj string, data []byte) error {
 	// Create a context with timeout
 	publishCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	// If js is nil, log a warning and return nil
-	if js == nil {
-		log.Printf("Warning: NATS JetStream not initialized, message to %s not published", subj)
-		return nil
+	if _, err := b.js.Publish(subj, data, nats.Context(publishCtx)); err != nil {
+		return fmt.Errorf("failed to publish message: %w", err)
 	}
 
-	// Publish the message
-	_, err := js.Publish(subj, data, nats.Context(publishCtx))
+	return nil
+}
+
+// Subscribe creates a JetStream consumer with manual acks. A queue name is also used as the durable consumer name.
+func (b *JetStreamBus) Subscribe(subj, queue string, handler Handler) (Subscription, error) {
+	cb := func(msg *nats.Msg) {
+		handler(&natsMessage{msg: msg, jetStream: true})
+	}
+
+	var sub *nats.Subscription
+	var err error
+	if queue != "" {
+		sub, err = b.js.QueueSubscribe(subj, queue, cb, nats.ManualAck(), nats.AckExplicit())
+	} else {
+		sub, err = b.js.Subscribe(subj, cb, nats.ManualAck(), nats.AckExplicit())
+	}
 	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to %s: %w", subj, err)
+	}
+
+	return sub, nil
+}
+
+// Status reports the state of the configured streams and their consumers
+func (b *JetStreamBus) Status(ctx context.Context) ([]StreamStatus, error) {
+	return StreamStatuses(ctx, b.js, b.streams), nil
+}
+
+// Close drains the NATS connection
+func (b *JetStreamBus) Close() error {
+	return b.nc.Drain()
+}
+
+// NewNATS connects to a NATS server without using JetStream
+func NewNATS(url string) (*NATSBus, error) {
+	nc, err := nats.Connect(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+
+	log.Println("NATS initialized successfully")
+	return &NATSBus{nc: nc}, nil
+}
+
+// Publish publishes a message on core NATS
+func (b *NATSBus) Publish(ctx context.Context, subj string, data []byte) error {
+	if err := b.nc.Publish(subj, data); err != nil {
 		return fmt.Errorf("failed to publish message: %w", err)
 	}
 
 	return nil
 }
 
-// Initialize the Publish variable with the default implementation
-func init() {
-	// Set the default implementation
-	DefaultPublish = DefaultPublishImpl
-	// Set the current implementation to the default
-	Publish = DefaultPublish
+// Subscribe subscribes on core NATS. Messages are delivered at most once, so Ack and Nak are no-ops.
+func (b *NATSBus) Subscribe(subj, queue string, handler Handler) (Subscription, error) {
+	cb := func(msg *nats.Msg) {
+		handler(&natsMessage{msg: msg})
+	}
+
+	var sub *nats.Subscription
+	var err error
+	if queue != "" {
+		sub, err = b.nc.QueueSubscribe(subj, queue, cb)
+	} else {
+		sub, err = b.nc.Subscribe(subj, cb)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to %s: %w", subj, err)
+	}
+
+	return sub, nil
+}
+
+// Close drains the NATS connection
+func (b *NATSBus) Close() error {
+	return b.nc.Drain()
+}
+
+// natsMessage adapts a NATS message to the Message interface
+type natsMessage struct {
+	msg       *nats.Msg
+	jetStream bool
 }
 
-// getEnv gets an environment variable or returns a default value
-func getEnv(key, defaultValue string) string {
-	value := os.Getenv(key)
-	if value == "" {
-		return defaultValue
+// Subject returns the subject the message was published on
+func (m *natsMessage) Subject() string {
+	return m.msg.Subject
+}
+
+// Data returns the message payload
+func (m *natsMessage) Data() []byte {
+	return m.msg.Data
+}
+
+// Ack acknowledges a JetStream message
+func (m *natsMessage) Ack() error {
+	if !m.jetStream {
+		return nil
+	}
+	return m.msg.Ack()
+}
+
+// Nak asks JetStream to redeliver the message
+func (m *natsMessage) Nak() error {
+	if !m.jetStream {
+		return nil
 	}
-	return value
+	return m.msg.Nak()
 }
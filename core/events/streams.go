@@ -0,0 +1,262 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// DefaultStreams is used when no stream configuration file is found
+var DefaultStreams = []nats.StreamConfig{
+	{
+		Name:              "PHOTO",
+		Subjects:          []string{"photo.>"},
+		Storage:           nats.FileStorage,
+		Retention:         nats.LimitsPolicy,
+		Discard:           nats.DiscardOld,
+		MaxAge:            7 * 24 * time.Hour, // 7 days
+		MaxConsumers:      -1,
+		MaxMsgs:           -1,
+		MaxBytes:          -1,
+		MaxMsgsPerSubject: -1,
+		MaxMsgSize:        -1,
+		Replicas:          1,
+		Duplicates:        2 * time.Minute,
+	},
+	{
+		Name:              "PLUGIN",
+		Subjects:          []string{"plugin.>"},
+		Storage:           nats.FileStorage,
+		Retention:         nats.LimitsPolicy,
+		Discard:           nats.DiscardOld,
+		MaxAge:            7 * 24 * time.Hour, // 7 days
+		MaxConsumers:      -1,
+		MaxMsgs:           -1,
+		MaxBytes:          -1,
+		MaxMsgsPerSubject: -1,
+		MaxMsgSize:        -1,
+		Replicas:          1,
+		Duplicates:        2 * time.Minute,
+	},
+	{
+		Name:              "AUDIT",
+		Subjects:          []string{"audit.>"},
+		Storage:           nats.FileStorage,
+		Retention:         nats.LimitsPolicy,
+		Discard:           nats.DiscardOld,
+		MaxAge:            7 * 24 * time.Hour, // 7 days
+		MaxConsumers:      -1,
+		MaxMsgs:           -1,
+		MaxBytes:          -1,
+		MaxMsgsPerSubject: -1,
+		MaxMsgSize:        -1,
+		Replicas:          1,
+		Duplicates:        2 * time.Minute,
+	},
+}
+
+// Drift describes a single field that differs between the desired and the actual stream configuration
+type Drift struct {
+	Field   string      `json:"field"`
+	Desired interface{} `json:"desired"`
+	Actual  interface{} `json:"actual"`
+}
+
+// ConsumerStatus is the health view of a single JetStream consumer
+type ConsumerStatus struct {
+	Name           string     `json:"name"`
+	Durable        bool       `json:"durable"`
+	NumPending     uint64     `json:"num_pending"`
+	NumAckPending  int        `json:"num_ack_pending"`
+	NumRedelivered int        `json:"num_redelivered"`
+	LastActive     *time.Time `json:"last_active,omitempty"`
+}
+
+// StreamStatus is the health view of a single JetStream stream
+type StreamStatus struct {
+	Name      string           `json:"name"`
+	Subjects  []string         `json:"subjects"`
+	Exists    bool             `json:"exists"`
+	Messages  uint64           `json:"messages"`
+	Bytes     uint64           `json:"bytes"`
+	Consumers []ConsumerStatus `json:"consumers"`
+	Drift     []Drift          `json:"drift,omitempty"`
+	Error     string           `json:"error,omitempty"`
+}
+
+// LoadStreamConfigs loads stream definitions from a JSON file.
+// The file may contain a single stream object or an array of them, using the
+// same field names as the NATS JetStream API (durations are in nanoseconds).
+// If the file does not exist, DefaultStreams is returned.
+func LoadStreamConfigs(path string) ([]nats.StreamConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			log.Printf("Stream config file %s not found, using built-in defaults", path)
+			return DefaultStreams, nil
+		}
+		return nil, fmt.Errorf("failed to read stream config file: %w", err)
+	}
+
+	return ParseStreamConfigs(data)
+}
+
+// ParseStreamConfigs parses stream definitions from JSON
+func ParseStreamConfigs(data []byte) ([]nats.StreamConfig, error) {
+	data = bytes.TrimSpace(data)
+
+	var configs []nats.StreamConfig
+	if len(data) > 0 && data[0] == '[' {
+		if err := json.Unmarshal(data, &configs); err != nil {
+			return nil, fmt.Errorf("failed to parse stream config: %w", err)
+		}
+	} else {
+		var cfg nats.StreamConfig
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse stream config: %w", err)
+		}
+		configs = append(configs, cfg)
+	}
+
+	// Validate the stream definitions
+	names := make(map[string]bool)
+	for _, cfg := range configs {
+		if cfg.Name == "" {
+			return nil, errors.New("stream config is missing a name")
+		}
+		if len(cfg.Subjects) == 0 {
+			return nil, fmt.Errorf("stream %s has no subjects", cfg.Name)
+		}
+		if names[cfg.Name] {
+			return nil, fmt.Errorf("stream %s is defined more than once", cfg.Name)
+		}
+		names[cfg.Name] = true
+	}
+
+	return configs, nil
+}
+
+// DiffStreamConfig compares the fields of a desired stream configuration with the actual one
+func DiffStreamConfig(desired, actual nats.StreamConfig) []Drift {
+	var drift []Drift
+	add := func(field string, d, a interface{}) {
+		if !reflect.DeepEqual(d, a) {
+			drift = append(drift, Drift{Field: field, Desired: d, Actual: a})
+		}
+	}
+
+	add("subjects", sortedCopy(desired.Subjects), sortedCopy(actual.Subjects))
+	add("retention", desired.Retention.String(), actual.Retention.String())
+	add("storage", desired.Storage.String(), actual.Storage.String())
+	add("discard", desired.Discard.String(), actual.Discard.String())
+	add("max_age", desired.MaxAge.String(), actual.MaxAge.String())
+	add("max_msgs", desired.MaxMsgs, actual.MaxMsgs)
+	add("max_bytes", desired.MaxBytes, actual.MaxBytes)
+	add("max_msgs_per_subject", desired.MaxMsgsPerSubject, actual.MaxMsgsPerSubject)
+	add("max_msg_size", desired.MaxMsgSize, actual.MaxMsgSize)
+	add("num_replicas", desired.Replicas, actual.Replicas)
+	// The server fills in a default duplicate window, so only compare it if one is configured
+	if desired.Duplicates != 0 {
+		add("duplicate_window", desired.Duplicates.String(), actual.Duplicates.String())
+	}
+
+	return drift
+}
+
+// EnsureStreams creates or updates each stream so that it matches its definition.
+// Streams that already match are left untouched. Fields that the server cannot
+// change in place (such as storage) are reported as an error.
+func EnsureStreams(ctx context.Context, jsc nats.JetStreamContext, configs []nats.StreamConfig) error {
+	var errs []error
+	for i := range configs {
+		cfg := configs[i]
+
+		info, err := jsc.StreamInfo(cfg.Name, nats.Context(ctx))
+		if errors.Is(err, nats.ErrStreamNotFound) {
+			if _, err := jsc.AddStream(&cfg, nats.Context(ctx)); err != nil {
+				errs = append(errs, fmt.Errorf("failed to create stream %s: %w", cfg.Name, err))
+				continue
+			}
+			log.Printf("Created stream %s on %s", cfg.Name, strings.Join(cfg.Subjects, ", "))
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to get stream %s: %w", cfg.Name, err))
+			continue
+		}
+
+		drift := DiffStreamConfig(cfg, info.Config)
+		if len(drift) == 0 {
+			log.Printf("Stream %s is up to date", cfg.Name)
+			continue
+		}
+
+		for _, d := range drift {
+			log.Printf("Stream %s drift detected: %s is %v, want %v", cfg.Name, d.Field, d.Actual, d.Desired)
+		}
+
+		if _, err := jsc.UpdateStream(&cfg, nats.Context(ctx)); err != nil {
+			errs = append(errs, fmt.Errorf("failed to update stream %s: %w", cfg.Name, err))
+			continue
+		}
+		log.Printf("Updated stream %s to match its configuration", cfg.Name)
+	}
+
+	return errors.Join(errs...)
+}
+
+// StreamStatuses reports the state of each configured stream and its consumers
+func StreamStatuses(ctx context.Context, jsc nats.JetStreamContext, configs []nats.StreamConfig) []StreamStatus {
+	statuses := make([]StreamStatus, 0, len(configs))
+	for _, cfg := range configs {
+		status := StreamStatus{
+			Name:      cfg.Name,
+			Subjects:  cfg.Subjects,
+			Consumers: []ConsumerStatus{},
+		}
+
+		info, err := jsc.StreamInfo(cfg.Name, nats.Context(ctx))
+		if err != nil {
+			status.Error = err.Error()
+			statuses = append(statuses, status)
+			continue
+		}
+
+		status.Exists = true
+		status.Messages = info.State.Msgs
+		status.Bytes = info.State.Bytes
+		status.Drift = DiffStreamConfig(cfg, info.Config)
+
+		for ci := range jsc.ConsumersInfo(cfg.Name, nats.Context(ctx)) {
+			status.Consumers = append(status.Consumers, ConsumerStatus{
+				Name:           ci.Name,
+				Durable:        ci.Config.Durable != "",
+				NumPending:     ci.NumPending,
+				NumAckPending:  ci.NumAckPending,
+				NumRedelivered: ci.NumRedelivered,
+				LastActive:     ci.Delivered.Last,
+			})
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
+
+// sortedCopy returns a sorted copy of a string slice
+func sortedCopy(in []string) []string {
+	out := append([]string{}, in...)
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,64 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestParseStreamConfigsMatchesRepoFile(t *testing.T) {
+	configs, err := LoadStreamConfigs("../../stream_config.json")
+	if err != nil {
+		t.Fatalf("LoadStreamConfigs: %v", err)
+	}
+	if len(configs) != len(DefaultStreams) {
+		t.Fatalf("expected %d streams, got %d", len(DefaultStreams), len(configs))
+	}
+
+	// The shipped file must agree with the built-in defaults
+	for i := range configs {
+		if drift := DiffStreamConfig(DefaultStreams[i], configs[i]); len(drift) != 0 {
+			t.Fatalf("stream_config.json drifts from DefaultStreams: %+v", drift)
+		}
+		if configs[i].MaxAge != 7*24*time.Hour {
+			t.Errorf("expected 7 day max age, got %s", configs[i].MaxAge)
+		}
+	}
+}
+
+func TestParseStreamConfigsArray(t *testing.T) {
+	configs, err := ParseStreamConfigs([]byte(`[
+		{"name": "PHOTO", "subjects": ["photo.>"], "storage": "file"},
+		{"name": "AUDIT", "subjects": ["audit.>"], "storage": "memory"}
+	]`))
+	if err != nil {
+		t.Fatalf("ParseStreamConfigs: %v", err)
+	}
+	if len(configs) != 2 || configs[1].Storage != nats.MemoryStorage {
+		t.Fatalf("unexpected configs: %+v", configs)
+	}
+
+	if _, err := ParseStreamConfigs([]byte(`[{"name": "A", "subjects": ["a"]}, {"name": "A", "subjects": ["b"]}]`)); err == nil {
+		t.Error("expected error for duplicate stream names")
+	}
+	if _, err := ParseStreamConfigs([]byte(`{"name": "A"}`)); err == nil {
+		t.Error("expected error for stream without subjects")
+	}
+}
+
+func TestDiffStreamConfig(t *testing.T) {
+	desired := DefaultStreams[0]
+	actual := desired
+	actual.Subjects = []string{"photo.*"}
+	actual.MaxAge = 0
+
+	drift := DiffStreamConfig(desired, actual)
+	fields := make(map[string]bool)
+	for _, d := range drift {
+		fields[d.Field] = true
+	}
+	if len(drift) != 2 || !fields["subjects"] || !fields["max_age"] {
+		t.Fatalf("unexpected drift: %+v", drift)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/jackc/pgx/v5"
+	"pixie/db"
+	"pixie/events"
+	"pixie/plugin/host"
+	"pixie/plugin/loader"
+	"pixie/plugin/manifest"
+	"pixie/storage"
+)
+
+// hostBackend gives plugins scoped access to photos through the host services
+type hostBackend struct {
+	db      *db.DB
+	storage *storage.S3
+	events  events.Bus
+}
+
+// ReadOriginal returns the original file of a photo
+func (b *hostBackend) ReadOriginal(ctx context.Context, photoID string) ([]byte, string, error) {
+	s3Key, mime, err := b.db.GetPhoto(ctx, photoID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, "", host.ErrNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	result, err := b.storage.GetObject(ctx, s3Key)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get object from S3: %w", err)
+	}
+	defer result.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(result.Body, host.MaxFileSize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read object: %w", err)
+	}
+	if len(data) > host.MaxFileSize {
+		return nil, "", host.ErrTooLarge
+	}
+
+	return data, mime, nil
+}
+
+// WriteDerivative stores a file derived from an existing photo
+func (b *hostBackend) WriteDerivative(ctx context.Context, photoID, key, mime string, data []byte) error {
+	if _, _, err := b.db.GetPhoto(ctx, photoID); errors.Is(err, pgx.ErrNoRows) {
+		return host.ErrNotFound
+	} else if err != nil {
+		return err
+	}
+
+	if err := b.storage.UploadObject(ctx, key, bytes.NewReader(data), mime); err != nil {
+		return err
+	}
+
+	// Derivatives count toward the storage of the photo's owner
+	err := b.db.RecordDerivative(ctx, photoID, key, int64(len(data)))
+	if errors.As(err, &db.ErrPhotoNotFound{}) {
+		return host.ErrNotFound
+	}
+	return err
+}
+
+// PatchPluginMetadata merges patch into meta.plugins.<plugin> of a photo
+func (b *hostBackend) PatchPluginMetadata(ctx context.Context, photoID, plugin string, patch map[string]interface{}) error {
+	err := b.db.PatchPluginMeta(ctx, photoID, plugin, patch)
+	if errors.As(err, &db.ErrPhotoNotFound{}) {
+		return host.ErrNotFound
+	}
+	return err
+}
+
+// Publish publishes an event emitted by a plugin
+func (b *hostBackend) Publish(ctx context.Context, subject string, data []byte) error {
+	return b.events.Publish(ctx, subject, data)
+}
+
+// pluginRegistrar adds plugins that register over the network to the loader
+type pluginRegistrar struct{}
+
+// Register adds or refreshes a remote plugin
+func (pluginRegistrar) Register(ctx context.Context, m *manifest.Manifest, addr string) (host.Registration, error) {
+	token, settings, err := loader.Register(ctx, m, addr)
+	if err != nil {
+		return host.Registration{}, err
+	}
+	return host.Registration{
+		Token:    token,
+		Settings: settings,
+		Refresh:  int(loader.RemoteRefresh.Seconds()),
+	}, nil
+}
+
+// Unregister removes a remote plugin
+func (pluginRegistrar) Unregister(ctx context.Context, name string) error {
+	return loader.Unregister(ctx, name)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"pixie/mail"
+	"pixie/user"
+)
+
+// Registration modes
+const (
+	// registrationClosed only lets people in with an invite or an admin-made account
+	registrationClosed = "closed"
+
+	// registrationApproval lets anyone sign up; accounts are inactive until an
+	// admin approves them
+	registrationApproval = "approval"
+)
+
+// listInvitesHandler handles listing the invites that have not expired
+func (app *App) listInvitesHandler(w http.ResponseWriter, r *http.Request) {
+	invites, err := app.UserMgr.ListInvites(r.Context())
+	if err != nil {
+		log.Printf("Failed to list invites: %v", err)
+		http.Error(w, "Failed to list invites", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"invites": invites,
+	})
+}
+
+// createInviteHandler handles an admin inviting someone. The invite link is
+// emailed if the invite has an address, and returned either way.
+func (app *App) createInviteHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value("user_id").(string)
+
+	var req user.CreateInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	invite, token, err := app.UserMgr.CreateInvite(r.Context(), req, userID)
+	if err != nil {
+		writeSignupError(w, err)
+		return
+	}
+	link := app.accountLink("invite", token)
+
+	emailed := false
+	if invite.Email != "" {
+		err := app.Mail.Send(r.Context(), mail.Message{
+			To:      invite.Email,
+			Subject: "You are invited to Pixie",
+			Body: fmt.Sprintf("You are invited to create a Pixie account. Open this link to choose your username and password:\n\n%s\n\nThe link works once and expires at %s.\n",
+				link, invite.ExpiresAt.UTC().Format(time.RFC1123)),
+		})
+		if err != nil {
+			log.Printf("Failed to send invite email: %v", err)
+		} else {
+			emailed = true
+		}
+	}
+	log.Printf("Invite %s created for role %s (emailed: %t)", invite.ID, invite.Role, emailed)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"invite":     invite,
+		"invite_url": link,
+		"emailed":    emailed,
+	})
+}
+
+// deleteInviteHandler handles revoking an invite
+func (app *App) deleteInviteHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	if err := app.UserMgr.DeleteInvite(r.Context(), id); err != nil {
+		writeSignupError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// acceptInviteHandler handles an invitee creating their account
+func (app *App) acceptInviteHandler(w http.ResponseWriter, r *http.Request) {
+	var req user.AcceptInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if wait, ok := app.LoginThrottle.Allow(clientIP(r), ""); !ok {
+		tooManyLogins(w, wait)
+		return
+	}
+
+	u, err := app.UserMgr.AcceptInvite(r.Context(), req)
+	if err != nil {
+		if errors.Is(err, user.ErrInvalidInvite) {
+			app.LoginThrottle.Failure(clientIP(r), "")
+		}
+		writeSignupError(w, err)
+		return
+	}
+	log.Printf("User %s created with an invite (role: %s)", u.Username, u.Role)
+
+	// Another address than the invited one is only set once verified
+	verificationSent := false
+	if req.Email != "" && u.Email == "" {
+		verificationSent = app.verifySignupEmail(r.Context(), u, req.Email)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user":              u,
+		"verification_sent": verificationSent,
+	})
+}
+
+// registrationConfigHandler tells the login page whether people can sign up
+func (app *App) registrationConfigHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled": app.Config.Registration == registrationApproval,
+	})
+}
+
+// registerHandler handles someone signing up. The account cannot log in until
+// an admin approves it, and admins with an email address are told about it.
+func (app *App) registerHandler(w http.ResponseWriter, r *http.Request) {
+	if app.Config.Registration != registrationApproval {
+		http.Error(w, "Registration is closed", http.StatusNotFound)
+		return
+	}
+
+	var req user.RegisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	// Sign-ups use up the login budget of the address, so accounts cannot be
+	// created in bulk
+	if wait, ok := app.LoginThrottle.Allow(clientIP(r), ""); !ok {
+		tooManyLogins(w, wait)
+		return
+	}
+
+	u, err := app.UserMgr.Register(r.Context(), req)
+	if err != nil {
+		writeSignupError(w, err)
+		return
+	}
+	log.Printf("User %s signed up and awaits approval", u.Username)
+	verificationSent := false
+	if req.Email != "" {
+		verificationSent = app.verifySignupEmail(r.Context(), u, req.Email)
+	}
+
+	app.notifyAdmins("New Pixie account awaiting approval", fmt.Sprintf(
+		"%s signed up for a Pixie account. Approve or delete it on the admin page:\n\n%s\n",
+		u.Username, app.Config.AppURL))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":           "Your account was created and is waiting for an admin to approve it",
+		"pending_approval":  true,
+		"verification_sent": verificationSent,
+	})
+}
+
+// verifySignupEmail sends a new user the link that confirms the address they
+// signed up with, and returns whether it was sent. The account is created
+// either way; the address can be added later from the account page.
+func (app *App) verifySignupEmail(ctx context.Context, u *user.User, email string) bool {
+	if _, _, err := app.sendEmailVerification(ctx, u.ID, email); err != nil {
+		log.Printf("Email address of new user %s not verified: %v", u.Username, err)
+		return false
+	}
+	return true
+}
+
+// approveUserHandler handles an admin activating an account that signed up
+func (app *App) approveUserHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	u, err := app.UserMgr.ApproveUser(r.Context(), id)
+	if err != nil {
+		writeSignupError(w, err)
+		return
+	}
+	log.Printf("User %s was approved", u.Username)
+
+	if u.Email != "" {
+		err := app.Mail.Send(r.Context(), mail.Message{
+			To:      u.Email,
+			Subject: "Your Pixie account was approved",
+			Body:    fmt.Sprintf("Your Pixie account %s was approved. You can sign in at:\n\n%s\n", u.Username, app.Config.AppURL),
+		})
+		if err != nil {
+			log.Printf("Failed to send approval email: %v", err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(u)
+}
+
+// notifyAdmins emails every active admin with an address in the background
+func (app *App) notifyAdmins(subject, body string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		users, err := app.UserMgr.ListUsers(ctx)
+		if err != nil {
+			log.Printf("Failed to list admins to notify: %v", err)
+			return
+		}
+		for _, u := range users {
+			if u.Role != user.RoleAdmin || !u.Active || u.Email == "" {
+				continue
+			}
+			if err := app.Mail.Send(ctx, mail.Message{To: u.Email, Subject: subject, Body: body}); err != nil {
+				log.Printf("Failed to notify admin %s: %v", u.Username, err)
+			}
+		}
+	}()
+}
+
+// writeSignupError responds to a failed invite, sign-up or approval
+func writeSignupError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, user.ErrInvalidUsername), errors.Is(err, user.ErrWeakPassword),
+		errors.Is(err, user.ErrInvalidEmail), errors.Is(err, user.ErrInvalidInviteExpiry):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, user.ErrRoleNotFound):
+		http.Error(w, "Unknown role", http.StatusBadRequest)
+	case errors.Is(err, user.ErrInvalidInvite):
+		http.Error(w, "This invite is invalid, expired or already used", http.StatusBadRequest)
+	case errors.Is(err, user.ErrUserAlreadyExists):
+		http.Error(w, "Username is taken", http.StatusConflict)
+	case errors.Is(err, user.ErrEmailTaken), errors.Is(err, user.ErrNotPendingApproval):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, user.ErrInviteNotFound), errors.Is(err, user.ErrUserNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		log.Printf("Sign-up request failed: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
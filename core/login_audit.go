@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"pixie/user"
+)
+
+// Subjects of the audit events of logins, kept by the AUDIT stream
+const (
+	auditLoginSucceeded  = "audit.login.succeeded"
+	auditLoginFailed     = "audit.login.failed"
+	auditLoginThrottled  = "audit.login.throttled"
+	auditAccountLocked   = "audit.account.locked"
+	auditAccountUnlocked = "audit.account.unlocked"
+)
+
+// Ways of logging in, as recorded in audit events
+const (
+	loginMethodPassword = "password"
+	loginMethodMFA      = "mfa"
+	loginMethodPasskey  = "passkey"
+	loginMethodOIDC     = "oidc"
+)
+
+// LoginAuditEvent describes a login attempt or a change to the lock of an
+// account
+type LoginAuditEvent struct {
+	Username    string     `json:"username,omitempty"`
+	UserID      string     `json:"user_id,omitempty"`
+	Method      string     `json:"method,omitempty"`
+	Reason      string     `json:"reason,omitempty"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+	IP          string     `json:"ip"`
+	UserAgent   string     `json:"user_agent,omitempty"`
+	Time        time.Time  `json:"time"`
+}
+
+// audit publishes an audit event about a request without holding it up
+func (app *App) audit(r *http.Request, subject string, event LoginAuditEvent) {
+	event.IP = clientIP(r)
+	event.UserAgent = clientDevice(r)
+	event.Time = time.Now().UTC()
+	log.Printf("Audit %s: user=%q method=%s ip=%s reason=%q", subject, event.Username, event.Method, event.IP, event.Reason)
+
+	if app.Events == nil {
+		return
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to marshal audit event: %v", err)
+		return
+	}
+
+	go func() {
+		publishCtx, publishCancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer publishCancel()
+
+		if err := app.Events.Publish(publishCtx, subject, data); err != nil {
+			log.Printf("Failed to publish %s event: %v", subject, err)
+		}
+	}()
+}
+
+// allowLogin answers with 429 Too Many Requests and returns false if the
+// address or username have used up their login attempts for now
+func (app *App) allowLogin(w http.ResponseWriter, r *http.Request, method, username string) bool {
+	wait, ok := app.LoginThrottle.Allow(clientIP(r), username)
+	if ok {
+		return true
+	}
+
+	app.audit(r, auditLoginThrottled, LoginAuditEvent{Username: username, Method: method})
+	tooManyLogins(w, wait)
+	return false
+}
+
+// loginFailed records and audits a failed login, then answers it after the
+// throttle's delay. Locked accounts get the same answer as throttled requests.
+func (app *App) loginFailed(w http.ResponseWriter, r *http.Request, method, username, userID string, err error) {
+	event := LoginAuditEvent{Username: username, UserID: userID, Method: method, Reason: err.Error()}
+
+	var locked *user.LockedError
+	if errors.As(err, &locked) {
+		event.LockedUntil = &locked.Until
+		if locked.Triggered {
+			app.audit(r, auditAccountLocked, event)
+		}
+	}
+	app.audit(r, auditLoginFailed, event)
+
+	delay := app.LoginThrottle.Failure(clientIP(r), username)
+	select {
+	case <-time.After(delay):
+	case <-r.Context().Done():
+		return
+	}
+
+	switch {
+	case locked != nil:
+		tooManyLogins(w, time.Until(locked.Until))
+	case method == loginMethodPassword:
+		// For security, don't reveal specific authentication failure reasons
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+	default:
+		writeMFAError(w, err)
+	}
+}
+
+// tooManyLogins answers a login attempt that may not be made yet
+func tooManyLogins(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, "Too many login attempts, please try again later", http.StatusTooManyRequests)
+}
+
+// unlockUserHandler handles lifting the lock of an account before it expires
+func (app *App) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	if err := app.UserMgr.UnlockUser(r.Context(), id); err != nil {
+		if err == user.ErrUserNotFound {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to unlock user: %v", err)
+		http.Error(w, "Failed to unlock user", http.StatusInternalServerError)
+		return
+	}
+
+	adminID, _ := r.Context().Value("user_id").(string)
+	app.audit(r, auditAccountUnlocked, LoginAuditEvent{UserID: id, Reason: "unlocked by " + adminID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,61 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LogSender writes email to the log instead of sending it, so a development
+// setup needs no mail server. Links in the messages can be copied from the log.
+type LogSender struct{}
+
+// NewLog creates a sender that logs messages
+func NewLog() *LogSender {
+	return &LogSender{}
+}
+
+// Send logs a message
+func (s *LogSender) Send(ctx context.Context, msg Message) error {
+	log.Printf("Mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// FileSender writes every message to its own .eml file in a directory, for
+// tests and setups that pick mail up from disk
+type FileSender struct {
+	dir  string
+	from string
+}
+
+// NewFile creates a sender that writes messages to dir, creating it if needed
+func NewFile(dir, from string) (*FileSender, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("mail directory is required")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create mail directory: %w", err)
+	}
+	return &FileSender{dir: dir, from: from}, nil
+}
+
+// Send writes a message to a new file
+func (s *FileSender) Send(ctx context.Context, msg Message) error {
+	now := time.Now()
+	data, err := format(s.from, msg, now)
+	if err != nil {
+		return err
+	}
+
+	// Names sort in the order the messages were sent
+	name := fmt.Sprintf("%s-%s.eml", now.UTC().Format("20060102T150405.000000000"), uuid.New().String())
+	if err := os.WriteFile(filepath.Join(s.dir, name), data, 0o600); err != nil {
+		return fmt.Errorf("failed to write mail: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	date := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	data, err := format("Pixie <pixie@example.com>", Message{
+		To:      "alice@example.com",
+		Subject: "Réinitialiser",
+		Body:    "line one\nline two",
+	}, date)
+	if err != nil {
+		t.Fatalf("format failed: %v", err)
+	}
+
+	want := "From: Pixie <pixie@example.com>\r\n" +
+		"To: alice@example.com\r\n" +
+		"Subject: =?utf-8?q?R=C3=A9initialiser?=\r\n" +
+		"Date: Tue, 02 Jan 2024 03:04:05 +0000\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: 8bit\r\n" +
+		"\r\n" +
+		"line one\r\nline two\r\n"
+	if string(data) != want {
+		t.Errorf("got\n%q\nwant\n%q", data, want)
+	}
+}
+
+func TestFormatRejectsHeaderInjection(t *testing.T) {
+	_, err := format("pixie@example.com", Message{
+		To:      "alice@example.com\r\nBcc: mallory@example.com",
+		Subject: "Hello",
+	}, time.Now())
+	if !errors.Is(err, ErrInvalidHeader) {
+		t.Errorf("got %v, want ErrInvalidHeader", err)
+	}
+}
+
+func TestFileSender(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	sender, err := New(Config{Driver: DriverFile, Dir: dir, From: "pixie@example.com"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	for _, to := range []string{"alice@example.com", "bob@example.com"} {
+		if err := sender.Send(context.Background(), Message{To: to, Subject: "Hi", Body: "Hello"}); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 2 {
+		t.Fatalf("got files %v (%v), want 2", files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("failed to read mail: %v", err)
+	}
+	if !strings.Contains(string(data), "To: alice@example.com\r\n") {
+		t.Errorf("first file is not the first message:\n%s", data)
+	}
+}
+
+func TestNewRejectsUnknownDriver(t *testing.T) {
+	if _, err := New(Config{Driver: "pigeon"}); err == nil {
+		t.Error("expected an error for an unknown driver")
+	}
+	if _, err := New(Config{Driver: DriverSMTP, From: "pixie@example.com"}); err == nil {
+		t.Error("expected an error for SMTP without a host")
+	}
+}
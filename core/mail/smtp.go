@@ -0,0 +1,72 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// SMTPSender sends email through an SMTP server. Servers that offer STARTTLS
+// are talked to over TLS.
+type SMTPSender struct {
+	addr string
+	host string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTP creates a sender for the SMTP server of config. Credentials are only
+// sent if a username is set.
+func NewSMTP(config Config) (*SMTPSender, error) {
+	if config.SMTPHost == "" {
+		return nil, errors.New("SMTP host is required")
+	}
+	if _, err := mail.ParseAddress(config.From); err != nil {
+		return nil, fmt.Errorf("invalid sender address %q: %w", config.From, err)
+	}
+
+	port := config.SMTPPort
+	if port == 0 {
+		port = 587
+	}
+
+	s := &SMTPSender{
+		addr: net.JoinHostPort(config.SMTPHost, strconv.Itoa(port)),
+		host: config.SMTPHost,
+		from: config.From,
+	}
+	if config.SMTPUsername != "" {
+		s.auth = smtp.PlainAuth("", config.SMTPUsername, config.SMTPPassword, config.SMTPHost)
+	}
+	return s, nil
+}
+
+// Send delivers a message. The context is not honored once the connection to
+// the server is made.
+func (s *SMTPSender) Send(ctx context.Context, msg Message) error {
+	from, err := mail.ParseAddress(s.from)
+	if err != nil {
+		return fmt.Errorf("invalid sender address: %w", err)
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient address: %w", err)
+	}
+	data, err := format(s.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := smtp.SendMail(s.addr, s.auth, from.Address, []string{to.Address}, data); err != nil {
+		return fmt.Errorf("failed to send mail: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"pixie/auth"
+	"pixie/user"
+)
+
+// MFALoginRequest represents the second step of a login: a TOTP or recovery
+// code, or the response of a passkey
+type MFALoginRequest struct {
+	MFAToken   string          `json:"mfa_token"`
+	Code       string          `json:"code"`
+	Credential json.RawMessage `json:"credential"`
+}
+
+// MFATokenRequest represents a request that continues a pending login
+type MFATokenRequest struct {
+	MFAToken string `json:"mfa_token"`
+}
+
+// PasskeyRequest represents the response of a passkey to a ceremony started by
+// the token
+type PasskeyRequest struct {
+	Token      string          `json:"token"`
+	Name       string          `json:"name"`
+	Credential json.RawMessage `json:"credential"`
+}
+
+// MFACodeRequest represents a request confirmed with a TOTP or recovery code
+type MFACodeRequest struct {
+	Code string `json:"code"`
+}
+
+// newMFAConfig configures second factors from the environment
+func newMFAConfig() user.MFAConfig {
+	return user.MFAConfig{
+		Issuer:         getEnv("MFA_ISSUER", "Pixie"),
+		PasskeyRPID:    getEnv("WEBAUTHN_RP_ID", ""),
+		PasskeyOrigins: splitList(getEnv("WEBAUTHN_RP_ORIGINS", "")),
+	}
+}
+
+// mfaChallengeResponse responds to a correct password with a challenge for the
+// second factor instead of tokens
+func (app *App) mfaChallengeResponse(w http.ResponseWriter, r *http.Request, u *user.User, status *user.MFAStatus) {
+	token, err := app.UserMgr.CreateMFAChallenge(r.Context(), u.ID)
+	if err != nil {
+		log.Printf("Failed to create MFA challenge: %v", err)
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+
+	methods := []string{}
+	if status.TOTP {
+		methods = append(methods, "totp")
+	}
+	if status.RecoveryCodes > 0 {
+		methods = append(methods, "recovery_code")
+	}
+	if status.Passkeys > 0 && app.UserMgr.PasskeysEnabled() {
+		methods = append(methods, "passkey")
+	}
+	log.Printf("User %s passed the first login step, asking for a second factor", u.Username)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"mfa_required":        true,
+		"mfa_token":           token,
+		"methods":             methods,
+		"enrollment_required": !status.Enabled(),
+		"expires_in":          int(user.MFAChallengeTTL.Seconds()),
+	})
+}
+
+// mfaLoginHandler handles the second step of a login
+func (app *App) mfaLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var req MFALoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MFAToken == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !app.allowLogin(w, r, loginMethodMFA, "") {
+		return
+	}
+
+	var result *user.MFAResult
+	var err error
+	if len(req.Credential) > 0 {
+		result, err = app.UserMgr.CompleteMFAWithPasskey(r.Context(), req.MFAToken, req.Credential)
+	} else {
+		result, err = app.UserMgr.CompleteMFAWithCode(r.Context(), req.MFAToken, req.Code)
+	}
+	if isLoginFailure(err) {
+		app.loginFailed(w, r, loginMethodMFA, "", "", err)
+		return
+	}
+	if err != nil {
+		writeMFAError(w, err)
+		return
+	}
+	log.Printf("User %s passed the second factor", result.User.Username)
+
+	var extra map[string]interface{}
+	if result.RecoveryCodes != nil {
+		extra = map[string]interface{}{"recovery_codes": result.RecoveryCodes}
+	}
+	app.completeLogin(w, r, result.User, loginMethodMFA, extra)
+}
+
+// mfaLoginPasskeyHandler handles asking for a passkey as the second factor
+func (app *App) mfaLoginPasskeyHandler(w http.ResponseWriter, r *http.Request) {
+	var req MFATokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MFAToken == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	options, err := app.UserMgr.BeginPasskeyMFA(r.Context(), req.MFAToken)
+	if err != nil {
+		writeMFAError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"options": options,
+	})
+}
+
+// mfaLoginEnrollHandler handles setting up TOTP during a login that requires a
+// second factor the user does not have yet
+func (app *App) mfaLoginEnrollHandler(w http.ResponseWriter, r *http.Request) {
+	var req MFATokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MFAToken == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	enrollment, err := app.UserMgr.BeginTOTPEnrollmentWithChallenge(r.Context(), req.MFAToken)
+	if err != nil {
+		writeMFAError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+// passkeyConfigHandler tells the UI whether it can offer passkeys
+func (app *App) passkeyConfigHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled": app.UserMgr.PasskeysEnabled(),
+	})
+}
+
+// passkeyLoginOptionsHandler handles starting a login with a passkey
+func (app *App) passkeyLoginOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	token, options, err := app.UserMgr.BeginPasskeyLogin(r.Context())
+	if err != nil {
+		writeMFAError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":   token,
+		"options": options,
+	})
+}
+
+// passkeyLoginHandler handles finishing a login with a passkey
+func (app *App) passkeyLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var req PasskeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !app.allowLogin(w, r, loginMethodPasskey, "") {
+		return
+	}
+
+	u, err := app.UserMgr.FinishPasskeyLogin(r.Context(), req.Token, req.Credential)
+	if isLoginFailure(err) {
+		app.loginFailed(w, r, loginMethodPasskey, "", "", err)
+		return
+	}
+	if err != nil {
+		writeMFAError(w, err)
+		return
+	}
+	log.Printf("Passkey login successful for user %s (role: %s)", u.Username, u.Role)
+
+	app.completeLogin(w, r, u, loginMethodPasskey, nil)
+}
+
+// mfaStatusHandler handles getting the second factors of the current user
+func (app *App) mfaStatusHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value("user_id").(string)
+
+	status, err := app.UserMgr.MFAStatus(r.Context(), userID)
+	if err != nil {
+		writeMFAError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"totp":             status.TOTP,
+		"passkeys":         status.Passkeys,
+		"recovery_codes":   status.RecoveryCodes,
+		"required":         status.Required,
+		"passkeys_enabled": app.UserMgr.PasskeysEnabled(),
+	})
+}
+
+// beginTOTPHandler handles starting the TOTP enrolment of the current user
+func (app *App) beginTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value("user_id").(string)
+
+	enrollment, err := app.UserMgr.BeginTOTPEnrollment(r.Context(), userID)
+	if err != nil {
+		writeMFAError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+// confirmTOTPHandler handles confirming the TOTP enrolment of the current user
+// with a first code
+func (app *App) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value("user_id").(string)
+
+	var req MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := app.UserMgr.ConfirmTOTP(r.Context(), userID, req.Code)
+	if err != nil {
+		writeMFAError(w, err)
+		return
+	}
+	log.Printf("User %s enabled TOTP", userID)
+
+	writeRecoveryCodes(w, codes)
+}
+
+// disableTOTPHandler handles turning off TOTP for the current user
+func (app *App) disableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value("user_id").(string)
+
+	var req MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := app.UserMgr.DisableTOTP(r.Context(), userID, req.Code); err != nil {
+		writeMFAError(w, err)
+		return
+	}
+	log.Printf("User %s disabled TOTP", userID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// regenerateRecoveryCodesHandler handles replacing the recovery codes of the
+// current user
+func (app *App) regenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value("user_id").(string)
+
+	var req MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := app.UserMgr.RegenerateRecoveryCodes(r.Context(), userID, req.Code)
+	if err != nil {
+		writeMFAError(w, err)
+		return
+	}
+	log.Printf("User %s regenerated their recovery codes", userID)
+
+	writeRecoveryCodes(w, codes)
+}
+
+// listPasskeysHandler handles listing the passkeys of the current user
+func (app *App) listPasskeysHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value("user_id").(string)
+
+	passkeys, err := app.UserMgr.ListPasskeys(r.Context(), userID)
+	if err != nil {
+		writeMFAError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"passkeys": passkeys,
+	})
+}
+
+// passkeyRegistrationOptionsHandler handles starting the registration of a
+// passkey for the current user
+func (app *App) passkeyRegistrationOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value("user_id").(string)
+
+	token, options, err := app.UserMgr.BeginPasskeyRegistration(r.Context(), userID)
+	if err != nil {
+		writeMFAError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":   token,
+		"options": options,
+	})
+}
+
+// registerPasskeyHandler handles finishing the registration of a passkey
+func (app *App) registerPasskeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value("user_id").(string)
+
+	var req PasskeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	passkey, err := app.UserMgr.FinishPasskeyRegistration(r.Context(), userID, req.Token, req.Name, req.Credential)
+	if err != nil {
+		writeMFAError(w, err)
+		return
+	}
+	log.Printf("User %s registered passkey %s", userID, passkey.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(passkey)
+}
+
+// deletePasskeyHandler handles deleting a passkey of the current user
+func (app *App) deletePasskeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value("user_id").(string)
+	id := mux.Vars(r)["id"]
+
+	if err := app.UserMgr.DeletePasskey(r.Context(), userID, id); err != nil {
+		writeMFAError(w, err)
+		return
+	}
+	log.Printf("User %s deleted passkey %s", userID, id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resetUserMFAHandler handles removing every second factor of a user who lost
+// them
+func (app *App) resetUserMFAHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	if err := app.UserMgr.ResetMFA(r.Context(), id); err != nil {
+		writeMFAError(w, err)
+		return
+	}
+	log.Printf("Reset the second factors of user %s", id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setRoleMFAHandler handles requiring a second factor for a role
+func (app *App) setRoleMFAHandler(w http.ResponseWriter, r *http.Request) {
+	name := user.Role(mux.Vars(r)["name"])
+
+	var req struct {
+		Required bool `json:"required"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := app.UserMgr.SetMFARequired(r.Context(), name, req.Required); err != nil {
+		writeRoleError(w, "update", err)
+		return
+	}
+	log.Printf("Set MFA required for role %s to %v", name, req.Required)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"role":     name,
+		"required": req.Required,
+	})
+}
+
+// loginSessionMiddleware rejects requests made with an API key, so a leaked key
+// cannot change the credentials of its user or make keys that outlive it
+func loginSessionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		customClaims, _ := r.Context().Value("custom_claims").(map[string]interface{})
+		if _, ok := customClaims[auth.APIKeyClaim]; ok {
+			http.Error(w, "Forbidden: this needs a login session, not an API key", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeRecoveryCodes responds with recovery codes, which are only shown once
+func writeRecoveryCodes(w http.ResponseWriter, codes []string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"recovery_codes": codes,
+	})
+}
+
+// isLoginFailure reports whether a second factor or passkey was wrong, rather
+// than the request failing for another reason
+func isLoginFailure(err error) bool {
+	return errors.Is(err, user.ErrInvalidMFACode) || errors.Is(err, user.ErrInvalidPasskey) || errors.Is(err, user.ErrAccountLocked)
+}
+
+// writeMFAError responds to a failed MFA or passkey request
+func writeMFAError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, user.ErrInvalidMFAChallenge):
+		http.Error(w, "Login expired, please start again", http.StatusUnauthorized)
+	case errors.Is(err, user.ErrInvalidMFACode), errors.Is(err, user.ErrInvalidPasskey):
+		http.Error(w, "Invalid code or passkey", http.StatusUnauthorized)
+	case errors.Is(err, user.ErrMFANotEnabled):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, user.ErrMFAAlreadyEnabled):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, user.ErrPasskeysDisabled), errors.Is(err, user.ErrPasskeyNotFound), errors.Is(err, user.ErrUserNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		log.Printf("MFA request failed: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,342 @@
+// Package oidc implements OpenID Connect login with the authorization code flow
+// and PKCE. The state, nonce and code verifier of a login in progress are kept in
+// a short-lived cookie, so any replica can finish a login another one started.
+package oidc
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+
+	"pixie/user"
+)
+
+var (
+	// ErrInvalidState is returned when a callback does not belong to a login
+	// started in this browser, or the login took too long
+	ErrInvalidState = errors.New("invalid or expired login state")
+
+	// ErrProviderError is returned when the identity provider reports an error
+	ErrProviderError = errors.New("identity provider returned an error")
+)
+
+const (
+	// cookieName holds the state of a login in progress
+	cookieName = "pixie_oidc"
+
+	// cookiePath limits the cookie to the OIDC endpoints
+	cookiePath = "/api/auth/oidc"
+
+	// loginTimeout is how long a user has to log in at the identity provider
+	loginTimeout = 10 * time.Minute
+
+	// httpTimeout bounds requests to the identity provider
+	httpTimeout = 10 * time.Second
+)
+
+// Config holds the OpenID Connect configuration
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	// RoleClaim names the ID token claim that decides the role, such as groups.
+	// Users whose claim holds one of AdminValues become admins; otherwise the
+	// first of RoleValues the claim holds decides the role, and users matching
+	// none get DefaultRole, or user if it is empty. Without a RoleClaim roles
+	// are managed in Pixie.
+	RoleClaim   string
+	AdminValues []string
+	RoleValues  []RoleValue
+	DefaultRole user.Role
+}
+
+// RoleValue maps a value of the role claim to a role
+type RoleValue struct {
+	Value string
+	Role  user.Role
+}
+
+// Identity is a user as asserted by the identity provider
+type Identity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+	FullName      string
+
+	// Role is the role mapped from the role claim, empty without one
+	Role user.Role
+}
+
+// Client logs users in with an OpenID Connect provider
+type Client struct {
+	config Config
+
+	mu       sync.Mutex
+	oauth    *oauth2.Config
+	verifier *gooidc.IDTokenVerifier
+}
+
+// loginState is the state of a login in progress
+type loginState struct {
+	State    string    `json:"state"`
+	Nonce    string    `json:"nonce"`
+	Verifier string    `json:"verifier"`
+	Redirect string    `json:"redirect"`
+	Expires  time.Time `json:"expires"`
+}
+
+// claims are the ID token claims used to provision users
+type claims struct {
+	Email             string `json:"email"`
+	EmailVerified     *bool  `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+}
+
+// New creates a client. The provider is discovered on first use, so core starts
+// even while the identity provider is unavailable.
+func New(config Config) (*Client, error) {
+	if config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+		return nil, errors.New("OIDC requires an issuer, a client ID and a redirect URL")
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{gooidc.ScopeOpenID, "profile", "email"}
+	}
+	if config.DefaultRole == "" {
+		config.DefaultRole = user.RoleUser
+	}
+	return &Client{config: config}, nil
+}
+
+// Roles returns the roles the role claim can be mapped to, empty without a
+// role claim
+func (c *Client) Roles() []user.Role {
+	if c.config.RoleClaim == "" {
+		return nil
+	}
+	roles := []user.Role{c.config.DefaultRole}
+	if len(c.config.AdminValues) > 0 {
+		roles = append(roles, user.RoleAdmin)
+	}
+	for _, rv := range c.config.RoleValues {
+		roles = append(roles, rv.Role)
+	}
+	return roles
+}
+
+// discover fetches the provider's configuration unless it has been already
+func (c *Client) discover(ctx context.Context) (*oauth2.Config, *gooidc.IDTokenVerifier, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.oauth != nil {
+		return c.oauth, c.verifier, nil
+	}
+
+	// The provider keeps the context to refresh its signing keys, so it must
+	// outlive the request
+	ctx = gooidc.ClientContext(context.WithoutCancel(ctx), &http.Client{Timeout: httpTimeout})
+	provider, err := gooidc.NewProvider(ctx, c.config.Issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to discover OIDC provider: %w", err)
+	}
+	log.Printf("Discovered OIDC provider %s", c.config.Issuer)
+
+	c.oauth = &oauth2.Config{
+		ClientID:     c.config.ClientID,
+		ClientSecret: c.config.ClientSecret,
+		RedirectURL:  c.config.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       c.config.Scopes,
+	}
+	c.verifier = provider.Verifier(&gooidc.Config{ClientID: c.config.ClientID})
+	return c.oauth, c.verifier, nil
+}
+
+// Begin redirects the browser to the identity provider. redirect is the local
+// path the user returns to after logging in.
+func (c *Client) Begin(w http.ResponseWriter, r *http.Request, redirect string) error {
+	oauth, _, err := c.discover(r.Context())
+	if err != nil {
+		return err
+	}
+
+	state := loginState{
+		State:    oauth2.GenerateVerifier(),
+		Nonce:    oauth2.GenerateVerifier(),
+		Verifier: oauth2.GenerateVerifier(),
+		Redirect: localPath(redirect),
+		Expires:  time.Now().Add(loginTimeout),
+	}
+	value, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode login state: %w", err)
+	}
+	http.SetCookie(w, c.cookie(base64.RawURLEncoding.EncodeToString(value), int(loginTimeout.Seconds())))
+
+	url := oauth.AuthCodeURL(state.State, gooidc.Nonce(state.Nonce), oauth2.S256ChallengeOption(state.Verifier))
+	http.Redirect(w, r, url, http.StatusFound)
+	return nil
+}
+
+// Finish completes a login on the callback from the identity provider and returns
+// the verified identity and the local path to return to
+func (c *Client) Finish(w http.ResponseWriter, r *http.Request) (*Identity, string, error) {
+	// The login state can only be used once
+	cookie, err := r.Cookie(cookieName)
+	http.SetCookie(w, c.cookie("", -1))
+	if err != nil {
+		return nil, "", ErrInvalidState
+	}
+	data, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return nil, "", ErrInvalidState
+	}
+	var state loginState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, "", ErrInvalidState
+	}
+
+	query := r.URL.Query()
+	if query.Get("state") != state.State || time.Now().After(state.Expires) {
+		return nil, "", ErrInvalidState
+	}
+	if e := query.Get("error"); e != "" {
+		return nil, "", fmt.Errorf("%w: %s %s", ErrProviderError, e, query.Get("error_description"))
+	}
+
+	oauth, verifier, err := c.discover(r.Context())
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Exchange the code, proving with the verifier that this client started the login
+	ctx := context.WithValue(r.Context(), oauth2.HTTPClient, &http.Client{Timeout: httpTimeout})
+	token, err := oauth.Exchange(ctx, query.Get("code"), oauth2.VerifierOption(state.Verifier))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to exchange authorization code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, "", errors.New("token response has no ID token")
+	}
+	idToken, err := verifier.Verify(r.Context(), rawIDToken)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to verify ID token: %w", err)
+	}
+	if idToken.Nonce != state.Nonce {
+		return nil, "", errors.New("ID token nonce does not match")
+	}
+
+	identity, err := c.identity(idToken)
+	if err != nil {
+		return nil, "", err
+	}
+	return identity, state.Redirect, nil
+}
+
+// identity extracts the user from a verified ID token
+func (c *Client) identity(idToken *gooidc.IDToken) (*Identity, error) {
+	var cl claims
+	if err := idToken.Claims(&cl); err != nil {
+		return nil, fmt.Errorf("failed to parse ID token claims: %w", err)
+	}
+
+	identity := &Identity{
+		Issuer:        idToken.Issuer,
+		Subject:       idToken.Subject,
+		Email:         cl.Email,
+		EmailVerified: cl.EmailVerified != nil && *cl.EmailVerified,
+		Username:      cl.PreferredUsername,
+		FullName:      cl.Name,
+	}
+	if identity.Username == "" {
+		identity.Username, _, _ = strings.Cut(cl.Email, "@")
+	}
+	if identity.Username == "" {
+		identity.Username = idToken.Subject
+	}
+
+	if c.config.RoleClaim != "" {
+		var all map[string]interface{}
+		if err := idToken.Claims(&all); err != nil {
+			return nil, fmt.Errorf("failed to parse ID token claims: %w", err)
+		}
+		identity.Role = c.claimRole(all[c.config.RoleClaim])
+	}
+	return identity, nil
+}
+
+// claimRole maps a role claim, a string or a list of strings, to a role
+func (c *Client) claimRole(claim interface{}) user.Role {
+	var values []string
+	switch v := claim.(type) {
+	case string:
+		values = []string{v}
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	holds := func(want string) bool {
+		for _, value := range values {
+			if value == want {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, admin := range c.config.AdminValues {
+		if holds(admin) {
+			return user.RoleAdmin
+		}
+	}
+	for _, rv := range c.config.RoleValues {
+		if holds(rv.Value) {
+			return rv.Role
+		}
+	}
+	return c.config.DefaultRole
+}
+
+// cookie returns the login state cookie. A negative maxAge deletes it.
+func (c *Client) cookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     cookieName,
+		Value:    value,
+		Path:     cookiePath,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(c.config.RedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// localPath returns redirect if it is a path on this site, so the login cannot
+// be used to send users elsewhere, and / otherwise
+func localPath(redirect string) string {
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || strings.HasPrefix(redirect, "/\\") {
+		return "/"
+	}
+	path, _, _ := strings.Cut(redirect, "#")
+	return path
+}
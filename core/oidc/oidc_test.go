@@ -0,0 +1,301 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"pixie/user"
+)
+
+// stubProvider is a minimal OpenID Connect provider. It remembers the PKCE
+// challenge and nonce of each authorization request and issues an ID token with
+// its claims for the code.
+type stubProvider struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]interface{}
+
+	mu    sync.Mutex
+	codes map[string]authRequest
+}
+
+type authRequest struct {
+	challenge string
+	nonce     string
+}
+
+func newStubProvider(t *testing.T, claims map[string]interface{}) *stubProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &stubProvider{key: key, claims: claims, codes: make(map[string]authRequest)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                p.URL,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"jwks_uri":                              p.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": "test",
+				"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", p.token)
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// authorize plays the user logging in at the provider and returns the callback URL
+func (p *stubProvider) authorize(t *testing.T, location string) string {
+	t.Helper()
+	u, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("authorization request without a PKCE challenge: %s", location)
+	}
+
+	p.mu.Lock()
+	p.codes["code-1"] = authRequest{challenge: query.Get("code_challenge"), nonce: query.Get("nonce")}
+	p.mu.Unlock()
+
+	return query.Get("redirect_uri") + "?" + url.Values{"code": {"code-1"}, "state": {query.Get("state")}}.Encode()
+}
+
+func (p *stubProvider) token(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	req, ok := p.codes[r.FormValue("code")]
+	delete(p.codes, r.FormValue("code"))
+	p.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != req.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":   p.URL,
+		"sub":   "subject-1",
+		"aud":   "pixie",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": req.nonce,
+	}
+	for k, v := range p.claims {
+		claims[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test"
+	idToken, err := token.SignedString(p.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     idToken,
+	})
+}
+
+func newTestClient(t *testing.T, p *stubProvider, config Config) *Client {
+	config.Issuer = p.URL
+	config.ClientID = "pixie"
+	config.ClientSecret = "secret"
+	config.RedirectURL = "http://pixie.test/api/auth/oidc/callback"
+	c, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// begin starts a login and returns the login state cookie and the callback the
+// provider redirects to
+func begin(t *testing.T, c *Client, p *stubProvider, redirect string) (*http.Cookie, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/api/auth/oidc/login", nil)
+	if err := c.Begin(w, r, redirect); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly {
+		t.Fatalf("expected an HttpOnly login state cookie, got %v", cookies)
+	}
+	return cookies[0], p.authorize(t, w.Header().Get("Location"))
+}
+
+// finish calls back with the given cookie
+func finish(c *Client, callback string, cookie *http.Cookie) (*Identity, string, error) {
+	r := httptest.NewRequest("GET", callback, nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	return c.Finish(httptest.NewRecorder(), r)
+}
+
+func TestLogin(t *testing.T) {
+	p := newStubProvider(t, map[string]interface{}{
+		"email":          "ada@example.com",
+		"email_verified": true,
+		"name":           "Ada Lovelace",
+	})
+	c := newTestClient(t, p, Config{})
+
+	cookie, callback := begin(t, c, p, "/albums?id=1")
+	identity, redirect, err := finish(c, callback, cookie)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Identity{
+		Issuer:        p.URL,
+		Subject:       "subject-1",
+		Email:         "ada@example.com",
+		EmailVerified: true,
+		Username:      "ada",
+		FullName:      "Ada Lovelace",
+	}
+	if *identity != want {
+		t.Errorf("expected %+v, got %+v", want, *identity)
+	}
+	if redirect != "/albums?id=1" {
+		t.Errorf("expected to return to /albums?id=1, got %s", redirect)
+	}
+}
+
+func TestLoginRejectsInvalidState(t *testing.T) {
+	p := newStubProvider(t, nil)
+	c := newTestClient(t, p, Config{})
+
+	// A callback without the cookie of the browser that started the login
+	_, callback := begin(t, c, p, "/")
+	if _, _, err := finish(c, callback, nil); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("expected ErrInvalidState without a cookie, got %v", err)
+	}
+
+	// A callback with the cookie of another login
+	cookie, _ := begin(t, c, p, "/")
+	_, callback = begin(t, c, p, "/")
+	if _, _, err := finish(c, callback, cookie); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("expected ErrInvalidState for another login's state, got %v", err)
+	}
+
+	// A provider error is reported as such
+	cookie, callback = begin(t, c, p, "/")
+	denied := strings.Replace(callback, "code=code-1", "error=access_denied", 1)
+	if _, _, err := finish(c, denied, cookie); !errors.Is(err, ErrProviderError) {
+		t.Errorf("expected ErrProviderError, got %v", err)
+	}
+}
+
+func TestLoginRequiresVerifier(t *testing.T) {
+	p := newStubProvider(t, nil)
+	c := newTestClient(t, p, Config{})
+
+	// An intercepted code is useless without the verifier in the cookie
+	cookie, callback := begin(t, c, p, "/")
+	var state loginState
+	data, _ := base64.RawURLEncoding.DecodeString(cookie.Value)
+	json.Unmarshal(data, &state)
+	state.Verifier = "intercepted"
+	data, _ = json.Marshal(state)
+	cookie.Value = base64.RawURLEncoding.EncodeToString(data)
+
+	if _, _, err := finish(c, callback, cookie); err == nil {
+		t.Error("expected the code exchange to fail with the wrong verifier")
+	}
+}
+
+func TestRoleClaim(t *testing.T) {
+	// The first matching value wins
+	familyRoles := []RoleValue{{Value: "photographers", Role: "editor"}, {Value: "family", Role: user.RoleViewer}}
+
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		config Config
+		want   user.Role
+	}{
+		{"no role claim", map[string]interface{}{"groups": []string{"admins"}}, Config{}, ""},
+		{"admin group", map[string]interface{}{"groups": []string{"staff", "admins"}}, Config{RoleClaim: "groups", AdminValues: []string{"admins"}}, user.RoleAdmin},
+		{"other group", map[string]interface{}{"groups": []string{"staff"}}, Config{RoleClaim: "groups", AdminValues: []string{"admins"}}, user.RoleUser},
+		{"string claim", map[string]interface{}{"role": "admin"}, Config{RoleClaim: "role", AdminValues: []string{"admin"}}, user.RoleAdmin},
+		{"missing claim", nil, Config{RoleClaim: "role", AdminValues: []string{"admin"}}, user.RoleUser},
+		{"viewer group", map[string]interface{}{"groups": []string{"family"}}, Config{RoleClaim: "groups", RoleValues: familyRoles}, user.RoleViewer},
+		{"custom role", map[string]interface{}{"groups": []string{"family", "photographers"}}, Config{RoleClaim: "groups", RoleValues: familyRoles}, "editor"},
+		{"admin first", map[string]interface{}{"groups": []string{"photographers", "admins"}}, Config{RoleClaim: "groups", AdminValues: []string{"admins"}, RoleValues: familyRoles}, user.RoleAdmin},
+		{"default role", map[string]interface{}{"groups": []string{"staff"}}, Config{RoleClaim: "groups", RoleValues: familyRoles, DefaultRole: user.RoleViewer}, user.RoleViewer},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newStubProvider(t, tt.claims)
+			c := newTestClient(t, p, tt.config)
+
+			cookie, callback := begin(t, c, p, "/")
+			identity, _, err := finish(c, callback, cookie)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if identity.Role != tt.want {
+				t.Errorf("expected role %q, got %q", tt.want, identity.Role)
+			}
+			if identity.Username != "subject-1" {
+				t.Errorf("expected the subject as username, got %q", identity.Username)
+			}
+		})
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	tests := map[string]string{
+		"":                      "/",
+		"/albums":               "/albums",
+		"/albums#token=x":       "/albums",
+		"https://evil.example/": "/",
+		"//evil.example/":       "/",
+		"/\\evil.example/":      "/",
+	}
+	for redirect, want := range tests {
+		if got := localPath(redirect); got != want {
+			t.Errorf("localPath(%q) = %q, want %q", redirect, got, want)
+		}
+	}
+}
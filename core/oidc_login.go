@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"pixie/oidc"
+	"pixie/user"
+)
+
+// newOIDCClient configures OpenID Connect login from the environment, or returns
+// nil if it is disabled. Every role the role claim maps to must exist.
+func newOIDCClient(ctx context.Context, userMgr *user.Manager) (*oidc.Client, error) {
+	issuer := getEnv("OIDC_ISSUER", "")
+	if issuer == "" {
+		return nil, nil
+	}
+
+	roleValues, err := parseRoleValues(getEnv("OIDC_ROLE_VALUES", ""))
+	if err != nil {
+		return nil, err
+	}
+	client, err := oidc.New(oidc.Config{
+		Issuer:       issuer,
+		ClientID:     getEnv("OIDC_CLIENT_ID", ""),
+		ClientSecret: getEnv("OIDC_CLIENT_SECRET", ""),
+		RedirectURL:  getEnv("OIDC_REDIRECT_URL", ""),
+		Scopes:       splitList(getEnv("OIDC_SCOPES", "")),
+		RoleClaim:    getEnv("OIDC_ROLE_CLAIM", ""),
+		AdminValues:  splitList(getEnv("OIDC_ADMIN_VALUES", "")),
+		RoleValues:   roleValues,
+		DefaultRole:  user.Role(getEnv("OIDC_DEFAULT_ROLE", "")),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, role := range client.Roles() {
+		if err := userMgr.ValidateRole(ctx, role); err != nil {
+			return nil, fmt.Errorf("role %q of the role claim: %w", role, err)
+		}
+	}
+	return client, nil
+}
+
+// parseRoleValues parses OIDC_ROLE_VALUES, a comma separated list of
+// value=role pairs in the order they are matched
+func parseRoleValues(s string) ([]oidc.RoleValue, error) {
+	var roleValues []oidc.RoleValue
+	for _, item := range splitList(s) {
+		value, role, ok := strings.Cut(item, "=")
+		value, role = strings.TrimSpace(value), strings.TrimSpace(role)
+		if !ok || value == "" || role == "" {
+			return nil, fmt.Errorf("invalid OIDC_ROLE_VALUES entry %q, expected value=role", item)
+		}
+		roleValues = append(roleValues, oidc.RoleValue{Value: value, Role: user.Role(role)})
+	}
+	return roleValues, nil
+}
+
+// splitList splits a comma separated list, dropping empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// oidcConfigHandler tells the login page whether single sign-on is available
+func (app *App) oidcConfigHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled": app.OIDC != nil,
+		"name":    getEnv("OIDC_NAME", "Single Sign-On"),
+	})
+}
+
+// oidcLoginHandler redirects to the identity provider
+func (app *App) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if app.OIDC == nil {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+
+	if err := app.OIDC.Begin(w, r, r.URL.Query().Get("redirect")); err != nil {
+		log.Printf("Failed to start OIDC login: %v", err)
+		http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+	}
+}
+
+// OIDCCompleteRequest continues a login at the identity provider
+type OIDCCompleteRequest struct {
+	LoginToken string `json:"login_token"`
+}
+
+// oidcCallbackHandler finishes a login at the identity provider. The user is
+// provisioned on first login and sent back to the UI with a single-use login
+// token in the URL fragment, which is never sent to a server. The UI exchanges
+// it at oidcCompleteHandler, so the login goes through the same steps as one
+// with a password.
+func (app *App) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if app.OIDC == nil {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+
+	identity, redirect, err := app.OIDC.Finish(w, r)
+	if err != nil {
+		log.Printf("OIDC login failed: %v", err)
+		app.audit(r, auditLoginFailed, LoginAuditEvent{Method: loginMethodOIDC, Reason: err.Error()})
+		message := "Login failed"
+		if errors.Is(err, oidc.ErrInvalidState) {
+			message = "Login expired, please try again"
+		} else if errors.Is(err, oidc.ErrProviderError) {
+			message = "Login was cancelled or denied"
+		}
+		oidcRedirect(w, r, "/", url.Values{"oidc_error": {message}})
+		return
+	}
+
+	u, err := app.UserMgr.ProvisionExternalUser(r.Context(), user.ExternalIdentity{
+		Issuer:        identity.Issuer,
+		Subject:       identity.Subject,
+		Email:         identity.Email,
+		EmailVerified: identity.EmailVerified,
+		Username:      identity.Username,
+		FullName:      identity.FullName,
+		Role:          identity.Role,
+	})
+	if err != nil {
+		log.Printf("Failed to provision OIDC user %s: %v", identity.Subject, err)
+		oidcRedirect(w, r, redirect, url.Values{"oidc_error": {"Login failed"}})
+		return
+	}
+	log.Printf("User %s logged in at the identity provider (role: %s)", u.Username, u.Role)
+
+	token, err := app.UserMgr.CreateExternalLoginChallenge(r.Context(), u.ID)
+	if err != nil {
+		log.Printf("Failed to create OIDC login challenge: %v", err)
+		oidcRedirect(w, r, redirect, url.Values{"oidc_error": {"Login failed"}})
+		return
+	}
+	oidcRedirect(w, r, redirect, url.Values{"login_token": {token}})
+}
+
+// oidcCompleteHandler continues a login at the identity provider like a login
+// whose password was checked: locked, inactive and unapproved accounts are
+// refused, and second factors are asked for
+func (app *App) oidcCompleteHandler(w http.ResponseWriter, r *http.Request) {
+	var req OIDCCompleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.LoginToken == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !app.allowLogin(w, r, loginMethodOIDC, "") {
+		return
+	}
+
+	u, err := app.UserMgr.CompleteExternalLogin(r.Context(), req.LoginToken)
+	if errors.Is(err, user.ErrAccountLocked) {
+		app.loginFailed(w, r, loginMethodOIDC, "", "", err)
+		return
+	}
+	if err != nil {
+		writeMFAError(w, err)
+		return
+	}
+
+	app.continueLogin(w, r, u, loginMethodOIDC)
+}
+
+// oidcRedirect sends the browser back to the UI with values in the fragment
+func oidcRedirect(w http.ResponseWriter, r *http.Request, path string, values url.Values) {
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, path+"#"+values.Encode(), http.StatusFound)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"pixie/user"
+)
+
+// PasswordChangeLoginRequest represents the new password that finishes the
+// login of an account with a one-time password
+type PasswordChangeLoginRequest struct {
+	PasswordToken string `json:"password_token"`
+	NewPassword   string `json:"new_password"`
+}
+
+// passwordChangeResponse responds to a login with a one-time password with a
+// challenge to choose a new one instead of tokens. Anything the earlier steps
+// of the login returned, such as recovery codes, is passed on.
+func (app *App) passwordChangeResponse(w http.ResponseWriter, r *http.Request, u *user.User, extra map[string]interface{}) {
+	token, err := app.UserMgr.CreatePasswordChangeChallenge(r.Context(), u.ID)
+	if err != nil {
+		log.Printf("Failed to create password change challenge: %v", err)
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %s must change their password before logging in", u.Username)
+
+	resp := map[string]interface{}{
+		"password_change_required": true,
+		"password_token":           token,
+		"min_password_length":      user.MinPasswordLength,
+		"expires_in":               int(user.MFAChallengeTTL.Seconds()),
+	}
+	for k, v := range extra {
+		resp[k] = v
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// passwordChangeLoginHandler handles finishing a login by replacing a one-time
+// password
+func (app *App) passwordChangeLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var req PasswordChangeLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PasswordToken == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !app.allowLogin(w, r, loginMethodPassword, "") {
+		return
+	}
+
+	u, err := app.UserMgr.CompletePasswordChange(r.Context(), req.PasswordToken, req.NewPassword)
+	if err != nil {
+		writePasswordError(w, err)
+		return
+	}
+	log.Printf("User %s changed their one-time password", u.Username)
+
+	app.completeLogin(w, r, u, loginMethodPassword, nil)
+}
+
+// writePasswordError responds to a password that could not be changed
+func writePasswordError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, user.ErrWeakPassword), errors.Is(err, user.ErrPasswordReused):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, user.ErrInvalidMFAChallenge):
+		http.Error(w, "Login expired, please start again", http.StatusUnauthorized)
+	case errors.Is(err, user.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	default:
+		log.Printf("Failed to change password: %v", err)
+		http.Error(w, "Failed to change password", http.StatusInternalServerError)
+	}
+}
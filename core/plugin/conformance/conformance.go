@@ -0,0 +1,348 @@
+// Package conformance checks that a plugin honours the contract core relies on.
+// Run starts the plugin through the real loader, with its sandbox, transport and
+// host services token, against a fake core and reports a result per check.
+package conformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"pixie/events"
+	pluginv1 "pixie/gen/plugin/v1"
+	"pixie/plugin/host"
+	"pixie/plugin/loader"
+	"pixie/plugin/manifest"
+)
+
+// Status is the outcome of a single check
+type Status string
+
+// Check outcomes
+const (
+	StatusPass Status = "PASS"
+	StatusFail Status = "FAIL"
+	StatusSkip Status = "SKIP"
+)
+
+// invalidToken is sent to auth plugins, which must reject it
+const invalidToken = "pixie-conformance-invalid-token"
+
+// Result is the outcome of a single check
+type Result struct {
+	Name     string
+	Status   Status
+	Detail   string
+	Duration time.Duration
+}
+
+// Report holds the results of all checks of a plugin
+type Report struct {
+	Dir     string
+	Plugin  string
+	Version string
+	Results []Result
+}
+
+// Passed reports whether no check failed
+func (r *Report) Passed() bool {
+	for _, result := range r.Results {
+		if result.Status == StatusFail {
+			return false
+		}
+	}
+	return true
+}
+
+// Write prints the report as a table followed by the verdict
+func (r *Report) Write(w io.Writer) {
+	name := r.Dir
+	if r.Plugin != "" {
+		name = fmt.Sprintf("%s %s (%s)", r.Plugin, r.Version, r.Dir)
+	}
+	fmt.Fprintf(w, "Conformance report for %s\n\n", name)
+
+	failed := 0
+	for _, result := range r.Results {
+		duration := ""
+		switch {
+		case result.Duration >= time.Millisecond:
+			duration = result.Duration.Round(time.Millisecond).String()
+		case result.Duration > 0:
+			duration = "<1ms"
+		}
+		fmt.Fprintf(w, "  %-4s  %-12s %8s  %s\n", result.Status, result.Name, duration, result.Detail)
+		if result.Status == StatusFail {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		fmt.Fprintf(w, "\nFAIL: %d of %d checks failed\n", failed, len(r.Results))
+		return
+	}
+	fmt.Fprintf(w, "\nPASS: %d checks\n", len(r.Results))
+}
+
+// add records the outcome of a check
+func (r *Report) add(name string, status Status, duration time.Duration, format string, args ...interface{}) {
+	r.Results = append(r.Results, Result{
+		Name:     name,
+		Status:   status,
+		Detail:   fmt.Sprintf(format, args...),
+		Duration: duration,
+	})
+}
+
+// skip records the remaining checks as skipped after a fatal failure
+func (r *Report) skip(reason string, names ...string) {
+	for _, name := range names {
+		r.add(name, StatusSkip, 0, "%s", reason)
+	}
+}
+
+// Run checks the plugin in dir. It configures the loader's host services, so it
+// must not be used in a process that also runs core's plugins.
+func Run(ctx context.Context, dir string) *Report {
+	r := &Report{Dir: dir}
+	all := []string{"handshake", "health", "process", "idempotency", "search", "auth", "stability", "shutdown"}
+
+	// The manifest must be one core would load
+	m, err := loadManifest(dir)
+	if err != nil {
+		r.add("manifest", StatusFail, 0, "%v", err)
+		r.skip("no valid manifest", all...)
+		return r
+	}
+	r.Plugin, r.Version = m.Name, m.Version
+	r.add("manifest", StatusPass, 0, "capabilities %v", m.Capabilities)
+
+	// Serve the host services from memory
+	core := newFakeCore()
+	hostServer := host.New(core)
+	if err := hostServer.Listen("127.0.0.1:0"); err != nil {
+		r.add("handshake", StatusFail, 0, "%v", err)
+		r.skip("fake core did not start", all[1:]...)
+		return r
+	}
+	defer hostServer.Stop()
+	loader.SetHost(hostServer)
+
+	timeouts := loader.CurrentTimeouts()
+
+	// The plugin must start and print its handshake in time
+	start := time.Now()
+	proc, err := loader.StartProcess(m, nil)
+	if err != nil {
+		r.add("handshake", StatusFail, time.Since(start), "%v", err)
+		r.skip("plugin did not start", all[1:]...)
+		return r
+	}
+	defer proc.Kill()
+	r.add("handshake", StatusPass, time.Since(start), "within the %s limit", timeouts.Start)
+
+	// The plugin must report SERVING before core routes calls to it
+	start = time.Now()
+	if err := proc.Health(ctx); err != nil {
+		r.add("health", StatusFail, time.Since(start), "%v", err)
+		r.skip("plugin is not healthy", all[2:len(all)-1]...)
+		r.checkShutdown(proc, timeouts)
+		return r
+	}
+	r.add("health", StatusPass, time.Since(start), "SERVING within the %s limit", timeouts.Health)
+
+	r.checkProcess(ctx, proc, core, m, timeouts)
+	r.checkSearch(ctx, proc, m, timeouts)
+	r.checkAuth(ctx, proc, m, timeouts)
+
+	// The calls above must not have crashed or wedged the plugin
+	start = time.Now()
+	select {
+	case <-proc.Exited():
+		r.add("stability", StatusFail, 0, "plugin exited during the checks")
+	default:
+		if err := proc.Health(ctx); err != nil {
+			r.add("stability", StatusFail, time.Since(start), "health check failed after the checks: %v", err)
+		} else {
+			r.add("stability", StatusPass, time.Since(start), "still SERVING after the checks")
+		}
+	}
+
+	r.checkShutdown(proc, timeouts)
+	return r
+}
+
+// loadManifest loads the manifest in dir and checks that core can start the plugin
+func loadManifest(dir string) (*manifest.Manifest, error) {
+	m, err := manifest.Load(filepath.Join(dir, manifest.FileName))
+	if err != nil {
+		return nil, err
+	}
+	if m.Transport == manifest.TransportRemote {
+		return nil, errors.New("remote plugins are not started by core; test them with transport unix or tcp")
+	}
+
+	info, err := os.Stat(m.EntrypointPath())
+	if err != nil {
+		return nil, fmt.Errorf("entrypoint: %w", err)
+	}
+	if info.IsDir() || info.Mode()&0111 == 0 {
+		return nil, fmt.Errorf("entrypoint %s is not executable", m.EntrypointPath())
+	}
+	return m, nil
+}
+
+// checkProcess sends a photo event twice and checks that the second delivery
+// changes nothing. Core may deliver an event more than once.
+func (r *Report) checkProcess(ctx context.Context, proc *loader.Process, core *fakeCore, m *manifest.Manifest, timeouts loader.Timeouts) {
+	if !m.Has(manifest.CapabilityProcess) {
+		r.skip("no process capability", "process", "idempotency")
+		return
+	}
+	subject, ok := subscribedSubject(m)
+	if !ok {
+		r.skip("not subscribed to a photo event", "process", "idempotency")
+		return
+	}
+
+	photoID, err := core.addTestPhoto()
+	if err != nil {
+		r.add("process", StatusFail, 0, "%v", err)
+		r.skip("no test photo", "idempotency")
+		return
+	}
+	photo := &pluginv1.Photo{Id: photoID, S3Key: "photos/" + photoID + ".png", Mime: "image/png", Event: subject}
+
+	process := func() (time.Duration, error) {
+		ctx, cancel := context.WithTimeout(ctx, timeouts.Dispatch)
+		defer cancel()
+		start := time.Now()
+		_, err := proc.Client.ProcessPhoto(ctx, photo)
+		return time.Since(start), callError(err, timeouts.Dispatch)
+	}
+
+	duration, err := process()
+	if err != nil {
+		r.add("process", StatusFail, duration, "%s: %v", subject, err)
+		r.skip("first delivery failed", "idempotency")
+		return
+	}
+	first := core.state(photoID)
+	r.add("process", StatusPass, duration, "%s: %d derivatives, %d events", subject, len(first.derivatives), core.eventCount())
+
+	duration, err = process()
+	if err != nil {
+		r.add("idempotency", StatusFail, duration, "second delivery failed: %v", err)
+		return
+	}
+	second := core.state(photoID)
+	switch {
+	case !reflect.DeepEqual(first.derivatives, second.derivatives):
+		r.add("idempotency", StatusFail, duration, "derivatives differ after the second delivery: %v, then %v", first.derivatives, second.derivatives)
+	case !reflect.DeepEqual(first.metadata, second.metadata):
+		r.add("idempotency", StatusFail, duration, "metadata differs after the second delivery: %v, then %v", first.metadata, second.metadata)
+	default:
+		r.add("idempotency", StatusPass, duration, "second delivery left derivatives and metadata unchanged")
+	}
+}
+
+// subscribedSubject returns the photo event the plugin is subscribed to
+func subscribedSubject(m *manifest.Manifest) (string, bool) {
+	for _, subject := range []string{"photo.uploaded", "photo.deleted"} {
+		for _, pattern := range m.Events {
+			if events.MatchSubject(pattern, subject) {
+				return subject, true
+			}
+		}
+	}
+	return "", false
+}
+
+// checkSearch checks that search results are a list of distinct photo IDs
+func (r *Report) checkSearch(ctx context.Context, proc *loader.Process, m *manifest.Manifest, timeouts loader.Timeouts) {
+	if !m.Has(manifest.CapabilitySearch) {
+		r.skip("no search capability", "search")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeouts.HTTP)
+	defer cancel()
+	start := time.Now()
+	result, err := proc.Client.Search(ctx, &pluginv1.SearchRequest{Query: "conformance"})
+	duration := time.Since(start)
+	if err = callError(err, timeouts.HTTP); err != nil {
+		r.add("search", StatusFail, duration, "%v", err)
+		return
+	}
+
+	seen := make(map[string]bool)
+	for _, id := range result.Ids {
+		if _, err := uuid.Parse(id); err != nil {
+			r.add("search", StatusFail, duration, "result %q is not a photo ID", id)
+			return
+		}
+		if seen[id] {
+			r.add("search", StatusFail, duration, "result %s is listed twice", id)
+			return
+		}
+		seen[id] = true
+	}
+	r.add("search", StatusPass, duration, "%d distinct photo IDs", len(result.Ids))
+}
+
+// checkAuth checks that an auth plugin rejects a token it cannot know
+func (r *Report) checkAuth(ctx context.Context, proc *loader.Process, m *manifest.Manifest, timeouts loader.Timeouts) {
+	if !m.Has(manifest.CapabilityAuth) {
+		r.skip("no auth capability", "auth")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeouts.Auth)
+	defer cancel()
+	start := time.Now()
+	resp, err := proc.Client.ValidateToken(ctx, &pluginv1.ValidateTokenRequest{Token: invalidToken})
+	duration := time.Since(start)
+
+	var timeout bool
+	if err != nil {
+		timeout = status.Code(err) == codes.DeadlineExceeded
+	}
+	switch {
+	case timeout:
+		r.add("auth", StatusFail, duration, "%v", callError(err, timeouts.Auth))
+	case err == nil && resp.Ok:
+		r.add("auth", StatusFail, duration, "accepted an invalid token as user %q", resp.UserId)
+	default:
+		r.add("auth", StatusPass, duration, "rejected an invalid token")
+	}
+}
+
+// checkShutdown checks that the plugin exits cleanly on SIGTERM within the grace period
+func (r *Report) checkShutdown(proc *loader.Process, timeouts loader.Timeouts) {
+	start := time.Now()
+	if err := proc.Stop(); err != nil {
+		r.add("shutdown", StatusFail, time.Since(start), "%v", err)
+		return
+	}
+	r.add("shutdown", StatusPass, time.Since(start), "exited cleanly within the %s limit", timeouts.Shutdown)
+}
+
+// callError explains a failed call, pointing out calls that ran into core's timeout
+func callError(err error, limit time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	if status.Code(err) == codes.DeadlineExceeded {
+		return fmt.Errorf("did not answer within core's %s limit", limit)
+	}
+	return err
+}
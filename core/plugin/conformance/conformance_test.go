@@ -0,0 +1,196 @@
+package conformance
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+
+	pluginv1 "pixie/gen/plugin/v1"
+)
+
+// helperEnv makes the test binary act as a plugin in the given mode
+const helperEnv = "PIXIE_CONFORMANCE_HELPER"
+
+func TestMain(m *testing.M) {
+	if mode := os.Getenv(helperEnv); mode != "" {
+		runHelper(mode)
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "conformance")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	os.Setenv("PLUGIN_DATA_DIR", filepath.Join(dir, "data"))
+	os.Setenv("PLUGIN_SOCKET_DIR", filepath.Join(dir, "sockets"))
+	os.Setenv("PLUGIN_SHUTDOWN_GRACE", "3s")
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// runHelper serves a plugin whose behaviour depends on mode:
+// good conforms, counter is not idempotent, badsearch returns invalid IDs
+// and stubborn ignores SIGTERM
+func runHelper(mode string) {
+	socket := os.Getenv("PIXIE_PLUGIN_SOCKET")
+	lis, err := net.Listen("unix", socket)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	conn, err := grpc.Dial(os.Getenv("PIXIE_HOST_ADDR"), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		os.Exit(1)
+	}
+	hostClient := pluginv1.NewHostServicesClient(conn)
+	token := os.Getenv("PIXIE_HOST_TOKEN")
+
+	s := grpc.NewServer()
+	healthServer := health.NewServer()
+	grpc_health_v1.RegisterHealthServer(s, healthServer)
+	s.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "plugin.v1.PhotoPlugin",
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{
+			{
+				MethodName: "ProcessPhoto",
+				Handler: func(_ interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+					in := new(pluginv1.Photo)
+					if err := dec(in); err != nil {
+						return nil, err
+					}
+					patch := `{"processed":true}`
+					if mode == "counter" {
+						patch = fmt.Sprintf(`{"at":%d}`, time.Now().UnixNano())
+					}
+					ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+					_, err := hostClient.PatchMetadata(ctx, &pluginv1.PatchMetadataRequest{PhotoId: in.Id, PatchJson: patch})
+					if err != nil {
+						return nil, err
+					}
+					return &emptypb.Empty{}, nil
+				},
+			},
+			{
+				MethodName: "Search",
+				Handler: func(_ interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+					if err := dec(new(pluginv1.SearchRequest)); err != nil {
+						return nil, err
+					}
+					if mode == "badsearch" {
+						return &pluginv1.SearchResult{Ids: []string{"cat.jpg"}}, nil
+					}
+					return &pluginv1.SearchResult{}, nil
+				},
+			},
+		},
+	}, struct{}{})
+
+	if mode == "stubborn" {
+		signal.Ignore(syscall.SIGTERM)
+	} else {
+		go func() {
+			sigCh := make(chan os.Signal, 1)
+			signal.Notify(sigCh, syscall.SIGTERM)
+			<-sigCh
+			s.GracefulStop()
+		}()
+	}
+
+	fmt.Printf("SOCKET=%s\n", socket)
+	s.Serve(lis)
+}
+
+// writeHelperPlugin writes a plugin directory running the test binary in mode
+func writeHelperPlugin(t *testing.T, mode string) string {
+	t.Helper()
+	t.Setenv(helperEnv, mode)
+	t.Setenv("PLUGIN_ENV", helperEnv)
+
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Symlink(exe, filepath.Join(dir, "helper")); err != nil {
+		t.Fatal(err)
+	}
+	manifest := `name: helper
+version: 0.1.0
+entrypoint: helper
+api_version: 1
+capabilities: [process, search]
+events: [photo.uploaded]
+permissions: [metadata.write]
+sandbox:
+  env: [` + helperEnv + `]
+`
+	if err := os.WriteFile(filepath.Join(dir, "plugin.yaml"), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// statuses returns the status of every check in a report
+func statuses(r *Report) map[string]Status {
+	m := make(map[string]Status)
+	for _, result := range r.Results {
+		m[result.Name] = result.Status
+	}
+	return m
+}
+
+func TestRun(t *testing.T) {
+	tests := map[string]map[string]Status{
+		"good": {
+			"manifest": StatusPass, "handshake": StatusPass, "health": StatusPass, "process": StatusPass,
+			"idempotency": StatusPass, "search": StatusPass, "auth": StatusSkip, "stability": StatusPass, "shutdown": StatusPass,
+		},
+		"counter":   {"process": StatusPass, "idempotency": StatusFail, "shutdown": StatusPass},
+		"badsearch": {"search": StatusFail, "idempotency": StatusPass},
+		"stubborn":  {"stability": StatusPass, "shutdown": StatusFail},
+	}
+	for mode, want := range tests {
+		t.Run(mode, func(t *testing.T) {
+			report := Run(context.Background(), writeHelperPlugin(t, mode))
+
+			got := statuses(report)
+			for name, status := range want {
+				if got[name] != status {
+					var out strings.Builder
+					report.Write(&out)
+					t.Fatalf("expected %s to be %s, got %s\n%s", name, status, got[name], out.String())
+				}
+			}
+			if report.Passed() != (mode == "good") {
+				t.Errorf("unexpected verdict %v", report.Passed())
+			}
+		})
+	}
+}
+
+func TestRunWithoutManifest(t *testing.T) {
+	report := Run(context.Background(), t.TempDir())
+
+	got := statuses(report)
+	if got["manifest"] != StatusFail || got["handshake"] != StatusSkip || report.Passed() {
+		t.Errorf("unexpected results %+v", report.Results)
+	}
+}
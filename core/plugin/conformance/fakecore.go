@@ -0,0 +1,180 @@
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"pixie/plugin/host"
+)
+
+// fakeCore keeps the photos, derivatives, metadata and events behind the host
+// services in memory
+type fakeCore struct {
+	mu          sync.Mutex
+	photos      map[string]fakePhoto
+	derivatives map[string][]byte
+	metadata    map[string]map[string]interface{}
+	events      []string
+}
+
+// fakePhoto is an original file held by the fake core
+type fakePhoto struct {
+	data []byte
+	mime string
+}
+
+// state is what a plugin has written for a photo, used to compare two runs
+type state struct {
+	// derivatives maps storage keys to a hash of their content
+	derivatives map[string]string
+	metadata    map[string]interface{}
+}
+
+// newFakeCore creates an empty fake core
+func newFakeCore() *fakeCore {
+	return &fakeCore{
+		photos:      make(map[string]fakePhoto),
+		derivatives: make(map[string][]byte),
+		metadata:    make(map[string]map[string]interface{}),
+	}
+}
+
+// addTestPhoto adds a small PNG and returns its ID
+func (c *fakeCore) addTestPhoto() (string, error) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for x := 0; x < 64; x++ {
+		for y := 0; y < 48; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 4), G: uint8(y * 5), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", fmt.Errorf("failed to encode test photo: %w", err)
+	}
+
+	id := uuid.NewString()
+	c.mu.Lock()
+	c.photos[id] = fakePhoto{data: buf.Bytes(), mime: "image/png"}
+	c.mu.Unlock()
+	return id, nil
+}
+
+// state returns what plugins have written for a photo
+func (c *fakeCore) state(photoID string) state {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := state{derivatives: make(map[string]string), metadata: copyMap(c.metadata[photoID])}
+	prefix := "/" + photoID + "/"
+	for key, data := range c.derivatives {
+		if strings.Contains(key, prefix) {
+			sum := sha256.Sum256(data)
+			s.derivatives[key] = hex.EncodeToString(sum[:])
+		}
+	}
+	return s
+}
+
+// eventCount returns the number of events plugins have emitted
+func (c *fakeCore) eventCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.events)
+}
+
+// ReadOriginal implements host.Backend
+func (c *fakeCore) ReadOriginal(ctx context.Context, photoID string) ([]byte, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p, ok := c.photos[photoID]
+	if !ok {
+		return nil, "", host.ErrNotFound
+	}
+	return p.data, p.mime, nil
+}
+
+// WriteDerivative implements host.Backend
+func (c *fakeCore) WriteDerivative(ctx context.Context, photoID, key, mime string, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.photos[photoID]; !ok {
+		return host.ErrNotFound
+	}
+	c.derivatives[key] = append([]byte(nil), data...)
+	return nil
+}
+
+// PatchPluginMetadata implements host.Backend
+func (c *fakeCore) PatchPluginMetadata(ctx context.Context, photoID, plugin string, patch map[string]interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.photos[photoID]; !ok {
+		return host.ErrNotFound
+	}
+	meta, ok := c.metadata[photoID]
+	if !ok {
+		meta = make(map[string]interface{})
+		c.metadata[photoID] = meta
+	}
+	namespace, _ := meta[plugin].(map[string]interface{})
+	if namespace == nil {
+		namespace = make(map[string]interface{})
+		meta[plugin] = namespace
+	}
+	mergePatch(namespace, patch)
+	return nil
+}
+
+// Publish implements host.Backend
+func (c *fakeCore) Publish(ctx context.Context, subject string, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.events = append(c.events, subject)
+	return nil
+}
+
+// mergePatch applies a JSON merge patch (RFC 7386) to target
+func mergePatch(target, patch map[string]interface{}) {
+	for key, value := range patch {
+		if value == nil {
+			delete(target, key)
+			continue
+		}
+		if nested, ok := value.(map[string]interface{}); ok {
+			existing, ok := target[key].(map[string]interface{})
+			if !ok {
+				existing = make(map[string]interface{})
+				target[key] = existing
+			}
+			mergePatch(existing, nested)
+			continue
+		}
+		target[key] = value
+	}
+}
+
+// copyMap returns a deep copy of nested metadata
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for key, value := range m {
+		if nested, ok := value.(map[string]interface{}); ok {
+			value = copyMap(nested)
+		}
+		out[key] = value
+	}
+	return out
+}
@@ -0,0 +1,441 @@
+// Package host implements the services core exposes to plugins over gRPC.
+// Plugins authenticate with a per-process token that is scoped to the
+// permissions declared in their manifest. Plugins running outside core
+// register over mTLS with a certificate for their name and a shared secret.
+package host
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	pluginv1 "pixie/gen/plugin/v1"
+	"pixie/plugin/manifest"
+)
+
+// MaxFileSize is the largest original or derivative exchanged with a plugin
+const MaxFileSize = 64 << 20
+
+// maxLogSize is the longest log message and attributes core writes for a plugin
+const maxLogSize = 4096
+
+var (
+	// ErrNotFound is returned by a Backend when the photo does not exist
+	ErrNotFound = errors.New("photo not found")
+
+	// ErrTooLarge is returned by a Backend when a file exceeds MaxFileSize
+	ErrTooLarge = errors.New("file too large")
+
+	// derivativeNameRegex restricts derivative names to a single safe path element
+	derivativeNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+	// eventNameRegex matches the part of an event subject chosen by the plugin
+	eventNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*(\.[a-z0-9][a-z0-9_-]*)*$`)
+
+	// logLevels lists the levels a plugin may log at
+	logLevels = map[string]bool{"debug": true, "info": true, "warn": true, "error": true}
+)
+
+// Backend provides the storage, database and event bus behind the host services
+type Backend interface {
+	// ReadOriginal returns the original file of a photo and its MIME type
+	ReadOriginal(ctx context.Context, photoID string) ([]byte, string, error)
+
+	// WriteDerivative stores a file derived from an existing photo under key
+	WriteDerivative(ctx context.Context, photoID, key, mime string, data []byte) error
+
+	// PatchPluginMetadata merges patch into the plugin's metadata namespace of a photo
+	PatchPluginMetadata(ctx context.Context, photoID, plugin string, patch map[string]interface{}) error
+
+	// Publish publishes an event
+	Publish(ctx context.Context, subject string, data []byte) error
+}
+
+// Grant is what a token allows a plugin to do
+type Grant struct {
+	Plugin      string
+	Permissions []manifest.Permission
+}
+
+// allows reports whether the grant includes a permission
+func (g Grant) allows(p manifest.Permission) bool {
+	for _, permission := range g.Permissions {
+		if permission == p {
+			return true
+		}
+	}
+	return false
+}
+
+// grantKey is the context key for the Grant of the calling plugin
+type grantKey struct{}
+
+// certNameKey is the context key for the plugin name in a registering plugin's certificate
+type certNameKey struct{}
+
+// certName returns the name in the verified client certificate of the caller, if any
+func certName(ctx context.Context) (string, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	return info.State.VerifiedChains[0][0].Subject.CommonName, true
+}
+
+// Server serves the host services to plugins
+type Server struct {
+	backend Backend
+	server  *grpc.Server
+	lis     net.Listener
+
+	// tlsServer serves plugins outside core over mTLS, see ListenTLS
+	tlsServer *grpc.Server
+	tlsLis    net.Listener
+
+	mu     sync.Mutex
+	grants map[string]Grant
+
+	// registrar and secret are set by SetRegistrar
+	registrar Registrar
+	secret    string
+}
+
+// New creates a host services server
+func New(backend Backend) *Server {
+	s := &Server{
+		backend: backend,
+		grants:  make(map[string]Grant),
+	}
+	s.server = s.newGRPCServer()
+	return s
+}
+
+// newGRPCServer creates a gRPC server for the host services and the registry
+func (s *Server) newGRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	server := grpc.NewServer(append(opts,
+		grpc.UnaryInterceptor(s.authenticate),
+		grpc.MaxRecvMsgSize(MaxFileSize+1<<20),
+		grpc.MaxSendMsgSize(MaxFileSize+1<<20),
+	)...)
+	pluginv1.RegisterHostServicesServer(server, &services{s})
+	pluginv1.RegisterPluginRegistryServer(server, &registry{s})
+	return server
+}
+
+// Listen starts serving on addr in the background
+func (s *Server) Listen(addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for host services: %w", err)
+	}
+	s.lis = lis
+
+	go func() {
+		if err := s.server.Serve(lis); err != nil {
+			log.Printf("Host services stopped: %v", err)
+		}
+	}()
+
+	log.Printf("Serving plugin host services on %s", lis.Addr())
+	return nil
+}
+
+// ListenTLS also serves plugins running outside core on addr, in the background.
+// Only clients with a certificate config accepts can connect, and only there
+// can plugins register.
+func (s *Server) ListenTLS(addr string, config *tls.Config) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for remote plugins: %w", err)
+	}
+	s.tlsLis = lis
+	s.tlsServer = s.newGRPCServer(grpc.Creds(credentials.NewTLS(config)))
+
+	go func() {
+		if err := s.tlsServer.Serve(lis); err != nil {
+			log.Printf("Remote plugin host services stopped: %v", err)
+		}
+	}()
+
+	log.Printf("Serving remote plugins on %s", lis.Addr())
+	return nil
+}
+
+// Addr returns the address plugins connect to
+func (s *Server) Addr() string {
+	if s.lis == nil {
+		return ""
+	}
+	return s.lis.Addr().String()
+}
+
+// TLSAddr returns the address plugins running outside core connect to
+func (s *Server) TLSAddr() string {
+	if s.tlsLis == nil {
+		return ""
+	}
+	return s.tlsLis.Addr().String()
+}
+
+// Stop stops the server, waiting for in-flight calls to finish
+func (s *Server) Stop() {
+	if s.tlsServer != nil {
+		s.tlsServer.GracefulStop()
+	}
+	s.server.GracefulStop()
+}
+
+// Issue creates a token for a plugin process, scoped to its permissions
+func (s *Server) Issue(plugin string, permissions []manifest.Permission) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate plugin token: %w", err)
+	}
+	token := hex.EncodeToString(buf)
+
+	s.mu.Lock()
+	s.grants[token] = Grant{
+		Plugin:      plugin,
+		Permissions: append([]manifest.Permission(nil), permissions...),
+	}
+	s.mu.Unlock()
+
+	return token, nil
+}
+
+// Revoke invalidates a token, e.g. once the plugin process has exited
+func (s *Server) Revoke(token string) {
+	s.mu.Lock()
+	delete(s.grants, token)
+	s.mu.Unlock()
+}
+
+// authenticate resolves the token of every call to a Grant. Registry calls are
+// checked against the registration secret instead.
+func (s *Server) authenticate(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "missing plugin token")
+	}
+
+	token, ok := strings.CutPrefix(values[0], "Bearer ")
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid authorization header")
+	}
+
+	if strings.HasPrefix(info.FullMethod, registryPrefix) {
+		name, err := s.checkRegistration(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+		return handler(context.WithValue(ctx, certNameKey{}, name), req)
+	}
+
+	s.mu.Lock()
+	grant, ok := s.grants[token]
+	s.mu.Unlock()
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid plugin token")
+	}
+
+	// Over mTLS, a token only works for the plugin it was issued to
+	if name, ok := certName(ctx); ok && name != grant.Plugin {
+		return nil, status.Error(codes.PermissionDenied, "plugin token was issued to another plugin")
+	}
+
+	return handler(context.WithValue(ctx, grantKey{}, grant), req)
+}
+
+// services implements pluginv1.HostServicesServer
+type services struct {
+	s *Server
+}
+
+// ReadOriginal returns the original file of a photo
+func (h *services) ReadOriginal(ctx context.Context, req *pluginv1.ReadOriginalRequest) (*pluginv1.ReadOriginalResponse, error) {
+	grant, err := require(ctx, manifest.PermissionReadPhotos)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPhotoID(req.PhotoId); err != nil {
+		return nil, err
+	}
+
+	data, mime, err := h.s.backend.ReadOriginal(ctx, req.PhotoId)
+	if err != nil {
+		return nil, toStatus(grant, "read original", err)
+	}
+
+	return &pluginv1.ReadOriginalResponse{Data: data, Mime: mime}, nil
+}
+
+// WriteDerivative stores a derivative under derivatives/<plugin>/<photo_id>/<name>
+func (h *services) WriteDerivative(ctx context.Context, req *pluginv1.WriteDerivativeRequest) (*pluginv1.WriteDerivativeResponse, error) {
+	grant, err := require(ctx, manifest.PermissionWriteDerivatives)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPhotoID(req.PhotoId); err != nil {
+		return nil, err
+	}
+	if !derivativeNameRegex.MatchString(req.Name) {
+		return nil, status.Error(codes.InvalidArgument, "invalid derivative name")
+	}
+	if len(req.Data) > MaxFileSize {
+		return nil, status.Error(codes.ResourceExhausted, ErrTooLarge.Error())
+	}
+
+	mime := req.Mime
+	if mime == "" {
+		mime = "application/octet-stream"
+	}
+
+	key := DerivativeKey(grant.Plugin, req.PhotoId, req.Name)
+	if err := h.s.backend.WriteDerivative(ctx, req.PhotoId, key, mime, req.Data); err != nil {
+		return nil, toStatus(grant, "write derivative", err)
+	}
+
+	return &pluginv1.WriteDerivativeResponse{Key: key}, nil
+}
+
+// PatchMetadata merges a JSON object into meta.plugins.<plugin> of a photo
+func (h *services) PatchMetadata(ctx context.Context, req *pluginv1.PatchMetadataRequest) (*emptypb.Empty, error) {
+	grant, err := require(ctx, manifest.PermissionWriteMetadata)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPhotoID(req.PhotoId); err != nil {
+		return nil, err
+	}
+
+	var patch map[string]interface{}
+	if err := json.Unmarshal([]byte(req.PatchJson), &patch); err != nil || patch == nil {
+		return nil, status.Error(codes.InvalidArgument, "patch must be a JSON object")
+	}
+
+	if err := h.s.backend.PatchPluginMetadata(ctx, req.PhotoId, grant.Plugin, patch); err != nil {
+		return nil, toStatus(grant, "patch metadata", err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// EmitEvent publishes an event on plugin.<plugin>.<name>
+func (h *services) EmitEvent(ctx context.Context, req *pluginv1.EmitEventRequest) (*emptypb.Empty, error) {
+	grant, err := require(ctx, manifest.PermissionEmitEvents)
+	if err != nil {
+		return nil, err
+	}
+	if !eventNameRegex.MatchString(req.Name) {
+		return nil, status.Error(codes.InvalidArgument, "invalid event name")
+	}
+
+	data := []byte(req.DataJson)
+	if len(data) == 0 {
+		data = []byte("{}")
+	}
+	if !json.Valid(data) {
+		return nil, status.Error(codes.InvalidArgument, "event data must be JSON")
+	}
+
+	if err := h.s.backend.Publish(ctx, EventSubject(grant.Plugin, req.Name), data); err != nil {
+		return nil, toStatus(grant, "emit event", err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// Log writes a plugin's log record to core's log. It needs no permission.
+func (h *services) Log(ctx context.Context, req *pluginv1.LogRequest) (*emptypb.Empty, error) {
+	grant, ok := ctx.Value(grantKey{}).(Grant)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "missing plugin token")
+	}
+
+	level := strings.ToLower(req.Level)
+	if !logLevels[level] {
+		level = "info"
+	}
+
+	line := strings.ReplaceAll(req.Message, "\n", " ")
+	if req.AttrsJson != "" {
+		var attrs bytes.Buffer
+		if err := json.Compact(&attrs, []byte(req.AttrsJson)); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "log attributes must be JSON")
+		}
+		line += " " + attrs.String()
+	}
+	if len(line) > maxLogSize {
+		line = line[:maxLogSize] + "..."
+	}
+
+	log.Printf("Plugin %s %s: %s", grant.Plugin, level, line)
+	return &emptypb.Empty{}, nil
+}
+
+// DerivativeKey returns the storage key of a plugin's derivative of a photo
+func DerivativeKey(plugin, photoID, name string) string {
+	return fmt.Sprintf("derivatives/%s/%s/%s", plugin, photoID, name)
+}
+
+// EventSubject returns the subject of an event emitted by a plugin
+func EventSubject(plugin, name string) string {
+	return "plugin." + plugin + "." + name
+}
+
+// require returns the caller's grant if it includes a permission
+func require(ctx context.Context, p manifest.Permission) (Grant, error) {
+	grant, ok := ctx.Value(grantKey{}).(Grant)
+	if !ok {
+		return Grant{}, status.Error(codes.Unauthenticated, "missing plugin token")
+	}
+	if !grant.allows(p) {
+		return Grant{}, status.Errorf(codes.PermissionDenied, "plugin %s does not have the %s permission", grant.Plugin, p)
+	}
+	return grant, nil
+}
+
+// checkPhotoID validates a photo ID
+func checkPhotoID(id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return status.Error(codes.InvalidArgument, "invalid photo ID")
+	}
+	return nil
+}
+
+// toStatus converts a backend error to a gRPC status
+func toStatus(grant Grant, action string, err error) error {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrTooLarge):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	default:
+		log.Printf("Plugin %s failed to %s: %v", grant.Plugin, action, err)
+		return status.Errorf(codes.Internal, "failed to %s", action)
+	}
+}
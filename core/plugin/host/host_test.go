@@ -0,0 +1,217 @@
+package host
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pluginv1 "pixie/gen/plugin/v1"
+	"pixie/plugin/manifest"
+)
+
+const photoID = "6f1c1b2e-8a63-4c3e-9d7e-2b1f0c3a4d5e"
+
+// fakeBackend records the calls made by the host services
+type fakeBackend struct {
+	mu        sync.Mutex
+	keys      []string
+	patches   map[string]map[string]interface{}
+	published []string
+}
+
+func (b *fakeBackend) ReadOriginal(ctx context.Context, id string) ([]byte, string, error) {
+	if id != photoID {
+		return nil, "", ErrNotFound
+	}
+	return []byte("original"), "image/png", nil
+}
+
+func (b *fakeBackend) WriteDerivative(ctx context.Context, id, key, mime string, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.keys = append(b.keys, key)
+	return nil
+}
+
+func (b *fakeBackend) PatchPluginMetadata(ctx context.Context, id, plugin string, patch map[string]interface{}) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.patches[plugin] = patch
+	return nil
+}
+
+func (b *fakeBackend) Publish(ctx context.Context, subject string, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.published = append(b.published, subject)
+	return nil
+}
+
+// newTestServer starts a host services server and returns a client for it
+func newTestServer(t *testing.T) (*Server, *fakeBackend, pluginv1.HostServicesClient) {
+	backend := &fakeBackend{patches: make(map[string]map[string]interface{})}
+	s := New(backend)
+	if err := s.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial(s.Addr(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return s, backend, pluginv1.NewHostServicesClient(conn)
+}
+
+// withToken adds a plugin token to the outgoing context
+func withToken(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func TestHostServicesRequireToken(t *testing.T) {
+	s, _, client := newTestServer(t)
+
+	_, err := client.ReadOriginal(context.Background(), &pluginv1.ReadOriginalRequest{PhotoId: photoID})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated without a token, got %v", err)
+	}
+
+	token, err := s.Issue("thumbnailer", []manifest.Permission{manifest.PermissionReadPhotos})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.ReadOriginal(withToken(token), &pluginv1.ReadOriginalRequest{PhotoId: photoID})
+	if err != nil {
+		t.Fatalf("ReadOriginal: %v", err)
+	}
+	if string(resp.Data) != "original" || resp.Mime != "image/png" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	s.Revoke(token)
+	_, err = client.ReadOriginal(withToken(token), &pluginv1.ReadOriginalRequest{PhotoId: photoID})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated with a revoked token, got %v", err)
+	}
+}
+
+func TestHostServicesEnforcePermissions(t *testing.T) {
+	s, backend, client := newTestServer(t)
+
+	token, err := s.Issue("thumbnailer", []manifest.Permission{manifest.PermissionReadPhotos})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := withToken(token)
+
+	_, err = client.WriteDerivative(ctx, &pluginv1.WriteDerivativeRequest{PhotoId: photoID, Name: "512.jpg", Data: []byte("x")})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied for WriteDerivative, got %v", err)
+	}
+	_, err = client.PatchMetadata(ctx, &pluginv1.PatchMetadataRequest{PhotoId: photoID, PatchJson: `{"a":1}`})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied for PatchMetadata, got %v", err)
+	}
+	_, err = client.EmitEvent(ctx, &pluginv1.EmitEventRequest{Name: "done"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied for EmitEvent, got %v", err)
+	}
+
+	if len(backend.keys) != 0 || len(backend.patches) != 0 || len(backend.published) != 0 {
+		t.Errorf("denied calls reached the backend")
+	}
+}
+
+func TestHostServicesScopeToPlugin(t *testing.T) {
+	s, backend, client := newTestServer(t)
+
+	token, err := s.Issue("thumbnailer", []manifest.Permission{
+		manifest.PermissionWriteDerivatives,
+		manifest.PermissionWriteMetadata,
+		manifest.PermissionEmitEvents,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := withToken(token)
+
+	resp, err := client.WriteDerivative(ctx, &pluginv1.WriteDerivativeRequest{PhotoId: photoID, Name: "512.jpg", Data: []byte("x")})
+	if err != nil {
+		t.Fatalf("WriteDerivative: %v", err)
+	}
+	if want := "derivatives/thumbnailer/" + photoID + "/512.jpg"; resp.Key != want {
+		t.Errorf("expected key %s, got %s", want, resp.Key)
+	}
+
+	_, err = client.WriteDerivative(ctx, &pluginv1.WriteDerivativeRequest{PhotoId: photoID, Name: "../../originals/x"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for an escaping name, got %v", err)
+	}
+
+	if _, err := client.PatchMetadata(ctx, &pluginv1.PatchMetadataRequest{PhotoId: photoID, PatchJson: `{"thumbnails":{"512":"k"}}`}); err != nil {
+		t.Fatalf("PatchMetadata: %v", err)
+	}
+	if _, ok := backend.patches["thumbnailer"]; !ok {
+		t.Errorf("patch was not applied to the plugin's namespace: %v", backend.patches)
+	}
+
+	_, err = client.PatchMetadata(ctx, &pluginv1.PatchMetadataRequest{PhotoId: photoID, PatchJson: `[1]`})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for a non-object patch, got %v", err)
+	}
+
+	if _, err := client.EmitEvent(ctx, &pluginv1.EmitEventRequest{Name: "thumbnail.created", DataJson: `{"id":"1"}`}); err != nil {
+		t.Fatalf("EmitEvent: %v", err)
+	}
+	if len(backend.published) != 1 || backend.published[0] != "plugin.thumbnailer.thumbnail.created" {
+		t.Errorf("unexpected subjects: %v", backend.published)
+	}
+
+	_, err = client.EmitEvent(ctx, &pluginv1.EmitEventRequest{Name: "thumbnail.>"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for a wildcard event name, got %v", err)
+	}
+}
+
+func TestHostServicesLog(t *testing.T) {
+	s, _, client := newTestServer(t)
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	// Logging needs a token but no permission
+	token, err := s.Issue("noop", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := withToken(token)
+
+	if _, err := client.Log(ctx, &pluginv1.LogRequest{Level: "warn", Message: "slow\nrequest", AttrsJson: "{\"ms\": 1200}"}); err != nil {
+		t.Fatalf("Log: %v", err)
+	}
+	if want := `Plugin noop warn: slow request {"ms":1200}`; !strings.Contains(buf.String(), want) {
+		t.Errorf("expected %q in log, got %q", want, buf.String())
+	}
+
+	_, err = client.Log(ctx, &pluginv1.LogRequest{Message: "x", AttrsJson: "{"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for invalid attributes, got %v", err)
+	}
+	_, err = client.Log(context.Background(), &pluginv1.LogRequest{Message: "x"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated without a token, got %v", err)
+	}
+}
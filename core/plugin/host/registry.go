@@ -0,0 +1,139 @@
+package host
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"net"
+	"strconv"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	pluginv1 "pixie/gen/plugin/v1"
+	"pixie/plugin/manifest"
+)
+
+// registryPrefix is the method prefix of the PluginRegistry service
+const registryPrefix = "/plugin.v1.PluginRegistry/"
+
+// Registration is what a plugin running outside core receives when it registers
+type Registration struct {
+	// Token authenticates the plugin's host service calls
+	Token string
+
+	// Settings are the plugin's effective settings as JSON
+	Settings []byte
+
+	// Refresh is how often the plugin must register again, in seconds
+	Refresh int
+}
+
+// Registrar adds plugins running outside core to the loader
+type Registrar interface {
+	Register(ctx context.Context, m *manifest.Manifest, addr string) (Registration, error)
+	Unregister(ctx context.Context, name string) error
+}
+
+// SetRegistrar allows plugins that know secret to register over the network.
+// It must be called before Listen. Registration is disabled without a secret,
+// and only possible on the listener started by ListenTLS.
+func (s *Server) SetRegistrar(r Registrar, secret string) {
+	s.registrar = r
+	s.secret = secret
+}
+
+// checkRegistration checks the client certificate and the registration secret
+// of a registry call. It returns the plugin name in the certificate.
+func (s *Server) checkRegistration(ctx context.Context, secret string) (string, error) {
+	if s.registrar == nil || s.secret == "" {
+		return "", status.Error(codes.Unimplemented, "plugin registration is disabled")
+	}
+	name, ok := certName(ctx)
+	if !ok {
+		return "", status.Error(codes.Unauthenticated, "plugin registration requires a client certificate")
+	}
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(s.secret)) != 1 {
+		return "", status.Error(codes.Unauthenticated, "invalid registration secret")
+	}
+	return name, nil
+}
+
+// checkName makes sure a plugin only registers under the name in its certificate
+func checkName(ctx context.Context, name string) error {
+	if certified, _ := ctx.Value(certNameKey{}).(string); certified != name {
+		return status.Errorf(codes.PermissionDenied, "certificate is not valid for plugin %s", name)
+	}
+	return nil
+}
+
+// registry implements pluginv1.PluginRegistryServer
+type registry struct {
+	s *Server
+}
+
+// Register adds or refreshes a plugin running outside core
+func (r *registry) Register(ctx context.Context, req *pluginv1.RegisterRequest) (*pluginv1.RegisterResponse, error) {
+	m, err := manifest.ParseRemote([]byte(req.ManifestYaml))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := checkName(ctx, m.Name); err != nil {
+		return nil, err
+	}
+	addr, err := resolveAddress(ctx, req.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	reg, err := r.s.registrar.Register(ctx, m, addr)
+	if err != nil {
+		log.Printf("Failed to register plugin %s at %s: %v", m.Name, addr, err)
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+
+	return &pluginv1.RegisterResponse{
+		HostToken:      reg.Token,
+		SettingsJson:   string(reg.Settings),
+		RefreshSeconds: int32(reg.Refresh),
+	}, nil
+}
+
+// Unregister removes a plugin running outside core, e.g. when it shuts down
+func (r *registry) Unregister(ctx context.Context, req *pluginv1.UnregisterRequest) (*emptypb.Empty, error) {
+	if err := checkName(ctx, req.Name); err != nil {
+		return nil, err
+	}
+	if err := r.s.registrar.Unregister(ctx, req.Name); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// resolveAddress validates the address a plugin asked core to connect to. A
+// missing host, as in ":50051", is replaced by the address the call came from.
+func resolveAddress(ctx context.Context, addr string) (string, error) {
+	hostname, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", status.Error(codes.InvalidArgument, "address must be host:port")
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", status.Error(codes.InvalidArgument, "invalid port")
+	}
+
+	if hostname == "" {
+		p, ok := peer.FromContext(ctx)
+		if !ok {
+			return "", status.Error(codes.InvalidArgument, "address must include a host")
+		}
+		tcpAddr, ok := p.Addr.(*net.TCPAddr)
+		if !ok {
+			return "", status.Error(codes.InvalidArgument, "address must include a host")
+		}
+		hostname = tcpAddr.IP.String()
+	}
+
+	return net.JoinHostPort(hostname, port), nil
+}
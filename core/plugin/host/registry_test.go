@@ -0,0 +1,223 @@
+package host
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	pluginv1 "pixie/gen/plugin/v1"
+	"pixie/plugin/manifest"
+)
+
+const remoteManifest = "name: thumbnailer\nversion: 0.1.0\napi_version: 1\ncapabilities: [process]\n"
+
+// fakeRegistrar records the plugins registered over the network
+type fakeRegistrar struct {
+	addrs map[string]string
+}
+
+func (r *fakeRegistrar) Register(ctx context.Context, m *manifest.Manifest, addr string) (Registration, error) {
+	r.addrs[m.Name] = addr
+	return Registration{Token: "token", Settings: []byte(`{}`), Refresh: 30}, nil
+}
+
+func (r *fakeRegistrar) Unregister(ctx context.Context, name string) error {
+	delete(r.addrs, name)
+	return nil
+}
+
+// testCA issues the certificates of a registry test
+type testCA struct {
+	t    *testing.T
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+// newTestCA creates a CA for a test
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{t: t, cert: cert, key: key, pool: pool}
+}
+
+// issue returns a certificate for name
+func (ca *testCA) issue(name string, usage x509.ExtKeyUsage) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		ca.t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		ca.t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// newRegistryServer starts a host services server that accepts registrations with
+// secret and returns a registry client with a certificate for plugin
+func newRegistryServer(t *testing.T, secret, plugin string) (*fakeRegistrar, *Server, pluginv1.PluginRegistryClient) {
+	ca := newTestCA(t)
+	registrar := &fakeRegistrar{addrs: make(map[string]string)}
+	s := New(&fakeBackend{})
+	s.SetRegistrar(registrar, secret)
+	if err := s.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	err := s.ListenTLS("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{ca.issue("pixie-core", x509.ExtKeyUsageServerAuth)},
+		ClientCAs:    ca.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Stop)
+
+	creds := credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{ca.issue(plugin, x509.ExtKeyUsageClientAuth)},
+		RootCAs:      ca.pool,
+		ServerName:   "pixie-core",
+	})
+	conn, err := grpc.Dial(s.TLSAddr(), grpc.WithTransportCredentials(creds))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return registrar, s, pluginv1.NewPluginRegistryClient(conn)
+}
+
+func TestRegistryRequiresSecret(t *testing.T) {
+	registrar, _, client := newRegistryServer(t, "s3cret", "thumbnailer")
+	req := &pluginv1.RegisterRequest{ManifestYaml: remoteManifest, Address: "thumbnailer:50051"}
+
+	if _, err := client.Register(withToken("wrong"), req); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated with a wrong secret, got %v", err)
+	}
+	if len(registrar.addrs) != 0 {
+		t.Fatalf("unauthenticated registration reached the registrar")
+	}
+
+	resp, err := client.Register(withToken("s3cret"), req)
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if resp.HostToken != "token" || resp.RefreshSeconds != 30 {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+	if registrar.addrs["thumbnailer"] != "thumbnailer:50051" {
+		t.Errorf("unexpected address %q", registrar.addrs["thumbnailer"])
+	}
+
+	if _, err := client.Unregister(withToken("s3cret"), &pluginv1.UnregisterRequest{Name: "thumbnailer"}); err != nil {
+		t.Fatalf("Unregister: %v", err)
+	}
+	if len(registrar.addrs) != 0 {
+		t.Errorf("plugin was not unregistered")
+	}
+}
+
+func TestRegistryDisabledWithoutSecret(t *testing.T) {
+	_, _, client := newRegistryServer(t, "", "thumbnailer")
+
+	_, err := client.Register(withToken(""), &pluginv1.RegisterRequest{ManifestYaml: remoteManifest, Address: "thumbnailer:50051"})
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("expected Unimplemented without a secret, got %v", err)
+	}
+}
+
+func TestRegistryResolvesAddress(t *testing.T) {
+	registrar, _, client := newRegistryServer(t, "s3cret", "thumbnailer")
+	ctx := withToken("s3cret")
+
+	// A missing host is replaced by the address the plugin called from
+	if _, err := client.Register(ctx, &pluginv1.RegisterRequest{ManifestYaml: remoteManifest, Address: ":50051"}); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if registrar.addrs["thumbnailer"] != "127.0.0.1:50051" {
+		t.Errorf("unexpected address %q", registrar.addrs["thumbnailer"])
+	}
+
+	for _, addr := range []string{"thumbnailer", "thumbnailer:0", "thumbnailer:http"} {
+		_, err := client.Register(ctx, &pluginv1.RegisterRequest{ManifestYaml: remoteManifest, Address: addr})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%s: expected InvalidArgument, got %v", addr, err)
+		}
+	}
+
+	_, err := client.Register(ctx, &pluginv1.RegisterRequest{ManifestYaml: "name: Bad\n", Address: ":50051"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for an invalid manifest, got %v", err)
+	}
+}
+
+func TestRegistryRequiresCertificateForName(t *testing.T) {
+	registrar, s, client := newRegistryServer(t, "s3cret", "other")
+	req := &pluginv1.RegisterRequest{ManifestYaml: remoteManifest, Address: "thumbnailer:50051"}
+
+	// A certificate for another plugin cannot register or unregister thumbnailer
+	if _, err := client.Register(withToken("s3cret"), req); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied for another plugin's certificate, got %v", err)
+	}
+	if _, err := client.Unregister(withToken("s3cret"), &pluginv1.UnregisterRequest{Name: "thumbnailer"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied for another plugin's certificate, got %v", err)
+	}
+
+	// The secret alone is not enough on the plaintext listener
+	conn, err := grpc.Dial(s.Addr(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	plain := pluginv1.NewPluginRegistryClient(conn)
+	if _, err := plain.Register(withToken("s3cret"), req); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated without a client certificate, got %v", err)
+	}
+	if len(registrar.addrs) != 0 {
+		t.Errorf("registration reached the registrar: %v", registrar.addrs)
+	}
+}
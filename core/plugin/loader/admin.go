@@ -0,0 +1,318 @@
+package loader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	pluginv1 "pixie/gen/plugin/v1"
+	"pixie/plugin/manifest"
+)
+
+var (
+	// ErrPluginNotFound is returned when no plugin has the requested name
+	ErrPluginNotFound = errors.New("plugin not found")
+
+	// ErrInvalidSettings is returned when settings do not match the plugin's schema
+	ErrInvalidSettings = errors.New("invalid plugin settings")
+)
+
+// Plugin statuses reported by List
+const (
+	StatusRunning  = "running"
+	StatusStarting = "starting"
+	StatusCrashed  = "crashed"
+	StatusDisabled = "disabled"
+)
+
+// Info describes the current state of a plugin
+type Info struct {
+	Name          string                `json:"name"`
+	Version       string                `json:"version"`
+	Description   string                `json:"description,omitempty"`
+	Capabilities  []manifest.Capability `json:"capabilities"`
+	Events        []string              `json:"events,omitempty"`
+	Enabled       bool                  `json:"enabled"`
+	Healthy       bool                  `json:"healthy"`
+	Status        string                `json:"status"`
+	PID           int                   `json:"pid,omitempty"`
+	Transport     string                `json:"transport,omitempty"`
+	Port          int                   `json:"port,omitempty"`
+	Address       string                `json:"address,omitempty"`
+	StartedAt     *time.Time            `json:"started_at,omitempty"`
+	UptimeSeconds int64                 `json:"uptime_seconds"`
+	Restarts      int                   `json:"restarts"`
+	LastError     string                `json:"last_error,omitempty"`
+}
+
+// List returns the state of every plugin with a valid manifest
+func List() []Info {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	infos := make([]Info, 0, len(plugins))
+	for _, p := range plugins {
+		infos = append(infos, p.info())
+	}
+	return infos
+}
+
+// Get returns the state of a single plugin
+func Get(name string) (Info, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	p := findLocked(name)
+	if p == nil {
+		return Info{}, ErrPluginNotFound
+	}
+	return p.info(), nil
+}
+
+// Enable starts a disabled plugin and persists the change
+func Enable(ctx context.Context, name string) error {
+	p, err := find(name)
+	if err != nil {
+		return err
+	}
+
+	p.ops.Lock()
+	defer p.ops.Unlock()
+
+	if err := saveEnabled(ctx, name, true); err != nil {
+		return err
+	}
+
+	mutex.Lock()
+	running := p.cancel != nil
+	p.enabled = true
+	mutex.Unlock()
+
+	if !running {
+		log.Printf("Enabling plugin %s", name)
+		p.startSupervisor(func() {})
+	}
+	return nil
+}
+
+// Disable stops a plugin, removes it from the registry and persists the change
+func Disable(ctx context.Context, name string) error {
+	p, err := find(name)
+	if err != nil {
+		return err
+	}
+
+	p.ops.Lock()
+	defer p.ops.Unlock()
+
+	if err := saveEnabled(ctx, name, false); err != nil {
+		return err
+	}
+
+	mutex.Lock()
+	p.enabled = false
+	mutex.Unlock()
+
+	log.Printf("Disabling plugin %s", name)
+	p.stopSupervisor()
+	return nil
+}
+
+// Reload re-reads a plugin's manifest and restarts it. Remote plugins are reconnected.
+// A disabled plugin stays disabled.
+func Reload(ctx context.Context, name string) error {
+	p, err := find(name)
+	if err != nil {
+		return err
+	}
+
+	p.ops.Lock()
+	defer p.ops.Unlock()
+
+	mutex.Lock()
+	path := p.manifest.Dir
+	enabled := p.enabled
+	remote := p.remote
+	mutex.Unlock()
+
+	// Remote plugins send their manifest when they register, so only reconnect
+	if remote != "" {
+		log.Printf("Reconnecting to remote plugin %s", name)
+		p.stopSupervisor()
+		if enabled {
+			p.startSupervisor(func() {})
+		}
+		return nil
+	}
+
+	m, err := manifest.Load(filepath.Join(path, manifest.FileName))
+	if err != nil {
+		return err
+	}
+	if m.Name != name {
+		return fmt.Errorf("%w: plugin was renamed from %s to %s", manifest.ErrInvalidManifest, name, m.Name)
+	}
+
+	log.Printf("Reloading plugin %s", name)
+	p.stopSupervisor()
+
+	mutex.Lock()
+	p.setManifest(m)
+	p.path = m.EntrypointPath()
+	p.lastErr = nil
+	mutex.Unlock()
+
+	if enabled {
+		p.startSupervisor(func() {})
+	}
+	return nil
+}
+
+// Settings returns the schema and the effective settings of a plugin
+func Settings(name string) (*manifest.Schema, map[string]interface{}, error) {
+	p, err := find(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mutex.Lock()
+	schema := p.manifest.Settings
+	mutex.Unlock()
+
+	return schema, p.effectiveSettings(), nil
+}
+
+// Configure validates new settings against the plugin's schema, persists them and
+// pushes them to the plugin if it is running
+func Configure(ctx context.Context, name string, settings map[string]interface{}) error {
+	p, err := find(name)
+	if err != nil {
+		return err
+	}
+
+	p.ops.Lock()
+	defer p.ops.Unlock()
+
+	mutex.Lock()
+	schema := p.manifest.Settings
+	mutex.Unlock()
+
+	if schema == nil {
+		return fmt.Errorf("%w: plugin %s has no settings", ErrInvalidSettings, name)
+	}
+	if err := schema.Validate(settings); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSettings, err)
+	}
+
+	if store != nil {
+		if err := store.SavePluginSettings(ctx, name, settings); err != nil {
+			return err
+		}
+	}
+
+	mutex.Lock()
+	p.settings = settings
+	client := p.client
+	mutex.Unlock()
+
+	// A stopped plugin picks up its settings from the environment when it next starts
+	if client == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(p.effectiveSettings())
+	if err != nil {
+		return fmt.Errorf("failed to marshal plugin settings: %w", err)
+	}
+	if _, err := client.Configure(ctx, &pluginv1.ConfigureRequest{SettingsJson: string(data)}); err != nil {
+		mutex.Lock()
+		p.lastErr = fmt.Errorf("failed to push settings: %w", err)
+		mutex.Unlock()
+		return fmt.Errorf("settings saved but could not be pushed to plugin: %w", err)
+	}
+
+	log.Printf("Pushed new settings to plugin %s", name)
+	return nil
+}
+
+// info builds the Info for a plugin. The caller must hold mutex.
+func (p *plugin) info() Info {
+	info := Info{
+		Name:         p.name,
+		Version:      p.manifest.Version,
+		Description:  p.manifest.Description,
+		Capabilities: p.manifest.Capabilities,
+		Events:       p.manifest.Events,
+		Enabled:      p.enabled,
+		Healthy:      p.healthy,
+		Restarts:     p.restarts,
+	}
+	if p.lastErr != nil {
+		info.LastError = p.lastErr.Error()
+	}
+
+	switch {
+	case !p.enabled:
+		info.Status = StatusDisabled
+	case p.healthy:
+		info.Status = StatusRunning
+	case p.lastErr != nil:
+		info.Status = StatusCrashed
+	default:
+		info.Status = StatusStarting
+	}
+
+	if p.healthy && p.cmd != nil && p.cmd.Process != nil {
+		startedAt := p.startedAt
+		info.PID = p.cmd.Process.Pid
+		info.Transport = p.transport
+		info.Port = p.port
+		info.StartedAt = &startedAt
+		info.UptimeSeconds = int64(time.Since(startedAt).Seconds())
+	}
+	if p.remote != "" {
+		info.Transport = manifest.TransportRemote
+		info.Address = p.remote
+		if p.healthy {
+			startedAt := p.startedAt
+			info.StartedAt = &startedAt
+			info.UptimeSeconds = int64(time.Since(startedAt).Seconds())
+		}
+	}
+
+	return info
+}
+
+// find returns the plugin with the given name
+func find(name string) (*plugin, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	p := findLocked(name)
+	if p == nil {
+		return nil, ErrPluginNotFound
+	}
+	return p, nil
+}
+
+// findLocked returns the plugin with the given name. The caller must hold mutex.
+func findLocked(name string) *plugin {
+	for _, p := range plugins {
+		if p.name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// saveEnabled persists whether a plugin is enabled, if a store is configured
+func saveEnabled(ctx context.Context, name string, enabled bool) error {
+	if store == nil {
+		return nil
+	}
+	return store.SetPluginEnabled(ctx, name, enabled)
+}
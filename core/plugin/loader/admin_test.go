@@ -0,0 +1,108 @@
+package loader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	pluginv1 "pixie/gen/plugin/v1"
+	"pixie/plugin/manifest"
+)
+
+// configurePlugin is a fake plugin that records the settings pushed to it
+type configurePlugin struct {
+	pluginv1.UnimplementedPhotoPluginServer
+
+	mu       sync.Mutex
+	settings []string
+}
+
+// Configure implements pluginv1.PhotoPluginServer
+func (f *configurePlugin) Configure(ctx context.Context, in *pluginv1.ConfigureRequest) (*emptypb.Empty, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.settings = append(f.settings, in.SettingsJson)
+	return &emptypb.Empty{}, nil
+}
+
+func TestConfigurePushesSettings(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	impl := &configurePlugin{}
+	server := grpc.NewServer()
+	pluginv1.RegisterPhotoPluginServer(server, impl)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	m, err := manifest.Parse([]byte(`name: thumbs
+version: 0.1.0
+entrypoint: bin
+api_version: 1
+capabilities: [process]
+settings:
+  type: object
+  properties:
+    size: {type: integer, default: 512, enum: [256, 512]}
+    workers: {type: integer, default: 4, minimum: 1}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newPlugin(m)
+	p.client = pluginv1.NewPhotoPluginClient(conn)
+	p.healthy = true
+
+	mutex.Lock()
+	plugins = []*plugin{p}
+	mutex.Unlock()
+	defer func() {
+		mutex.Lock()
+		plugins = nil
+		mutex.Unlock()
+	}()
+
+	if err := Configure(context.Background(), "thumbs", map[string]interface{}{"size": 256}); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+
+	// The plugin receives the new settings merged over the defaults
+	impl.mu.Lock()
+	pushed := append([]string(nil), impl.settings...)
+	impl.mu.Unlock()
+	if len(pushed) != 1 {
+		t.Fatalf("expected one Configure call, got %d", len(pushed))
+	}
+	var settings map[string]interface{}
+	if err := json.Unmarshal([]byte(pushed[0]), &settings); err != nil {
+		t.Fatal(err)
+	}
+	if settings["size"] != float64(256) || settings["workers"] != float64(4) {
+		t.Errorf("unexpected settings pushed: %v", settings)
+	}
+
+	// Invalid settings are neither saved nor pushed
+	if err := Configure(context.Background(), "thumbs", map[string]interface{}{"size": 300}); !errors.Is(err, ErrInvalidSettings) {
+		t.Errorf("expected ErrInvalidSettings, got %v", err)
+	}
+	impl.mu.Lock()
+	calls := len(impl.settings)
+	impl.mu.Unlock()
+	if calls != 1 {
+		t.Errorf("expected invalid settings not to be pushed, got %d calls", calls)
+	}
+}
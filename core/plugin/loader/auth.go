@@ -0,0 +1,76 @@
+package loader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	pluginv1 "pixie/gen/plugin/v1"
+	"pixie/plugin/manifest"
+)
+
+// authTimeout bounds a single ValidateToken call
+const authTimeout = 5 * time.Second
+
+// ErrTokenRejected is returned when no auth plugin accepts a token
+var ErrTokenRejected = errors.New("token rejected by auth plugins")
+
+// authClient is a healthy plugin with the auth capability
+type authClient struct {
+	name   string
+	client pluginv1.PhotoPluginClient
+}
+
+// ValidateToken asks the auth plugins, in load order, to validate a bearer token.
+// The first plugin that accepts it determines the user ID and custom claims.
+func ValidateToken(ctx context.Context, token string) (string, map[string]interface{}, error) {
+	mutex.Lock()
+	var clients []authClient
+	for _, p := range plugins {
+		if p.healthy && p.client != nil && p.manifest.Has(manifest.CapabilityAuth) {
+			clients = append(clients, authClient{name: p.name, client: p.client})
+		}
+	}
+	mutex.Unlock()
+
+	for _, c := range clients {
+		userID, claims, err := c.validate(ctx, token)
+		if err != nil {
+			log.Printf("Auth plugin %s did not accept token: %v", c.name, err)
+			continue
+		}
+		return userID, claims, nil
+	}
+	return "", nil, ErrTokenRejected
+}
+
+// validate calls ValidateToken on a single plugin
+func (c authClient) validate(ctx context.Context, token string) (string, map[string]interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, authTimeout)
+	defer cancel()
+
+	resp, err := c.client.ValidateToken(ctx, &pluginv1.ValidateTokenRequest{Token: token})
+	if err != nil {
+		return "", nil, err
+	}
+	if !resp.Ok {
+		if resp.Error == "" {
+			return "", nil, ErrTokenRejected
+		}
+		return "", nil, errors.New(resp.Error)
+	}
+	if resp.UserId == "" {
+		return "", nil, errors.New("plugin returned no user ID")
+	}
+
+	claims := make(map[string]interface{})
+	if resp.ClaimsJson != "" {
+		if err := json.Unmarshal([]byte(resp.ClaimsJson), &claims); err != nil {
+			return "", nil, fmt.Errorf("invalid claims: %w", err)
+		}
+	}
+	return resp.UserId, claims, nil
+}
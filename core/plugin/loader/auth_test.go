@@ -0,0 +1,88 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	pluginv1 "pixie/gen/plugin/v1"
+	"pixie/plugin/manifest"
+)
+
+// fakeAuthPlugin accepts a single API key
+type fakeAuthPlugin struct{}
+
+func (fakeAuthPlugin) ValidateToken(ctx context.Context, req *pluginv1.ValidateTokenRequest) (*pluginv1.ValidateTokenResponse, error) {
+	if req.Token != "pxk_valid" {
+		return &pluginv1.ValidateTokenResponse{Error: "unknown key"}, nil
+	}
+	return &pluginv1.ValidateTokenResponse{Ok: true, UserId: "key-owner", ClaimsJson: `{"role":"user"}`}, nil
+}
+
+// serveAuthPlugin serves ValidateToken like an auth plugin and returns a client for it
+func serveAuthPlugin(t *testing.T) pluginv1.PhotoPluginClient {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "plugin.v1.PhotoPlugin",
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "ValidateToken",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := new(pluginv1.ValidateTokenRequest)
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				return srv.(fakeAuthPlugin).ValidateToken(ctx, in)
+			},
+		}},
+	}, fakeAuthPlugin{})
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pluginv1.NewPhotoPluginClient(conn)
+}
+
+func TestValidateTokenWithAuthPlugins(t *testing.T) {
+	auth := newPlugin(&manifest.Manifest{Name: "apikeys", Capabilities: []manifest.Capability{manifest.CapabilityAuth}})
+	auth.client = serveAuthPlugin(t)
+	auth.healthy = true
+
+	// Plugins without the auth capability are never asked
+	search := newPlugin(&manifest.Manifest{Name: "search", Capabilities: []manifest.Capability{manifest.CapabilitySearch}})
+	search.client = auth.client
+	search.healthy = true
+
+	mutex.Lock()
+	plugins = []*plugin{search, auth}
+	mutex.Unlock()
+	defer func() {
+		mutex.Lock()
+		plugins = nil
+		mutex.Unlock()
+	}()
+
+	userID, claims, err := ValidateToken(context.Background(), "pxk_valid")
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	if userID != "key-owner" || claims["role"] != "user" {
+		t.Errorf("unexpected result %q %v", userID, claims)
+	}
+
+	if _, _, err := ValidateToken(context.Background(), "pxk_invalid"); !errors.Is(err, ErrTokenRejected) {
+		t.Errorf("expected ErrTokenRejected, got %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package loader
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"pixie/events"
+	pluginv1 "pixie/gen/plugin/v1"
+	"pixie/plugin/manifest"
+)
+
+// dispatchTimeout bounds a single ProcessPhoto call
+const dispatchTimeout = 30 * time.Second
+
+// photoEvent holds the fields of a photo event that are passed on to plugins
+type photoEvent struct {
+	Id    string `json:"id"`
+	S3Key string `json:"s3_key"`
+	Mime  string `json:"mime"`
+}
+
+// maxPendingEvents bounds the events being delivered at once across all plugins.
+// Further events wait in the bus until one of them is done.
+const maxPendingEvents = 256
+
+// Dispatch subscribes to photo events on the bus and calls ProcessPhoto on
+// every processing plugin whose manifest subscribes to the event's subject.
+// Events are delivered concurrently, up to each plugin's concurrency, and an
+// event is redelivered if any plugin fails to process it.
+func Dispatch(bus events.Bus) (events.Subscription, error) {
+	pending := make(chan struct{}, maxPendingEvents)
+	return bus.Subscribe("photo.>", "core-plugins", func(msg events.Message) {
+		targets := subscribers(msg.Subject())
+		if len(targets) == 0 {
+			msg.Ack()
+			return
+		}
+
+		var event photoEvent
+		if err := json.Unmarshal(msg.Data(), &event); err != nil {
+			log.Printf("Failed to unmarshal %s event: %v", msg.Subject(), err)
+			msg.Ack()
+			return
+		}
+
+		photo := &pluginv1.Photo{
+			Id:    event.Id,
+			S3Key: event.S3Key,
+			Mime:  event.Mime,
+			Event: msg.Subject(),
+		}
+
+		// Block the subscription while too many events are in flight, so the
+		// backlog stays in the bus
+		pending <- struct{}{}
+		go func() {
+			defer func() { <-pending }()
+			deliver(msg, photo, targets)
+		}()
+	})
+}
+
+// deliver calls ProcessPhoto on every target in parallel, each within its
+// plugin's concurrency. The event is acked once every plugin has processed it
+// and redelivered to all of them otherwise, so ProcessPhoto must be idempotent.
+func deliver(msg events.Message, photo *pluginv1.Photo, targets []target) {
+	var wg sync.WaitGroup
+	var failed atomic.Bool
+	for _, t := range targets {
+		wg.Add(1)
+		go func(t target) {
+			defer wg.Done()
+
+			t.slots <- struct{}{}
+			defer func() { <-t.slots }()
+
+			ctx, cancel := context.WithTimeout(context.Background(), dispatchTimeout)
+			defer cancel()
+			if _, err := t.client.ProcessPhoto(ctx, photo); err != nil {
+				log.Printf("Plugin %s failed to process %s for photo %s: %v", t.name, photo.Event, photo.Id, err)
+				failed.Store(true)
+			}
+		}(t)
+	}
+	wg.Wait()
+
+	if failed.Load() {
+		msg.Nak()
+		return
+	}
+	msg.Ack()
+}
+
+// Search asks every search plugin for photos matching query and merges the results
+func Search(ctx context.Context, query string) []string {
+	seen := make(map[string]bool)
+	ids := []string{}
+	for _, client := range Clients(manifest.CapabilitySearch) {
+		result, err := client.Search(ctx, &pluginv1.SearchRequest{Query: query})
+		if err != nil {
+			log.Printf("Plugin search failed: %v", err)
+			continue
+		}
+		for _, id := range result.Ids {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
@@ -0,0 +1,186 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"pixie/events"
+	pluginv1 "pixie/gen/plugin/v1"
+	"pixie/plugin/manifest"
+)
+
+// processPlugin is a fake processing plugin that records its ProcessPhoto calls
+type processPlugin struct {
+	pluginv1.UnimplementedPhotoPluginServer
+
+	mu       sync.Mutex
+	calls    int
+	inFlight int
+	peak     int
+	fail     int
+	release  chan struct{}
+}
+
+// ProcessPhoto implements pluginv1.PhotoPluginServer
+func (f *processPlugin) ProcessPhoto(ctx context.Context, in *pluginv1.Photo) (*emptypb.Empty, error) {
+	f.mu.Lock()
+	f.calls++
+	f.inFlight++
+	if f.inFlight > f.peak {
+		f.peak = f.inFlight
+	}
+	fail := f.fail > 0
+	if fail {
+		f.fail--
+	}
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		f.inFlight--
+		f.mu.Unlock()
+	}()
+
+	if f.release != nil {
+		select {
+		case <-f.release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if fail {
+		return nil, errors.New("transient failure")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// stats returns the number of calls and the most calls that were in flight at once
+func (f *processPlugin) stats() (int, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls, f.peak
+}
+
+// serveProcessPlugin adds a healthy processing plugin subscribed to photo.uploaded
+func serveProcessPlugin(t *testing.T, name string, concurrency int, impl *processPlugin) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	pluginv1.RegisterPhotoPluginServer(server, impl)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	p := newPlugin(&manifest.Manifest{
+		Name:         name,
+		Capabilities: []manifest.Capability{manifest.CapabilityProcess},
+		Events:       []string{"photo.uploaded"},
+		Concurrency:  concurrency,
+	})
+	p.client = pluginv1.NewPhotoPluginClient(conn)
+	p.healthy = true
+
+	mutex.Lock()
+	plugins = append(plugins, p)
+	mutex.Unlock()
+	t.Cleanup(func() {
+		mutex.Lock()
+		plugins = nil
+		mutex.Unlock()
+	})
+}
+
+// waitFor polls cond until it is true or the test times out
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDispatchRespectsConcurrency(t *testing.T) {
+	bus := events.NewInProc()
+	defer bus.Close()
+
+	slow := &processPlugin{release: make(chan struct{})}
+	serveProcessPlugin(t, "slow", 2, slow)
+	single := &processPlugin{}
+	serveProcessPlugin(t, "single", 0, single)
+
+	if _, err := Dispatch(bus); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := bus.Publish(context.Background(), "photo.uploaded", []byte(`{"id":"p1"}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The slow plugin gets two events at once and no more, while the other keeps up
+	waitFor(t, "two deliveries to the slow plugin", func() bool {
+		calls, _ := slow.stats()
+		return calls == 2
+	})
+	waitFor(t, "all deliveries to the other plugin", func() bool {
+		calls, _ := single.stats()
+		return calls == 5
+	})
+	if calls, _ := slow.stats(); calls != 2 {
+		t.Fatalf("expected the slow plugin to be limited to 2 calls, got %d", calls)
+	}
+
+	close(slow.release)
+	waitFor(t, "all deliveries to the slow plugin", func() bool {
+		calls, _ := slow.stats()
+		return calls == 5
+	})
+	if _, peak := slow.stats(); peak != 2 {
+		t.Errorf("expected at most 2 concurrent calls to the slow plugin, got %d", peak)
+	}
+	if _, peak := single.stats(); peak != 1 {
+		t.Errorf("expected 1 concurrent call by default, got %d", peak)
+	}
+}
+
+func TestDispatchRedeliversFailedEvents(t *testing.T) {
+	bus := events.NewInProc()
+	defer bus.Close()
+
+	flaky := &processPlugin{fail: 1}
+	serveProcessPlugin(t, "flaky", 1, flaky)
+
+	if _, err := Dispatch(bus); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Publish(context.Background(), "photo.uploaded", []byte(`{"id":"p1"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The failed delivery is retried and then acked
+	waitFor(t, "redelivery", func() bool {
+		calls, _ := flaky.stats()
+		return calls == 2
+	})
+	time.Sleep(100 * time.Millisecond)
+	if calls, _ := flaky.stats(); calls != 2 {
+		t.Errorf("expected the event to be acked after it succeeded, got %d calls", calls)
+	}
+}
@@ -0,0 +1,101 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	pluginv1 "pixie/gen/plugin/v1"
+	"pixie/plugin/manifest"
+)
+
+// httpTimeout bounds a single proxied HTTP request
+const httpTimeout = 30 * time.Second
+
+var (
+	// ErrRouteNotFound is returned when a plugin declares no route for a request
+	ErrRouteNotFound = errors.New("plugin route not found")
+
+	// ErrPluginUnavailable is returned when a plugin cannot serve requests right now
+	ErrPluginUnavailable = errors.New("plugin unavailable")
+)
+
+// UIExtension describes the navigation entries contributed by a plugin
+type UIExtension struct {
+	Plugin  string               `json:"plugin"`
+	BaseURL string               `json:"base_url"`
+	Menu    []manifest.MenuEntry `json:"menu"`
+}
+
+// MatchRoute returns the route of a plugin handling a request. path must be clean.
+func MatchRoute(name, method, path string) (manifest.Route, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	p := findLocked(name)
+	if p == nil {
+		return manifest.Route{}, ErrPluginNotFound
+	}
+	route, ok := p.manifest.Route(method, path)
+	if !ok {
+		return manifest.Route{}, ErrRouteNotFound
+	}
+	return route, nil
+}
+
+// ServeHTTP forwards a request to one of a plugin's routes
+func ServeHTTP(ctx context.Context, name string, req *pluginv1.HTTPRequest) (*pluginv1.HTTPResponse, error) {
+	mutex.Lock()
+	p := findLocked(name)
+	if p == nil {
+		mutex.Unlock()
+		return nil, ErrPluginNotFound
+	}
+	client := p.client
+	available := p.enabled && p.healthy && client != nil
+	mutex.Unlock()
+
+	if !available {
+		return nil, ErrPluginUnavailable
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, httpTimeout)
+	defer cancel()
+
+	return client.HandleHTTP(ctx, req)
+}
+
+// UIExtensions returns the menu entries of enabled, healthy plugins with UI assets
+func UIExtensions() []UIExtension {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	var extensions []UIExtension
+	for _, p := range plugins {
+		if !p.enabled || !p.healthy || len(p.manifest.UI.Menu) == 0 || p.manifest.AssetsPath() == "" {
+			continue
+		}
+		extensions = append(extensions, UIExtension{
+			Plugin:  p.name,
+			BaseURL: "/plugin-ui/" + p.name + "/",
+			Menu:    p.manifest.UI.Menu,
+		})
+	}
+	return extensions
+}
+
+// AssetsPath returns the directory holding a plugin's UI assets
+func AssetsPath(name string) (string, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	p := findLocked(name)
+	if p == nil || !p.enabled {
+		return "", ErrPluginNotFound
+	}
+	path := p.manifest.AssetsPath()
+	if path == "" {
+		return "", ErrPluginNotFound
+	}
+	return path, nil
+}
@@ -0,0 +1,126 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	pluginv1 "pixie/gen/plugin/v1"
+	"pixie/plugin/manifest"
+)
+
+// serveHTTPPlugin serves HandleHTTP by echoing the request and returns a client for it
+func serveHTTPPlugin(t *testing.T) pluginv1.PhotoPluginClient {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "plugin.v1.PhotoPlugin",
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "HandleHTTP",
+			Handler: func(_ interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := new(pluginv1.HTTPRequest)
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				return &pluginv1.HTTPResponse{
+					Status:  201,
+					Headers: map[string]string{"X-User": in.UserId},
+					Body:    []byte(in.Method + " " + in.Path),
+				}, nil
+			},
+		}},
+	}, struct{}{})
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pluginv1.NewPhotoPluginClient(conn)
+}
+
+func TestServeHTTP(t *testing.T) {
+	p := newPlugin(&manifest.Manifest{
+		Name:         "faces",
+		Capabilities: []manifest.Capability{manifest.CapabilityUI},
+		Routes:       []manifest.Route{{Method: "GET", Path: "/people/*"}},
+	})
+	p.client = serveHTTPPlugin(t)
+	p.healthy = true
+
+	mutex.Lock()
+	plugins = []*plugin{p}
+	mutex.Unlock()
+	defer func() {
+		mutex.Lock()
+		plugins = nil
+		mutex.Unlock()
+	}()
+
+	if _, err := MatchRoute("faces", "GET", "/people/1"); err != nil {
+		t.Errorf("MatchRoute: %v", err)
+	}
+	if _, err := MatchRoute("faces", "POST", "/people/1"); !errors.Is(err, ErrRouteNotFound) {
+		t.Errorf("expected ErrRouteNotFound, got %v", err)
+	}
+	if _, err := MatchRoute("other", "GET", "/people"); !errors.Is(err, ErrPluginNotFound) {
+		t.Errorf("expected ErrPluginNotFound, got %v", err)
+	}
+
+	resp, err := ServeHTTP(context.Background(), "faces", &pluginv1.HTTPRequest{Method: "GET", Path: "/people/1", UserId: "u1"})
+	if err != nil {
+		t.Fatalf("ServeHTTP: %v", err)
+	}
+	if resp.Status != 201 || resp.Headers["X-User"] != "u1" || string(resp.Body) != "GET /people/1" {
+		t.Errorf("unexpected response %+v", resp)
+	}
+
+	mutex.Lock()
+	p.healthy = false
+	mutex.Unlock()
+	if _, err := ServeHTTP(context.Background(), "faces", &pluginv1.HTTPRequest{Method: "GET", Path: "/people"}); !errors.Is(err, ErrPluginUnavailable) {
+		t.Errorf("expected ErrPluginUnavailable, got %v", err)
+	}
+}
+
+func TestUIExtensions(t *testing.T) {
+	menu := []manifest.MenuEntry{{Label: "People", Page: "index.html"}}
+	withUI := newPlugin(&manifest.Manifest{Name: "faces", Dir: "/plugins/faces", UI: manifest.UI{Assets: "ui", Menu: menu}})
+	withUI.healthy = true
+	disabled := newPlugin(&manifest.Manifest{Name: "maps", Dir: "/plugins/maps", UI: manifest.UI{Assets: "ui", Menu: menu}})
+	disabled.healthy = true
+	disabled.enabled = false
+	withoutUI := newPlugin(&manifest.Manifest{Name: "noop", Dir: "/plugins/noop"})
+	withoutUI.healthy = true
+
+	mutex.Lock()
+	plugins = []*plugin{withUI, disabled, withoutUI}
+	mutex.Unlock()
+	defer func() {
+		mutex.Lock()
+		plugins = nil
+		mutex.Unlock()
+	}()
+
+	extensions := UIExtensions()
+	if len(extensions) != 1 || extensions[0].Plugin != "faces" || extensions[0].BaseURL != "/plugin-ui/faces/" {
+		t.Fatalf("unexpected extensions %+v", extensions)
+	}
+
+	if path, err := AssetsPath("faces"); err != nil || path != "/plugins/faces/ui" {
+		t.Errorf("AssetsPath = %q, %v", path, err)
+	}
+	if _, err := AssetsPath("maps"); !errors.Is(err, ErrPluginNotFound) {
+		t.Errorf("expected ErrPluginNotFound for disabled plugin, got %v", err)
+	}
+}
@@ -1,45 +1,88 @@
 package loader
 
 import (
-	"bufio"
 	"context"
 	"errors"
 	"fmt"
 	"io/fs"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"sync"
 	"time"
 
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
-	"google.golang.org/grpc/health/grpc_health_v1"
-
+	"pixie/db"
+	"pixie/events"
 	pluginv1 "pixie/gen/plugin/v1"
+	"pixie/plugin/manifest"
 )
 
 var (
-	// Registry holds all loaded plugin clients
+	// Registry holds the clients of all healthy plugins
 	Registry []pluginv1.PhotoPluginClient
 
-	// pluginProcesses holds references to all plugin processes for cleanup
-	pluginProcesses []*os.Process
+	// plugins holds every supervised plugin, healthy or not
+	plugins []*plugin
 
 	// portRegex is used to extract the port number from plugin output
 	portRegex = regexp.MustCompile(`PORT=(\d+)`)
 
+	// socketRegex is used to extract the socket path from plugin output
+	socketRegex = regexp.MustCompile(`^SOCKET=(\S+)$`)
+
 	// ErrPluginTimeout is returned when a plugin fails to start within the timeout period
 	ErrPluginTimeout = errors.New("plugin failed to start within timeout period")
 
 	// mutex for thread-safe registry operations
 	mutex sync.Mutex
+
+	// rootCtx is cancelled when the loader shuts down
+	rootCtx    context.Context
+	rootCancel context.CancelFunc
+
+	// supervisors tracks the running plugin supervisors
+	supervisors sync.WaitGroup
+
+	// store persists plugin state, if configured
+	store Store
+
+	// host issues plugin tokens for the host services, if configured
+	host Host
+
+	// healthInterval is the time between health checks of a running plugin
+	healthInterval = 10 * time.Second
+
+	// shutdownGrace is how long a plugin has to exit after SIGTERM before it is killed
+	shutdownGrace = 10 * time.Second
 )
 
-// Init initializes the plugin loader
+// Store persists whether plugins are enabled and their settings
+type Store interface {
+	ListPluginStates(ctx context.Context) (map[string]db.PluginState, error)
+	SetPluginEnabled(ctx context.Context, name string, enabled bool) error
+	SavePluginSettings(ctx context.Context, name string, settings map[string]interface{}) error
+}
+
+// Host issues the tokens plugins use to call the host services
+type Host interface {
+	Addr() string
+	Issue(plugin string, permissions []manifest.Permission) (string, error)
+	Revoke(token string)
+}
+
+// SetHost configures the host services offered to plugins. It must be called before Init.
+func SetHost(h Host) {
+	host = h
+}
+
+// SetStore configures where plugin state is persisted. It must be called before Init.
+func SetStore(s Store) {
+	store = s
+}
+
+// Init initializes the plugin loader and starts a supervisor for each enabled plugin
 func Init() error {
 	log.Println("Initializing plugin loader...")
 
@@ -47,24 +90,107 @@ func Init() error {
 	pluginsDir := getEnv("PLUGINS_DIR", "./plugins")
 	log.Printf("Using plugins directory: %s", pluginsDir)
 
-	// Setup cleanup on interrupt/termination
-	setupCleanup()
+	if err := loadConfig(); err != nil {
+		return err
+	}
 
-	// Load plugins
-	if err := loadPlugins(pluginsDir); err != nil {
+	// Keep plugins running as the same user from reading core's secrets through /proc
+	if sandbox.isolate {
+		if err := protectCore(); err != nil {
+			log.Printf("Failed to protect core process memory: %v", err)
+		}
+	}
+
+	// Find the plugins with a valid manifest
+	manifests, err := findPlugins(pluginsDir)
+	if err != nil {
 		return fmt.Errorf("failed to load plugins: %w", err)
 	}
 
-	log.Printf("Successfully loaded %d plugins", len(Registry))
+	// Load the persisted plugin state
+	states := make(map[string]db.PluginState)
+	if store != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		states, err = store.ListPluginStates(ctx)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to load plugin state: %w", err)
+		}
+	}
+
+	mutex.Lock()
+	rootCtx, rootCancel = context.WithCancel(context.Background())
+	mutex.Unlock()
+
+	// Start a supervisor for each enabled plugin and wait for the first start attempt
+	var started sync.WaitGroup
+	for _, m := range manifests {
+		p := newPlugin(m)
+		if state, ok := states[m.Name]; ok {
+			p.enabled = state.Enabled
+			p.settings = state.Settings
+		}
+
+		mutex.Lock()
+		plugins = append(plugins, p)
+		mutex.Unlock()
+
+		if !p.enabled {
+			log.Printf("Plugin %s is disabled", p.name)
+			continue
+		}
+
+		started.Add(1)
+		p.ops.Lock()
+		p.startSupervisor(started.Done)
+		p.ops.Unlock()
+	}
+	started.Wait()
+
+	log.Printf("Successfully loaded %d plugins", Count())
 	return nil
 }
 
+// loadConfig reads the supervisor, sandbox and transport configuration from the environment
+func loadConfig() error {
+	healthInterval = getDurationEnv("PLUGIN_HEALTH_INTERVAL", healthInterval)
+	shutdownGrace = getDurationEnv("PLUGIN_SHUTDOWN_GRACE", shutdownGrace)
+	loadSandboxConfig()
+	if err := loadRemoteConfig(); err != nil {
+		return err
+	}
+	return initTransport()
+}
+
+// Shutdown stops all plugins. Each plugin gets SIGTERM and is killed if it has
+// not exited within the shutdown grace period.
+func Shutdown() {
+	if rootCancel == nil {
+		return
+	}
+
+	log.Println("Stopping plugin processes...")
+	rootCancel()
+	supervisors.Wait()
+	closeTransport()
+	log.Println("All plugin processes stopped")
+}
+
+// Count returns the number of healthy plugins
+func Count() int {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	return len(Registry)
+}
+
 // ForEach executes the provided function for each plugin in the registry
 func ForEach(fn func(pluginv1.PhotoPluginClient) error) error {
 	mutex.Lock()
-	defer mutex.Unlock()
+	clients := append([]pluginv1.PhotoPluginClient(nil), Registry...)
+	mutex.Unlock()
 
-	for _, plugin := range Registry {
+	for _, plugin := range clients {
 		if err := fn(plugin); err != nil {
 			return err
 		}
@@ -72,19 +198,84 @@ func ForEach(fn func(pluginv1.PhotoPluginClient) error) error {
 	return nil
 }
 
-// loadPlugins loads all plugins from the specified directory
-func loadPlugins(dir string) error {
+// Clients returns the healthy plugins that declare a capability
+func Clients(capability manifest.Capability) []pluginv1.PhotoPluginClient {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	var clients []pluginv1.PhotoPluginClient
+	for _, p := range plugins {
+		if p.healthy && p.client != nil && p.manifest.Has(capability) {
+			clients = append(clients, p.client)
+		}
+	}
+	return clients
+}
+
+// Subscribers returns the healthy processing plugins subscribed to an event subject
+func Subscribers(subject string) []pluginv1.PhotoPluginClient {
+	targets := subscribers(subject)
+	clients := make([]pluginv1.PhotoPluginClient, len(targets))
+	for i, t := range targets {
+		clients[i] = t.client
+	}
+	return clients
+}
+
+// target is a processing plugin an event is delivered to
+type target struct {
+	name   string
+	client pluginv1.PhotoPluginClient
+	slots  chan struct{}
+}
+
+// subscribers returns the delivery targets for an event subject
+func subscribers(subject string) []target {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	var targets []target
+	for _, p := range plugins {
+		if !p.healthy || p.client == nil || !p.manifest.Has(manifest.CapabilityProcess) {
+			continue
+		}
+		for _, pattern := range p.manifest.Events {
+			if events.MatchSubject(pattern, subject) {
+				targets = append(targets, target{name: p.name, client: p.client, slots: p.slots})
+				break
+			}
+		}
+	}
+	return targets
+}
+
+// rebuildRegistry refreshes Registry from the healthy plugins. The caller must hold mutex.
+func rebuildRegistry() {
+	registry := make([]pluginv1.PhotoPluginClient, 0, len(plugins))
+	for _, p := range plugins {
+		if p.healthy && p.client != nil {
+			registry = append(registry, p.client)
+		}
+	}
+	Registry = registry
+}
+
+// findPlugins loads the plugin manifests in the specified directory.
+// Only plugins with a valid manifest are returned.
+func findPlugins(dir string) ([]*manifest.Manifest, error) {
 	// Check if directory exists
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
 		log.Printf("Plugins directory %s does not exist, creating it", dir)
 		if err := os.MkdirAll(dir, 0755); err != nil {
-			return fmt.Errorf("failed to create plugins directory: %w", err)
+			return nil, fmt.Errorf("failed to create plugins directory: %w", err)
 		}
-		return nil // No plugins to load
+		return nil, nil // No plugins to load
 	}
 
-	// Walk through the directory and load each executable file
-	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+	// Walk through the directory and load each manifest
+	var manifests []*manifest.Manifest
+	names := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			log.Printf("Error accessing path %s: %v", path, err)
 			return nil // Continue walking
@@ -99,159 +290,93 @@ func loadPlugins(dir string) error {
 			return nil
 		}
 
-		// Check if file is executable
-		info, err := d.Info()
+		if d.Name() != manifest.FileName {
+			return nil
+		}
+
+		m, err := manifest.Load(path)
 		if err != nil {
-			log.Printf("Error getting file info for %s: %v", path, err)
+			log.Printf("Skipping plugin %s: %v", path, err)
 			return nil // Continue walking
 		}
 
-		// Check if file is executable (on Unix-like systems)
-		if info.Mode()&0111 != 0 {
-			// Load the plugin
-			if err := loadPlugin(path); err != nil {
-				log.Printf("Failed to load plugin %s: %v", path, err)
-				// Continue loading other plugins
-				return nil
-			}
+		// Remote plugins are started elsewhere and register over the network
+		if m.Transport == manifest.TransportRemote {
+			log.Printf("Skipping plugin %s: remote plugins register with core", m.Name)
+			return nil
 		}
 
-		return nil
-	})
-}
-
-// loadPlugin loads a single plugin
-func loadPlugin(path string) error {
-	log.Printf("Loading plugin: %s", path)
-
-	// Start the plugin process
-	cmd := exec.Command(path, "--port=0")
-	
-	// Pass environment variables to the plugin process
-	cmd.Env = os.Environ()
-	
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return fmt.Errorf("failed to create stdout pipe: %w", err)
-	}
-
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return fmt.Errorf("failed to create stderr pipe: %w", err)
-	}
-
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start plugin: %w", err)
-	}
-
-	// Store process for cleanup
-	pluginProcesses = append(pluginProcesses, cmd.Process)
-
-	// Read port from stdout
-	scanner := bufio.NewScanner(stdout)
-	portChan := make(chan int, 1)
-	errChan := make(chan error, 1)
-
-	// Start goroutine to scan stdout for PORT line
-	go func() {
-		for scanner.Scan() {
-			line := scanner.Text()
-			log.Printf("Plugin output: %s", line)
-
-			// Check if line contains PORT=
-			matches := portRegex.FindStringSubmatch(line)
-			if len(matches) == 2 {
-				port, err := strconv.Atoi(matches[1])
-				if err != nil {
-					errChan <- fmt.Errorf("invalid port number: %s", matches[1])
-					return
-				}
-				portChan <- port
-				return
-			}
-		}
-		if err := scanner.Err(); err != nil {
-			errChan <- fmt.Errorf("error reading plugin output: %w", err)
+		if other, ok := names[m.Name]; ok {
+			log.Printf("Skipping plugin %s: name %q is already used by %s", path, m.Name, other)
+			return nil
 		}
-	}()
 
-	// Start goroutine to log stderr
-	go func() {
-		stderrScanner := bufio.NewScanner(stderr)
-		for stderrScanner.Scan() {
-			log.Printf("Plugin error: %s", stderrScanner.Text())
+		// Check if the entrypoint is executable (on Unix-like systems)
+		info, err := os.Stat(m.EntrypointPath())
+		if err != nil {
+			log.Printf("Skipping plugin %s: %v", m.Name, err)
+			return nil
+		}
+		if info.IsDir() || info.Mode()&0111 == 0 {
+			log.Printf("Skipping plugin %s: entrypoint %s is not executable", m.Name, m.EntrypointPath())
+			return nil
 		}
-	}()
-
-	// Wait for port or timeout
-	var port int
-	select {
-	case port = <-portChan:
-		log.Printf("Plugin listening on port %d", port)
-	case err := <-errChan:
-		return fmt.Errorf("plugin error: %w", err)
-	case <-time.After(5 * time.Second):
-		return ErrPluginTimeout
-	}
 
-	// Connect to the plugin
-	addr := fmt.Sprintf("localhost:%d", port)
-	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
-	if err != nil {
-		return fmt.Errorf("failed to connect to plugin: %w", err)
-	}
+		names[m.Name] = path
+		manifests = append(manifests, m)
+		return nil
+	})
 
-	// Check health
-	healthClient := grpc_health_v1.NewHealthClient(conn)
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	return manifests, err
+}
 
-	_, err = healthClient.Check(ctx, &grpc_health_v1.HealthCheckRequest{})
-	if err != nil {
-		return fmt.Errorf("health check failed: %w", err)
+// getEnv gets an environment variable or returns a default value
+func getEnv(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
 	}
-
-	// Create plugin client
-	client := pluginv1.NewPhotoPluginClient(conn)
-
-	// Add to registry
-	mutex.Lock()
-	Registry = append(Registry, client)
-	mutex.Unlock()
-
-	log.Printf("Successfully loaded plugin: %s", path)
-	return nil
+	return value
 }
 
-// setupCleanup registers signal handlers to clean up plugin processes
-func setupCleanup() {
-	// This is a simplified version - in a real application, you would use a proper
-	// signal handling mechanism to catch SIGINT/SIGTERM
-	go func() {
-		// Wait for process to exit
-		<-context.Background().Done()
-		cleanup()
-	}()
+// getUintEnv gets an environment variable as an unsigned integer or returns a default value
+func getUintEnv(key string, defaultValue uint64) uint64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		log.Printf("Invalid number for %s: %v", key, err)
+		return defaultValue
+	}
+	return n
 }
 
-// cleanup terminates all plugin processes
-func cleanup() {
-	log.Println("Cleaning up plugin processes...")
-	for _, process := range pluginProcesses {
-		if process != nil {
-			log.Printf("Terminating plugin process %d", process.Pid)
-			if err := process.Kill(); err != nil {
-				log.Printf("Failed to kill plugin process %d: %v", process.Pid, err)
-			}
-		}
+// getBoolEnv gets an environment variable as a boolean or returns a default value
+func getBoolEnv(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
 	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid boolean for %s: %v", key, err)
+		return defaultValue
+	}
+	return b
 }
 
-// getEnv gets an environment variable or returns a default value
-func getEnv(key, defaultValue string) string {
+// getDurationEnv gets an environment variable as a duration or returns a default value
+func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
 	value := os.Getenv(key)
 	if value == "" {
 		return defaultValue
 	}
-	return value
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid duration for %s: %v", key, err)
+		return defaultValue
+	}
+	return d
 }
@@ -0,0 +1,132 @@
+package loader
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	pluginv1 "pixie/gen/plugin/v1"
+	"pixie/plugin/manifest"
+)
+
+var (
+	// processConfig loads the configuration for processes started outside Init
+	processConfig    sync.Once
+	processConfigErr error
+)
+
+// Timeouts are the limits core puts on a plugin
+type Timeouts struct {
+	// Start is how long a plugin has to print its handshake
+	Start time.Duration
+
+	// Health is how long a plugin has to answer a health check
+	Health time.Duration
+
+	// Dispatch, Auth and HTTP bound ProcessPhoto, ValidateToken and HandleHTTP calls
+	Dispatch time.Duration
+	Auth     time.Duration
+	HTTP     time.Duration
+
+	// Shutdown is how long a plugin has to exit after SIGTERM
+	Shutdown time.Duration
+}
+
+// CurrentTimeouts returns the timeouts core applies to plugins
+func CurrentTimeouts() Timeouts {
+	return Timeouts{
+		Start:    startTimeout,
+		Health:   healthTimeout,
+		Dispatch: dispatchTimeout,
+		Auth:     authTimeout,
+		HTTP:     httpTimeout,
+		Shutdown: shutdownGrace,
+	}
+}
+
+// Process is a single plugin process started without a supervisor. It is not
+// restarted or added to the registry, which makes it suitable for testing a plugin.
+type Process struct {
+	p    *plugin
+	conn *grpc.ClientConn
+
+	// Client calls the plugin's PhotoPlugin service
+	Client pluginv1.PhotoPluginClient
+}
+
+// StartProcess starts a plugin exactly like the supervisor does, in its sandbox,
+// with its transport and a host services token, and connects to it. It returns
+// once the plugin has completed its handshake.
+func StartProcess(m *manifest.Manifest, settings map[string]interface{}) (*Process, error) {
+	processConfig.Do(func() {
+		processConfigErr = loadConfig()
+	})
+	if processConfigErr != nil {
+		return nil, processConfigErr
+	}
+
+	p := newPlugin(m)
+	p.settings = settings
+
+	target, creds, err := p.start()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := grpc.Dial(target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		p.terminate(0)
+		return nil, fmt.Errorf("failed to connect to plugin: %w", err)
+	}
+
+	return &Process{p: p, conn: conn, Client: pluginv1.NewPhotoPluginClient(conn)}, nil
+}
+
+// Health runs a single health check against the plugin
+func (pr *Process) Health(ctx context.Context) error {
+	return checkHealth(ctx, grpc_health_v1.NewHealthClient(pr.conn))
+}
+
+// Exited is closed when the plugin process has exited
+func (pr *Process) Exited() <-chan struct{} {
+	return pr.p.exited
+}
+
+// Stop sends SIGTERM to the plugin and waits for it to exit. It returns an error
+// if the plugin had to be killed after the shutdown grace period or did not exit
+// cleanly.
+func (pr *Process) Stop() error {
+	pr.conn.Close()
+
+	select {
+	case <-pr.p.exited:
+		return fmt.Errorf("plugin exited before SIGTERM: %s", pr.p.cmd.ProcessState)
+	default:
+	}
+
+	if err := pr.p.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		pr.p.terminate(0)
+		return fmt.Errorf("failed to send SIGTERM: %w", err)
+	}
+	select {
+	case <-pr.p.exited:
+	case <-time.After(shutdownGrace):
+		pr.p.terminate(0)
+		return fmt.Errorf("plugin did not exit within %s of SIGTERM", shutdownGrace)
+	}
+
+	if state := pr.p.cmd.ProcessState; !state.Success() {
+		return fmt.Errorf("plugin %s after SIGTERM", state)
+	}
+	return nil
+}
+
+// Kill stops the plugin immediately
+func (pr *Process) Kill() {
+	pr.conn.Close()
+	pr.p.terminate(0)
+}
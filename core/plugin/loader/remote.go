@@ -0,0 +1,340 @@
+package loader
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"pixie/plugin/manifest"
+)
+
+// RemoteRefresh is how often plugins running outside core register again, so they
+// are added back after core restarts
+const RemoteRefresh = 30 * time.Second
+
+// remoteExpiry is how long a remote plugin that is down may go without registering
+// again before it is removed
+const remoteExpiry = 3 * RemoteRefresh
+
+var (
+	// ErrNotRemote is returned when a remote operation targets a plugin started by core
+	ErrNotRemote = errors.New("plugin is not a remote plugin")
+
+	// ErrNotRunning is returned when a plugin registers before the loader is initialized
+	ErrNotRunning = errors.New("plugin loader is not running")
+
+	// ErrPermissionsNotApproved is returned when a remote plugin asks for
+	// permissions the operator has not approved in PLUGIN_REMOTE_PERMISSIONS
+	ErrPermissionsNotApproved = errors.New("permissions not approved for remote plugin")
+
+	// ErrNoPluginCA is returned when remote plugins are used without a plugin CA on disk
+	ErrNoPluginCA = errors.New("remote plugins require PLUGIN_CA_CERT_FILE and PLUGIN_CA_KEY_FILE")
+
+	// remotePermissions are the permissions the operator approved for each remote plugin
+	remotePermissions map[string][]manifest.Permission
+)
+
+// loadRemoteConfig reads the permissions approved for remote plugins from
+// PLUGIN_REMOTE_PERMISSIONS, e.g. "thumbnailer=photos.read,metadata.write;tagger=photos.read"
+func loadRemoteConfig() error {
+	remotePermissions = make(map[string][]manifest.Permission)
+	for _, entry := range strings.Split(getEnv("PLUGIN_REMOTE_PERMISSIONS", ""), ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, list, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("invalid PLUGIN_REMOTE_PERMISSIONS entry %q, expected <plugin>=<permission>,...", entry)
+		}
+		name = strings.TrimSpace(name)
+		for _, permission := range strings.Split(list, ",") {
+			if permission = strings.TrimSpace(permission); permission != "" {
+				remotePermissions[name] = append(remotePermissions[name], manifest.Permission(permission))
+			}
+		}
+	}
+	return nil
+}
+
+// unapprovedPermissions returns the permissions of a remote plugin the operator has not approved
+func unapprovedPermissions(m *manifest.Manifest) []manifest.Permission {
+	var missing []manifest.Permission
+	for _, permission := range m.Permissions {
+		if !slices.Contains(remotePermissions[m.Name], permission) {
+			missing = append(missing, permission)
+		}
+	}
+	return missing
+}
+
+// RemoteTLSConfig returns the TLS configuration of the listener remote plugins
+// register on. It accepts any client with a certificate from the plugin CA.
+func RemoteTLSConfig() (*tls.Config, error) {
+	if pluginCAFile == "" {
+		return nil, ErrNoPluginCA
+	}
+	return pluginCA.serverConfig()
+}
+
+// IssueRemoteCert writes a certificate and key for the remote plugin name to dir,
+// together with the CA certificate. The CA is read from PLUGIN_CA_CERT_FILE and
+// PLUGIN_CA_KEY_FILE and created first if neither file exists.
+func IssueRemoteCert(name, dir string) error {
+	certFile, keyFile := getEnv("PLUGIN_CA_CERT_FILE", ""), getEnv("PLUGIN_CA_KEY_FILE", "")
+	if certFile == "" || keyFile == "" {
+		return ErrNoPluginCA
+	}
+	if !manifest.ValidName(name) {
+		return fmt.Errorf("invalid plugin name %q", name)
+	}
+
+	if !fileExists(certFile) && !fileExists(keyFile) {
+		if err := writeCertAuthority(certFile, keyFile); err != nil {
+			return err
+		}
+		log.Printf("Created plugin CA in %s", certFile)
+	}
+	ca, err := loadCertAuthority(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+
+	// The plugin serves core and calls core's registry with the same certificate
+	certPEM, keyPEM, err := ca.issueFor(name, remoteCertValidity, x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create certificate directory: %w", err)
+	}
+	files := []struct {
+		name string
+		data []byte
+		mode os.FileMode
+	}{
+		{"ca.pem", ca.certPEM, 0644},
+		{"cert.pem", certPEM, 0644},
+		{"key.pem", keyPEM, 0600},
+	}
+	for _, file := range files {
+		if err := os.WriteFile(filepath.Join(dir, file.name), file.data, file.mode); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file.name, err)
+		}
+	}
+	return nil
+}
+
+// writeCertAuthority creates a CA for remote plugins and writes it to disk
+func writeCertAuthority(certFile, keyFile string) error {
+	der, key, err := createCA(remoteCertValidity)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to marshal CA key: %w", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return fmt.Errorf("failed to write plugin CA key: %w", err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return fmt.Errorf("failed to write plugin CA certificate: %w", err)
+	}
+	return nil
+}
+
+// fileExists reports whether path exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Register adds a plugin running outside core, or refreshes its registration. Core
+// connects to the plugin at addr and supervises it like a local plugin. It returns
+// the plugin's host services token and its effective settings as JSON.
+func Register(ctx context.Context, m *manifest.Manifest, addr string) (string, []byte, error) {
+	mutex.Lock()
+	running := rootCtx != nil && rootCtx.Err() == nil
+	p := findLocked(m.Name)
+	mutex.Unlock()
+
+	if !running {
+		return "", nil, ErrNotRunning
+	}
+
+	// The operator decides what a plugin outside core may do
+	if missing := unapprovedPermissions(m); len(missing) > 0 {
+		return "", nil, fmt.Errorf("%w: %s asks for %v", ErrPermissionsNotApproved, m.Name, missing)
+	}
+
+	if p == nil {
+		var err error
+		if p, err = addRemote(ctx, m, addr); err != nil {
+			return "", nil, err
+		}
+	}
+
+	p.ops.Lock()
+	defer p.ops.Unlock()
+
+	mutex.Lock()
+	p.lastSeen = time.Now()
+	remote := p.remote
+	changed := remote != addr || !reflect.DeepEqual(p.manifest, m)
+	started := p.cancel != nil
+	enabled := p.enabled
+	token := p.token
+	mutex.Unlock()
+
+	if remote == "" {
+		return "", nil, fmt.Errorf("%w: name %s is used by a local plugin", ErrNotRemote, m.Name)
+	}
+
+	// Restart the supervisor and reissue the token if the plugin moved or changed
+	if changed {
+		log.Printf("Updating remote plugin %s %s at %s", m.Name, m.Version, addr)
+		p.stopSupervisor()
+		p.revokeToken()
+
+		mutex.Lock()
+		p.setManifest(m)
+		p.remote = addr
+		p.lastErr = nil
+		mutex.Unlock()
+		started = false
+	}
+
+	if host != nil && (changed || token == "") {
+		var err error
+		if token, err = host.Issue(m.Name, m.Permissions); err != nil {
+			return "", nil, err
+		}
+		mutex.Lock()
+		p.token = token
+		mutex.Unlock()
+	}
+
+	if enabled && !started {
+		p.startSupervisor(func() {})
+	}
+
+	settings, err := json.Marshal(p.effectiveSettings())
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to marshal plugin settings: %w", err)
+	}
+	return token, settings, nil
+}
+
+// Unregister stops supervising a plugin running outside core and revokes its token
+func Unregister(ctx context.Context, name string) error {
+	p, err := find(name)
+	if err != nil {
+		return err
+	}
+
+	p.ops.Lock()
+	defer p.ops.Unlock()
+
+	mutex.Lock()
+	remote := p.remote
+	mutex.Unlock()
+	if remote == "" {
+		return ErrNotRemote
+	}
+
+	log.Printf("Unregistering remote plugin %s", name)
+	p.stopSupervisor()
+	p.revokeToken()
+
+	mutex.Lock()
+	p.removeLocked()
+	mutex.Unlock()
+	return nil
+}
+
+// expire removes a remote plugin that is down and has stopped registering, e.g. because
+// its container is gone. It is called by the plugin's supervisor, which then exits.
+func (p *plugin) expire() bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if p.remote == "" || time.Since(p.lastSeen) < remoteExpiry {
+		return false
+	}
+
+	log.Printf("Remote plugin %s has not registered for %s, removing it", p.name, remoteExpiry)
+	if p.token != "" && host != nil {
+		host.Revoke(p.token)
+	}
+	p.token = ""
+	if p.cancel != nil {
+		p.cancel()
+	}
+	p.cancel = nil
+	p.done = nil
+	p.removeLocked()
+	return true
+}
+
+// removeLocked removes the plugin from the list of plugins. The caller must hold mutex.
+func (p *plugin) removeLocked() {
+	for i, other := range plugins {
+		if other == p {
+			plugins = append(plugins[:i], plugins[i+1:]...)
+			break
+		}
+	}
+	rebuildRegistry()
+}
+
+// addRemote adds a new remote plugin with its persisted state
+func addRemote(ctx context.Context, m *manifest.Manifest, addr string) (*plugin, error) {
+	p := newPlugin(m)
+	p.remote = addr
+
+	if store != nil {
+		states, err := store.ListPluginStates(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load plugin state: %w", err)
+		}
+		if state, ok := states[m.Name]; ok {
+			p.enabled = state.Enabled
+			p.settings = state.Settings
+		}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// Another registration may have added the plugin in the meantime
+	if other := findLocked(m.Name); other != nil {
+		return other, nil
+	}
+	log.Printf("Registering remote plugin %s %s at %s", m.Name, m.Version, addr)
+	plugins = append(plugins, p)
+	return p, nil
+}
+
+// revokeToken revokes a remote plugin's host services token. The caller must hold p.ops.
+func (p *plugin) revokeToken() {
+	mutex.Lock()
+	token := p.token
+	p.token = ""
+	mutex.Unlock()
+
+	if token != "" && host != nil {
+		host.Revoke(token)
+	}
+}
@@ -0,0 +1,182 @@
+package loader
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"pixie/plugin/manifest"
+)
+
+// serveRemotePlugin serves the health service over mTLS like a plugin in
+// another container would, with a certificate issued by `plugin cert`
+func serveRemotePlugin(t *testing.T, name string) string {
+	dir := t.TempDir()
+	t.Setenv("PLUGIN_CA_CERT_FILE", filepath.Join(dir, "ca.pem"))
+	t.Setenv("PLUGIN_CA_KEY_FILE", filepath.Join(dir, "ca-key.pem"))
+	if err := IssueRemoteCert(name, filepath.Join(dir, name)); err != nil {
+		t.Fatalf("IssueRemoteCert: %v", err)
+	}
+	if err := initTransport(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pluginCA, pluginCAFile = nil, "" })
+
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, name, "cert.pem"), filepath.Join(dir, name, "key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(pluginCA.cert)
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(config)))
+	grpc_health_v1.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+	return lis.Addr().String()
+}
+
+func TestRegisterRemotePlugin(t *testing.T) {
+	addr := serveRemotePlugin(t, "remote")
+
+	m, err := manifest.ParseRemote([]byte("name: remote\nversion: 0.1.0\napi_version: 1\ncapabilities: [process]\nevents: [photo.uploaded]\npermissions: [photos.read]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := Register(context.Background(), m, addr); !errors.Is(err, ErrNotRunning) {
+		t.Fatalf("expected ErrNotRunning before Init, got %v", err)
+	}
+
+	mutex.Lock()
+	rootCtx, rootCancel = context.WithCancel(context.Background())
+	mutex.Unlock()
+	defer Shutdown()
+
+	// Only the permissions the operator approved may be granted
+	t.Setenv("PLUGIN_REMOTE_PERMISSIONS", "remote=metadata.write")
+	if err := loadRemoteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { remotePermissions = nil }()
+	if _, _, err := Register(context.Background(), m, addr); !errors.Is(err, ErrPermissionsNotApproved) {
+		t.Fatalf("expected ErrPermissionsNotApproved, got %v", err)
+	}
+	t.Setenv("PLUGIN_REMOTE_PERMISSIONS", "other=photos.read; remote=metadata.write,photos.read")
+	if err := loadRemoteConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := Register(context.Background(), m, addr); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	// The plugin is routed to once its health check passes
+	deadline := time.Now().Add(5 * time.Second)
+	for len(Subscribers("photo.uploaded")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("remote plugin was not added to the registry")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	info, err := Get("remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Transport != manifest.TransportRemote || info.Address != addr || info.Status != StatusRunning {
+		t.Errorf("unexpected info %+v", info)
+	}
+
+	// Refreshing an unchanged registration keeps the plugin connected
+	if _, _, err := Register(context.Background(), m, addr); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if Count() != 1 {
+		t.Errorf("expected the plugin to stay registered, got %d", Count())
+	}
+
+	if err := Unregister(context.Background(), "remote"); err != nil {
+		t.Fatalf("Unregister: %v", err)
+	}
+	if _, err := Get("remote"); !errors.Is(err, ErrPluginNotFound) {
+		t.Errorf("expected the plugin to be removed, got %v", err)
+	}
+	if Count() != 0 {
+		t.Errorf("expected an empty registry, got %d", Count())
+	}
+}
+
+func TestRegisterRejectsLocalName(t *testing.T) {
+	local := newPlugin(&manifest.Manifest{Name: "local", Version: "0.1.0", Entrypoint: "bin"})
+
+	mutex.Lock()
+	plugins = append(plugins, local)
+	rootCtx, rootCancel = context.WithCancel(context.Background())
+	mutex.Unlock()
+	defer func() {
+		Shutdown()
+		mutex.Lock()
+		plugins = nil
+		mutex.Unlock()
+	}()
+
+	m, err := manifest.ParseRemote([]byte("name: local\nversion: 0.1.0\napi_version: 1\ncapabilities: [search]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Register(context.Background(), m, "127.0.0.1:1"); !errors.Is(err, ErrNotRemote) {
+		t.Errorf("expected ErrNotRemote, got %v", err)
+	}
+	if err := Unregister(context.Background(), "local"); !errors.Is(err, ErrNotRemote) {
+		t.Errorf("expected ErrNotRemote, got %v", err)
+	}
+}
+
+func TestExpireRemotePlugin(t *testing.T) {
+	m, err := manifest.ParseRemote([]byte("name: gone\nversion: 0.1.0\napi_version: 1\ncapabilities: [search]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newPlugin(m)
+	p.remote = "127.0.0.1:1"
+	p.lastSeen = time.Now()
+
+	mutex.Lock()
+	plugins = append(plugins, p)
+	mutex.Unlock()
+
+	if p.expire() {
+		t.Fatal("a plugin that registered recently should not expire")
+	}
+
+	mutex.Lock()
+	p.lastSeen = time.Now().Add(-remoteExpiry)
+	mutex.Unlock()
+
+	if !p.expire() {
+		t.Fatal("expected the plugin to expire")
+	}
+	if _, err := Get("gone"); !errors.Is(err, ErrPluginNotFound) {
+		t.Errorf("expected the plugin to be removed, got %v", err)
+	}
+}
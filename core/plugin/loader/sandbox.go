@@ -0,0 +1,205 @@
+package loader
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"pixie/plugin/manifest"
+)
+
+// defaultPath is passed to plugins when core itself has no PATH
+const defaultPath = "/usr/local/bin:/usr/bin:/bin"
+
+// deniedEnv are core's secrets. They are never passed to a plugin, whatever
+// its manifest and PLUGIN_ENV allow.
+var deniedEnv = []string{
+	"JWT_*",
+	"S3_ACCESS_KEY",
+	"S3_SECRET_KEY",
+	"DATABASE_URL",
+	"PLUGIN_REGISTRATION_SECRET",
+	"LINK_SIGNING_KEY",
+	"OIDC_CLIENT_SECRET",
+	"SMTP_PASSWORD",
+}
+
+// sandboxConfig holds the sandbox settings that apply to every plugin
+type sandboxConfig struct {
+	// dataDir contains one private working directory per plugin
+	dataDir string
+
+	// limits are the defaults and maximums for every plugin; zero means unlimited
+	limits manifest.Limits
+
+	// user and group run plugins that do not set their own under another account
+	user  string
+	group string
+
+	// isolate runs plugins in their own PID, mount, IPC and UTS namespaces where available
+	isolate bool
+
+	// env lists the core variables plugins may ask for in their manifest
+	env []string
+}
+
+var (
+	// sandbox is the sandbox configuration, loaded from the environment by Init
+	sandbox = sandboxConfig{
+		dataDir: "./plugin-data",
+		limits:  manifest.Limits{MemoryMB: 512, OpenFiles: 1024},
+		isolate: true,
+	}
+
+	// isolationUnavailable is set once starting a plugin in new namespaces has failed
+	isolationUnavailable atomic.Bool
+)
+
+// sandboxSpec is the sandbox applied to a single plugin process
+type sandboxSpec struct {
+	workDir string
+	env     []string
+	limits  manifest.Limits
+
+	// uid and gid are -1 when the plugin runs as the same user as core
+	uid int
+	gid int
+}
+
+// loadSandboxConfig reads the sandbox configuration from the environment
+func loadSandboxConfig() {
+	sandbox.dataDir = getEnv("PLUGIN_DATA_DIR", sandbox.dataDir)
+	sandbox.limits.MemoryMB = getUintEnv("PLUGIN_MEMORY_LIMIT_MB", sandbox.limits.MemoryMB)
+	sandbox.limits.CPUSeconds = getUintEnv("PLUGIN_CPU_LIMIT_SECONDS", sandbox.limits.CPUSeconds)
+	sandbox.limits.OpenFiles = getUintEnv("PLUGIN_OPEN_FILES_LIMIT", sandbox.limits.OpenFiles)
+	sandbox.user = getEnv("PLUGIN_USER", sandbox.user)
+	sandbox.group = getEnv("PLUGIN_GROUP", sandbox.group)
+	sandbox.isolate = getBoolEnv("PLUGIN_ISOLATION", sandbox.isolate)
+
+	sandbox.env = nil
+	for _, pattern := range strings.FieldsFunc(getEnv("PLUGIN_ENV", ""), func(r rune) bool {
+		return r == ',' || r == ' '
+	}) {
+		if !manifest.ValidEnvPattern(pattern) {
+			log.Printf("Ignoring invalid variable %q in PLUGIN_ENV", pattern)
+			continue
+		}
+		sandbox.env = append(sandbox.env, pattern)
+	}
+}
+
+// sandboxSpec prepares the sandbox for the plugin's next process
+func (p *plugin) sandboxSpec(settings []byte) (*sandboxSpec, error) {
+	mutex.Lock()
+	m := p.manifest
+	mutex.Unlock()
+
+	spec := &sandboxSpec{
+		limits: manifest.Limits{
+			MemoryMB:   effectiveLimit(m.Sandbox.Limits.MemoryMB, sandbox.limits.MemoryMB),
+			CPUSeconds: effectiveLimit(m.Sandbox.Limits.CPUSeconds, sandbox.limits.CPUSeconds),
+			OpenFiles:  effectiveLimit(m.Sandbox.Limits.OpenFiles, sandbox.limits.OpenFiles),
+		},
+		uid: -1,
+		gid: -1,
+	}
+
+	// Resolve the account the plugin runs as
+	userName, groupName := m.Sandbox.User, m.Sandbox.Group
+	if userName == "" && groupName == "" {
+		userName, groupName = sandbox.user, sandbox.group
+	}
+	if userName != "" || groupName != "" {
+		uid, gid, err := lookupAccount(userName, groupName)
+		if err != nil {
+			return nil, err
+		}
+		spec.uid, spec.gid = uid, gid
+	}
+
+	// Give the plugin a private working directory
+	dataDir, err := filepath.Abs(sandbox.dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve plugin data directory: %w", err)
+	}
+	spec.workDir = filepath.Join(dataDir, m.Name)
+	if err := os.MkdirAll(spec.workDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create plugin working directory: %w", err)
+	}
+	if spec.uid >= 0 || spec.gid >= 0 {
+		if err := os.Chown(spec.workDir, spec.uid, spec.gid); err != nil {
+			return nil, fmt.Errorf("failed to change owner of plugin working directory: %w", err)
+		}
+	}
+
+	spec.env = pluginEnv(m, spec.workDir, settings)
+	return spec, nil
+}
+
+// pluginEnv builds the environment of a plugin process. Only the variables the
+// manifest asks for and PLUGIN_ENV allows are passed on from core, and never
+// core's secrets.
+func pluginEnv(m *manifest.Manifest, workDir string, settings []byte) []string {
+	env := []string{
+		"PATH=" + getEnv("PATH", defaultPath),
+		"HOME=" + workDir,
+		"TMPDIR=" + workDir,
+	}
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if m.Sandbox.AllowsEnv(name) && manifest.MatchEnv(sandbox.env, name) && !manifest.MatchEnv(deniedEnv, name) {
+			env = append(env, kv)
+		}
+	}
+
+	// Set last so the manifest cannot override them
+	return append(env,
+		"PIXIE_PLUGIN_NAME="+m.Name,
+		"PIXIE_PLUGIN_DATA_DIR="+workDir,
+		"PIXIE_PLUGIN_SETTINGS="+string(settings),
+	)
+}
+
+// effectiveLimit returns the requested limit, capped by the configured maximum
+func effectiveLimit(requested, max uint64) uint64 {
+	if requested == 0 || (max != 0 && requested > max) {
+		return max
+	}
+	return requested
+}
+
+// lookupAccount resolves a user and group name to numeric IDs. If only the user
+// is set, its primary group is used.
+func lookupAccount(userName, groupName string) (int, int, error) {
+	uid, gid := -1, -1
+	if userName != "" {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to look up plugin user: %w", err)
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return 0, 0, fmt.Errorf("unsupported uid %q for user %s", u.Uid, userName)
+		}
+		if gid, err = strconv.Atoi(u.Gid); err != nil {
+			return 0, 0, fmt.Errorf("unsupported gid %q for user %s", u.Gid, userName)
+		}
+	}
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to look up plugin group: %w", err)
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return 0, 0, fmt.Errorf("unsupported gid %q for group %s", g.Gid, groupName)
+		}
+	}
+	if uid < 0 {
+		uid = os.Getuid()
+	}
+	return uid, gid, nil
+}
@@ -0,0 +1,176 @@
+package loader
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"pixie/plugin/manifest"
+)
+
+// sandboxInitArg is argv[0] when core re-executes itself as the first process
+// of a plugin sandbox. Limits, mounts and credentials are set up there, before
+// the plugin is executed, so the plugin never runs without them.
+const sandboxInitArg = "pixie-plugin-init"
+
+func init() {
+	if len(os.Args) > 0 && os.Args[0] == sandboxInitArg {
+		sandboxInit(os.Args[1:])
+	}
+}
+
+// configureSandbox runs the plugin command through the sandbox init process
+// and sets its namespaces
+func configureSandbox(cmd *exec.Cmd, spec *sandboxSpec, isolate bool) error {
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find core executable: %w", err)
+	}
+
+	attr := &syscall.SysProcAttr{
+		// Make sure plugins do not outlive core
+		Pdeathsig: syscall.SIGKILL,
+	}
+	if isolate {
+		// The PID and mount namespaces together with a fresh /proc hide core and
+		// the other plugins from the plugin
+		attr.Cloneflags = syscall.CLONE_NEWPID | syscall.CLONE_NEWNS | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+
+		// Without root, a user namespace is needed to create the other namespaces
+		if os.Geteuid() != 0 {
+			attr.Cloneflags |= syscall.CLONE_NEWUSER
+			attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}}
+			attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}}
+			attr.GidMappingsEnableSetgroups = false
+		}
+	}
+	if spec.uid >= 0 && os.Geteuid() != 0 {
+		return errors.New("running plugins as another user requires core to run as root")
+	}
+
+	args := []string{
+		sandboxInitArg,
+		"-memory-mb=" + strconv.FormatUint(spec.limits.MemoryMB, 10),
+		"-cpu-seconds=" + strconv.FormatUint(spec.limits.CPUSeconds, 10),
+		"-open-files=" + strconv.FormatUint(spec.limits.OpenFiles, 10),
+		"-uid=" + strconv.Itoa(spec.uid),
+		"-gid=" + strconv.Itoa(spec.gid),
+		"-proc=" + strconv.FormatBool(isolate),
+		"--",
+		cmd.Path,
+	}
+	cmd.Args = append(args, cmd.Args[1:]...)
+	cmd.Path = self
+	cmd.SysProcAttr = attr
+	return nil
+}
+
+// sandboxInit sets up the sandbox inside the new process and executes the
+// plugin. It never returns.
+func sandboxInit(args []string) {
+	fail := func(format string, v ...interface{}) {
+		fmt.Fprintf(os.Stderr, "plugin sandbox: "+format+"\n", v...)
+		os.Exit(126)
+	}
+
+	var limits manifest.Limits
+	var uid, gid int
+	var mountProc bool
+	flags := flag.NewFlagSet(sandboxInitArg, flag.ContinueOnError)
+	flags.Uint64Var(&limits.MemoryMB, "memory-mb", 0, "")
+	flags.Uint64Var(&limits.CPUSeconds, "cpu-seconds", 0, "")
+	flags.Uint64Var(&limits.OpenFiles, "open-files", 0, "")
+	flags.IntVar(&uid, "uid", -1, "")
+	flags.IntVar(&gid, "gid", -1, "")
+	flags.BoolVar(&mountProc, "proc", false, "")
+	if err := flags.Parse(args); err != nil || flags.NArg() == 0 {
+		fail("invalid arguments %q", args)
+	}
+
+	// A new /proc only shows the processes in the plugin's PID namespace. It
+	// cannot be mounted in some unprivileged containers; the plugin then still
+	// runs in its namespaces.
+	if mountProc {
+		if err := remountProc(); err != nil {
+			fmt.Fprintf(os.Stderr, "plugin sandbox: failed to mount /proc, other processes remain visible: %v\n", err)
+		}
+	}
+
+	if err := setLimits(limits); err != nil {
+		fail("%v", err)
+	}
+
+	// Drop to the plugin's account last, since it cannot mount
+	if uid >= 0 {
+		if err := syscall.Setgroups(nil); err != nil {
+			fail("failed to clear groups: %v", err)
+		}
+		if err := syscall.Setgid(gid); err != nil {
+			fail("failed to set group: %v", err)
+		}
+		if err := syscall.Setuid(uid); err != nil {
+			fail("failed to set user: %v", err)
+		}
+
+		// Changing credentials clears the parent death signal
+		if err := unix.Prctl(unix.PR_SET_PDEATHSIG, uintptr(syscall.SIGKILL), 0, 0, 0); err != nil {
+			fail("failed to set parent death signal: %v", err)
+		}
+	}
+
+	path := flags.Arg(0)
+	err := syscall.Exec(path, flags.Args(), os.Environ())
+	fail("failed to run %s: %v", path, err)
+}
+
+// remountProc mounts a /proc for the current PID namespace, without
+// propagating any mount to core's namespace
+func remountProc() error {
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return err
+	}
+	return unix.Mount("proc", "/proc", "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, "")
+}
+
+// setLimits sets the resource limits of the current process, inherited by the plugin
+func setLimits(limits manifest.Limits) error {
+	set := func(resource int, value uint64) error {
+		if value == 0 {
+			return nil
+		}
+		// syscall.Setrlimit rather than unix.Setrlimit, so the Go runtime does
+		// not restore its own open files limit on exec
+		return syscall.Setrlimit(resource, &syscall.Rlimit{Cur: value, Max: value})
+	}
+
+	// RLIMIT_DATA rather than RLIMIT_AS, since the Go runtime reserves far more
+	// address space than it uses
+	if err := set(unix.RLIMIT_DATA, limits.MemoryMB<<20); err != nil {
+		return fmt.Errorf("failed to set memory limit: %w", err)
+	}
+	if err := set(unix.RLIMIT_CPU, limits.CPUSeconds); err != nil {
+		return fmt.Errorf("failed to set CPU limit: %w", err)
+	}
+	if err := set(unix.RLIMIT_NOFILE, limits.OpenFiles); err != nil {
+		return fmt.Errorf("failed to set open files limit: %w", err)
+	}
+	return nil
+}
+
+// isIsolationError reports whether a plugin failed to start because namespaces are not available
+func isIsolationError(err error) bool {
+	return errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EINVAL) ||
+		errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.ENOSYS)
+}
+
+// protectCore stops processes running as the same user from reading core's
+// memory and environment through /proc
+func protectCore() error {
+	return unix.Prctl(unix.PR_SET_DUMPABLE, 0, 0, 0, 0)
+}
@@ -0,0 +1,65 @@
+package loader
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+
+	"pixie/plugin/manifest"
+)
+
+func TestSandboxAppliesLimitsBeforeExec(t *testing.T) {
+	spec := &sandboxSpec{limits: manifest.Limits{MemoryMB: 64, OpenFiles: 32}, uid: -1, gid: -1}
+	cmd := exec.Command("cat", "/proc/self/limits")
+	if err := configureSandbox(cmd, spec, false); err != nil {
+		t.Fatalf("configureSandbox: %v", err)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("sandboxed command failed: %v", err)
+	}
+
+	// The limits are already in place when the plugin's own code starts
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case strings.HasPrefix(line, "Max data size") && fields[3] != "67108864":
+			t.Errorf("memory limit not applied: %s", line)
+		case strings.HasPrefix(line, "Max open files") && fields[3] != "32":
+			t.Errorf("open files limit not applied: %s", line)
+		case strings.HasPrefix(line, "Max cpu time") && fields[3] != "unlimited":
+			t.Errorf("unexpected CPU limit: %s", line)
+		}
+	}
+}
+
+func TestSandboxHidesOtherProcesses(t *testing.T) {
+	spec := &sandboxSpec{uid: -1, gid: -1}
+	cmd := exec.Command("ls", "/proc")
+	if err := configureSandbox(cmd, spec, true); err != nil {
+		t.Fatalf("configureSandbox: %v", err)
+	}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil && isIsolationError(err) {
+		t.Skipf("namespaces are not available: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("sandboxed command failed: %v: %s", err, stderr.String())
+	}
+	if strings.Contains(stderr.String(), "failed to mount /proc") {
+		t.Skipf("/proc cannot be mounted here: %s", stderr.String())
+	}
+
+	// Only the plugin itself is visible, as PID 1
+	var pids []string
+	for _, name := range strings.Fields(string(out)) {
+		if strings.Trim(name, "0123456789") == "" {
+			pids = append(pids, name)
+		}
+	}
+	if len(pids) != 1 || pids[0] != "1" {
+		t.Errorf("expected only PID 1 in /proc, got %v", pids)
+	}
+}
@@ -0,0 +1,28 @@
+//go:build !linux
+
+package loader
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// configureSandbox sets the credentials and namespaces of a plugin process.
+// Only the environment allowlist and working directory apply outside Linux,
+// so resource limits are not enforced.
+func configureSandbox(cmd *exec.Cmd, spec *sandboxSpec, isolate bool) error {
+	if spec.uid >= 0 {
+		return errors.New("running plugins as another user is only supported on Linux")
+	}
+	return nil
+}
+
+// isIsolationError reports whether a plugin failed to start because namespaces are not available
+func isIsolationError(err error) bool {
+	return false
+}
+
+// protectCore stops other processes from reading core's memory and environment
+func protectCore() error {
+	return nil
+}
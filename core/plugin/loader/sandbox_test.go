@@ -0,0 +1,68 @@
+package loader
+
+import (
+	"strings"
+	"testing"
+
+	"pixie/plugin/manifest"
+)
+
+func TestPluginEnvOnlyPassesAllowedVariables(t *testing.T) {
+	t.Setenv("JWT_SECRET", "secret")
+	t.Setenv("S3_SECRET_KEY", "secret")
+	t.Setenv("JWT_PRIVATE_KEY_FILE", "/keys/jwt.pem")
+	t.Setenv("THUMB_WORKERS", "8")
+	t.Setenv("THUMB_QUALITY", "85")
+	t.Setenv("NATS_URL", "nats://nats:4222")
+
+	// The operator allows THUMB_WORKERS but not THUMB_QUALITY, and cannot allow secrets
+	t.Setenv("PLUGIN_ENV", "NATS_URL, THUMB_WORKERS,J*,S3_SECRET_KEY,PIXIE_PLUGIN_NAME")
+	defer func(saved sandboxConfig) { sandbox = saved }(sandbox)
+	loadSandboxConfig()
+
+	m := &manifest.Manifest{
+		Name:    "thumbnailer",
+		Sandbox: manifest.Sandbox{Env: []string{"NATS_URL", "THUMB_*", "J*", "S3_*", "PIXIE_PLUGIN_NAME"}},
+	}
+	env := pluginEnv(m, "/data/thumbnailer", []byte(`{"size":512}`))
+
+	values := make(map[string]string)
+	for _, kv := range env {
+		name, value, _ := strings.Cut(kv, "=")
+		values[name] = value
+	}
+
+	for _, name := range []string{"JWT_SECRET", "JWT_PRIVATE_KEY_FILE", "S3_SECRET_KEY", "THUMB_QUALITY"} {
+		if _, ok := values[name]; ok {
+			t.Errorf("%s should not be passed to the plugin", name)
+		}
+	}
+	if values["NATS_URL"] != "nats://nats:4222" || values["THUMB_WORKERS"] != "8" {
+		t.Errorf("allowed variables missing: %v", env)
+	}
+	if values["HOME"] != "/data/thumbnailer" || values["PIXIE_PLUGIN_SETTINGS"] != `{"size":512}` {
+		t.Errorf("unexpected plugin variables: %v", env)
+	}
+
+	// Later values win, so core's own variables must come last
+	if env[len(env)-3] != "PIXIE_PLUGIN_NAME=thumbnailer" {
+		t.Errorf("PIXIE_PLUGIN_NAME can be overridden: %v", env)
+	}
+}
+
+func TestEffectiveLimit(t *testing.T) {
+	tests := []struct {
+		requested, max, want uint64
+	}{
+		{0, 512, 512},
+		{256, 512, 256},
+		{1024, 512, 512},
+		{1024, 0, 1024},
+		{0, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := effectiveLimit(tt.requested, tt.max); got != tt.want {
+			t.Errorf("effectiveLimit(%d, %d) = %d, want %d", tt.requested, tt.max, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,481 @@
+package loader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	pluginv1 "pixie/gen/plugin/v1"
+	"pixie/plugin/manifest"
+)
+
+const (
+	// startTimeout is how long a plugin has to complete its handshake
+	startTimeout = 5 * time.Second
+
+	// healthTimeout is the timeout for a single health check
+	healthTimeout = 5 * time.Second
+
+	// maxHealthFailures is the number of consecutive failed health checks before a plugin is restarted
+	maxHealthFailures = 3
+
+	// minBackoff and maxBackoff bound the delay between restarts
+	minBackoff = 1 * time.Second
+	maxBackoff = 1 * time.Minute
+
+	// stableRunTime is how long a plugin must run before its backoff is reset
+	stableRunTime = 1 * time.Minute
+)
+
+// errUnhealthy is recorded when a plugin is restarted because it failed its health checks
+var errUnhealthy = errors.New("plugin failed health checks")
+
+// plugin is a single supervised plugin process
+type plugin struct {
+	// ops serializes enable, disable, reload and configure operations
+	ops sync.Mutex
+
+	// The fields below are protected by the package mutex
+	manifest  *manifest.Manifest
+	path      string
+	name      string
+	enabled   bool
+	settings  map[string]interface{}
+	cancel    context.CancelFunc
+	done      chan struct{}
+	cmd       *exec.Cmd
+	conn      *grpc.ClientConn
+	client    pluginv1.PhotoPluginClient
+	transport string
+	port      int
+	remote    string
+	token     string
+	lastSeen  time.Time
+	healthy   bool
+	startedAt time.Time
+	restarts  int
+	lastErr   error
+
+	// slots limits the events delivered to the plugin at once to its concurrency
+	slots chan struct{}
+
+	// exited is closed when the current process has exited
+	exited chan struct{}
+}
+
+// newPlugin creates a plugin from its manifest
+func newPlugin(m *manifest.Manifest) *plugin {
+	return &plugin{
+		manifest: m,
+		path:     m.EntrypointPath(),
+		name:     m.Name,
+		enabled:  true,
+		slots:    make(chan struct{}, m.ConcurrencyOrDefault()),
+	}
+}
+
+// setManifest replaces the plugin's manifest. Deliveries in progress finish
+// within the old concurrency limit. The caller must hold mutex.
+func (p *plugin) setManifest(m *manifest.Manifest) {
+	p.manifest = m
+	if cap(p.slots) != m.ConcurrencyOrDefault() {
+		p.slots = make(chan struct{}, m.ConcurrencyOrDefault())
+	}
+}
+
+// startSupervisor starts a supervisor for the plugin. The caller must hold p.ops.
+func (p *plugin) startSupervisor(started func()) {
+	ctx, cancel := context.WithCancel(rootCtx)
+	done := make(chan struct{})
+
+	mutex.Lock()
+	p.cancel = cancel
+	p.done = done
+	mutex.Unlock()
+
+	supervisors.Add(1)
+	go func() {
+		defer close(done)
+		p.supervise(ctx, &supervisors, started)
+	}()
+}
+
+// stopSupervisor stops the plugin's supervisor and waits for the process to exit.
+// The caller must hold p.ops.
+func (p *plugin) stopSupervisor() {
+	mutex.Lock()
+	cancel, done := p.cancel, p.done
+	p.cancel = nil
+	p.done = nil
+	mutex.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// supervise starts the plugin and keeps it running until ctx is cancelled.
+// started is called once the first start attempt has either succeeded or failed.
+func (p *plugin) supervise(ctx context.Context, wg *sync.WaitGroup, started func()) {
+	defer wg.Done()
+
+	var once sync.Once
+	defer once.Do(started)
+
+	backoff := minBackoff
+	for {
+		startedAt := time.Now()
+		err := p.run(ctx, func() { once.Do(started) })
+		once.Do(started)
+
+		// Drop the plugin from the registry while it is down
+		mutex.Lock()
+		p.healthy = false
+		p.client = nil
+		p.conn = nil
+		if err != nil && ctx.Err() == nil {
+			p.lastErr = err
+		}
+		rebuildRegistry()
+		mutex.Unlock()
+
+		if ctx.Err() != nil || p.expire() {
+			return
+		}
+
+		// Reset the backoff if the plugin ran long enough to be considered stable
+		if time.Since(startedAt) > stableRunTime {
+			backoff = minBackoff
+		}
+
+		log.Printf("Plugin %s stopped: %v; restarting in %s", p.name, err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+
+		mutex.Lock()
+		p.restarts++
+		mutex.Unlock()
+	}
+}
+
+// run starts the plugin process and blocks until it exits, fails its health checks,
+// or ctx is cancelled. It returns the reason the plugin stopped.
+func (p *plugin) run(ctx context.Context, ready func()) error {
+	target, creds, err := p.start()
+	if err != nil {
+		return err
+	}
+
+	// Connect to the plugin
+	conn, err := grpc.Dial(target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		p.terminate(0)
+		return fmt.Errorf("failed to connect to plugin: %w", err)
+	}
+	defer conn.Close()
+
+	// Check health before adding the plugin to the registry
+	healthClient := grpc_health_v1.NewHealthClient(conn)
+	if err := checkHealth(ctx, healthClient); err != nil {
+		p.terminate(0)
+		return fmt.Errorf("health check failed: %w", err)
+	}
+
+	mutex.Lock()
+	p.conn = conn
+	p.client = pluginv1.NewPhotoPluginClient(conn)
+	p.healthy = true
+	p.lastErr = nil
+	rebuildRegistry()
+	mutex.Unlock()
+
+	log.Printf("Successfully loaded plugin: %s", p.name)
+	ready()
+
+	// Poll health until the process exits or fails too many checks in a row
+	ticker := time.NewTicker(healthInterval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-p.exited:
+			return fmt.Errorf("plugin exited: %s", p.cmd.ProcessState)
+		case <-ctx.Done():
+			p.terminate(shutdownGrace)
+			return ctx.Err()
+		case <-ticker.C:
+			if err := checkHealth(ctx, healthClient); err != nil {
+				failures++
+				log.Printf("Plugin %s health check failed (%d/%d): %v", p.name, failures, maxHealthFailures, err)
+				if failures >= maxHealthFailures {
+					p.terminate(shutdownGrace)
+					return errUnhealthy
+				}
+				continue
+			}
+			failures = 0
+		}
+	}
+}
+
+// start launches the plugin process and waits for its handshake. It returns the
+// address to dial and the credentials to use.
+func (p *plugin) start() (string, credentials.TransportCredentials, error) {
+	mutex.Lock()
+	remote := p.remote
+	mutex.Unlock()
+
+	// Remote plugins are already running, core only connects to them
+	if remote != "" {
+		mutex.Lock()
+		p.transport = manifest.TransportRemote
+		p.startedAt = time.Now()
+		mutex.Unlock()
+
+		// Only a plugin with a certificate for its name can serve it
+		if pluginCAFile == "" {
+			return "", nil, ErrNoPluginCA
+		}
+		log.Printf("Connecting to remote plugin %s at %s", p.name, remote)
+		return remote, credentials.NewTLS(pluginCA.clientConfig(p.name)), nil
+	}
+
+	log.Printf("Loading plugin: %s %s (%s)", p.name, p.manifest.Version, p.path)
+
+	// Prepare the sandbox, passing the plugin its settings in the environment
+	settings, err := json.Marshal(p.effectiveSettings())
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to marshal plugin settings: %w", err)
+	}
+	spec, err := p.sandboxSpec(settings)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to prepare plugin sandbox: %w", err)
+	}
+	tr, err := p.prepareTransport(spec)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to prepare plugin transport: %w", err)
+	}
+	spec.env = append(spec.env, tr.env...)
+
+	// Give the plugin a token scoped to the permissions in its manifest. Without
+	// permissions it can still send its logs to core.
+	var token string
+	if host != nil {
+		if token, err = host.Issue(p.name, p.manifest.Permissions); err != nil {
+			return "", nil, err
+		}
+		spec.env = append(spec.env, "PIXIE_HOST_ADDR="+host.Addr(), "PIXIE_HOST_TOKEN="+token)
+	}
+	revoke := func() {
+		if token != "" {
+			host.Revoke(token)
+		}
+	}
+
+	handshakes := make(chan handshake, 1)
+	isolate := sandbox.isolate && !isolationUnavailable.Load()
+	cmd, err := p.command(spec, handshakes, isolate)
+	if err != nil {
+		revoke()
+		return "", nil, err
+	}
+	err = cmd.Start()
+	if err != nil && isolate && isIsolationError(err) {
+		// Fall back to running without namespaces, e.g. inside an unprivileged container
+		log.Printf("Plugin isolation is not available, running plugins without namespaces: %v", err)
+		isolationUnavailable.Store(true)
+		if cmd, err = p.command(spec, handshakes, false); err != nil {
+			revoke()
+			return "", nil, err
+		}
+		err = cmd.Start()
+	}
+	if err != nil {
+		revoke()
+		return "", nil, fmt.Errorf("failed to start plugin: %w", err)
+	}
+
+	// Watch the process so crashes are noticed immediately. The token dies with the process.
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		revoke()
+		close(exited)
+	}()
+
+	mutex.Lock()
+	p.cmd = cmd
+	p.exited = exited
+	p.startedAt = time.Now()
+	mutex.Unlock()
+
+	// Wait for the handshake, exit or timeout
+	select {
+	case h := <-handshakes:
+		target, err := tr.target(h)
+		if err != nil {
+			p.terminate(0)
+			return "", nil, err
+		}
+
+		mutex.Lock()
+		p.port = h.port
+		p.transport = tr.kind
+		mutex.Unlock()
+
+		log.Printf("Plugin %s listening on %s", p.name, target)
+		return target, tr.creds, nil
+	case <-exited:
+		return "", nil, fmt.Errorf("plugin exited before its handshake: %s", cmd.ProcessState)
+	case <-time.After(startTimeout):
+		p.terminate(0)
+		return "", nil, ErrPluginTimeout
+	}
+}
+
+// command builds the plugin command inside its sandbox. The plugin's handshake
+// is sent to handshakes.
+func (p *plugin) command(spec *sandboxSpec, handshakes chan<- handshake, isolate bool) (*exec.Cmd, error) {
+	cmd := exec.Command(p.path, "--port=0")
+	cmd.Dir = spec.workDir
+	cmd.Env = spec.env
+	if err := configureSandbox(cmd, spec, isolate); err != nil {
+		return nil, fmt.Errorf("failed to configure plugin sandbox: %w", err)
+	}
+
+	cmd.Stdout = &lineWriter{fn: func(line string) {
+		log.Printf("Plugin %s output: %s", p.name, line)
+
+		// Check if line contains the handshake
+		if h, ok := parseHandshake(line); ok {
+			select {
+			case handshakes <- h:
+			default:
+			}
+		}
+	}}
+	cmd.Stderr = &lineWriter{fn: func(line string) {
+		log.Printf("Plugin %s error: %s", p.name, line)
+	}}
+
+	return cmd, nil
+}
+
+// parseHandshake parses a SOCKET=<path> or PORT=<port> line printed by a plugin
+func parseHandshake(line string) (handshake, bool) {
+	if matches := socketRegex.FindStringSubmatch(line); len(matches) == 2 {
+		return handshake{socket: matches[1]}, true
+	}
+	if matches := portRegex.FindStringSubmatch(line); len(matches) == 2 {
+		if port, err := strconv.Atoi(matches[1]); err == nil && port > 0 && port < 65536 {
+			return handshake{port: port}, true
+		}
+	}
+	return handshake{}, false
+}
+
+// effectiveSettings returns the saved settings merged over the manifest defaults
+func (p *plugin) effectiveSettings() map[string]interface{} {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	settings := make(map[string]interface{})
+	if p.manifest.Settings != nil {
+		for k, v := range p.manifest.Settings.Defaults() {
+			settings[k] = v
+		}
+	}
+	for k, v := range p.settings {
+		settings[k] = v
+	}
+	return settings
+}
+
+// terminate sends SIGTERM to the plugin process and kills it if it has not exited after grace
+func (p *plugin) terminate(grace time.Duration) {
+	if p.cmd == nil || p.cmd.Process == nil {
+		return
+	}
+
+	select {
+	case <-p.exited:
+		return
+	default:
+	}
+
+	if grace > 0 {
+		log.Printf("Terminating plugin process %d (%s)", p.cmd.Process.Pid, p.name)
+		if err := p.cmd.Process.Signal(syscall.SIGTERM); err == nil {
+			select {
+			case <-p.exited:
+				return
+			case <-time.After(grace):
+				log.Printf("Plugin %s did not exit within %s", p.name, grace)
+			}
+		}
+	}
+
+	log.Printf("Killing plugin process %d (%s)", p.cmd.Process.Pid, p.name)
+	if err := p.cmd.Process.Kill(); err != nil {
+		log.Printf("Failed to kill plugin process %d: %v", p.cmd.Process.Pid, err)
+	}
+	<-p.exited
+}
+
+// checkHealth runs a single gRPC health check
+func checkHealth(ctx context.Context, client grpc_health_v1.HealthClient) error {
+	ctx, cancel := context.WithTimeout(ctx, healthTimeout)
+	defer cancel()
+
+	resp, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		return err
+	}
+	if resp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		return fmt.Errorf("plugin is %s", resp.Status)
+	}
+	return nil
+}
+
+// lineWriter calls fn for every complete line written to it
+type lineWriter struct {
+	fn  func(line string)
+	buf []byte
+}
+
+// Write implements io.Writer
+func (w *lineWriter) Write(data []byte) (int, error) {
+	w.buf = append(w.buf, data...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.fn(string(bytes.TrimRight(w.buf[:i], "\r")))
+		w.buf = w.buf[i+1:]
+	}
+	return len(data), nil
+}
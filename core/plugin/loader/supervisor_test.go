@@ -0,0 +1,72 @@
+package loader
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"pixie/plugin/manifest"
+)
+
+func TestLineWriter(t *testing.T) {
+	var lines []string
+	w := &lineWriter{fn: func(line string) { lines = append(lines, line) }}
+
+	w.Write([]byte("PORT=12"))
+	w.Write([]byte("34\r\nhello\n"))
+	w.Write([]byte("partial"))
+
+	if strings.Join(lines, "|") != "PORT=1234|hello" {
+		t.Fatalf("unexpected lines: %q", lines)
+	}
+}
+
+func TestSuperviseRestartsCrashedPlugin(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "crash"), []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	p := newPlugin(&manifest.Manifest{
+		Name:         "crash",
+		Version:      "0.1.0",
+		Entrypoint:   "crash",
+		APIVersion:   manifest.APIVersion,
+		Capabilities: []manifest.Capability{manifest.CapabilityProcess},
+		Dir:          dir,
+	})
+	sandbox.dataDir = t.TempDir()
+	rootCtx, rootCancel = context.WithCancel(context.Background())
+	var started sync.WaitGroup
+	started.Add(1)
+	p.startSupervisor(started.Done)
+	started.Wait()
+
+	// The first attempt fails immediately; the supervisor retries after minBackoff
+	deadline := time.Now().Add(minBackoff + 2*time.Second)
+	for {
+		mutex.Lock()
+		restarts, lastErr := p.restarts, p.lastErr
+		mutex.Unlock()
+		if restarts > 0 {
+			if lastErr == nil {
+				t.Error("expected the crash to be recorded")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("plugin was not restarted")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	Shutdown()
+
+	if Count() != 0 {
+		t.Errorf("crashed plugin should not be in the registry")
+	}
+}
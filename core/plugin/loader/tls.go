@@ -0,0 +1,265 @@
+package loader
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// certValidity is how long certificates issued to plugins are valid
+const certValidity = 30 * 24 * time.Hour
+
+// caValidity is how long a CA created at startup is valid. It outlives core,
+// while the certificates it issues are renewed.
+const caValidity = 10 * 365 * 24 * time.Hour
+
+// remoteCertValidity is how long certificates for remote plugins and a CA
+// created for them are valid, since they are issued ahead of time
+const remoteCertValidity = 2 * 365 * 24 * time.Hour
+
+// coreCertName is the common name of the client certificate core presents to plugins
+const coreCertName = "pixie-core"
+
+// certAuthority is a CA that issues certificates for mTLS between core and
+// plugins. Unless remote plugins need one loaded from disk, it is created at
+// startup and never written to disk.
+type certAuthority struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+
+	// validity is how long the certificates issued by issue are valid
+	validity time.Duration
+
+	// now returns the current time, for tests
+	now func() time.Time
+
+	// client is the certificate core presents to plugins
+	client *renewingCert
+}
+
+// renewingCert is a certificate core presents, issued when first needed and
+// issued again once less than a third of its validity is left
+type renewingCert struct {
+	ca     *certAuthority
+	name   string
+	usages []x509.ExtKeyUsage
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	renewAt time.Time
+}
+
+// newCertAuthority creates an in-memory CA
+func newCertAuthority() (*certAuthority, error) {
+	der, key, err := createCA(caValidity)
+	if err != nil {
+		return nil, err
+	}
+	return certAuthorityFrom(der, key)
+}
+
+// loadCertAuthority loads the CA remote plugin certificates are issued by. Its
+// key must be an EC key.
+func loadCertAuthority(certFile, keyFile string) (*certAuthority, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plugin CA certificate: %w", err)
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plugin CA key: %w", err)
+	}
+
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil || certBlock.Type != "CERTIFICATE" {
+		return nil, errors.New("no certificate found in plugin CA certificate file")
+	}
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, errors.New("no key found in plugin CA key file")
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		parsed, pkcs8Err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+		ecKey, ok := parsed.(*ecdsa.PrivateKey)
+		if pkcs8Err != nil || !ok {
+			return nil, fmt.Errorf("failed to parse plugin CA key: %w", err)
+		}
+		key = ecKey
+	}
+
+	return certAuthorityFrom(certBlock.Bytes, key)
+}
+
+// createCA creates a self-signed CA certificate and its key
+func createCA(validity time.Duration) ([]byte, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate CA key: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{CommonName: "Pixie plugin CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+	return der, key, nil
+}
+
+// certAuthorityFrom sets up a CA from its certificate and key
+func certAuthorityFrom(der []byte, key *ecdsa.PrivateKey) (*certAuthority, error) {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+	if !cert.IsCA {
+		return nil, errors.New("plugin CA certificate is not a CA")
+	}
+
+	ca := &certAuthority{
+		cert:     cert,
+		key:      key,
+		certPEM:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		validity: certValidity,
+		now:      time.Now,
+	}
+	ca.client = ca.renewing(coreCertName, x509.ExtKeyUsageClientAuth)
+
+	return ca, nil
+}
+
+// issue creates a certificate for name, returning the certificate and key as PEM
+func (ca *certAuthority) issue(name string, usages ...x509.ExtKeyUsage) ([]byte, []byte, error) {
+	return ca.issueFor(name, ca.validity, usages...)
+}
+
+// renewing returns a certificate for name that is issued by get
+func (ca *certAuthority) renewing(name string, usages ...x509.ExtKeyUsage) *renewingCert {
+	return &renewingCert{ca: ca, name: name, usages: usages}
+}
+
+// get returns the certificate, issuing a new one if there is none yet or it is
+// close to expiring
+func (c *renewingCert) get() (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cert != nil && c.ca.now().Before(c.renewAt) {
+		return c.cert, nil
+	}
+
+	certPEM, keyPEM, err := c.ca.issue(c.name, c.usages...)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate of %s: %w", c.name, err)
+	}
+	c.cert = &cert
+	c.renewAt = cert.Leaf.NotAfter.Add(-c.ca.validity / 3)
+	return c.cert, nil
+}
+
+// issueFor creates a certificate for name that is valid for validity
+func (ca *certAuthority) issueFor(name string, validity time.Duration, usages ...x509.ExtKeyUsage) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    ca.now().Add(-time.Minute),
+		NotAfter:     ca.now().Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  usages,
+	}
+	for _, usage := range usages {
+		if usage == x509.ExtKeyUsageServerAuth {
+			template.DNSNames = []string{name, "localhost"}
+			template.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal key: %w", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		nil
+}
+
+// clientConfig returns the TLS configuration core uses to connect to the plugin name
+func (ca *certAuthority) clientConfig(name string) *tls.Config {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	return &tls.Config{
+		RootCAs: pool,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return ca.client.get()
+		},
+		ServerName: name,
+		MinVersion: tls.VersionTLS13,
+	}
+}
+
+// serverConfig returns the TLS configuration of the listener remote plugins
+// register on. Only clients with a certificate issued by the CA are accepted.
+func (ca *certAuthority) serverConfig() (*tls.Config, error) {
+	server := ca.renewing(coreCertName, x509.ExtKeyUsageServerAuth)
+	// Fail at startup rather than on the first registration
+	if _, err := server.get(); err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	return &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return server.get()
+		},
+		ClientCAs:  pool,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		MinVersion: tls.VersionTLS13,
+	}, nil
+}
+
+// randomSerial returns a random certificate serial number
+func randomSerial() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		panic(fmt.Sprintf("failed to generate serial number: %v", err))
+	}
+	return serial
+}
@@ -0,0 +1,232 @@
+package loader
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"pixie/plugin/manifest"
+)
+
+// socketName is the name of the socket in each plugin's socket directory
+const socketName = "plugin.sock"
+
+var (
+	// socketRoot is the 0700 directory holding one socket directory per plugin
+	socketRoot string
+
+	// socketRootTemp is set when socketRoot was created by core and is removed on shutdown
+	socketRootTemp bool
+
+	// socketRootOnce creates socketRoot on first use
+	socketRootOnce sync.Once
+	socketRootErr  error
+
+	// pluginCA issues mTLS certificates for TCP plugins if PLUGIN_TLS is enabled,
+	// and verifies remote plugins if PLUGIN_CA_CERT_FILE is set
+	pluginCA *certAuthority
+
+	// pluginCAFile is the file pluginCA was loaded from, empty for an in-memory CA
+	pluginCAFile string
+
+	// tcpTLS requires mTLS for plugins using the tcp transport
+	tcpTLS bool
+)
+
+// handshake is what a plugin prints once it is listening
+type handshake struct {
+	port   int
+	socket string
+}
+
+// transport is how core connects to a single plugin process
+type transport struct {
+	kind   string
+	socket string
+	creds  credentials.TransportCredentials
+	env    []string
+}
+
+// initTransport reads the transport configuration from the environment
+func initTransport() error {
+	socketRoot = getEnv("PLUGIN_SOCKET_DIR", "")
+
+	tcpTLS = getBoolEnv("PLUGIN_TLS", false)
+
+	// Remote plugins have certificates issued ahead of time, so their CA is
+	// loaded from disk. It then issues the TCP plugin certificates too.
+	pluginCA, pluginCAFile = nil, ""
+	certFile, keyFile := getEnv("PLUGIN_CA_CERT_FILE", ""), getEnv("PLUGIN_CA_KEY_FILE", "")
+	if certFile != "" || keyFile != "" {
+		ca, err := loadCertAuthority(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		pluginCA, pluginCAFile = ca, certFile
+		log.Printf("Using plugin CA from %s", certFile)
+	} else if tcpTLS {
+		ca, err := newCertAuthority()
+		if err != nil {
+			return fmt.Errorf("failed to create plugin CA: %w", err)
+		}
+		pluginCA = ca
+	}
+	if tcpTLS {
+		log.Println("Using mTLS for TCP plugins")
+	}
+
+	return nil
+}
+
+// closeTransport removes the socket directory if core created it
+func closeTransport() {
+	if socketRootTemp {
+		os.RemoveAll(socketRoot)
+	}
+}
+
+// ensureSocketRoot creates the socket directory, using a private temporary directory by default
+func ensureSocketRoot() (string, error) {
+	socketRootOnce.Do(func() {
+		if socketRoot == "" {
+			socketRoot, socketRootErr = os.MkdirTemp("", "pixie-plugins-")
+			socketRootTemp = socketRootErr == nil
+			return
+		}
+
+		if socketRootErr = os.MkdirAll(socketRoot, 0700); socketRootErr != nil {
+			return
+		}
+		// The directory may already exist with looser permissions
+		socketRootErr = os.Chmod(socketRoot, 0700)
+	})
+	if socketRootErr != nil {
+		return "", fmt.Errorf("failed to create plugin socket directory: %w", socketRootErr)
+	}
+	return socketRoot, nil
+}
+
+// prepareTransport sets up the socket or certificates for the plugin's next process
+func (p *plugin) prepareTransport(spec *sandboxSpec) (*transport, error) {
+	mutex.Lock()
+	kind := p.manifest.TransportOrDefault()
+	mutex.Unlock()
+
+	switch kind {
+	case manifest.TransportUnix:
+		root, err := ensureSocketRoot()
+		if err != nil {
+			return nil, err
+		}
+
+		// Each plugin gets its own directory so it can own its socket
+		dir := filepath.Join(root, p.name)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create plugin socket directory: %w", err)
+		}
+		if spec.uid >= 0 || spec.gid >= 0 {
+			if err := os.Chown(dir, spec.uid, spec.gid); err != nil {
+				return nil, fmt.Errorf("failed to change owner of plugin socket directory: %w", err)
+			}
+		}
+
+		// Remove a socket left behind by a previous process
+		socket := filepath.Join(dir, socketName)
+		if err := os.Remove(socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to remove stale plugin socket: %w", err)
+		}
+
+		return &transport{
+			kind:   kind,
+			socket: socket,
+			creds:  insecure.NewCredentials(),
+			env:    []string{"PIXIE_PLUGIN_SOCKET=" + socket},
+		}, nil
+
+	case manifest.TransportTCP:
+		if !tcpTLS {
+			return &transport{kind: kind, creds: insecure.NewCredentials()}, nil
+		}
+
+		env, err := writePluginCerts(p.name, spec)
+		if err != nil {
+			return nil, err
+		}
+		return &transport{
+			kind:  kind,
+			creds: credentials.NewTLS(pluginCA.clientConfig(p.name)),
+			env:   env,
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported transport %q", kind)
+	}
+}
+
+// target returns the address to dial after the plugin's handshake
+func (t *transport) target(h handshake) (string, error) {
+	switch t.kind {
+	case manifest.TransportUnix:
+		if h.socket == "" {
+			return "", errors.New("plugin must listen on PIXIE_PLUGIN_SOCKET and print SOCKET=<path>")
+		}
+		if h.socket != t.socket {
+			return "", fmt.Errorf("plugin listened on %s instead of %s", h.socket, t.socket)
+		}
+		return "unix://" + t.socket, nil
+	default:
+		if h.port == 0 {
+			return "", errors.New("plugin must print PORT=<port>")
+		}
+		return fmt.Sprintf("localhost:%d", h.port), nil
+	}
+}
+
+// writePluginCerts issues a server certificate for a TCP plugin and writes it to
+// the plugin's working directory. It returns the variables that point the plugin at it.
+func writePluginCerts(name string, spec *sandboxSpec) ([]string, error) {
+	certPEM, keyPEM, err := pluginCA.issue(name, x509.ExtKeyUsageServerAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(spec.workDir, "tls")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create plugin TLS directory: %w", err)
+	}
+
+	files := map[string][]byte{
+		"ca.pem":   pluginCA.certPEM,
+		"cert.pem": certPEM,
+		"key.pem":  keyPEM,
+	}
+	for file, data := range files {
+		path := filepath.Join(dir, file)
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			return nil, fmt.Errorf("failed to write plugin %s: %w", file, err)
+		}
+		if spec.uid >= 0 || spec.gid >= 0 {
+			if err := os.Chown(path, spec.uid, spec.gid); err != nil {
+				return nil, fmt.Errorf("failed to change owner of plugin %s: %w", file, err)
+			}
+		}
+	}
+	if spec.uid >= 0 || spec.gid >= 0 {
+		if err := os.Chown(dir, spec.uid, spec.gid); err != nil {
+			return nil, fmt.Errorf("failed to change owner of plugin TLS directory: %w", err)
+		}
+	}
+
+	return []string{
+		"PIXIE_PLUGIN_TLS_CA=" + filepath.Join(dir, "ca.pem"),
+		"PIXIE_PLUGIN_TLS_CERT=" + filepath.Join(dir, "cert.pem"),
+		"PIXIE_PLUGIN_TLS_KEY=" + filepath.Join(dir, "key.pem"),
+	}, nil
+}
@@ -0,0 +1,172 @@
+package loader
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"testing"
+	"time"
+
+	"pixie/plugin/manifest"
+)
+
+func TestParseHandshake(t *testing.T) {
+	tests := map[string]handshake{
+		"PORT=1234":                  {port: 1234},
+		"SOCKET=/run/pixie/p/x.sock": {socket: "/run/pixie/p/x.sock"},
+	}
+	for line, want := range tests {
+		got, ok := parseHandshake(line)
+		if !ok || got != want {
+			t.Errorf("parseHandshake(%q) = %+v, %v", line, got, ok)
+		}
+	}
+
+	for _, line := range []string{"Starting server", "PORT=0", "PORT=70000", "log: SOCKET=/tmp/x"} {
+		if h, ok := parseHandshake(line); ok {
+			t.Errorf("parseHandshake(%q) = %+v, expected no handshake", line, h)
+		}
+	}
+}
+
+func TestUnixTransportRejectsOtherSockets(t *testing.T) {
+	tr := &transport{kind: manifest.TransportUnix, socket: "/run/pixie/p/plugin.sock"}
+
+	if target, err := tr.target(handshake{socket: tr.socket}); err != nil || target != "unix:///run/pixie/p/plugin.sock" {
+		t.Errorf("unexpected target %q: %v", target, err)
+	}
+	if _, err := tr.target(handshake{socket: "/tmp/evil.sock"}); err == nil {
+		t.Error("expected a different socket to be rejected")
+	}
+	if _, err := tr.target(handshake{port: 1234}); err == nil {
+		t.Error("expected a TCP port to be rejected for a Unix socket plugin")
+	}
+}
+
+func TestCertAuthorityMutualTLS(t *testing.T) {
+	ca, err := newCertAuthority()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// serve starts a TLS server with a certificate for name and returns its address
+	serve := func(name string, usage x509.ExtKeyUsage) string {
+		certPEM, keyPEM, err := ca.issue(name, usage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pool := x509.NewCertPool()
+		pool.AddCert(ca.cert)
+
+		lis, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientCAs:    pool,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { lis.Close() })
+
+		go func() {
+			for {
+				conn, err := lis.Accept()
+				if err != nil {
+					return
+				}
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}
+		}()
+		return lis.Addr().String()
+	}
+
+	dial := func(addr, name string) error {
+		conn, err := tls.Dial("tcp", addr, ca.clientConfig(name))
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		// Read to surface a rejected client certificate under TLS 1.3
+		_, err = conn.Read(make([]byte, 1))
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return nil
+		}
+		if err != nil && err.Error() == "EOF" {
+			return nil
+		}
+		return err
+	}
+
+	addr := serve("thumbnailer", x509.ExtKeyUsageServerAuth)
+	if err := dial(addr, "thumbnailer"); err != nil {
+		t.Errorf("expected core to connect to its plugin: %v", err)
+	}
+	if err := dial(addr, "noop"); err == nil {
+		t.Error("expected a plugin certificate for another name to be rejected")
+	}
+}
+
+func TestCertAuthorityRenewsCoreCertificates(t *testing.T) {
+	ca, err := newCertAuthority()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca.cert.NotAfter.Before(time.Now().Add(5 * 365 * 24 * time.Hour)) {
+		t.Errorf("expected the CA to outlive core, it expires %v", ca.cert.NotAfter)
+	}
+
+	now := time.Now()
+	ca.now = func() time.Time { return now }
+	server, err := ca.serverConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := ca.clientConfig("thumbnailer")
+
+	// certs returns the certificates core presents as a server and a client
+	certs := func() (*x509.Certificate, *x509.Certificate) {
+		t.Helper()
+		s, err := server.GetCertificate(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := client.GetClientCertificate(&tls.CertificateRequestInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.Leaf, c.Leaf
+	}
+
+	firstServer, firstClient := certs()
+	now = now.Add(certValidity / 2)
+	if s, c := certs(); s != firstServer || c != firstClient {
+		t.Error("expected certificates with more than a third of their validity left to be kept")
+	}
+
+	now = now.Add(certValidity / 4)
+	s, c := certs()
+	if s == firstServer || c == firstClient {
+		t.Fatal("expected certificates close to expiring to be renewed")
+	}
+	for _, cert := range []*x509.Certificate{s, c} {
+		if !cert.NotAfter.After(now.Add(certValidity / 2)) {
+			t.Errorf("expected a renewed certificate to be valid for a while, it expires %v", cert.NotAfter)
+		}
+	}
+
+	// The renewed client certificate still verifies after the first expired
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	if _, err := c.Verify(x509.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: firstClient.NotAfter.Add(time.Hour),
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		t.Errorf("expected the renewed client certificate to verify: %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package manifest
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	// routePathRegex matches a route path, optionally ending in /* to match a prefix
+	routePathRegex = regexp.MustCompile(`^/([A-Za-z0-9._~-]+(/[A-Za-z0-9._~-]+)*)?(/\*)?$`)
+
+	// routeMethods lists the HTTP methods a route may handle
+	routeMethods = map[string]bool{
+		"GET":    true,
+		"POST":   true,
+		"PUT":    true,
+		"PATCH":  true,
+		"DELETE": true,
+	}
+)
+
+// Route is an HTTP endpoint mounted by core under /api/plugins/<name>/
+type Route struct {
+	Method string `yaml:"method" json:"method"`
+
+	// Path is relative to the plugin's prefix. A trailing /* matches every path below it.
+	Path string `yaml:"path" json:"path"`
+
+	// Admin restricts the route to administrators
+	Admin bool `yaml:"admin,omitempty" json:"admin,omitempty"`
+}
+
+// UI describes the user interface a plugin contributes
+type UI struct {
+	// Assets is a directory, relative to the manifest, served at /plugin-ui/<name>/
+	Assets string `yaml:"assets,omitempty" json:"assets,omitempty"`
+
+	// Menu lists the entries added to the navigation
+	Menu []MenuEntry `yaml:"menu,omitempty" json:"menu,omitempty"`
+}
+
+// MenuEntry is a navigation entry that opens a page of the plugin's UI assets
+type MenuEntry struct {
+	Label string `yaml:"label" json:"label"`
+
+	// Page is the asset opened by the entry, e.g. index.html
+	Page string `yaml:"page" json:"page"`
+
+	// Admin only shows the entry to administrators
+	Admin bool `yaml:"admin,omitempty" json:"admin,omitempty"`
+}
+
+// Matches reports whether the route handles a request. path must be clean.
+func (r Route) Matches(method, path string) bool {
+	if r.Method != method {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(r.Path, "/*"); ok {
+		return path == prefix || strings.HasPrefix(path, prefix+"/")
+	}
+	return path == r.Path
+}
+
+// validate checks a route of a manifest
+func (r Route) validate() error {
+	if !routeMethods[r.Method] {
+		return fmt.Errorf("unsupported method %q", r.Method)
+	}
+	if !routePathRegex.MatchString(r.Path) || strings.Contains(r.Path, "/../") || strings.HasSuffix(r.Path, "/..") {
+		return fmt.Errorf("invalid path %q", r.Path)
+	}
+	return nil
+}
+
+// validate checks the ui section of a manifest
+func (u *UI) validate() error {
+	if u.Assets != "" && (filepath.IsAbs(u.Assets) || !filepath.IsLocal(u.Assets)) {
+		return fmt.Errorf("assets must be a directory inside the plugin directory")
+	}
+	for _, entry := range u.Menu {
+		if entry.Label == "" {
+			return fmt.Errorf("menu entries need a label")
+		}
+		if u.Assets == "" {
+			return fmt.Errorf("menu entries require assets")
+		}
+		if entry.Page == "" || filepath.IsAbs(entry.Page) || !filepath.IsLocal(entry.Page) {
+			return fmt.Errorf("menu entry %q must open a page inside the assets", entry.Label)
+		}
+	}
+	return nil
+}
+
+// Route returns the route handling a request, if any. path must be clean.
+func (m *Manifest) Route(method, path string) (Route, bool) {
+	for _, r := range m.Routes {
+		if r.Matches(method, path) {
+			return r, true
+		}
+	}
+	return Route{}, false
+}
+
+// AssetsPath returns the absolute path of the plugin's UI assets, or "" if it has none
+func (m *Manifest) AssetsPath() string {
+	if m.UI.Assets == "" || m.Dir == "" {
+		return ""
+	}
+	return filepath.Join(m.Dir, m.UI.Assets)
+}
@@ -0,0 +1,280 @@
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileName is the name of the manifest file in each plugin directory
+const FileName = "plugin.yaml"
+
+// APIVersion is the plugin API version implemented by core
+const APIVersion = 1
+
+// Capability is something a plugin can do for core
+type Capability string
+
+const (
+	// CapabilitySearch plugins answer Search calls
+	CapabilitySearch Capability = "search"
+
+	// CapabilityProcess plugins receive ProcessPhoto calls for the events they subscribe to
+	CapabilityProcess Capability = "process"
+
+	// CapabilityAuth plugins answer ValidateToken calls
+	CapabilityAuth Capability = "auth"
+
+	// CapabilityUI plugins contribute user interface extensions
+	CapabilityUI Capability = "ui"
+)
+
+// Transports a plugin can listen on
+const (
+	// TransportUnix plugins listen on the Unix socket core passes in PIXIE_PLUGIN_SOCKET
+	TransportUnix = "unix"
+
+	// TransportTCP plugins listen on a TCP port and print it as PORT=<port>
+	TransportTCP = "tcp"
+
+	// TransportRemote plugins run outside core and register over the network
+	TransportRemote = "remote"
+)
+
+// MaxConcurrency is the most events core delivers to a single plugin at once
+const MaxConcurrency = 64
+
+// Permission grants a plugin access to a host service
+type Permission string
+
+const (
+	// PermissionReadPhotos allows reading the original file of a photo
+	PermissionReadPhotos Permission = "photos.read"
+
+	// PermissionWriteDerivatives allows storing files derived from a photo
+	PermissionWriteDerivatives Permission = "derivatives.write"
+
+	// PermissionWriteMetadata allows patching the plugin's own metadata namespace of a photo
+	PermissionWriteMetadata Permission = "metadata.write"
+
+	// PermissionEmitEvents allows publishing events under plugin.<name>.
+	PermissionEmitEvents Permission = "events.emit"
+)
+
+var (
+	// ErrInvalidManifest is returned when a manifest fails validation
+	ErrInvalidManifest = errors.New("invalid plugin manifest")
+
+	// nameRegex restricts plugin names to something safe to use in paths and URLs
+	nameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+	// knownCapabilities lists the capabilities core understands
+	knownCapabilities = map[Capability]bool{
+		CapabilitySearch:  true,
+		CapabilityProcess: true,
+		CapabilityAuth:    true,
+		CapabilityUI:      true,
+	}
+
+	// knownPermissions lists the permissions core can grant
+	knownPermissions = map[Permission]bool{
+		PermissionReadPhotos:       true,
+		PermissionWriteDerivatives: true,
+		PermissionWriteMetadata:    true,
+		PermissionEmitEvents:       true,
+	}
+)
+
+// Manifest describes a plugin
+type Manifest struct {
+	Name         string       `yaml:"name" json:"name"`
+	Version      string       `yaml:"version" json:"version"`
+	Description  string       `yaml:"description,omitempty" json:"description,omitempty"`
+	Entrypoint   string       `yaml:"entrypoint" json:"entrypoint"`
+	APIVersion   int          `yaml:"api_version" json:"api_version"`
+	Capabilities []Capability `yaml:"capabilities" json:"capabilities"`
+	Events       []string     `yaml:"events,omitempty" json:"events,omitempty"`
+	Concurrency  int          `yaml:"concurrency,omitempty" json:"concurrency,omitempty"`
+	Permissions  []Permission `yaml:"permissions,omitempty" json:"permissions,omitempty"`
+	Transport    string       `yaml:"transport,omitempty" json:"transport,omitempty"`
+	Settings     *Schema      `yaml:"settings,omitempty" json:"settings,omitempty"`
+	Sandbox      Sandbox      `yaml:"sandbox,omitempty" json:"sandbox,omitempty"`
+	Routes       []Route      `yaml:"routes,omitempty" json:"routes,omitempty"`
+	UI           UI           `yaml:"ui,omitempty" json:"ui,omitempty"`
+
+	// Dir is the directory the manifest was loaded from
+	Dir string `yaml:"-" json:"-"`
+}
+
+// Load reads and validates the manifest at path
+func Load(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	m, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve plugin directory: %w", err)
+	}
+	m.Dir = dir
+
+	return m, nil
+}
+
+// Parse parses and validates a manifest
+func Parse(data []byte) (*Manifest, error) {
+	var m Manifest
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidManifest, err)
+	}
+
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// ParseRemote parses and validates the manifest of a plugin registering over the
+// network. The entrypoint and sandbox are ignored since core does not start it,
+// and so is the UI since core cannot serve its assets.
+func ParseRemote(data []byte) (*Manifest, error) {
+	var m Manifest
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidManifest, err)
+	}
+	m.Entrypoint = ""
+	m.Transport = TransportRemote
+	m.Sandbox = Sandbox{}
+	m.UI = UI{}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// ValidName reports whether name can be used as a plugin name
+func ValidName(name string) bool {
+	return nameRegex.MatchString(name)
+}
+
+// Validate checks that the manifest is complete and supported by this version of core
+func (m *Manifest) Validate() error {
+	if !ValidName(m.Name) {
+		return fmt.Errorf("%w: name %q must be lowercase letters, digits and dashes", ErrInvalidManifest, m.Name)
+	}
+	if m.Version == "" {
+		return fmt.Errorf("%w: version is required", ErrInvalidManifest)
+	}
+	if m.Entrypoint == "" && m.Transport != TransportRemote {
+		return fmt.Errorf("%w: entrypoint is required", ErrInvalidManifest)
+	}
+	if m.Entrypoint != "" && (filepath.IsAbs(m.Entrypoint) || !filepath.IsLocal(m.Entrypoint)) {
+		return fmt.Errorf("%w: entrypoint must be a path inside the plugin directory", ErrInvalidManifest)
+	}
+	if m.APIVersion < 1 || m.APIVersion > APIVersion {
+		return fmt.Errorf("%w: plugin requires API version %d, core supports %d", ErrInvalidManifest, m.APIVersion, APIVersion)
+	}
+	if len(m.Capabilities) == 0 {
+		return fmt.Errorf("%w: at least one capability is required", ErrInvalidManifest)
+	}
+	for _, c := range m.Capabilities {
+		if !knownCapabilities[c] {
+			return fmt.Errorf("%w: unknown capability %q", ErrInvalidManifest, c)
+		}
+	}
+	if len(m.Events) > 0 && !m.Has(CapabilityProcess) {
+		return fmt.Errorf("%w: events require the %s capability", ErrInvalidManifest, CapabilityProcess)
+	}
+	for _, event := range m.Events {
+		if event == "" {
+			return fmt.Errorf("%w: empty event subject", ErrInvalidManifest)
+		}
+	}
+	if m.Concurrency < 0 || m.Concurrency > MaxConcurrency {
+		return fmt.Errorf("%w: concurrency must be between 1 and %d", ErrInvalidManifest, MaxConcurrency)
+	}
+	switch m.Transport {
+	case "", TransportUnix, TransportTCP, TransportRemote:
+	default:
+		return fmt.Errorf("%w: unknown transport %q", ErrInvalidManifest, m.Transport)
+	}
+	for _, p := range m.Permissions {
+		if !knownPermissions[p] {
+			return fmt.Errorf("%w: unknown permission %q", ErrInvalidManifest, p)
+		}
+	}
+	if m.Settings != nil {
+		if err := m.Settings.Check(); err != nil {
+			return fmt.Errorf("%w: settings: %v", ErrInvalidManifest, err)
+		}
+	}
+	if err := m.Sandbox.validate(); err != nil {
+		return fmt.Errorf("%w: sandbox: %v", ErrInvalidManifest, err)
+	}
+	for _, r := range m.Routes {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("%w: route: %v", ErrInvalidManifest, err)
+		}
+	}
+	if len(m.UI.Menu) > 0 && !m.Has(CapabilityUI) {
+		return fmt.Errorf("%w: menu entries require the %s capability", ErrInvalidManifest, CapabilityUI)
+	}
+	if err := m.UI.validate(); err != nil {
+		return fmt.Errorf("%w: ui: %v", ErrInvalidManifest, err)
+	}
+
+	return nil
+}
+
+// Has reports whether the plugin declares a capability
+func (m *Manifest) Has(c Capability) bool {
+	for _, capability := range m.Capabilities {
+		if capability == c {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether the plugin declares a permission
+func (m *Manifest) Allows(p Permission) bool {
+	for _, permission := range m.Permissions {
+		if permission == p {
+			return true
+		}
+	}
+	return false
+}
+
+// ConcurrencyOrDefault returns how many events core may deliver to the plugin at
+// once, which defaults to one
+func (m *Manifest) ConcurrencyOrDefault() int {
+	if m.Concurrency < 1 {
+		return 1
+	}
+	return m.Concurrency
+}
+
+// TransportOrDefault returns the plugin's transport, which defaults to a Unix socket
+func (m *Manifest) TransportOrDefault() string {
+	if m.Transport == "" {
+		return TransportUnix
+	}
+	return m.Transport
+}
+
+// EntrypointPath returns the absolute path of the plugin executable
+func (m *Manifest) EntrypointPath() string {
+	return filepath.Join(m.Dir, m.Entrypoint)
+}
@@ -0,0 +1,145 @@
+package manifest
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadRepoManifests(t *testing.T) {
+	paths, err := filepath.Glob("../../../plugins/*/" + FileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no plugin manifests found")
+	}
+
+	for _, path := range paths {
+		m, err := Load(path)
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		if m.Settings != nil {
+			if err := m.Settings.Validate(m.Settings.Defaults()); err != nil {
+				t.Errorf("%s: defaults do not validate: %v", path, err)
+			}
+		}
+	}
+}
+
+func TestParseRejectsInvalidManifests(t *testing.T) {
+	tests := map[string]string{
+		"missing name":       "version: 1.0.0\nentrypoint: bin\napi_version: 1\ncapabilities: [search]\n",
+		"bad name":           "name: My Plugin\nversion: 1.0.0\nentrypoint: bin\napi_version: 1\ncapabilities: [search]\n",
+		"escaping entry":     "name: p\nversion: 1.0.0\nentrypoint: ../bin\napi_version: 1\ncapabilities: [search]\n",
+		"future api":         "name: p\nversion: 1.0.0\nentrypoint: bin\napi_version: 99\ncapabilities: [search]\n",
+		"unknown capability": "name: p\nversion: 1.0.0\nentrypoint: bin\napi_version: 1\ncapabilities: [teleport]\n",
+		"events without process": "name: p\nversion: 1.0.0\nentrypoint: bin\napi_version: 1\ncapabilities: [search]\n" +
+			"events: [photo.uploaded]\n",
+		"unknown permission": "name: p\nversion: 1.0.0\nentrypoint: bin\napi_version: 1\ncapabilities: [search]\n" +
+			"permissions: [database.write]\n",
+		"wildcard env": "name: p\nversion: 1.0.0\nentrypoint: bin\napi_version: 1\ncapabilities: [search]\n" +
+			"sandbox:\n  env: ['*']\n",
+		"bad route method": "name: p\nversion: 1.0.0\nentrypoint: bin\napi_version: 1\ncapabilities: [ui]\n" +
+			"routes:\n  - {method: TRACE, path: /x}\n",
+		"escaping route": "name: p\nversion: 1.0.0\nentrypoint: bin\napi_version: 1\ncapabilities: [ui]\n" +
+			"routes:\n  - {method: GET, path: /x/../y}\n",
+		"menu without ui": "name: p\nversion: 1.0.0\nentrypoint: bin\napi_version: 1\ncapabilities: [search]\n" +
+			"ui:\n  assets: ui\n  menu:\n    - {label: P, page: index.html}\n",
+		"escaping page": "name: p\nversion: 1.0.0\nentrypoint: bin\napi_version: 1\ncapabilities: [ui]\n" +
+			"ui:\n  assets: ui\n  menu:\n    - {label: P, page: ../index.html}\n",
+		"too concurrent":    "name: p\nversion: 1.0.0\nentrypoint: bin\napi_version: 1\ncapabilities: [process]\nconcurrency: 1000\n",
+		"unknown transport": "name: p\nversion: 1.0.0\nentrypoint: bin\napi_version: 1\ncapabilities: [search]\ntransport: pipe\n",
+		"bad default": "name: p\nversion: 1.0.0\nentrypoint: bin\napi_version: 1\ncapabilities: [ui]\n" +
+			"settings:\n  type: object\n  properties:\n    size:\n      type: integer\n      default: big\n",
+	}
+
+	for name, data := range tests {
+		if _, err := Parse([]byte(data)); !errors.Is(err, ErrInvalidManifest) {
+			t.Errorf("%s: expected ErrInvalidManifest, got %v", name, err)
+		}
+	}
+}
+
+func TestParseRemote(t *testing.T) {
+	m, err := ParseRemote([]byte("name: p\nversion: 1.0.0\napi_version: 1\ncapabilities: [process]\n" +
+		"sandbox:\n  user: nobody\n"))
+	if err != nil {
+		t.Fatalf("ParseRemote: %v", err)
+	}
+	if m.Transport != TransportRemote || m.Sandbox.User != "" {
+		t.Errorf("unexpected remote manifest %+v", m)
+	}
+
+	// Local manifests still need an entrypoint
+	if _, err := Parse([]byte("name: p\nversion: 1.0.0\napi_version: 1\ncapabilities: [process]\n")); !errors.Is(err, ErrInvalidManifest) {
+		t.Errorf("expected ErrInvalidManifest without entrypoint, got %v", err)
+	}
+}
+
+func TestRouteMatches(t *testing.T) {
+	m, err := Parse([]byte("name: p\nversion: 1.0.0\nentrypoint: bin\napi_version: 1\ncapabilities: [ui]\n" +
+		"routes:\n  - {method: GET, path: /faces}\n  - {method: POST, path: /faces/*, admin: true}\n"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	tests := []struct {
+		method, path string
+		found, admin bool
+	}{
+		{"GET", "/faces", true, false},
+		{"GET", "/faces/1", false, false},
+		{"POST", "/faces", true, true},
+		{"POST", "/faces/1/merge", true, true},
+		{"POST", "/facesx", false, false},
+		{"DELETE", "/faces", false, false},
+	}
+	for _, tt := range tests {
+		r, ok := m.Route(tt.method, tt.path)
+		if ok != tt.found || r.Admin != tt.admin {
+			t.Errorf("%s %s: got %+v, %v", tt.method, tt.path, r, ok)
+		}
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	m, err := Parse([]byte(`
+name: p
+version: 1.0.0
+entrypoint: bin
+api_version: 1
+capabilities: [process]
+settings:
+  type: object
+  required: [size]
+  properties:
+    size:
+      type: integer
+      enum: [256, 512]
+    label:
+      type: string
+`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	valid := map[string]interface{}{"size": float64(512), "label": "x"}
+	if err := m.Settings.Validate(valid); err != nil {
+		t.Errorf("expected valid settings, got %v", err)
+	}
+
+	invalid := []map[string]interface{}{
+		{},
+		{"size": float64(300)},
+		{"size": "512"},
+		{"size": float64(512), "unknown": true},
+	}
+	for _, settings := range invalid {
+		if err := m.Settings.Validate(settings); err == nil {
+			t.Errorf("expected %v to be rejected", settings)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package manifest
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// envNameRegex matches an environment variable name, optionally ending in * to allow a prefix
+var envNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*\*?$`)
+
+// Sandbox describes what a plugin process is allowed to see and use
+type Sandbox struct {
+	// Env lists the core environment variables the plugin asks for. A
+	// trailing * asks for every variable with that prefix. Core only passes
+	// the ones its operator allows.
+	Env []string `yaml:"env,omitempty" json:"env,omitempty"`
+
+	// Limits requests resource limits below the ones configured in core
+	Limits Limits `yaml:"limits,omitempty" json:"limits,omitempty"`
+
+	// User and Group run the plugin under another account. They require core to run as root.
+	User  string `yaml:"user,omitempty" json:"user,omitempty"`
+	Group string `yaml:"group,omitempty" json:"group,omitempty"`
+}
+
+// Limits are per-process resource limits. Zero means no limit is requested.
+type Limits struct {
+	MemoryMB   uint64 `yaml:"memory_mb,omitempty" json:"memory_mb,omitempty"`
+	CPUSeconds uint64 `yaml:"cpu_seconds,omitempty" json:"cpu_seconds,omitempty"`
+	OpenFiles  uint64 `yaml:"open_files,omitempty" json:"open_files,omitempty"`
+}
+
+// validate checks the sandbox section of a manifest
+func (s *Sandbox) validate() error {
+	for _, name := range s.Env {
+		if !ValidEnvPattern(name) {
+			return fmt.Errorf("invalid environment variable %q", name)
+		}
+	}
+	return nil
+}
+
+// AllowsEnv reports whether the plugin asks for the environment variable name
+func (s *Sandbox) AllowsEnv(name string) bool {
+	return MatchEnv(s.Env, name)
+}
+
+// ValidEnvPattern reports whether pattern is a variable name, optionally ending in *
+func ValidEnvPattern(pattern string) bool {
+	return envNameRegex.MatchString(pattern)
+}
+
+// MatchEnv reports whether the environment variable name matches one of patterns
+func MatchEnv(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+package manifest
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Schema is the subset of JSON Schema used to describe plugin settings
+type Schema struct {
+	Type        string             `yaml:"type" json:"type"`
+	Description string             `yaml:"description,omitempty" json:"description,omitempty"`
+	Properties  map[string]*Schema `yaml:"properties,omitempty" json:"properties,omitempty"`
+	Required    []string           `yaml:"required,omitempty" json:"required,omitempty"`
+	Items       *Schema            `yaml:"items,omitempty" json:"items,omitempty"`
+	Enum        []interface{}      `yaml:"enum,omitempty" json:"enum,omitempty"`
+	Default     interface{}        `yaml:"default,omitempty" json:"default,omitempty"`
+	Minimum     *float64           `yaml:"minimum,omitempty" json:"minimum,omitempty"`
+	Maximum     *float64           `yaml:"maximum,omitempty" json:"maximum,omitempty"`
+}
+
+// Check validates the schema itself
+func (s *Schema) Check() error {
+	switch s.Type {
+	case "object":
+		for _, name := range s.Required {
+			if _, ok := s.Properties[name]; !ok {
+				return fmt.Errorf("required property %q is not defined", name)
+			}
+		}
+		for name, prop := range s.Properties {
+			if prop == nil {
+				return fmt.Errorf("property %q has no schema", name)
+			}
+			if err := prop.Check(); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	case "array":
+		if s.Items != nil {
+			if err := s.Items.Check(); err != nil {
+				return fmt.Errorf("items: %w", err)
+			}
+		}
+	case "string", "integer", "number", "boolean":
+	default:
+		return fmt.Errorf("unsupported type %q", s.Type)
+	}
+
+	if s.Default != nil {
+		if err := s.Validate(s.Default); err != nil {
+			return fmt.Errorf("default: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Validate checks a JSON-decoded value against the schema
+func (s *Schema) Validate(value interface{}) error {
+	return s.validate("", normalize(value))
+}
+
+// Defaults returns the default value of each top-level property that has one
+func (s *Schema) Defaults() map[string]interface{} {
+	defaults := make(map[string]interface{})
+	for name, prop := range s.Properties {
+		if prop.Default != nil {
+			defaults[name] = normalize(prop.Default)
+		}
+	}
+	return defaults
+}
+
+// validate checks value against the schema, using path in error messages
+func (s *Schema) validate(path string, value interface{}) error {
+	where := path
+	if where == "" {
+		where = "value"
+	}
+
+	switch s.Type {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be an object", where)
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s is required", join(path, name))
+			}
+		}
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop, ok := s.Properties[name]
+			if !ok {
+				return fmt.Errorf("%s is not a known setting", join(path, name))
+			}
+			if err := prop.validate(join(path, name), obj[name]); err != nil {
+				return err
+			}
+		}
+	case "array":
+		arr, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be an array", where)
+		}
+		if s.Items != nil {
+			for i, item := range arr {
+				if err := s.Items.validate(fmt.Sprintf("%s[%d]", where, i), item); err != nil {
+					return err
+				}
+			}
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%s must be a string", where)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s must be a boolean", where)
+		}
+	case "integer", "number":
+		n, ok := value.(float64)
+		if !ok {
+			return fmt.Errorf("%s must be a number", where)
+		}
+		if s.Type == "integer" && n != float64(int64(n)) {
+			return fmt.Errorf("%s must be an integer", where)
+		}
+		if s.Minimum != nil && n < *s.Minimum {
+			return fmt.Errorf("%s must be at least %v", where, *s.Minimum)
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			return fmt.Errorf("%s must be at most %v", where, *s.Maximum)
+		}
+	}
+
+	if len(s.Enum) > 0 {
+		for _, allowed := range s.Enum {
+			if reflect.DeepEqual(normalize(allowed), value) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s must be one of %v", where, s.Enum)
+	}
+
+	return nil
+}
+
+// normalize converts YAML-decoded values to the types produced by encoding/json
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[k] = normalize(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = normalize(item)
+		}
+		return out
+	default:
+		return value
+	}
+}
+
+// join builds a dotted path to a setting
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"pixie/auth"
+	pluginv1 "pixie/gen/plugin/v1"
+	"pixie/plugin/loader"
+	"pixie/plugin/manifest"
+)
+
+// maxPluginRequestBody limits the size of request bodies forwarded to plugins
+const maxPluginRequestBody = 10 << 20
+
+// pluginStrippedHeaders are never exchanged with plugins. Credentials stay with
+// core, and plugins cannot set cookies on the core origin.
+var pluginStrippedHeaders = map[string]bool{
+	"Authorization":     true,
+	"Cookie":            true,
+	"Set-Cookie":        true,
+	"Connection":        true,
+	"Keep-Alive":        true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+	"Content-Length":    true,
+}
+
+// pluginRouteCSP keeps a plugin response from running script on the core origin,
+// even if the user is sent to it directly
+const pluginRouteCSP = "sandbox; default-src 'none'; frame-ancestors 'none'"
+
+// pluginUICSP confines plugin pages to their own assets in an opaque origin, so
+// they cannot read the web UI's storage or call core as the user. They reach
+// their routes through the web UI instead.
+const pluginUICSP = "sandbox allow-scripts allow-forms; default-src 'self'; style-src 'self' 'unsafe-inline'; " +
+	"img-src 'self' data: blob:; connect-src 'none'; form-action 'none'; base-uri 'none'; frame-ancestors 'self'"
+
+// pluginInlineTypes are the response types of plugin routes a browser may
+// display. Anything else is sent as a download.
+var pluginInlineTypes = map[string]bool{
+	"application/json": true,
+	"text/plain":       true,
+	"text/csv":         true,
+	"image/png":        true,
+	"image/jpeg":       true,
+	"image/gif":        true,
+	"image/webp":       true,
+	"image/avif":       true,
+}
+
+// listPluginUIHandler handles listing the UI extensions visible to the user
+func (app *App) listPluginUIHandler(w http.ResponseWriter, r *http.Request) {
+	admin := isAdmin(r)
+
+	extensions := make([]loader.UIExtension, 0)
+	for _, ext := range loader.UIExtensions() {
+		var menu []manifest.MenuEntry
+		for _, entry := range ext.Menu {
+			if !entry.Admin || admin {
+				menu = append(menu, entry)
+			}
+		}
+		if len(menu) > 0 {
+			ext.Menu = menu
+			extensions = append(extensions, ext)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"plugins": extensions,
+	})
+}
+
+// pluginRouteHandler proxies a request to a route declared by a plugin. Reading
+// needs photos:read and anything else photos:write, as plugins act on photos
+// for the user; admin routes also need plugins:admin.
+func (app *App) pluginRouteHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+	routePath := path.Clean("/" + vars["path"])
+
+	customClaims, _ := r.Context().Value("custom_claims").(map[string]interface{})
+	if scope := pluginRouteScope(r.Method); !auth.HasScope(customClaims, scope) {
+		http.Error(w, "Forbidden: the "+scope+" scope is required", http.StatusForbidden)
+		return
+	}
+
+	route, err := loader.MatchRoute(name, r.Method, routePath)
+	if err != nil {
+		writePluginRouteError(w, err)
+		return
+	}
+	if route.Admin && !isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPluginRequestBody))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	headers := make(map[string]string, len(r.Header))
+	for key, values := range r.Header {
+		if !pluginStrippedHeaders[key] {
+			headers[key] = strings.Join(values, ", ")
+		}
+	}
+
+	userID, _ := r.Context().Value("user_id").(string)
+	claims, err := json.Marshal(r.Context().Value("custom_claims"))
+	if err != nil {
+		http.Error(w, "Failed to encode claims", http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := loader.ServeHTTP(r.Context(), name, &pluginv1.HTTPRequest{
+		Method:     r.Method,
+		Path:       routePath,
+		Query:      r.URL.RawQuery,
+		Headers:    headers,
+		Body:       body,
+		UserId:     userID,
+		ClaimsJson: string(claims),
+	})
+	if err != nil {
+		log.Printf("Plugin %s failed to handle %s %s: %v", name, r.Method, routePath, err)
+		writePluginRouteError(w, err)
+		return
+	}
+
+	for key, value := range resp.Headers {
+		key = http.CanonicalHeaderKey(key)
+		if !pluginStrippedHeaders[key] {
+			w.Header().Set(key, value)
+		}
+	}
+	securePluginResponse(w.Header())
+	status := int(resp.Status)
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status < 100 || status > 599 {
+		log.Printf("Plugin %s returned invalid status %d", name, status)
+		http.Error(w, "Plugin returned an invalid response", http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(status)
+	w.Write(resp.Body)
+}
+
+// pluginAssetsHandler serves the static UI assets of a plugin
+func (app *App) pluginAssetsHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	dir, err := loader.AssetsPath(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", pluginUICSP)
+	// Public like the files themselves, so module scripts load in the opaque origin
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	http.StripPrefix("/plugin-ui/"+name, http.FileServer(http.Dir(dir))).ServeHTTP(w, r)
+}
+
+// securePluginResponse stops a plugin response from being rendered as a page on
+// the core origin: types that are not safe to display become downloads
+func securePluginResponse(header http.Header) {
+	header.Set("Content-Security-Policy", pluginRouteCSP)
+	header.Set("X-Content-Type-Options", "nosniff")
+
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || !pluginInlineTypes[mediaType] {
+		// Keep the file name of a download the plugin asked for
+		if disposition, _, err := mime.ParseMediaType(header.Get("Content-Disposition")); err != nil || disposition != "attachment" {
+			header.Set("Content-Disposition", "attachment")
+		}
+	}
+}
+
+// writePluginRouteError maps plugin route errors to HTTP responses
+func writePluginRouteError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, loader.ErrPluginNotFound), errors.Is(err, loader.ErrRouteNotFound):
+		http.Error(w, "Not found", http.StatusNotFound)
+	case errors.Is(err, loader.ErrPluginUnavailable):
+		http.Error(w, "Plugin unavailable", http.StatusServiceUnavailable)
+	default:
+		http.Error(w, "Plugin request failed", http.StatusBadGateway)
+	}
+}
+
+// pluginRouteScope returns the scope a request to a plugin route needs
+func pluginRouteScope(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return auth.ScopePhotosRead
+	}
+	return auth.ScopePhotosWrite
+}
+
+// isAdmin reports whether the request may use the admin routes of plugins
+func isAdmin(r *http.Request) bool {
+	customClaims, _ := r.Context().Value("custom_claims").(map[string]interface{})
+	return auth.HasScope(customClaims, auth.ScopePluginsAdmin)
+}
@@ -1,3 +1,7 @@
+//go:build ignore
+
+// Manual check of the plugin loader, not a test; excluded so the package tests build
+
 package main
 
 import (
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"pixie/auth"
+	"pixie/user"
+)
+
+// listRolesHandler handles listing the roles and the scopes they may grant
+func (app *App) listRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.UserMgr.ListRoles(r.Context())
+	if err != nil {
+		log.Printf("Failed to list roles: %v", err)
+		http.Error(w, "Failed to list roles", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"roles":  roles,
+		"scopes": auth.Scopes,
+	})
+}
+
+// createRoleHandler handles creating a custom role
+func (app *App) createRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var req user.CreateRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	role, err := app.UserMgr.CreateRole(r.Context(), req)
+	if err != nil {
+		writeRoleError(w, "create", err)
+		return
+	}
+	log.Printf("Created role %s with permissions %v", role.Name, role.Permissions)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(role)
+}
+
+// updateRoleHandler handles updating a custom role
+func (app *App) updateRoleHandler(w http.ResponseWriter, r *http.Request) {
+	name := user.Role(mux.Vars(r)["name"])
+
+	var req user.UpdateRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	role, err := app.UserMgr.UpdateRole(r.Context(), name, req)
+	if err != nil {
+		writeRoleError(w, "update", err)
+		return
+	}
+	log.Printf("Updated role %s with permissions %v", role.Name, role.Permissions)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(role)
+}
+
+// deleteRoleHandler handles deleting a custom role
+func (app *App) deleteRoleHandler(w http.ResponseWriter, r *http.Request) {
+	name := user.Role(mux.Vars(r)["name"])
+
+	if err := app.UserMgr.DeleteRole(r.Context(), name); err != nil {
+		writeRoleError(w, "delete", err)
+		return
+	}
+	log.Printf("Deleted role %s", name)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeRoleError responds to a failed change of a role
+func writeRoleError(w http.ResponseWriter, action string, err error) {
+	switch {
+	case errors.Is(err, user.ErrRoleNotFound):
+		http.Error(w, "Role not found", http.StatusNotFound)
+	case errors.Is(err, user.ErrRoleExists):
+		http.Error(w, "Role already exists", http.StatusConflict)
+	case errors.Is(err, user.ErrRoleInUse):
+		http.Error(w, "Role is still assigned to users", http.StatusConflict)
+	case errors.Is(err, user.ErrInvalidRole):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("Failed to %s role: %v", action, err)
+		http.Error(w, "Failed to "+action+" role", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"pixie/auth"
+	"pixie/db"
+	"pixie/storage"
+)
+
+// testRoles grants the scopes of the built-in roles
+type testRoles struct{}
+
+func (testRoles) RolePermissions(ctx context.Context, role string) ([]string, error) {
+	switch role {
+	case "admin":
+		return auth.Scopes, nil
+	case "user":
+		return []string{auth.ScopePhotosRead, auth.ScopePhotosWrite}, nil
+	case "editor":
+		return []string{auth.ScopePhotosRead, auth.ScopePhotosWrite, auth.ScopePhotosDelete}, nil
+	case "viewer":
+		return []string{auth.ScopePhotosRead}, nil
+	}
+	return nil, nil
+}
+
+// testAPIKeys is an in-memory auth.APIKeyStore whose keys belong to users
+// with the role in roles
+type testAPIKeys struct {
+	mu    sync.Mutex
+	keys  map[string]*db.APIKey
+	roles map[string]string
+}
+
+func (s *testAPIKeys) CreateAPIKey(ctx context.Context, k *db.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := *k
+	s.keys[k.ID] = &c
+	return nil
+}
+
+func (s *testAPIKeys) GetAPIKeyByHash(ctx context.Context, hash string) (*db.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range s.keys {
+		if k.Hash == hash {
+			c := *k
+			c.Role = s.roles[k.UserID]
+			c.UserActive = true
+			return &c, nil
+		}
+	}
+	return nil, db.ErrAPIKeyNotFound
+}
+
+func (s *testAPIKeys) ListAPIKeys(ctx context.Context, userID string) ([]db.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []db.APIKey{}
+	for _, k := range s.keys {
+		if k.UserID == userID {
+			keys = append(keys, *k)
+		}
+	}
+	return keys, nil
+}
+
+func (s *testAPIKeys) DeleteAPIKey(ctx context.Context, userID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if k, ok := s.keys[id]; !ok || k.UserID != userID {
+		return db.ErrAPIKeyNotFound
+	}
+	delete(s.keys, id)
+	return nil
+}
+
+func (s *testAPIKeys) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	return nil
+}
+
+// testApp is an App on in-memory stores, with its users' roles
+type testApp struct {
+	*App
+	db      *db.MockDB
+	storage *storage.MockS3
+	keys    *testAPIKeys
+	router  http.Handler
+}
+
+func newTestApp(t *testing.T) *testApp {
+	t.Helper()
+	authService, err := auth.NewService(auth.Config{
+		JWTAlgo:         "HS256",
+		JWTSecret:       "test-secret",
+		TokenExpiration: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := &testAPIKeys{keys: map[string]*db.APIKey{}, roles: map[string]string{}}
+	authService.SetAPIKeyStore(keys)
+	authService.SetRoleStore(testRoles{})
+
+	ta := &testApp{db: db.NewMock(), storage: storage.NewMock(), keys: keys}
+	ta.App = &App{DB: ta.db, Storage: ta.storage, Auth: authService}
+	ta.router = ta.routes()
+	return ta
+}
+
+// token returns an access token of a user with a role, as a login issues
+func (ta *testApp) token(t *testing.T, userID, role string) string {
+	t.Helper()
+	ta.keys.roles[userID] = role
+	token, err := ta.Auth.GenerateToken(userID, map[string]interface{}{"role": role})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// apiKey returns the secret of an API key of a user with a role
+func (ta *testApp) apiKey(t *testing.T, userID, role string, scopes ...string) string {
+	t.Helper()
+	ta.keys.roles[userID] = role
+	_, secret, err := ta.Auth.CreateAPIKey(context.Background(), userID, "test", scopes, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+// do sends a request with a bearer token, if any, and returns the response
+func (ta *testApp) do(method, path, token, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	ta.router.ServeHTTP(w, r)
+	return w
+}
+
+func TestAPIKeysCannotManageKeysOrSessions(t *testing.T) {
+	ta := newTestApp(t)
+	key := ta.apiKey(t, "alice", "admin", auth.ScopeAdmin)
+
+	tests := []struct {
+		method, path, body string
+	}{
+		{"POST", "/api/auth/keys", `{"name": "forever", "scopes": ["photos:read"]}`},
+		{"GET", "/api/auth/keys", ""},
+		{"DELETE", "/api/auth/keys/some-key", ""},
+		{"GET", "/api/auth/sessions", ""},
+		{"DELETE", "/api/auth/sessions/some-session", ""},
+	}
+	for _, tt := range tests {
+		if w := ta.do(tt.method, tt.path, key, tt.body); w.Code != http.StatusForbidden {
+			t.Errorf("%s %s with an API key: expected 403, got %d", tt.method, tt.path, w.Code)
+		}
+	}
+	if n := len(ta.keys.keys); n != 1 {
+		t.Errorf("expected no new key, got %d keys", n)
+	}
+
+	// A login session can create keys
+	w := ta.do("POST", "/api/auth/keys", ta.token(t, "alice", "admin"), `{"name": "backup", "scopes": ["photos:read"]}`)
+	if w.Code != http.StatusCreated {
+		t.Errorf("expected 201, got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestCreateAPIKeyRejectsAPIKeys(t *testing.T) {
+	ta := newTestApp(t)
+	key := ta.apiKey(t, "alice", "user", auth.ScopePhotosRead)
+	userID, claims, err := ta.Auth.Authenticate(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The handler refuses keys even if a route forgets the middleware
+	r := httptest.NewRequest("POST", "/api/auth/keys", bytes.NewBufferString(`{"name": "forever"}`))
+	ctx := context.WithValue(r.Context(), "user_id", userID)
+	ctx = context.WithValue(ctx, "custom_claims", claims)
+	w := httptest.NewRecorder()
+	ta.createAPIKeyHandler(w, r.WithContext(ctx))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected 403, got %d", w.Code)
+	}
+}
+
+func TestEmptyTrashKeepsOtherUsersTrash(t *testing.T) {
+	ta := newTestApp(t)
+	ctx := context.Background()
+	for _, owner := range []string{"alice", "bob"} {
+		if err := ta.db.SavePhoto(ctx, owner+"-photo", "photos/"+owner, owner+".jpg", "image/jpeg", owner, 100, 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := ta.db.TrashPhoto(ctx, owner+"-photo"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Users without admin only see and empty their own trash
+	alice := ta.token(t, "alice", "editor")
+	trashed := func(token string) []string {
+		t.Helper()
+		w := ta.do("GET", "/api/photos/trash", token, "")
+		var resp struct {
+			Photos []db.Photo `json:"photos"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode the trash: %v", err)
+		}
+		var ids []string
+		for _, p := range resp.Photos {
+			ids = append(ids, p.ID)
+		}
+		return ids
+	}
+	if ids := trashed(alice); len(ids) != 1 || ids[0] != "alice-photo" {
+		t.Errorf("expected only the photo of alice in her trash, got %v", ids)
+	}
+	if w := ta.do("DELETE", "/api/photos/trash", alice, ""); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if _, err := ta.db.PhotoOwner(ctx, "bob-photo"); err != nil {
+		t.Errorf("expected the trash of bob to survive: %v", err)
+	}
+	if _, err := ta.db.PhotoOwner(ctx, "alice-photo"); err == nil {
+		t.Error("expected the trash of alice to be emptied")
+	}
+
+	// Admins see the trash of everyone
+	if ids := trashed(ta.token(t, "root", "admin")); len(ids) != 1 || ids[0] != "bob-photo" {
+		t.Errorf("expected the photo of bob in the trash, got %v", ids)
+	}
+}
+
+func TestPluginRoutesNeedPhotoScopes(t *testing.T) {
+	ta := newTestApp(t)
+	viewer := ta.token(t, "vera", "viewer")
+	user := ta.token(t, "alice", "user")
+
+	tests := []struct {
+		method string
+		token  string
+		want   int
+	}{
+		{"GET", viewer, http.StatusNotFound},
+		{"POST", viewer, http.StatusForbidden},
+		{"DELETE", viewer, http.StatusForbidden},
+		{"POST", user, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		// No plugin is loaded, so allowed requests find no route
+		if w := ta.do(tt.method, "/api/plugins/faces/people/1", tt.token, ""); w.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.method, tt.want, w.Code)
+		}
+	}
+}
+
+func TestEventsHealthNeedsPluginsAdmin(t *testing.T) {
+	ta := newTestApp(t)
+
+	if w := ta.do("GET", "/api/events/health", "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("without a token: expected 401, got %d", w.Code)
+	}
+	if w := ta.do("GET", "/api/events/health", ta.token(t, "alice", "user"), ""); w.Code != http.StatusForbidden {
+		t.Errorf("as a user: expected 403, got %d", w.Code)
+	}
+	if w := ta.do("GET", "/api/events/health", ta.token(t, "root", "admin"), ""); w.Code != http.StatusOK {
+		t.Errorf("as an admin: expected 200, got %d: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"pixie/auth"
+	"pixie/db"
+	"pixie/user"
+)
+
+// maxDeviceLength limits how much of the User-Agent is stored with a session
+const maxDeviceLength = 256
+
+// RefreshRequest represents a request to exchange a refresh token
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// sessionInfo is a session as listed to its user
+type sessionInfo struct {
+	db.Session
+	Current bool `json:"current"`
+}
+
+// sessionTokens issues an access token for a session and returns it with the
+// session's new refresh token
+func (app *App) sessionTokens(ctx context.Context, u *user.User, session *db.Session, refreshToken string) (map[string]interface{}, error) {
+	// The token carries the permissions of the role, which clients use to show
+	// what the user may do
+	scopes, err := app.UserMgr.RolePermissions(ctx, string(u.Role))
+	if err != nil {
+		return nil, err
+	}
+
+	customClaims := map[string]interface{}{
+		"role":            u.Role,
+		"username":        u.Username,
+		"full_name":       u.FullName,
+		auth.SessionClaim: session.ID,
+		auth.ScopesClaim:  scopes,
+	}
+
+	// Generate the token using the user ID as the subject
+	token, err := app.Auth.GenerateToken(u.ID, customClaims)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"token":         token,
+		"token_type":    "Bearer",
+		"expires_in":    int(app.Auth.TokenExpiration().Seconds()),
+		"refresh_token": refreshToken,
+		"session_id":    session.ID,
+	}, nil
+}
+
+// refreshHandler handles the /auth/refresh endpoint
+func (app *App) refreshHandler(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.RefreshToken == "" {
+		http.Error(w, "Refresh token is required", http.StatusBadRequest)
+		return
+	}
+
+	session, refreshToken, err := app.Auth.Refresh(r.Context(), req.RefreshToken, clientIP(r))
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidRefreshToken) || errors.Is(err, auth.ErrRefreshTokenReused) {
+			log.Printf("Refresh failed: %v", err)
+			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		} else {
+			log.Printf("Failed to refresh session: %v", err)
+			http.Error(w, "Failed to refresh session", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// The user may have been deactivated or changed role since the last refresh
+	u, err := app.UserMgr.GetUser(r.Context(), session.UserID)
+	if err != nil || !u.Active {
+		if err := app.Auth.RevokeSession(r.Context(), session.UserID, session.ID); err != nil {
+			log.Printf("Failed to revoke session %s: %v", session.ID, err)
+		}
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	resp, err := app.sessionTokens(r.Context(), u, session, refreshToken)
+	if err != nil {
+		log.Printf("Failed to generate token: %v", err)
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// listSessionsHandler handles listing the sessions of the current user
+func (app *App) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value("user_id").(string)
+	customClaims, _ := r.Context().Value("custom_claims").(map[string]interface{})
+	currentID, _ := customClaims[auth.SessionClaim].(string)
+
+	sessions, err := app.Auth.ListSessions(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to list sessions: %v", err)
+		http.Error(w, "Failed to list sessions", http.StatusInternalServerError)
+		return
+	}
+
+	infos := make([]sessionInfo, 0, len(sessions))
+	for _, s := range sessions {
+		infos = append(infos, sessionInfo{Session: s, Current: s.ID == currentID})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sessions": infos,
+	})
+}
+
+// revokeSessionHandler handles revoking a session of the current user
+func (app *App) revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value("user_id").(string)
+	id := mux.Vars(r)["id"]
+
+	if err := app.Auth.RevokeSession(r.Context(), userID, id); err != nil {
+		if errors.Is(err, db.ErrSessionNotFound) {
+			http.Error(w, "Session not found", http.StatusNotFound)
+		} else {
+			log.Printf("Failed to revoke session: %v", err)
+			http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// logoutUserHandler handles logging a user out on all devices (admin only)
+func (app *App) logoutUserHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	if _, err := app.UserMgr.GetUser(r.Context(), id); err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	revoked, err := app.Auth.RevokeUserSessions(r.Context(), id)
+	if err != nil {
+		log.Printf("Failed to log out user %s: %v", id, err)
+		http.Error(w, "Failed to log out user", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Logged out user %s everywhere, revoked %d sessions", id, revoked)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"revoked": revoked,
+	})
+}
+
+// revokeUserSessions ends all sessions of a user after an account change, such
+// as deactivation, without failing the request
+func (app *App) revokeUserSessions(r *http.Request, userID string) {
+	if _, err := app.Auth.RevokeUserSessions(r.Context(), userID); err != nil {
+		log.Printf("Failed to revoke sessions of user %s: %v", userID, err)
+	}
+}
+
+// clientIP returns the address a request came from
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientDevice describes the device a request came from
+func clientDevice(r *http.Request) string {
+	device := r.UserAgent()
+	if len(device) > maxDeviceLength {
+		device = device[:maxDeviceLength]
+	}
+	return device
+}
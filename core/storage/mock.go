@@ -71,6 +71,18 @@ func (m *MockS3) GetObject(ctx context.Context, key string) (*s3.GetObjectOutput
 	return output, nil
 }
 
+// ObjectSize returns the size of an object in the mock S3
+func (m *MockS3) ObjectSize(ctx context.Context, key string) (int64, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	content, ok := m.objects[key]
+	if !ok {
+		return 0, fmt.Errorf("object not found: %s", key)
+	}
+	return int64(len(content)), nil
+}
+
 // DeleteObject deletes an object from the mock S3
 func (m *MockS3) DeleteObject(ctx context.Context, key string) error {
 	m.mutex.Lock()
@@ -130,6 +130,19 @@ func (s *S3) GetObject(ctx context.Context, key string) (*s3.GetObjectOutput, er
 	return result, nil
 }
 
+// ObjectSize returns the size of an object in S3 without downloading it
+func (s *S3) ObjectSize(ctx context.Context, key string) (int64, error) {
+	result, err := s.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.BucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get object size: %w", err)
+	}
+
+	return aws.ToInt64(result.ContentLength), nil
+}
+
 // DeleteObject deletes an object from S3
 func (s *S3) DeleteObject(ctx context.Context, key string) error {
 	_, err := s.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
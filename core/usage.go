@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"pixie/db"
+	"pixie/user"
+)
+
+// usageReportEntry is the storage used by one owner in the admin report
+type usageReportEntry struct {
+	OwnerID  string   `json:"owner_id"`
+	Username string   `json:"username,omitempty"`
+	Quota    int64    `json:"quota"`
+	Usage    db.Usage `json:"usage"`
+}
+
+// checkUploadQuota answers an upload that cannot fit the quota of its owner and
+// returns the quota otherwise. A file larger than the whole quota is rejected
+// with 413, one that does not fit what is left with 507. Owners that are not
+// users, such as the subjects of admin-issued tokens, have no quota.
+func (app *App) checkUploadQuota(ctx context.Context, w http.ResponseWriter, ownerID string, size int64) (int64, bool) {
+	quota, err := app.UserMgr.StorageQuota(ctx, ownerID)
+	if errors.Is(err, user.ErrUserNotFound) {
+		return 0, true
+	}
+	if err != nil {
+		log.Printf("Failed to get storage quota: %v", err)
+		http.Error(w, "Failed to check storage quota", http.StatusInternalServerError)
+		return 0, false
+	}
+	if quota == 0 {
+		return 0, true
+	}
+
+	if size > quota {
+		http.Error(w, "File is larger than your storage quota", http.StatusRequestEntityTooLarge)
+		return 0, false
+	}
+	usage, err := app.DB.GetUsage(ctx, ownerID)
+	if err != nil {
+		log.Printf("Failed to get storage usage: %v", err)
+		http.Error(w, "Failed to check storage quota", http.StatusInternalServerError)
+		return 0, false
+	}
+	if usage.Total.Bytes+size > quota {
+		http.Error(w, "Storage quota exceeded", http.StatusInsufficientStorage)
+		return 0, false
+	}
+
+	return quota, true
+}
+
+// deleteDerivatives deletes the stored derivatives of a photo about to be
+// purged, logging the ones that fail
+func (app *App) deleteDerivatives(ctx context.Context, photoID string) {
+	keys, err := app.DB.DerivativeKeys(ctx, photoID)
+	if err != nil {
+		log.Printf("Failed to list derivatives of photo %s: %v", photoID, err)
+		return
+	}
+	for _, key := range keys {
+		if err := app.Storage.DeleteObject(ctx, key); err != nil {
+			log.Printf("Failed to delete derivative S3 object %s: %v", key, err)
+		}
+	}
+}
+
+// meUsageHandler handles the current user getting the storage they use and
+// their quota
+func (app *App) meUsageHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value("user_id").(string)
+
+	usage, err := app.DB.GetUsage(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to get storage usage: %v", err)
+		http.Error(w, "Failed to get storage usage", http.StatusInternalServerError)
+		return
+	}
+	quota, err := app.UserMgr.StorageQuota(r.Context(), userID)
+	if err != nil && !errors.Is(err, user.ErrUserNotFound) {
+		log.Printf("Failed to get storage quota: %v", err)
+		http.Error(w, "Failed to get storage usage", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"usage": usage,
+		"quota": quota,
+	})
+}
+
+// usageReportHandler handles an admin getting the storage used by every user
+// and by owners that are not users
+func (app *App) usageReportHandler(w http.ResponseWriter, r *http.Request) {
+	report, err := app.DB.ListUsage(r.Context())
+	if err != nil {
+		log.Printf("Failed to list storage usage: %v", err)
+		http.Error(w, "Failed to get storage usage", http.StatusInternalServerError)
+		return
+	}
+	users, err := app.UserMgr.ListUsers(r.Context())
+	if err != nil {
+		log.Printf("Failed to list users: %v", err)
+		http.Error(w, "Failed to get storage usage", http.StatusInternalServerError)
+		return
+	}
+
+	usersByID := make(map[string]*user.User, len(users))
+	for _, u := range users {
+		usersByID[u.ID] = u
+	}
+
+	// Owners come largest first, then the users who store nothing
+	entries := make([]usageReportEntry, 0, len(report)+len(users))
+	var total db.UsageCount
+	for _, usage := range report {
+		entry := usageReportEntry{OwnerID: usage.OwnerID, Usage: usage}
+		if u, ok := usersByID[usage.OwnerID]; ok {
+			entry.Username = u.Username
+			entry.Quota = u.StorageQuota
+			delete(usersByID, usage.OwnerID)
+		}
+		entries = append(entries, entry)
+		total.Bytes += usage.Total.Bytes
+		total.Objects += usage.Total.Objects
+	}
+	for _, u := range users {
+		if _, ok := usersByID[u.ID]; ok {
+			entries = append(entries, usageReportEntry{
+				OwnerID:  u.ID,
+				Username: u.Username,
+				Quota:    u.StorageQuota,
+				Usage:    db.Usage{OwnerID: u.ID},
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"owners": entries,
+		"total":  total,
+	})
+}
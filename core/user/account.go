@@ -0,0 +1,302 @@
+package user
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrInvalidLink is returned when a password reset or email verification
+	// link is malformed, expired or already used
+	ErrInvalidLink = errors.New("invalid or expired link")
+
+	// ErrInvalidEmail is returned when an email address cannot be parsed
+	ErrInvalidEmail = errors.New("invalid email address")
+
+	// ErrEmailTaken is returned when an email address belongs to another user
+	ErrEmailTaken = errors.New("email address is already in use")
+
+	// ErrNoLinkKey is returned when links are signed before SetLinkKey
+	ErrNoLinkKey = errors.New("no link signing key configured")
+)
+
+const (
+	// PasswordResetTTL is how long a password reset link is valid
+	PasswordResetTTL = time.Hour
+
+	// EmailVerificationTTL is how long an email verification link is valid
+	EmailVerificationTTL = 24 * time.Hour
+)
+
+// Purposes of signed links
+const (
+	linkPasswordReset = "password_reset"
+	linkEmailChange   = "email_change"
+)
+
+// SetLinkKey sets the key that signs password reset and email verification
+// links. Replicas must share it for links to work on all of them.
+func (m *Manager) SetLinkKey(key []byte) {
+	m.linkKey = key
+}
+
+// ChangePassword replaces the password of a user who knows the current one. A
+// wrong current password counts as a failed login.
+func (m *Manager) ChangePassword(ctx context.Context, id, current, password string) (*User, error) {
+	u, err := m.GetUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockedError(u.LockedUntil); err != nil {
+		return nil, err
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(current)) != nil {
+		if err := m.recordLoginFailure(ctx, u.ID); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidCredentials
+	}
+	if err := validatePassword(password); err != nil {
+		return nil, err
+	}
+	if current == password {
+		return nil, ErrPasswordReused
+	}
+
+	if err := m.setPassword(ctx, u, password); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// PasswordResetToken returns a signed token that lets whoever holds it choose a
+// new password for a user. It stops working once the password changes, so it
+// can be used once.
+func (m *Manager) PasswordResetToken(ctx context.Context, id string) (string, time.Time, error) {
+	u, err := m.GetUser(ctx, id)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiresAt := time.Now().Add(PasswordResetTTL)
+	token, err := m.signLink(linkPasswordReset, u.ID, "", expiresAt, u.PasswordHash)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// ResetPassword sets a new password with a password reset token and unlocks the
+// account
+func (m *Manager) ResetPassword(ctx context.Context, token, password string) (*User, error) {
+	link, err := parseLink(token, linkPasswordReset)
+	if err != nil {
+		return nil, err
+	}
+	u, err := m.GetUser(ctx, link.userID)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, ErrInvalidLink
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := m.verifyLink(link, u.PasswordHash); err != nil {
+		return nil, err
+	}
+	if err := validatePassword(password); err != nil {
+		return nil, err
+	}
+
+	if err := m.setPassword(ctx, u, password); err != nil {
+		return nil, err
+	}
+	if err := m.UnlockUser(ctx, u.ID); err != nil {
+		return nil, err
+	}
+	u.LockedUntil = nil
+	return u, nil
+}
+
+// EmailChangeToken returns a signed token that changes the email address of a
+// user to email once it is verified, and the address in canonical form
+func (m *Manager) EmailChangeToken(ctx context.Context, id, email string) (string, string, time.Time, error) {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	if email == "" {
+		return "", "", time.Time{}, ErrInvalidEmail
+	}
+
+	u, err := m.GetUser(ctx, id)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	if err := m.checkEmailFree(ctx, u.ID, email); err != nil {
+		return "", "", time.Time{}, err
+	}
+
+	// The token is bound to the current address, so it cannot be replayed after
+	// another change
+	expiresAt := time.Now().Add(EmailVerificationTTL)
+	token, err := m.signLink(linkEmailChange, u.ID, email, expiresAt, u.Email)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	return token, email, expiresAt, nil
+}
+
+// VerifyEmail sets the email address an email change token was issued for
+func (m *Manager) VerifyEmail(ctx context.Context, token string) (*User, error) {
+	link, err := parseLink(token, linkEmailChange)
+	if err != nil {
+		return nil, err
+	}
+	u, err := m.GetUser(ctx, link.userID)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, ErrInvalidLink
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := m.verifyLink(link, u.Email); err != nil {
+		return nil, err
+	}
+
+	// The address may have been taken since the link was sent
+	if err := m.checkEmailFree(ctx, u.ID, link.value); err != nil {
+		return nil, err
+	}
+	if _, err := m.pool.Exec(ctx, `UPDATE users SET email = $1 WHERE id = $2`, link.value, u.ID); err != nil {
+		return nil, fmt.Errorf("failed to update email: %w", err)
+	}
+
+	u.Email = link.value
+	return u, nil
+}
+
+// normalizeEmail returns an email address without a display name, or "" for
+// none
+func normalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return "", nil
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Name != "" {
+		return "", ErrInvalidEmail
+	}
+
+	return addr.Address, nil
+}
+
+// checkEmailFree returns ErrEmailTaken if another user has an email address
+func (m *Manager) checkEmailFree(ctx context.Context, userID, email string) error {
+	var count int
+	err := m.pool.QueryRow(ctx, `
+		SELECT COUNT(*) FROM users WHERE LOWER(email) = LOWER($1) AND id <> $2
+	`, email, userID).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to check email: %w", err)
+	}
+	if count > 0 {
+		return ErrEmailTaken
+	}
+
+	return nil
+}
+
+// signedLink is the content of a signed link token
+type signedLink struct {
+	payload   string
+	signature []byte
+	userID    string
+	value     string
+	expiresAt time.Time
+}
+
+// signLink signs a token for a purpose, a user and an optional value. The
+// signature also covers binding, a piece of the user's state that the token is
+// no longer valid without, but which is not part of the token.
+func (m *Manager) signLink(purpose, userID, value string, expiresAt time.Time, binding string) (string, error) {
+	if len(m.linkKey) == 0 {
+		return "", ErrNoLinkKey
+	}
+
+	payload := strings.Join([]string{purpose, userID, value, strconv.FormatInt(expiresAt.Unix(), 10)}, "\x00")
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(m.linkMAC(encoded, binding)), nil
+}
+
+// linkMAC computes the signature of an encoded link payload
+func (m *Manager) linkMAC(encoded, binding string) []byte {
+	mac := hmac.New(sha256.New, m.linkKey)
+	mac.Write([]byte(encoded))
+	mac.Write([]byte{0})
+	mac.Write([]byte(binding))
+	return mac.Sum(nil)
+}
+
+// parseLink decodes a token of a purpose without checking its signature, which
+// needs the user's state
+func parseLink(token, purpose string) (*signedLink, error) {
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidLink
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidLink
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return nil, ErrInvalidLink
+	}
+
+	parts := strings.Split(string(payload), "\x00")
+	if len(parts) != 4 || parts[0] != purpose {
+		return nil, ErrInvalidLink
+	}
+	if _, err := uuid.Parse(parts[1]); err != nil {
+		return nil, ErrInvalidLink
+	}
+	expires, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidLink
+	}
+
+	return &signedLink{
+		payload:   encoded,
+		signature: signature,
+		userID:    parts[1],
+		value:     parts[2],
+		expiresAt: time.Unix(expires, 0),
+	}, nil
+}
+
+// verifyLink checks the signature and expiry of a parsed token
+func (m *Manager) verifyLink(link *signedLink, binding string) error {
+	if len(m.linkKey) == 0 {
+		return ErrNoLinkKey
+	}
+	if !hmac.Equal(link.signature, m.linkMAC(link.payload, binding)) {
+		return ErrInvalidLink
+	}
+	if time.Now().After(link.expiresAt) {
+		return ErrInvalidLink
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+)
+
+// AdminReset is the outcome of ResetAdmin
+type AdminReset struct {
+	User *User
+
+	// Password is the one-time password of the admin, which must be changed at
+	// the next login
+	Password string
+
+	// Created is set if the user did not exist before
+	Created bool
+}
+
+// ResetAdmin gives a user the admin role and a random one-time password, for
+// regaining access to a server whose admins are locked out. The user is created
+// if it does not exist; otherwise it is reactivated, unlocked and its second
+// factors are removed. Other admins are left alone.
+//
+// It is meant for the admin reset command, which runs next to the database,
+// and is not reachable over HTTP.
+func (m *Manager) ResetAdmin(ctx context.Context, username string) (*AdminReset, error) {
+	password, err := generatePassword()
+	if err != nil {
+		return nil, err
+	}
+	passwordHash, err := hashPassword(password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	u, err := m.GetUserByUsername(ctx, username)
+	if errors.Is(err, ErrUserNotFound) {
+		u, err := m.insertAdmin(ctx, username, passwordHash)
+		if err != nil {
+			return nil, err
+		}
+		return &AdminReset{User: u, Password: password, Created: true}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = m.pool.Exec(ctx, `
+		UPDATE users SET
+			password_hash = $1, role = $2, active = TRUE, must_change_password = TRUE,
+			failed_logins = 0, locked_until = NULL
+		WHERE id = $3
+	`, passwordHash, RoleAdmin, u.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reset admin: %w", err)
+	}
+	if err := m.ResetMFA(ctx, u.ID); err != nil {
+		return nil, err
+	}
+
+	u.PasswordHash = passwordHash
+	u.Role = RoleAdmin
+	u.Active = true
+	u.MustChangePassword = true
+	u.LockedUntil = nil
+	return &AdminReset{User: u, Password: password}, nil
+}
+
+// createInitialAdmin creates the admin of a new installation with a random
+// password that is shown once in the log and must be changed at first login
+func (m *Manager) createInitialAdmin(ctx context.Context) error {
+	password, err := generatePassword()
+	if err != nil {
+		return err
+	}
+	passwordHash, err := hashPassword(password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	if _, err := m.insertAdmin(ctx, "admin", passwordHash); err != nil {
+		return err
+	}
+
+	log.Println("===================================================================")
+	log.Printf("Created the initial admin user: username=admin, password=%s", password)
+	log.Println("The password must be changed at the first login.")
+	log.Println("===================================================================")
+	return nil
+}
+
+// insertAdmin creates an admin who must change their password
+func (m *Manager) insertAdmin(ctx context.Context, username, passwordHash string) (*User, error) {
+	u := &User{
+		ID:                 uuid.New().String(),
+		Username:           username,
+		PasswordHash:       passwordHash,
+		Role:               RoleAdmin,
+		Active:             true,
+		MustChangePassword: true,
+	}
+
+	err := m.pool.QueryRow(ctx, `
+		INSERT INTO users (id, username, password_hash, role, active, must_change_password)
+		VALUES ($1, $2, $3, $4, TRUE, TRUE)
+		RETURNING created_at
+	`, u.ID, u.Username, u.PasswordHash, u.Role).Scan(&u.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create admin user: %w", err)
+	}
+
+	return u, nil
+}
@@ -0,0 +1,111 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestInitialAdminMustChangePassword(t *testing.T) {
+	m := newTestManager(t)
+	ctx := context.Background()
+
+	admin, err := m.GetUserByUsername(ctx, "admin")
+	if err != nil {
+		t.Fatalf("expected the initial admin to be created: %v", err)
+	}
+	if admin.Role != RoleAdmin || !admin.Active || !admin.MustChangePassword {
+		t.Errorf("expected an active admin who must change their password, got %+v", admin)
+	}
+
+	// A second start does not create another admin
+	if err := m.InitSchema(ctx); err != nil {
+		t.Fatalf("InitSchema: %v", err)
+	}
+	users, err := m.ListUsers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 {
+		t.Errorf("expected 1 user, got %d", len(users))
+	}
+}
+
+func TestResetAdminCreatesUser(t *testing.T) {
+	m := newTestManager(t)
+	ctx := context.Background()
+
+	reset, err := m.ResetAdmin(ctx, "root")
+	if err != nil {
+		t.Fatalf("ResetAdmin: %v", err)
+	}
+	if !reset.Created || reset.User.Username != "root" || reset.User.Role != RoleAdmin || !reset.User.MustChangePassword {
+		t.Errorf("unexpected reset %+v", reset.User)
+	}
+	if len(reset.Password) < MinPasswordLength {
+		t.Errorf("expected a password of at least %d characters, got %q", MinPasswordLength, reset.Password)
+	}
+
+	u, err := m.Authenticate(ctx, "root", reset.Password)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if !u.MustChangePassword {
+		t.Error("expected the one-time password to have to be changed")
+	}
+}
+
+func TestResetAdminRestoresAccess(t *testing.T) {
+	m := newTestManager(t)
+	ctx := context.Background()
+	alice := createTestUser(t, m, "alice", "alice@example.com", RoleViewer)
+	enableTOTP(t, m, alice.ID)
+	if _, err := m.pool.Exec(ctx, `
+		UPDATE users SET active = FALSE, failed_logins = 3, locked_until = $1 WHERE id = $2
+	`, time.Now().Add(time.Hour), alice.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	reset, err := m.ResetAdmin(ctx, "alice")
+	if err != nil {
+		t.Fatalf("ResetAdmin: %v", err)
+	}
+	if reset.Created || reset.User.ID != alice.ID {
+		t.Fatalf("expected alice to be reset, got %+v", reset.User)
+	}
+
+	u, err := m.GetUser(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Role != RoleAdmin || !u.Active || u.LockedUntil != nil || !u.MustChangePassword {
+		t.Errorf("expected an active, unlocked admin who must change their password, got %+v", u)
+	}
+	status, err := m.MFAStatus(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Enabled() || status.RecoveryCodes != 0 {
+		t.Errorf("expected the second factors to be removed, got %+v", status)
+	}
+
+	if _, err := m.Authenticate(ctx, "alice", "correct horse battery"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("expected the old password to stop working, got %v", err)
+	}
+	if _, err := m.Authenticate(ctx, "alice", reset.Password); err != nil {
+		t.Errorf("Authenticate with the one-time password: %v", err)
+	}
+
+	// Each reset makes a new password
+	again, err := m.ResetAdmin(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Password == reset.Password {
+		t.Error("expected a new one-time password")
+	}
+	if _, err := m.Authenticate(ctx, "alice", reset.Password); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("expected the previous one-time password to stop working, got %v", err)
+	}
+}
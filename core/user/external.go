@@ -0,0 +1,190 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// unusablePasswordHash is stored for users created by an identity provider. It is
+// not a bcrypt hash, so no password matches it.
+const unusablePasswordHash = "!"
+
+// ExternalIdentity is a user as asserted by an external identity provider
+type ExternalIdentity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+	FullName      string
+
+	// Role overrides the user's role on every login if set
+	Role Role
+}
+
+// initIdentitySchema creates the table linking users to external identities
+func (m *Manager) initIdentitySchema(ctx context.Context) error {
+	_, err := m.pool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS user_identities (
+			issuer TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (issuer, subject)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create user_identities table: %w", err)
+	}
+
+	return nil
+}
+
+// ProvisionExternalUser returns the user of an external identity. An identity
+// seen for the first time is linked to the user with the same verified email,
+// or a new user is created for it.
+func (m *Manager) ProvisionExternalUser(ctx context.Context, identity ExternalIdentity) (*User, error) {
+	// A role may have been deleted since the identity provider was configured
+	if identity.Role != "" {
+		if err := m.ValidateRole(ctx, identity.Role); err != nil {
+			return nil, fmt.Errorf("role %q: %w", identity.Role, err)
+		}
+	}
+
+	tx, err := m.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var userID string
+	err = tx.QueryRow(ctx, `
+		SELECT user_id FROM user_identities WHERE issuer = $1 AND subject = $2
+	`, identity.Issuer, identity.Subject).Scan(&userID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("failed to look up identity: %w", err)
+	}
+
+	if userID == "" {
+		userID, err = m.linkOrCreate(ctx, tx, identity)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO user_identities (issuer, subject, user_id) VALUES ($1, $2, $3)
+		`, identity.Issuer, identity.Subject, userID); err != nil {
+			return nil, fmt.Errorf("failed to link identity: %w", err)
+		}
+	}
+
+	// Keep the role in sync with the identity provider
+	if identity.Role != "" {
+		if _, err := tx.Exec(ctx, `
+			UPDATE users SET role = $1 WHERE id = $2
+		`, identity.Role, userID); err != nil {
+			return nil, fmt.Errorf("failed to update role: %w", err)
+		}
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE users SET last_login = $1 WHERE id = $2
+	`, time.Now(), userID); err != nil {
+		return nil, fmt.Errorf("failed to update last login time: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit identity: %w", err)
+	}
+
+	return m.GetUser(ctx, userID)
+}
+
+// CreateExternalLoginChallenge returns a single-use token for a user the
+// identity provider logged in. The login continues with the token like one
+// whose password was checked, so lockout and second factors still apply.
+func (m *Manager) CreateExternalLoginChallenge(ctx context.Context, userID string) (string, error) {
+	return m.createChallenge(ctx, &userID, challengeExternalLogin, nil)
+}
+
+// CompleteExternalLogin uses up the token of CreateExternalLoginChallenge and
+// returns its user, unless the account is locked
+func (m *Manager) CompleteExternalLogin(ctx context.Context, token string) (*User, error) {
+	c, err := m.getChallenge(ctx, token, challengeExternalLogin)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.checkLocked(ctx, *c.userID); err != nil {
+		return nil, err
+	}
+
+	return m.finishChallenge(ctx, c)
+}
+
+// linkOrCreate returns the user with the identity's verified email, or creates
+// one. Stored addresses were set by an admin or verified by their owner, so a
+// match is the same person.
+func (m *Manager) linkOrCreate(ctx context.Context, tx pgx.Tx, identity ExternalIdentity) (string, error) {
+	var userID string
+	emailTaken := false
+	if identity.Email != "" {
+		err := tx.QueryRow(ctx, `
+			SELECT id FROM users WHERE lower(email) = lower($1)
+		`, identity.Email).Scan(&userID)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return "", fmt.Errorf("failed to look up email: %w", err)
+		}
+		emailTaken = userID != ""
+	}
+
+	// Only an address the provider has verified proves the account is the same
+	if emailTaken && identity.EmailVerified {
+		return userID, nil
+	}
+
+	username, err := m.freeUsername(ctx, tx, identity.Username)
+	if err != nil {
+		return "", err
+	}
+	role := identity.Role
+	if role == "" {
+		role = RoleUser
+	}
+	var email *string
+	if identity.Email != "" && !emailTaken {
+		email = &identity.Email
+	}
+
+	userID = uuid.New().String()
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO users (id, username, password_hash, email, full_name, role, created_at, active)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW(), true)
+	`, userID, username, unusablePasswordHash, email, identity.FullName, role); err != nil {
+		return "", fmt.Errorf("failed to insert user: %w", err)
+	}
+
+	return userID, nil
+}
+
+// freeUsername returns username, or username with the lowest free numeric suffix
+func (m *Manager) freeUsername(ctx context.Context, tx pgx.Tx, username string) (string, error) {
+	username = strings.TrimSpace(username)
+	candidate := username
+	for i := 2; ; i++ {
+		var taken bool
+		if err := tx.QueryRow(ctx, `
+			SELECT EXISTS (SELECT 1 FROM users WHERE username = $1)
+		`, candidate).Scan(&taken); err != nil {
+			return "", fmt.Errorf("failed to check username: %w", err)
+		}
+		if !taken {
+			return candidate, nil
+		}
+		candidate = username + "-" + strconv.Itoa(i)
+	}
+}
@@ -0,0 +1,172 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestProvisionLinksVerifiedEmail(t *testing.T) {
+	m := newTestManager(t)
+	ctx := context.Background()
+	alice := createTestUser(t, m, "alice", "alice@example.com", RoleUser)
+
+	identity := ExternalIdentity{
+		Issuer:        "https://idp.example.com",
+		Subject:       "1234",
+		Email:         "Alice@Example.com",
+		EmailVerified: true,
+		Username:      "alice.idp",
+	}
+	u, err := m.ProvisionExternalUser(ctx, identity)
+	if err != nil {
+		t.Fatalf("ProvisionExternalUser: %v", err)
+	}
+	if u.ID != alice.ID {
+		t.Fatalf("expected the identity to be linked to %s, got %s (%s)", alice.ID, u.ID, u.Username)
+	}
+
+	// The link is kept when the email changes at the provider
+	identity.Email = "alice@elsewhere.example.com"
+	u, err = m.ProvisionExternalUser(ctx, identity)
+	if err != nil {
+		t.Fatalf("ProvisionExternalUser: %v", err)
+	}
+	if u.ID != alice.ID {
+		t.Errorf("expected the linked user %s, got %s", alice.ID, u.ID)
+	}
+	if u.LastLogin == nil {
+		t.Errorf("expected the last login time to be set")
+	}
+}
+
+func TestProvisionDoesNotLinkUnverifiedEmail(t *testing.T) {
+	m := newTestManager(t)
+	ctx := context.Background()
+	alice := createTestUser(t, m, "alice", "alice@example.com", RoleAdmin)
+
+	u, err := m.ProvisionExternalUser(ctx, ExternalIdentity{
+		Issuer:   "https://idp.example.com",
+		Subject:  "5678",
+		Email:    "alice@example.com",
+		Username: "alice",
+	})
+	if err != nil {
+		t.Fatalf("ProvisionExternalUser: %v", err)
+	}
+	if u.ID == alice.ID {
+		t.Fatal("an unverified email was linked to an existing account")
+	}
+	if u.Role != RoleUser {
+		t.Errorf("expected a new user with role %q, got %q", RoleUser, u.Role)
+	}
+
+	// The address belongs to the existing user, so the new one has none
+	if u.Email != "" {
+		t.Errorf("expected the new user to have no email, got %q", u.Email)
+	}
+	if u.PasswordHash != unusablePasswordHash {
+		t.Errorf("expected the new user to have no password")
+	}
+	if _, err := m.Authenticate(ctx, u.Username, ""); err == nil {
+		t.Errorf("a user created by the identity provider can log in with a password")
+	}
+}
+
+func TestProvisionSuffixesUsername(t *testing.T) {
+	m := newTestManager(t)
+	ctx := context.Background()
+	createTestUser(t, m, "bob", "bob@example.com", RoleUser)
+	createTestUser(t, m, "bob-2", "bob2@example.com", RoleUser)
+
+	for i, want := range []string{"bob-3", "bob-4"} {
+		u, err := m.ProvisionExternalUser(ctx, ExternalIdentity{
+			Issuer:   "https://idp.example.com",
+			Subject:  want,
+			Username: " bob ",
+			Role:     RoleViewer,
+		})
+		if err != nil {
+			t.Fatalf("ProvisionExternalUser %d: %v", i, err)
+		}
+		if u.Username != want {
+			t.Errorf("expected username %q, got %q", want, u.Username)
+		}
+		if u.Role != RoleViewer {
+			t.Errorf("expected the role from the identity provider, got %q", u.Role)
+		}
+	}
+}
+
+func TestProvisionRejectsUnknownRole(t *testing.T) {
+	m := newTestManager(t)
+	ctx := context.Background()
+
+	_, err := m.ProvisionExternalUser(ctx, ExternalIdentity{
+		Issuer:   "https://idp.example.com",
+		Subject:  "9012",
+		Username: "carol",
+		Role:     "editor",
+	})
+	if !errors.Is(err, ErrRoleNotFound) {
+		t.Fatalf("expected ErrRoleNotFound, got %v", err)
+	}
+	if _, err := m.GetUserByUsername(ctx, "carol"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("a user was created with an unknown role")
+	}
+
+	if _, err := m.CreateRole(ctx, CreateRoleRequest{Name: "editor", Permissions: []string{"photos:read", "photos:write"}}); err != nil {
+		t.Fatalf("CreateRole: %v", err)
+	}
+	u, err := m.ProvisionExternalUser(ctx, ExternalIdentity{
+		Issuer:   "https://idp.example.com",
+		Subject:  "9012",
+		Username: "carol",
+		Role:     "editor",
+	})
+	if err != nil {
+		t.Fatalf("ProvisionExternalUser: %v", err)
+	}
+	if u.Role != "editor" {
+		t.Errorf("expected the custom role, got %q", u.Role)
+	}
+}
+
+func TestExternalLoginChallenge(t *testing.T) {
+	m := newTestManager(t)
+	ctx := context.Background()
+	alice := createTestUser(t, m, "alice", "alice@example.com", RoleUser)
+
+	token, err := m.CreateExternalLoginChallenge(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The token does not stand in for a second factor
+	if _, err := m.CompleteMFAWithCode(ctx, token, "000000"); !errors.Is(err, ErrInvalidMFAChallenge) {
+		t.Errorf("expected a login token to be rejected as an MFA challenge, got %v", err)
+	}
+
+	u, err := m.CompleteExternalLogin(ctx, token)
+	if err != nil {
+		t.Fatalf("CompleteExternalLogin: %v", err)
+	}
+	if u.ID != alice.ID {
+		t.Errorf("expected %s, got %s", alice.ID, u.ID)
+	}
+	if _, err := m.CompleteExternalLogin(ctx, token); !errors.Is(err, ErrInvalidMFAChallenge) {
+		t.Errorf("expected a used login token to be rejected, got %v", err)
+	}
+
+	// A locked account cannot log in at the identity provider either
+	token, err = m.CreateExternalLoginChallenge(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.pool.Exec(ctx, `UPDATE users SET locked_until = NOW() + INTERVAL '1 minute' WHERE id = $1`, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.CompleteExternalLogin(ctx, token); !errors.Is(err, ErrAccountLocked) {
+		t.Errorf("expected ErrAccountLocked, got %v", err)
+	}
+}
@@ -0,0 +1,234 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	// ErrInvalidInvite is returned when an invite token is unknown, expired or
+	// already used
+	ErrInvalidInvite = errors.New("invalid or expired invite")
+
+	// ErrInviteNotFound is returned when an invite to revoke does not exist
+	ErrInviteNotFound = errors.New("invite not found")
+
+	// ErrInvalidInviteExpiry is returned when an invite would expire in the past
+	// or after MaxInviteTTL
+	ErrInvalidInviteExpiry = errors.New("invite must expire within 30 days")
+)
+
+const (
+	// DefaultInviteTTL is how long an invite is valid unless it says otherwise
+	DefaultInviteTTL = 7 * 24 * time.Hour
+
+	// MaxInviteTTL is the longest an invite can be valid
+	MaxInviteTTL = 30 * 24 * time.Hour
+)
+
+// Invite lets one person create an account with a role. The token is only
+// known when the invite is created.
+type Invite struct {
+	ID        string     `json:"id"`
+	Role      Role       `json:"role"`
+	Email     string     `json:"email,omitempty"`
+	CreatedBy string     `json:"created_by,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	UsedBy    string     `json:"used_by,omitempty"`
+}
+
+// CreateInviteRequest represents an admin inviting someone
+type CreateInviteRequest struct {
+	Role      Role       `json:"role"`
+	Email     string     `json:"email"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// AcceptInviteRequest represents an invitee choosing their account
+type AcceptInviteRequest struct {
+	Token string `json:"token"`
+	RegisterRequest
+}
+
+// initInviteSchema creates the table of invites
+func (m *Manager) initInviteSchema(ctx context.Context) error {
+	_, err := m.pool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS invites (
+			id UUID PRIMARY KEY,
+			token_hash TEXT UNIQUE NOT NULL,
+			role TEXT NOT NULL,
+			email TEXT,
+			created_by TEXT,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			expires_at TIMESTAMPTZ NOT NULL,
+			used_at TIMESTAMPTZ,
+			used_by UUID REFERENCES users(id) ON DELETE SET NULL
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create invites table: %w", err)
+	}
+
+	return nil
+}
+
+// CreateInvite creates an invite and returns it with its token. Only the hash
+// of the token is stored.
+func (m *Manager) CreateInvite(ctx context.Context, req CreateInviteRequest, createdBy string) (*Invite, string, error) {
+	if req.Role == "" {
+		req.Role = RoleUser
+	}
+	if err := m.ValidateRole(ctx, req.Role); err != nil {
+		return nil, "", err
+	}
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(DefaultInviteTTL)
+	if req.ExpiresAt != nil {
+		expiresAt = *req.ExpiresAt
+	}
+	if !expiresAt.After(now) || expiresAt.After(now.Add(MaxInviteTTL)) {
+		return nil, "", ErrInvalidInviteExpiry
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", fmt.Errorf("failed to generate invite: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	invite := &Invite{
+		ID:        uuid.New().String(),
+		Role:      req.Role,
+		Email:     email,
+		CreatedBy: createdBy,
+		ExpiresAt: expiresAt,
+	}
+	err = m.pool.QueryRow(ctx, `
+		INSERT INTO invites (id, token_hash, role, email, created_by, expires_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6)
+		RETURNING created_at
+	`, invite.ID, hashSecret(token), invite.Role, invite.Email, createdBy, invite.ExpiresAt).Scan(&invite.CreatedAt)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create invite: %w", err)
+	}
+
+	return invite, token, nil
+}
+
+// ListInvites returns the invites that have not expired, used or not, newest
+// first
+func (m *Manager) ListInvites(ctx context.Context) ([]*Invite, error) {
+	rows, err := m.pool.Query(ctx, `
+		SELECT id, role, COALESCE(email, ''), COALESCE(created_by, ''), created_at, expires_at, used_at, COALESCE(used_by::TEXT, '')
+		FROM invites
+		WHERE expires_at > NOW() OR used_at IS NOT NULL
+		ORDER BY created_at DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list invites: %w", err)
+	}
+	defer rows.Close()
+
+	invites := []*Invite{}
+	for rows.Next() {
+		var invite Invite
+		if err := rows.Scan(
+			&invite.ID, &invite.Role, &invite.Email, &invite.CreatedBy,
+			&invite.CreatedAt, &invite.ExpiresAt, &invite.UsedAt, &invite.UsedBy,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan invite: %w", err)
+		}
+		invites = append(invites, &invite)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating invites: %w", err)
+	}
+
+	return invites, nil
+}
+
+// DeleteInvite revokes an invite, or removes a used one from the list
+func (m *Manager) DeleteInvite(ctx context.Context, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInviteNotFound
+	}
+	result, err := m.pool.Exec(ctx, `DELETE FROM invites WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete invite: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrInviteNotFound
+	}
+
+	return nil
+}
+
+// AcceptInvite creates the account an invite was for, with the username and
+// password the invitee chose, and uses the invite up. Only the address the
+// admin invited is stored; another address the invitee gives is left out until
+// it is verified with EmailChangeToken.
+func (m *Manager) AcceptInvite(ctx context.Context, req AcceptInviteRequest) (*User, error) {
+	u, err := newSignup(req.RegisterRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := m.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Locking the invite makes concurrent uses of it wait, then fail
+	var inviteID, email string
+	err = tx.QueryRow(ctx, `
+		SELECT id, role, COALESCE(email, '') FROM invites
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		FOR UPDATE
+	`, hashSecret(req.Token)).Scan(&inviteID, &u.Role, &email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrInvalidInvite
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up invite: %w", err)
+	}
+	if !strings.EqualFold(u.Email, email) {
+		u.Email = email
+	}
+
+	// The role may have been deleted since the invite was made
+	if err := m.ValidateRole(ctx, u.Role); err != nil {
+		return nil, err
+	}
+
+	if err := insertSignup(ctx, tx, u); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE invites SET used_at = NOW(), used_by = $1 WHERE id = $2
+	`, u.ID, inviteID); err != nil {
+		return nil, fmt.Errorf("failed to use invite: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit user: %w", err)
+	}
+	return u, nil
+}
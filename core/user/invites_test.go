@@ -0,0 +1,176 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// signup returns a sign-up with a valid password
+func signup(username, email string) RegisterRequest {
+	return RegisterRequest{Username: username, Password: "correct horse battery", Email: email}
+}
+
+func TestInviteIsSingleUse(t *testing.T) {
+	m := newTestManager(t)
+	ctx := context.Background()
+	admin, err := m.GetUserByUsername(ctx, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invite, token, err := m.CreateInvite(ctx, CreateInviteRequest{Role: RoleViewer}, admin.ID)
+	if err != nil {
+		t.Fatalf("CreateInvite: %v", err)
+	}
+
+	u, err := m.AcceptInvite(ctx, AcceptInviteRequest{Token: token, RegisterRequest: signup("grandma", "")})
+	if err != nil {
+		t.Fatalf("AcceptInvite: %v", err)
+	}
+	if u.Role != RoleViewer || !u.Active || u.PendingApproval {
+		t.Errorf("expected an active viewer, got %+v", u)
+	}
+	if _, err := m.Authenticate(ctx, "grandma", "correct horse battery"); err != nil {
+		t.Errorf("Authenticate: %v", err)
+	}
+
+	if _, err := m.AcceptInvite(ctx, AcceptInviteRequest{Token: token, RegisterRequest: signup("grandpa", "")}); !errors.Is(err, ErrInvalidInvite) {
+		t.Errorf("expected a used invite to be rejected, got %v", err)
+	}
+	if _, err := m.GetUserByUsername(ctx, "grandpa"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected no user for a rejected invite, got %v", err)
+	}
+
+	invites, err := m.ListInvites(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invites) != 1 || invites[0].ID != invite.ID || invites[0].UsedBy != u.ID || invites[0].UsedAt == nil {
+		t.Errorf("expected the invite to be used by %s, got %+v", u.ID, invites)
+	}
+}
+
+func TestInviteFailedSignupKeepsInvite(t *testing.T) {
+	m := newTestManager(t)
+	ctx := context.Background()
+	createTestUser(t, m, "bob", "bob@example.com", RoleUser)
+
+	_, token, err := m.CreateInvite(ctx, CreateInviteRequest{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.AcceptInvite(ctx, AcceptInviteRequest{Token: token, RegisterRequest: signup("bob", "")}); !errors.Is(err, ErrUserAlreadyExists) {
+		t.Fatalf("expected ErrUserAlreadyExists, got %v", err)
+	}
+
+	// The invite defaults to the user role and still works
+	u, err := m.AcceptInvite(ctx, AcceptInviteRequest{Token: token, RegisterRequest: signup("alice", "")})
+	if err != nil {
+		t.Fatalf("AcceptInvite: %v", err)
+	}
+	if u.Role != RoleUser {
+		t.Errorf("expected role %s, got %s", RoleUser, u.Role)
+	}
+}
+
+func TestInviteExpiry(t *testing.T) {
+	m := newTestManager(t)
+	ctx := context.Background()
+
+	past := time.Now().Add(-time.Minute)
+	if _, _, err := m.CreateInvite(ctx, CreateInviteRequest{ExpiresAt: &past}, ""); !errors.Is(err, ErrInvalidInviteExpiry) {
+		t.Errorf("expected an expiry in the past to be rejected, got %v", err)
+	}
+	late := time.Now().Add(MaxInviteTTL + time.Hour)
+	if _, _, err := m.CreateInvite(ctx, CreateInviteRequest{ExpiresAt: &late}, ""); !errors.Is(err, ErrInvalidInviteExpiry) {
+		t.Errorf("expected an expiry after %s to be rejected, got %v", MaxInviteTTL, err)
+	}
+
+	invite, token, err := m.CreateInvite(ctx, CreateInviteRequest{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(invite.ExpiresAt); d < DefaultInviteTTL-time.Minute || d > DefaultInviteTTL {
+		t.Errorf("expected the invite to expire in %s, got %s", DefaultInviteTTL, d)
+	}
+
+	if _, err := m.pool.Exec(ctx, `UPDATE invites SET expires_at = NOW() - INTERVAL '1 second'`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.AcceptInvite(ctx, AcceptInviteRequest{Token: token, RegisterRequest: signup("alice", "")}); !errors.Is(err, ErrInvalidInvite) {
+		t.Errorf("expected an expired invite to be rejected, got %v", err)
+	}
+	if invites, err := m.ListInvites(ctx); err != nil || len(invites) != 0 {
+		t.Errorf("expected expired invites not to be listed, got %v, %v", invites, err)
+	}
+}
+
+func TestInviteRole(t *testing.T) {
+	m := newTestManager(t)
+	ctx := context.Background()
+
+	if _, _, err := m.CreateInvite(ctx, CreateInviteRequest{Role: "editor"}, ""); !errors.Is(err, ErrRoleNotFound) {
+		t.Errorf("expected ErrRoleNotFound, got %v", err)
+	}
+
+	if _, err := m.CreateRole(ctx, CreateRoleRequest{Name: "editor", Permissions: []string{"photos:read"}}); err != nil {
+		t.Fatalf("CreateRole: %v", err)
+	}
+	_, token, err := m.CreateInvite(ctx, CreateInviteRequest{Role: "editor"}, "")
+	if err != nil {
+		t.Fatalf("CreateInvite: %v", err)
+	}
+
+	// The role may be deleted before the invite is used
+	if err := m.DeleteRole(ctx, "editor"); err != nil {
+		t.Fatalf("DeleteRole: %v", err)
+	}
+	if _, err := m.AcceptInvite(ctx, AcceptInviteRequest{Token: token, RegisterRequest: signup("alice", "")}); !errors.Is(err, ErrRoleNotFound) {
+		t.Errorf("expected ErrRoleNotFound, got %v", err)
+	}
+}
+
+func TestInviteEmail(t *testing.T) {
+	m := newTestManager(t)
+	ctx := context.Background()
+
+	// The invited address is stored, whatever its case
+	_, token, err := m.CreateInvite(ctx, CreateInviteRequest{Email: "Alice <alice@example.com>"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := m.AcceptInvite(ctx, AcceptInviteRequest{Token: token, RegisterRequest: signup("alice", "ALICE@example.com")})
+	if err != nil {
+		t.Fatalf("AcceptInvite: %v", err)
+	}
+	if u.Email != "alice@example.com" {
+		t.Errorf("expected alice@example.com, got %q", u.Email)
+	}
+
+	// Another address is not, since nothing proves it belongs to the invitee
+	_, token, err = m.CreateInvite(ctx, CreateInviteRequest{Email: "bob@example.com"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err = m.AcceptInvite(ctx, AcceptInviteRequest{Token: token, RegisterRequest: signup("bob", "victim@example.com")})
+	if err != nil {
+		t.Fatalf("AcceptInvite: %v", err)
+	}
+	if u.Email != "bob@example.com" {
+		t.Errorf("expected bob@example.com, got %q", u.Email)
+	}
+
+	_, token, err = m.CreateInvite(ctx, CreateInviteRequest{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err = m.AcceptInvite(ctx, AcceptInviteRequest{Token: token, RegisterRequest: signup("carol", "victim@example.com")})
+	if err != nil {
+		t.Fatalf("AcceptInvite: %v", err)
+	}
+	if u.Email != "" {
+		t.Errorf("expected no address, got %q", u.Email)
+	}
+}
@@ -0,0 +1,152 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrAccountLocked is returned when a user logs in while their account is
+// locked after too many failed attempts
+var ErrAccountLocked = errors.New("account locked")
+
+// LockedError is returned when a login fails because the account is locked
+type LockedError struct {
+	Until time.Time
+
+	// Triggered is set if this attempt locked the account, rather than it
+	// being locked already
+	Triggered bool
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("account locked until %s", e.Until.Format(time.RFC3339))
+}
+
+func (e *LockedError) Unwrap() error {
+	return ErrAccountLocked
+}
+
+// LockoutPolicy decides when an account is locked after failed logins
+type LockoutPolicy struct {
+	// MaxFailures is the number of failed passwords or second factors in a row
+	// that lock the account. Zero disables locking.
+	MaxFailures int
+
+	// Duration is how long the account stays locked
+	Duration time.Duration
+}
+
+// DefaultLockoutPolicy locks an account for 15 minutes after 10 failures
+var DefaultLockoutPolicy = LockoutPolicy{
+	MaxFailures: 10,
+	Duration:    15 * time.Minute,
+}
+
+// SetLockoutPolicy sets when accounts are locked after failed logins
+func (m *Manager) SetLockoutPolicy(policy LockoutPolicy) {
+	m.lockout = policy
+}
+
+// initLockoutSchema adds the failed login counter and lock to users
+func (m *Manager) initLockoutSchema(ctx context.Context) error {
+	_, err := m.pool.Exec(ctx, `
+		ALTER TABLE users
+			ADD COLUMN IF NOT EXISTS failed_logins INTEGER NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS locked_until TIMESTAMPTZ;
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to add lockout columns: %w", err)
+	}
+
+	return nil
+}
+
+// UnlockUser lifts the lock of an account and forgets its failed logins
+func (m *Manager) UnlockUser(ctx context.Context, id string) error {
+	result, err := m.pool.Exec(ctx, `
+		UPDATE users SET failed_logins = 0, locked_until = NULL WHERE id = $1
+	`, id)
+	if err != nil {
+		return fmt.Errorf("failed to unlock user: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// checkLocked returns a LockedError if the account of a user is locked
+func (m *Manager) checkLocked(ctx context.Context, userID string) error {
+	var lockedUntil *time.Time
+	err := m.pool.QueryRow(ctx, `
+		SELECT locked_until FROM users WHERE id = $1
+	`, userID).Scan(&lockedUntil)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check lock: %w", err)
+	}
+
+	return lockedError(lockedUntil)
+}
+
+// recordLoginFailure counts a failed login of a user, locking the account once
+// the policy's number of failures is reached. It returns a LockedError if this
+// failure locked the account.
+func (m *Manager) recordLoginFailure(ctx context.Context, userID string) error {
+	if m.lockout.MaxFailures <= 0 {
+		return nil
+	}
+
+	// The counter starts over once the account is locked
+	var failures int
+	var lockedUntil *time.Time
+	err := m.pool.QueryRow(ctx, `
+		UPDATE users SET
+			failed_logins = CASE WHEN failed_logins + 1 >= $2 THEN 0 ELSE failed_logins + 1 END,
+			locked_until = CASE WHEN failed_logins + 1 >= $2 THEN $3 ELSE locked_until END
+		WHERE id = $1
+		RETURNING failed_logins, locked_until
+	`, userID, m.lockout.MaxFailures, time.Now().Add(m.lockout.Duration)).Scan(&failures, &lockedUntil)
+	if err != nil {
+		return fmt.Errorf("failed to record failed login: %w", err)
+	}
+
+	if failures == 0 && lockedUntil != nil {
+		log.Printf("Locked user %s until %s after %d failed logins", userID, lockedUntil.Format(time.RFC3339), m.lockout.MaxFailures)
+		return &LockedError{Until: *lockedUntil, Triggered: true}
+	}
+
+	return nil
+}
+
+// ResetFailedLogins forgets the failed logins of a user once they have logged
+// in. A correct password alone does not reset them while a second factor is
+// outstanding, so guessing codes still locks the account.
+func (m *Manager) ResetFailedLogins(ctx context.Context, userID string) error {
+	_, err := m.pool.Exec(ctx, `
+		UPDATE users SET failed_logins = 0, locked_until = NULL WHERE id = $1 AND failed_logins > 0
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to reset failed logins: %w", err)
+	}
+
+	return nil
+}
+
+// lockedError returns a LockedError if a lock has not expired yet
+func lockedError(lockedUntil *time.Time) error {
+	if lockedUntil != nil && lockedUntil.After(time.Now()) {
+		return &LockedError{Until: *lockedUntil}
+	}
+
+	return nil
+}
@@ -0,0 +1,665 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/jackc/pgx/v5"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+var (
+	// ErrMFAAlreadyEnabled is returned when TOTP is enrolled a second time
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication already enabled")
+
+	// ErrMFANotEnabled is returned when a second factor is used that the user
+	// has not set up
+	ErrMFANotEnabled = errors.New("two-factor authentication not enabled")
+
+	// ErrInvalidMFACode is returned when a TOTP or recovery code is wrong or was
+	// already used
+	ErrInvalidMFACode = errors.New("invalid two-factor code")
+
+	// ErrInvalidMFAChallenge is returned when an MFA challenge is unknown,
+	// expired or used up
+	ErrInvalidMFAChallenge = errors.New("invalid or expired MFA challenge")
+)
+
+const (
+	// MFAChallengeTTL is how long the second step of a login may take
+	MFAChallengeTTL = 5 * time.Minute
+
+	// maxMFAAttempts is how many wrong codes a challenge survives
+	maxMFAAttempts = 5
+
+	// recoveryCodeCount is how many recovery codes are issued at a time
+	recoveryCodeCount = 10
+
+	// totpPeriod is the TOTP time step in seconds
+	totpPeriod = 30
+)
+
+// Purposes of MFA challenges
+const (
+	challengeLogin               = "login"
+	challengePasskeyRegistration = "passkey_registration"
+	challengePasskeyLogin        = "passkey_login"
+	challengeExternalLogin       = "external_login"
+)
+
+// totpOpts are the TOTP parameters every authenticator app supports
+var totpOpts = totp.ValidateOpts{
+	Period:    totpPeriod,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+// recoveryEncoding writes recovery codes without padding or ambiguous case
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// MFAConfig configures second factors
+type MFAConfig struct {
+	// Issuer names the service in authenticator apps
+	Issuer string
+
+	// PasskeyRPID is the domain passkeys are bound to, such as photos.example.com.
+	// Passkeys are disabled without it.
+	PasskeyRPID string
+
+	// PasskeyOrigins are the origins of the UI passkeys are used from
+	PasskeyOrigins []string
+}
+
+// MFAStatus describes the second factors of a user
+type MFAStatus struct {
+	TOTP          bool `json:"totp"`
+	Passkeys      int  `json:"passkeys"`
+	RecoveryCodes int  `json:"recovery_codes"`
+
+	// Required is set if the user's role requires a second factor
+	Required bool `json:"required"`
+}
+
+// Enabled reports whether the user has a second factor
+func (s *MFAStatus) Enabled() bool {
+	return s.TOTP || s.Passkeys > 0
+}
+
+// TOTPEnrollment is a TOTP secret waiting to be confirmed with a first code
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URL    string `json:"url"`
+}
+
+// MFAResult is the outcome of the second step of a login
+type MFAResult struct {
+	User *User
+
+	// RecoveryCodes are set if the step confirmed a TOTP enrolment
+	RecoveryCodes []string
+}
+
+// mfaChallenge is a pending second step of a login or passkey ceremony
+type mfaChallenge struct {
+	id       string
+	userID   *string
+	purpose  string
+	session  *webauthn.SessionData
+	attempts int
+}
+
+// ConfigureMFA configures TOTP and passkeys
+func (m *Manager) ConfigureMFA(config MFAConfig) error {
+	if config.Issuer != "" {
+		m.mfaIssuer = config.Issuer
+	}
+
+	if config.PasskeyRPID == "" {
+		m.webauthn = nil
+		return nil
+	}
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          config.PasskeyRPID,
+		RPDisplayName: m.mfaIssuer,
+		RPOrigins:     config.PasskeyOrigins,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to configure passkeys: %w", err)
+	}
+	m.webauthn = w
+
+	return nil
+}
+
+// initMFASchema creates the tables of second factors, MFA challenges and the
+// roles that require MFA
+func (m *Manager) initMFASchema(ctx context.Context) error {
+	_, err := m.pool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS user_totp (
+			user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+			secret TEXT NOT NULL,
+			confirmed BOOLEAN NOT NULL DEFAULT FALSE,
+			last_used_step BIGINT NOT NULL DEFAULT 0,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE TABLE IF NOT EXISTS user_recovery_codes (
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			code_hash TEXT NOT NULL,
+			used_at TIMESTAMPTZ,
+			PRIMARY KEY (user_id, code_hash)
+		);
+		CREATE TABLE IF NOT EXISTS user_passkeys (
+			id TEXT PRIMARY KEY,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			credential JSONB NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			last_used_at TIMESTAMPTZ
+		);
+		CREATE INDEX IF NOT EXISTS user_passkeys_user_id_idx ON user_passkeys (user_id);
+		CREATE TABLE IF NOT EXISTS mfa_challenges (
+			id TEXT PRIMARY KEY,
+			user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+			purpose TEXT NOT NULL,
+			webauthn JSONB,
+			attempts INT NOT NULL DEFAULT 0,
+			expires_at TIMESTAMPTZ NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS role_mfa (
+			role TEXT PRIMARY KEY
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create MFA tables: %w", err)
+	}
+
+	return nil
+}
+
+// MFAStatus returns the second factors of a user
+func (m *Manager) MFAStatus(ctx context.Context, userID string) (*MFAStatus, error) {
+	var status MFAStatus
+	var role Role
+	err := m.pool.QueryRow(ctx, `
+		SELECT u.role,
+			EXISTS (SELECT 1 FROM user_totp t WHERE t.user_id = u.id AND t.confirmed),
+			(SELECT COUNT(*) FROM user_passkeys p WHERE p.user_id = u.id),
+			(SELECT COUNT(*) FROM user_recovery_codes c WHERE c.user_id = u.id AND c.used_at IS NULL)
+		FROM users u WHERE u.id = $1
+	`, userID).Scan(&role, &status.TOTP, &status.Passkeys, &status.RecoveryCodes)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get MFA status: %w", err)
+	}
+
+	status.Required, err = m.MFARequired(ctx, role)
+	if err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
+
+// MFARequired reports whether users of a role must log in with a second factor
+func (m *Manager) MFARequired(ctx context.Context, role Role) (bool, error) {
+	var required bool
+	err := m.pool.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM role_mfa WHERE role = $1)
+	`, role).Scan(&required)
+	if err != nil {
+		return false, fmt.Errorf("failed to check MFA policy: %w", err)
+	}
+
+	return required, nil
+}
+
+// SetMFARequired sets whether users of a role must log in with a second factor.
+// Users without one are asked to set up TOTP at their next login.
+func (m *Manager) SetMFARequired(ctx context.Context, role Role, required bool) error {
+	if err := m.ValidateRole(ctx, role); err != nil {
+		return err
+	}
+
+	query := `DELETE FROM role_mfa WHERE role = $1`
+	if required {
+		query = `INSERT INTO role_mfa (role) VALUES ($1) ON CONFLICT DO NOTHING`
+	}
+	if _, err := m.pool.Exec(ctx, query, role); err != nil {
+		return fmt.Errorf("failed to update MFA policy: %w", err)
+	}
+
+	return nil
+}
+
+// BeginTOTPEnrollment creates a TOTP secret for a user. It is not used until
+// ConfirmTOTP is called with a code from it.
+func (m *Manager) BeginTOTPEnrollment(ctx context.Context, userID string) (*TOTPEnrollment, error) {
+	u, err := m.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      m.mfaIssuer,
+		AccountName: u.Username,
+		Period:      totpPeriod,
+		Digits:      otp.DigitsSix,
+		Algorithm:   otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate TOTP secret: %w", err)
+	}
+
+	// A pending enrolment is replaced, a confirmed one is kept
+	result, err := m.pool.Exec(ctx, `
+		INSERT INTO user_totp (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, created_at = NOW()
+		WHERE NOT user_totp.confirmed
+	`, userID, key.Secret())
+	if err != nil {
+		return nil, fmt.Errorf("failed to store TOTP secret: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	return &TOTPEnrollment{Secret: key.Secret(), URL: key.URL()}, nil
+}
+
+// ConfirmTOTP enables the pending TOTP secret of a user if the code matches it,
+// and returns a fresh set of recovery codes
+func (m *Manager) ConfirmTOTP(ctx context.Context, userID, code string) ([]string, error) {
+	tx, err := m.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := checkTOTP(ctx, tx, userID, code, false); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE user_totp SET confirmed = TRUE WHERE user_id = $1`, userID); err != nil {
+		return nil, fmt.Errorf("failed to confirm TOTP: %w", err)
+	}
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return codes, nil
+}
+
+// DisableTOTP removes the TOTP secret and recovery codes of a user after
+// checking a current TOTP or recovery code
+func (m *Manager) DisableTOTP(ctx context.Context, userID, code string) error {
+	tx, err := m.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := checkCode(ctx, tx, userID, code); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete TOTP secret: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of a user after checking
+// a current TOTP or recovery code
+func (m *Manager) RegenerateRecoveryCodes(ctx context.Context, userID, code string) ([]string, error) {
+	tx, err := m.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := checkCode(ctx, tx, userID, code); err != nil {
+		return nil, err
+	}
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return codes, nil
+}
+
+// ResetMFA removes every second factor of a user, for users who lost them
+func (m *Manager) ResetMFA(ctx context.Context, userID string) error {
+	tx, err := m.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	for _, table := range []string{"user_totp", "user_recovery_codes", "user_passkeys"} {
+		if _, err := tx.Exec(ctx, "DELETE FROM "+table+" WHERE user_id = $1", userID); err != nil {
+			return fmt.Errorf("failed to reset MFA: %w", err)
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// CreateMFAChallenge starts the second step of a login for a user whose
+// password was checked, and returns the token that continues it
+func (m *Manager) CreateMFAChallenge(ctx context.Context, userID string) (string, error) {
+	return m.createChallenge(ctx, &userID, challengeLogin, nil)
+}
+
+// BeginTOTPEnrollmentWithChallenge lets a user whose role requires MFA but who
+// has no second factor yet set up TOTP during login
+func (m *Manager) BeginTOTPEnrollmentWithChallenge(ctx context.Context, token string) (*TOTPEnrollment, error) {
+	c, err := m.getChallenge(ctx, token, challengeLogin)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := m.MFAStatus(ctx, *c.userID)
+	if err != nil {
+		return nil, err
+	}
+	if status.Enabled() {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	return m.BeginTOTPEnrollment(ctx, *c.userID)
+}
+
+// CompleteMFAWithCode finishes a login with a TOTP or recovery code. A TOTP
+// code of a pending enrolment confirms it if the user has no other factor.
+func (m *Manager) CompleteMFAWithCode(ctx context.Context, token, code string) (*MFAResult, error) {
+	c, err := m.getChallenge(ctx, token, challengeLogin)
+	if err != nil {
+		return nil, err
+	}
+	userID := *c.userID
+	if err := m.checkLocked(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	status, err := m.MFAStatus(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	if status.Enabled() {
+		err = m.verifyCode(ctx, userID, code)
+	} else {
+		codes, err = m.ConfirmTOTP(ctx, userID, code)
+	}
+	if err != nil {
+		if errors.Is(err, ErrInvalidMFACode) || errors.Is(err, ErrMFANotEnabled) {
+			if lerr := m.failChallenge(ctx, c); lerr != nil {
+				return nil, lerr
+			}
+		}
+		return nil, err
+	}
+
+	u, err := m.finishChallenge(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MFAResult{User: u, RecoveryCodes: codes}, nil
+}
+
+// verifyCode checks a TOTP or recovery code outside a transaction
+func (m *Manager) verifyCode(ctx context.Context, userID, code string) error {
+	tx, err := m.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := checkCode(ctx, tx, userID, code); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// createChallenge stores a challenge and returns its token. Only the hash of the
+// token is stored.
+func (m *Manager) createChallenge(ctx context.Context, userID *string, purpose string, session *webauthn.SessionData) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate MFA challenge: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	var data []byte
+	if session != nil {
+		var err error
+		if data, err = json.Marshal(session); err != nil {
+			return "", fmt.Errorf("failed to encode WebAuthn session: %w", err)
+		}
+	}
+
+	// Expired challenges are cleaned up as new ones are created
+	if _, err := m.pool.Exec(ctx, `DELETE FROM mfa_challenges WHERE expires_at < NOW()`); err != nil {
+		return "", fmt.Errorf("failed to delete expired MFA challenges: %w", err)
+	}
+	_, err := m.pool.Exec(ctx, `
+		INSERT INTO mfa_challenges (id, user_id, purpose, webauthn, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, hashSecret(token), userID, purpose, data, time.Now().Add(MFAChallengeTTL))
+	if err != nil {
+		return "", fmt.Errorf("failed to store MFA challenge: %w", err)
+	}
+
+	return token, nil
+}
+
+// getChallenge returns the challenge of a token if it is still valid
+func (m *Manager) getChallenge(ctx context.Context, token, purpose string) (*mfaChallenge, error) {
+	c := mfaChallenge{id: hashSecret(token)}
+	var data []byte
+	err := m.pool.QueryRow(ctx, `
+		SELECT user_id, purpose, webauthn, attempts FROM mfa_challenges
+		WHERE id = $1 AND expires_at > NOW()
+	`, c.id).Scan(&c.userID, &c.purpose, &data, &c.attempts)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrInvalidMFAChallenge
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get MFA challenge: %w", err)
+	}
+	if c.purpose != purpose || c.attempts >= maxMFAAttempts {
+		return nil, ErrInvalidMFAChallenge
+	}
+
+	if data != nil {
+		c.session = &webauthn.SessionData{}
+		if err := json.Unmarshal(data, c.session); err != nil {
+			return nil, fmt.Errorf("failed to decode WebAuthn session: %w", err)
+		}
+	}
+
+	return &c, nil
+}
+
+// failChallenge counts a wrong answer to a challenge. It returns a LockedError
+// if the answer locked the account.
+func (m *Manager) failChallenge(ctx context.Context, c *mfaChallenge) error {
+	if _, err := m.pool.Exec(ctx, `
+		UPDATE mfa_challenges SET attempts = attempts + 1 WHERE id = $1
+	`, c.id); err != nil {
+		log.Printf("Failed to count MFA attempt: %v", err)
+	}
+
+	// Wrong second factors count towards locking the account like wrong
+	// passwords
+	if c.purpose == challengeLogin && c.userID != nil {
+		err := m.recordLoginFailure(ctx, *c.userID)
+		if errors.Is(err, ErrAccountLocked) {
+			return err
+		}
+		if err != nil {
+			log.Printf("Failed to record failed login: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// finishChallenge uses up a challenge and returns its user. A challenge
+// finished concurrently by another request is invalid.
+func (m *Manager) finishChallenge(ctx context.Context, c *mfaChallenge) (*User, error) {
+	result, err := m.pool.Exec(ctx, `DELETE FROM mfa_challenges WHERE id = $1`, c.id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete MFA challenge: %w", err)
+	}
+	if result.RowsAffected() == 0 || c.userID == nil {
+		return nil, ErrInvalidMFAChallenge
+	}
+
+	return m.GetUser(ctx, *c.userID)
+}
+
+// checkCode checks a TOTP code, or failing that, uses up a recovery code
+func checkCode(ctx context.Context, tx pgx.Tx, userID, code string) error {
+	err := checkTOTP(ctx, tx, userID, code, true)
+	if !errors.Is(err, ErrInvalidMFACode) && !errors.Is(err, ErrMFANotEnabled) {
+		return err
+	}
+
+	result, rerr := tx.Exec(ctx, `
+		UPDATE user_recovery_codes SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`, userID, hashSecret(normalizeRecoveryCode(code)))
+	if rerr != nil {
+		return fmt.Errorf("failed to use recovery code: %w", rerr)
+	}
+	if result.RowsAffected() == 0 {
+		return err
+	}
+
+	return nil
+}
+
+// checkTOTP checks a TOTP code against the secret of a user, allowing one step
+// of clock drift. Each step is only accepted once.
+func checkTOTP(ctx context.Context, tx pgx.Tx, userID, code string, confirmed bool) error {
+	var secret string
+	var lastStep int64
+	err := tx.QueryRow(ctx, `
+		SELECT secret, last_used_step FROM user_totp
+		WHERE user_id = $1 AND confirmed = $2
+		FOR UPDATE
+	`, userID, confirmed).Scan(&secret, &lastStep)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrMFANotEnabled
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get TOTP secret: %w", err)
+	}
+
+	step, ok := matchTOTP(secret, strings.TrimSpace(code), time.Now())
+	if !ok || step <= lastStep {
+		return ErrInvalidMFACode
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE user_totp SET last_used_step = $2 WHERE user_id = $1
+	`, userID, step); err != nil {
+		return fmt.Errorf("failed to update TOTP secret: %w", err)
+	}
+
+	return nil
+}
+
+// matchTOTP returns the time step a code belongs to, if any, looking one step
+// either side of now
+func matchTOTP(secret, code string, now time.Time) (int64, bool) {
+	if len(code) != int(otp.DigitsSix) {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for _, step := range []int64{current - 1, current, current + 1} {
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*totpPeriod, 0), totpOpts)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// replaceRecoveryCodes deletes the recovery codes of a user and returns new ones
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID string) ([]string, error) {
+	if _, err := tx.Exec(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		code := strings.ToLower(recoveryEncoding.EncodeToString(b))
+		codes[i] = code[:4] + "-" + code[4:]
+
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)
+		`, userID, hashSecret(code)); err != nil {
+			return nil, fmt.Errorf("failed to store recovery code: %w", err)
+		}
+	}
+
+	return codes, nil
+}
+
+// normalizeRecoveryCode accepts recovery codes with any case and separators
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, code)
+}
+
+// hashSecret hashes a random secret for storage
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,300 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+)
+
+// totpCode returns the TOTP code of a secret at a time
+func totpCode(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+	code, err := totp.GenerateCodeCustom(secret, at, totpOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// enableTOTP enrols and confirms TOTP for a user and returns the secret, the
+// time the confirming code was made for and the recovery codes
+func enableTOTP(t *testing.T, m *Manager, userID string) (string, time.Time, []string) {
+	t.Helper()
+	ctx := context.Background()
+	enrollment, err := m.BeginTOTPEnrollment(ctx, userID)
+	if err != nil {
+		t.Fatalf("BeginTOTPEnrollment: %v", err)
+	}
+	now := time.Now()
+	codes, err := m.ConfirmTOTP(ctx, userID, totpCode(t, enrollment.Secret, now))
+	if err != nil {
+		t.Fatalf("ConfirmTOTP: %v", err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("expected %d recovery codes, got %d", recoveryCodeCount, len(codes))
+	}
+	return enrollment.Secret, now, codes
+}
+
+func TestMatchTOTP(t *testing.T) {
+	secret := "JBSWY3DPEHPK3PXP"
+	now := time.Unix(1700000000, 0)
+	step := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name string
+		at   time.Time
+		ok   bool
+		step int64
+	}{
+		{"current step", now, true, step},
+		{"previous step", now.Add(-totpPeriod * time.Second), true, step - 1},
+		{"next step", now.Add(totpPeriod * time.Second), true, step + 1},
+		{"two steps ago", now.Add(-2 * totpPeriod * time.Second), false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := matchTOTP(secret, totpCode(t, secret, tt.at), now)
+			if ok != tt.ok || got != tt.step {
+				t.Errorf("expected (%d, %v), got (%d, %v)", tt.step, tt.ok, got, ok)
+			}
+		})
+	}
+
+	if _, ok := matchTOTP(secret, "12345", now); ok {
+		t.Error("a code of the wrong length matched")
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	for _, code := range []string{"abcd-efgh", "ABCD-EFGH", "abcd efgh", "abcdefgh"} {
+		if got := normalizeRecoveryCode(code); got != "abcdefgh" {
+			t.Errorf("%q: expected abcdefgh, got %q", code, got)
+		}
+	}
+}
+
+func TestTOTPRejectsReplay(t *testing.T) {
+	m := newTestManager(t)
+	ctx := context.Background()
+	alice := createTestUser(t, m, "alice", "alice@example.com", RoleUser)
+	secret, confirmedAt, _ := enableTOTP(t, m, alice.ID)
+
+	status, err := m.MFAStatus(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.TOTP || !status.Enabled() || status.RecoveryCodes != recoveryCodeCount {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	// The code that confirmed the enrolment cannot log in
+	token, err := m.CreateMFAChallenge(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.CompleteMFAWithCode(ctx, token, totpCode(t, secret, confirmedAt)); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("expected a replayed code to be rejected, got %v", err)
+	}
+	if _, err := m.CompleteMFAWithCode(ctx, token, "000000"); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("expected a wrong code to be rejected, got %v", err)
+	}
+
+	// A code of a later step logs in once
+	next := totpCode(t, secret, confirmedAt.Add(totpPeriod*time.Second))
+	result, err := m.CompleteMFAWithCode(ctx, token, next)
+	if err != nil {
+		t.Fatalf("CompleteMFAWithCode: %v", err)
+	}
+	if result.User.ID != alice.ID || result.RecoveryCodes != nil {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	token, err = m.CreateMFAChallenge(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.CompleteMFAWithCode(ctx, token, next); !errors.Is(err, ErrInvalidMFACode) {
+		t.Errorf("expected a used code to be rejected, got %v", err)
+	}
+}
+
+func TestRecoveryCodesAreSingleUse(t *testing.T) {
+	m := newTestManager(t)
+	ctx := context.Background()
+	alice := createTestUser(t, m, "alice", "alice@example.com", RoleUser)
+	_, _, codes := enableTOTP(t, m, alice.ID)
+
+	// Recovery codes are accepted in any case
+	token, err := m.CreateMFAChallenge(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.CompleteMFAWithCode(ctx, token, strings.ToUpper(codes[0])); err != nil {
+		t.Fatalf("CompleteMFAWithCode: %v", err)
+	}
+
+	status, err := m.MFAStatus(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.RecoveryCodes != recoveryCodeCount-1 {
+		t.Errorf("expected %d recovery codes left, got %d", recoveryCodeCount-1, status.RecoveryCodes)
+	}
+
+	token, err = m.CreateMFAChallenge(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.CompleteMFAWithCode(ctx, token, codes[0]); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("expected a used recovery code to be rejected, got %v", err)
+	}
+
+	// Regenerating replaces every code
+	fresh, err := m.RegenerateRecoveryCodes(ctx, alice.ID, codes[1])
+	if err != nil {
+		t.Fatalf("RegenerateRecoveryCodes: %v", err)
+	}
+	if _, err := m.CompleteMFAWithCode(ctx, token, codes[2]); !errors.Is(err, ErrInvalidMFACode) {
+		t.Errorf("expected an old recovery code to be rejected, got %v", err)
+	}
+	if _, err := m.CompleteMFAWithCode(ctx, token, fresh[0]); err != nil {
+		t.Errorf("CompleteMFAWithCode with a new recovery code: %v", err)
+	}
+}
+
+func TestMFAChallengeExpiresAndIsSingleUse(t *testing.T) {
+	m := newTestManager(t)
+	ctx := context.Background()
+	alice := createTestUser(t, m, "alice", "alice@example.com", RoleUser)
+	_, _, codes := enableTOTP(t, m, alice.ID)
+
+	token, err := m.CreateMFAChallenge(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.CompleteMFAWithCode(ctx, token, codes[0]); err != nil {
+		t.Fatalf("CompleteMFAWithCode: %v", err)
+	}
+	if _, err := m.CompleteMFAWithCode(ctx, token, codes[1]); !errors.Is(err, ErrInvalidMFAChallenge) {
+		t.Errorf("expected a used challenge to be rejected, got %v", err)
+	}
+
+	token, err = m.CreateMFAChallenge(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.pool.Exec(ctx, `UPDATE mfa_challenges SET expires_at = NOW() - INTERVAL '1 second'`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.CompleteMFAWithCode(ctx, token, codes[1]); !errors.Is(err, ErrInvalidMFAChallenge) {
+		t.Errorf("expected an expired challenge to be rejected, got %v", err)
+	}
+
+	// A challenge survives only a few wrong codes
+	token, err = m.CreateMFAChallenge(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxMFAAttempts; i++ {
+		if _, err := m.CompleteMFAWithCode(ctx, token, "000000"); !errors.Is(err, ErrInvalidMFACode) {
+			t.Fatalf("attempt %d: expected ErrInvalidMFACode, got %v", i, err)
+		}
+	}
+	if _, err := m.CompleteMFAWithCode(ctx, token, codes[1]); !errors.Is(err, ErrInvalidMFAChallenge) {
+		t.Errorf("expected a used up challenge to be rejected, got %v", err)
+	}
+
+	if _, err := m.CompleteMFAWithCode(ctx, "unknown", codes[1]); !errors.Is(err, ErrInvalidMFAChallenge) {
+		t.Errorf("expected an unknown challenge to be rejected, got %v", err)
+	}
+}
+
+func TestWrongCodesLockAccount(t *testing.T) {
+	m := newTestManager(t)
+	m.SetLockoutPolicy(LockoutPolicy{MaxFailures: 2, Duration: time.Minute})
+	ctx := context.Background()
+	alice := createTestUser(t, m, "alice", "alice@example.com", RoleUser)
+	_, _, codes := enableTOTP(t, m, alice.ID)
+
+	token, err := m.CreateMFAChallenge(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.CompleteMFAWithCode(ctx, token, "000000"); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("expected ErrInvalidMFACode, got %v", err)
+	}
+	var locked *LockedError
+	if _, err := m.CompleteMFAWithCode(ctx, token, "000000"); !errors.As(err, &locked) || !locked.Triggered {
+		t.Fatalf("expected the second wrong code to lock the account, got %v", err)
+	}
+	if _, err := m.CompleteMFAWithCode(ctx, token, codes[0]); !errors.Is(err, ErrAccountLocked) {
+		t.Errorf("expected a locked account to be refused, got %v", err)
+	}
+}
+
+func TestMFARequiredPerRole(t *testing.T) {
+	m := newTestManager(t)
+	ctx := context.Background()
+	viewer := createTestUser(t, m, "grandma", "grandma@example.com", RoleViewer)
+	alice := createTestUser(t, m, "alice", "alice@example.com", RoleUser)
+
+	if err := m.SetMFARequired(ctx, "nope", true); !errors.Is(err, ErrRoleNotFound) {
+		t.Errorf("expected ErrRoleNotFound for an unknown role, got %v", err)
+	}
+	if err := m.SetMFARequired(ctx, RoleViewer, true); err != nil {
+		t.Fatalf("SetMFARequired: %v", err)
+	}
+
+	status, err := m.MFAStatus(ctx, viewer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Required || status.Enabled() {
+		t.Fatalf("expected MFA to be required but not set up, got %+v", status)
+	}
+	status, err = m.MFAStatus(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Required {
+		t.Errorf("expected MFA not to be required of the user role")
+	}
+
+	// A user without a second factor sets up TOTP during the login
+	token, err := m.CreateMFAChallenge(ctx, viewer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enrollment, err := m.BeginTOTPEnrollmentWithChallenge(ctx, token)
+	if err != nil {
+		t.Fatalf("BeginTOTPEnrollmentWithChallenge: %v", err)
+	}
+	result, err := m.CompleteMFAWithCode(ctx, token, totpCode(t, enrollment.Secret, time.Now()))
+	if err != nil {
+		t.Fatalf("CompleteMFAWithCode: %v", err)
+	}
+	if result.User.ID != viewer.ID || len(result.RecoveryCodes) != recoveryCodeCount {
+		t.Errorf("expected the enrolment to be confirmed with recovery codes, got %+v", result)
+	}
+
+	// Once set up, enrolling again during a login is refused
+	token, err = m.CreateMFAChallenge(ctx, viewer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.BeginTOTPEnrollmentWithChallenge(ctx, token); !errors.Is(err, ErrMFAAlreadyEnabled) {
+		t.Errorf("expected ErrMFAAlreadyEnabled, got %v", err)
+	}
+
+	if err := m.SetMFARequired(ctx, RoleViewer, false); err != nil {
+		t.Fatalf("SetMFARequired: %v", err)
+	}
+	if required, err := m.MFARequired(ctx, RoleViewer); err != nil || required {
+		t.Errorf("expected MFA to be no longer required, got %v, %v", required, err)
+	}
+}
@@ -0,0 +1,414 @@
+package user
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrPasskeysDisabled is returned when passkeys are used but not configured
+	ErrPasskeysDisabled = errors.New("passkeys are not configured")
+
+	// ErrPasskeyNotFound is returned when a passkey does not exist
+	ErrPasskeyNotFound = errors.New("passkey not found")
+
+	// ErrInvalidPasskey is returned when a passkey response does not verify
+	ErrInvalidPasskey = errors.New("invalid passkey response")
+)
+
+// maxPasskeyNameLength limits the name of a passkey
+const maxPasskeyNameLength = 100
+
+// Passkey is a WebAuthn credential registered by a user
+type Passkey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// passkeyUser is a user with their passkeys, as WebAuthn sees them
+type passkeyUser struct {
+	user        *User
+	credentials []webauthn.Credential
+}
+
+// WebAuthnID returns the user handle, the bytes of the user's UUID
+func (p *passkeyUser) WebAuthnID() []byte {
+	id, err := uuid.Parse(p.user.ID)
+	if err != nil {
+		return []byte(p.user.ID)
+	}
+	return id[:]
+}
+
+// WebAuthnName returns the username
+func (p *passkeyUser) WebAuthnName() string {
+	return p.user.Username
+}
+
+// WebAuthnDisplayName returns the full name, or the username without one
+func (p *passkeyUser) WebAuthnDisplayName() string {
+	if p.user.FullName != "" {
+		return p.user.FullName
+	}
+	return p.user.Username
+}
+
+// WebAuthnCredentials returns the user's passkeys
+func (p *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	return p.credentials
+}
+
+// PasskeysEnabled reports whether passkeys are configured
+func (m *Manager) PasskeysEnabled() bool {
+	return m.webauthn != nil
+}
+
+// ListPasskeys returns the passkeys of a user, newest first
+func (m *Manager) ListPasskeys(ctx context.Context, userID string) ([]Passkey, error) {
+	rows, err := m.pool.Query(ctx, `
+		SELECT id, name, created_at, last_used_at FROM user_passkeys
+		WHERE user_id = $1 ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query passkeys: %w", err)
+	}
+	defer rows.Close()
+
+	passkeys := []Passkey{}
+	for rows.Next() {
+		var p Passkey
+		if err := rows.Scan(&p.ID, &p.Name, &p.CreatedAt, &p.LastUsedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan passkey: %w", err)
+		}
+		passkeys = append(passkeys, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating passkeys: %w", err)
+	}
+
+	return passkeys, nil
+}
+
+// DeletePasskey deletes a passkey of a user
+func (m *Manager) DeletePasskey(ctx context.Context, userID, id string) error {
+	result, err := m.pool.Exec(ctx, `
+		DELETE FROM user_passkeys WHERE id = $1 AND user_id = $2
+	`, id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete passkey: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrPasskeyNotFound
+	}
+
+	return nil
+}
+
+// BeginPasskeyRegistration starts registering a passkey for a user. It returns
+// the token that finishes the registration and the options for
+// navigator.credentials.create.
+func (m *Manager) BeginPasskeyRegistration(ctx context.Context, userID string) (string, *protocol.CredentialCreation, error) {
+	if m.webauthn == nil {
+		return "", nil, ErrPasskeysDisabled
+	}
+
+	pu, err := m.passkeyUser(ctx, userID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Passkeys are discoverable so they can log in without a username
+	exclusions := make([]protocol.CredentialDescriptor, len(pu.credentials))
+	for i, c := range pu.credentials {
+		exclusions[i] = c.Descriptor()
+	}
+	options, session, err := m.webauthn.BeginRegistration(pu,
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+		webauthn.WithExclusions(exclusions),
+	)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to begin passkey registration: %w", err)
+	}
+
+	token, err := m.createChallenge(ctx, &userID, challengePasskeyRegistration, session)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, options, nil
+}
+
+// FinishPasskeyRegistration verifies the response of the authenticator and
+// stores the new passkey under name
+func (m *Manager) FinishPasskeyRegistration(ctx context.Context, userID, token, name string, response []byte) (*Passkey, error) {
+	if m.webauthn == nil {
+		return nil, ErrPasskeysDisabled
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "Passkey"
+	}
+	if len(name) > maxPasskeyNameLength {
+		name = name[:maxPasskeyNameLength]
+	}
+
+	c, err := m.getChallenge(ctx, token, challengePasskeyRegistration)
+	if err != nil {
+		return nil, err
+	}
+	if c.userID == nil || *c.userID != userID || c.session == nil {
+		return nil, ErrInvalidMFAChallenge
+	}
+	if _, err := m.finishChallenge(ctx, c); err != nil {
+		return nil, err
+	}
+
+	pu, err := m.passkeyUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(response)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+	credential, err := m.webauthn.CreateCredential(pu, *c.session, parsed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode passkey: %w", err)
+	}
+	passkey := &Passkey{
+		ID:   base64.RawURLEncoding.EncodeToString(credential.ID),
+		Name: name,
+	}
+	err = m.pool.QueryRow(ctx, `
+		INSERT INTO user_passkeys (id, user_id, name, credential) VALUES ($1, $2, $3, $4)
+		RETURNING created_at
+	`, passkey.ID, userID, passkey.Name, data).Scan(&passkey.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store passkey: %w", err)
+	}
+
+	return passkey, nil
+}
+
+// BeginPasskeyLogin starts a login with any passkey, without a username. It
+// returns the token that finishes the login and the options for
+// navigator.credentials.get.
+func (m *Manager) BeginPasskeyLogin(ctx context.Context) (string, *protocol.CredentialAssertion, error) {
+	if m.webauthn == nil {
+		return "", nil, ErrPasskeysDisabled
+	}
+
+	options, session, err := m.webauthn.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to begin passkey login: %w", err)
+	}
+
+	token, err := m.createChallenge(ctx, nil, challengePasskeyLogin, session)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, options, nil
+}
+
+// FinishPasskeyLogin verifies a passkey login and returns its user. A passkey
+// verifies the user itself, so no second factor is asked for.
+func (m *Manager) FinishPasskeyLogin(ctx context.Context, token string, response []byte) (*User, error) {
+	if m.webauthn == nil {
+		return nil, ErrPasskeysDisabled
+	}
+
+	c, err := m.getChallenge(ctx, token, challengePasskeyLogin)
+	if err != nil {
+		return nil, err
+	}
+	if c.session == nil {
+		return nil, ErrInvalidMFAChallenge
+	}
+	if _, err := m.pool.Exec(ctx, `DELETE FROM mfa_challenges WHERE id = $1`, c.id); err != nil {
+		return nil, fmt.Errorf("failed to delete MFA challenge: %w", err)
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+
+	var pu *passkeyUser
+	credential, err := m.webauthn.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		id, err := uuid.FromBytes(userHandle)
+		if err != nil {
+			return nil, err
+		}
+		pu, err = m.passkeyUser(ctx, id.String())
+		return pu, err
+	}, *c.session, parsed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+
+	if err := m.updatePasskey(ctx, pu.user.ID, credential); err != nil {
+		return nil, err
+	}
+
+	return pu.user, nil
+}
+
+// BeginPasskeyMFA asks for one of the user's passkeys as the second step of a
+// login. It returns the options for navigator.credentials.get.
+func (m *Manager) BeginPasskeyMFA(ctx context.Context, token string) (*protocol.CredentialAssertion, error) {
+	if m.webauthn == nil {
+		return nil, ErrPasskeysDisabled
+	}
+
+	c, err := m.getChallenge(ctx, token, challengeLogin)
+	if err != nil {
+		return nil, err
+	}
+	pu, err := m.passkeyUser(ctx, *c.userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(pu.credentials) == 0 {
+		return nil, ErrMFANotEnabled
+	}
+
+	options, session, err := m.webauthn.BeginLogin(pu)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin passkey login: %w", err)
+	}
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode WebAuthn session: %w", err)
+	}
+	if _, err := m.pool.Exec(ctx, `
+		UPDATE mfa_challenges SET webauthn = $2 WHERE id = $1
+	`, c.id, data); err != nil {
+		return nil, fmt.Errorf("failed to store WebAuthn session: %w", err)
+	}
+
+	return options, nil
+}
+
+// CompleteMFAWithPasskey finishes a login with the response to BeginPasskeyMFA
+func (m *Manager) CompleteMFAWithPasskey(ctx context.Context, token string, response []byte) (*MFAResult, error) {
+	if m.webauthn == nil {
+		return nil, ErrPasskeysDisabled
+	}
+
+	c, err := m.getChallenge(ctx, token, challengeLogin)
+	if err != nil {
+		return nil, err
+	}
+	if c.session == nil {
+		return nil, ErrInvalidMFAChallenge
+	}
+
+	pu, err := m.passkeyUser(ctx, *c.userID)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		if lerr := m.failChallenge(ctx, c); lerr != nil {
+			return nil, lerr
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+	credential, err := m.webauthn.ValidateLogin(pu, *c.session, parsed)
+	if err != nil {
+		if lerr := m.failChallenge(ctx, c); lerr != nil {
+			return nil, lerr
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+
+	u, err := m.finishChallenge(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.updatePasskey(ctx, u.ID, credential); err != nil {
+		return nil, err
+	}
+
+	return &MFAResult{User: u}, nil
+}
+
+// passkeyUser loads a user with their passkeys
+func (m *Manager) passkeyUser(ctx context.Context, userID string) (*passkeyUser, error) {
+	u, err := m.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := m.pool.Query(ctx, `
+		SELECT credential FROM user_passkeys WHERE user_id = $1
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query passkeys: %w", err)
+	}
+	defer rows.Close()
+
+	pu := &passkeyUser{user: u}
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to scan passkey: %w", err)
+		}
+		var c webauthn.Credential
+		if err := json.Unmarshal(data, &c); err != nil {
+			return nil, fmt.Errorf("failed to decode passkey: %w", err)
+		}
+		pu.credentials = append(pu.credentials, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating passkeys: %w", err)
+	}
+
+	return pu, nil
+}
+
+// updatePasskey stores the signature counter of a passkey after a login and
+// records its use. A counter that went backwards means the passkey was cloned.
+func (m *Manager) updatePasskey(ctx context.Context, userID string, credential *webauthn.Credential) error {
+	if credential.Authenticator.CloneWarning {
+		return fmt.Errorf("%w: the passkey may have been cloned", ErrInvalidPasskey)
+	}
+
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return fmt.Errorf("failed to encode passkey: %w", err)
+	}
+	_, err = m.pool.Exec(ctx, `
+		UPDATE user_passkeys SET credential = $3, last_used_at = NOW()
+		WHERE id = $1 AND user_id = $2
+	`, base64.RawURLEncoding.EncodeToString(credential.ID), userID, data)
+	if err != nil {
+		return fmt.Errorf("failed to update passkey: %w", err)
+	}
+
+	return nil
+}
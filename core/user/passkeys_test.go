@@ -0,0 +1,86 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestPasskeysDisabled(t *testing.T) {
+	m := &Manager{}
+	ctx := context.Background()
+
+	if m.PasskeysEnabled() {
+		t.Fatal("expected passkeys to be disabled without a relying party")
+	}
+	if _, _, err := m.BeginPasskeyRegistration(ctx, "user"); !errors.Is(err, ErrPasskeysDisabled) {
+		t.Errorf("BeginPasskeyRegistration: expected ErrPasskeysDisabled, got %v", err)
+	}
+	if _, _, err := m.BeginPasskeyLogin(ctx); !errors.Is(err, ErrPasskeysDisabled) {
+		t.Errorf("BeginPasskeyLogin: expected ErrPasskeysDisabled, got %v", err)
+	}
+	if _, err := m.FinishPasskeyLogin(ctx, "token", nil); !errors.Is(err, ErrPasskeysDisabled) {
+		t.Errorf("FinishPasskeyLogin: expected ErrPasskeysDisabled, got %v", err)
+	}
+	if _, err := m.BeginPasskeyMFA(ctx, "token"); !errors.Is(err, ErrPasskeysDisabled) {
+		t.Errorf("BeginPasskeyMFA: expected ErrPasskeysDisabled, got %v", err)
+	}
+}
+
+func TestPasskeyChallenges(t *testing.T) {
+	m := newTestManager(t)
+	if err := m.ConfigureMFA(MFAConfig{PasskeyRPID: "localhost", PasskeyOrigins: []string{"http://localhost:8080"}}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	alice := createTestUser(t, m, "alice", "alice@example.com", RoleUser)
+	bob := createTestUser(t, m, "bob", "bob@example.com", RoleUser)
+
+	token, options, err := m.BeginPasskeyRegistration(ctx, alice.ID)
+	if err != nil {
+		t.Fatalf("BeginPasskeyRegistration: %v", err)
+	}
+	if options.Response.RelyingParty.ID != "localhost" {
+		t.Errorf("unexpected relying party %q", options.Response.RelyingParty.ID)
+	}
+
+	// A registration token is neither another user's nor a login challenge
+	if _, err := m.FinishPasskeyRegistration(ctx, bob.ID, token, "key", []byte(`{}`)); !errors.Is(err, ErrInvalidMFAChallenge) {
+		t.Errorf("expected another user's registration to be rejected, got %v", err)
+	}
+	if _, err := m.CompleteMFAWithCode(ctx, token, "000000"); !errors.Is(err, ErrInvalidMFAChallenge) {
+		t.Errorf("expected a registration token to be rejected as a login challenge, got %v", err)
+	}
+
+	// An invalid response uses up the registration
+	if _, err := m.FinishPasskeyRegistration(ctx, alice.ID, token, "key", []byte(`{}`)); !errors.Is(err, ErrInvalidPasskey) {
+		t.Errorf("expected ErrInvalidPasskey, got %v", err)
+	}
+	if _, err := m.FinishPasskeyRegistration(ctx, alice.ID, token, "key", []byte(`{}`)); !errors.Is(err, ErrInvalidMFAChallenge) {
+		t.Errorf("expected a used registration to be rejected, got %v", err)
+	}
+	if passkeys, err := m.ListPasskeys(ctx, alice.ID); err != nil || len(passkeys) != 0 {
+		t.Errorf("expected no passkeys, got %v, %v", passkeys, err)
+	}
+
+	// A user without passkeys cannot use one as their second factor
+	token, err = m.CreateMFAChallenge(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.BeginPasskeyMFA(ctx, token); !errors.Is(err, ErrMFANotEnabled) {
+		t.Errorf("expected ErrMFANotEnabled, got %v", err)
+	}
+
+	// A passkey login token is single-use whatever the response
+	token, _, err = m.BeginPasskeyLogin(ctx)
+	if err != nil {
+		t.Fatalf("BeginPasskeyLogin: %v", err)
+	}
+	if _, err := m.FinishPasskeyLogin(ctx, token, []byte(`{}`)); !errors.Is(err, ErrInvalidPasskey) {
+		t.Errorf("expected ErrInvalidPasskey, got %v", err)
+	}
+	if _, err := m.FinishPasskeyLogin(ctx, token, []byte(`{}`)); !errors.Is(err, ErrInvalidMFAChallenge) {
+		t.Errorf("expected a used passkey login to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrWeakPassword is returned when a new password is too short
+	ErrWeakPassword = errors.New("password must be at least 8 characters")
+
+	// ErrPasswordReused is returned when a password that must be changed is
+	// changed to itself
+	ErrPasswordReused = errors.New("new password must differ from the current one")
+)
+
+// MinPasswordLength is the shortest password users can choose
+const MinPasswordLength = 8
+
+// challengePasswordChange is the purpose of the challenge that finishes a login
+// with a new password
+const challengePasswordChange = "password_change"
+
+// initPasswordSchema adds the flag that forces a user to choose a new password
+func (m *Manager) initPasswordSchema(ctx context.Context) error {
+	_, err := m.pool.Exec(ctx, `
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS must_change_password BOOLEAN NOT NULL DEFAULT FALSE;
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to add must_change_password column: %w", err)
+	}
+
+	return nil
+}
+
+// CreatePasswordChangeChallenge starts the step of a login in which a user
+// whose password must be changed chooses a new one, and returns the token that
+// continues it
+func (m *Manager) CreatePasswordChangeChallenge(ctx context.Context, userID string) (string, error) {
+	return m.createChallenge(ctx, &userID, challengePasswordChange, nil)
+}
+
+// CompletePasswordChange sets the new password of a user who had to change it
+// and returns the user
+func (m *Manager) CompletePasswordChange(ctx context.Context, token, password string) (*User, error) {
+	c, err := m.getChallenge(ctx, token, challengePasswordChange)
+	if err != nil {
+		return nil, err
+	}
+	if err := validatePassword(password); err != nil {
+		return nil, err
+	}
+
+	u, err := m.GetUser(ctx, *c.userID)
+	if err != nil {
+		return nil, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) == nil {
+		return nil, ErrPasswordReused
+	}
+
+	if u, err = m.finishChallenge(ctx, c); err != nil {
+		return nil, err
+	}
+	if err := m.setPassword(ctx, u, password); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// setPassword replaces the password of a user and clears the need to change it
+func (m *Manager) setPassword(ctx context.Context, u *User, password string) error {
+	passwordHash, err := hashPassword(password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	_, err = m.pool.Exec(ctx, `
+		UPDATE users SET password_hash = $1, must_change_password = FALSE WHERE id = $2
+	`, passwordHash, u.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+
+	u.PasswordHash = passwordHash
+	u.MustChangePassword = false
+	return nil
+}
+
+// validatePassword checks that a password users chose is long enough
+func validatePassword(password string) error {
+	if len([]rune(password)) < MinPasswordLength {
+		return ErrWeakPassword
+	}
+
+	return nil
+}
+
+// generatePassword returns a random password for an account that must change
+// it at its first login
+func generatePassword() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate password: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
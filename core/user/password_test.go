@@ -0,0 +1,99 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestValidatePassword(t *testing.T) {
+	tests := []struct {
+		password string
+		valid    bool
+	}{
+		{"", false},
+		{"1234567", false},
+		{"12345678", true},
+		// Length is counted in characters, not bytes
+		{"ééééééé", false},
+		{"éééééééé", true},
+	}
+	for _, tt := range tests {
+		if err := validatePassword(tt.password); (err == nil) != tt.valid {
+			t.Errorf("%q: expected valid %v, got %v", tt.password, tt.valid, err)
+		}
+	}
+}
+
+func TestOneTimePasswordMustBeChanged(t *testing.T) {
+	m := newTestManager(t)
+	ctx := context.Background()
+
+	reset, err := m.ResetAdmin(ctx, "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := m.CreatePasswordChangeChallenge(ctx, reset.User.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rejected passwords leave the challenge usable
+	if _, err := m.CompletePasswordChange(ctx, token, "short"); !errors.Is(err, ErrWeakPassword) {
+		t.Errorf("expected ErrWeakPassword, got %v", err)
+	}
+	if _, err := m.CompletePasswordChange(ctx, token, reset.Password); !errors.Is(err, ErrPasswordReused) {
+		t.Errorf("expected ErrPasswordReused, got %v", err)
+	}
+
+	u, err := m.CompletePasswordChange(ctx, token, "a new password")
+	if err != nil {
+		t.Fatalf("CompletePasswordChange: %v", err)
+	}
+	if u.ID != reset.User.ID || u.MustChangePassword {
+		t.Errorf("expected the password change to be done, got %+v", u)
+	}
+	if _, err := m.CompletePasswordChange(ctx, token, "another password"); !errors.Is(err, ErrInvalidMFAChallenge) {
+		t.Errorf("expected a used challenge to be rejected, got %v", err)
+	}
+
+	if _, err := m.Authenticate(ctx, "root", reset.Password); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("expected the one-time password to stop working, got %v", err)
+	}
+	u, err = m.Authenticate(ctx, "root", "a new password")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if u.MustChangePassword {
+		t.Error("expected the password no longer to have to be changed")
+	}
+}
+
+func TestPasswordChangeChallengePurpose(t *testing.T) {
+	m := newTestManager(t)
+	ctx := context.Background()
+	alice := createTestUser(t, m, "alice", "alice@example.com", RoleUser)
+
+	// A second factor challenge cannot set a password, nor the other way round
+	mfaToken, err := m.CreateMFAChallenge(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.CompletePasswordChange(ctx, mfaToken, "a new password"); !errors.Is(err, ErrInvalidMFAChallenge) {
+		t.Errorf("expected an MFA challenge to be rejected, got %v", err)
+	}
+	passwordToken, err := m.CreatePasswordChangeChallenge(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.CompleteMFAWithCode(ctx, passwordToken, "000000"); !errors.Is(err, ErrInvalidMFAChallenge) {
+		t.Errorf("expected a password change challenge to be rejected, got %v", err)
+	}
+
+	if _, err := m.pool.Exec(ctx, `UPDATE mfa_challenges SET expires_at = NOW() - INTERVAL '1 second'`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.CompletePasswordChange(ctx, passwordToken, "a new password"); !errors.Is(err, ErrInvalidMFAChallenge) {
+		t.Errorf("expected an expired challenge to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrInvalidQuota is returned when a storage quota is negative
+var ErrInvalidQuota = errors.New("storage quota cannot be negative")
+
+// initQuotaSchema adds the storage quota of users
+func (m *Manager) initQuotaSchema(ctx context.Context) error {
+	_, err := m.pool.Exec(ctx, `
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS storage_quota BIGINT NOT NULL DEFAULT 0;
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to add storage_quota column: %w", err)
+	}
+
+	return nil
+}
+
+// StorageQuota returns the most bytes a user can store, or 0 if there is no
+// limit
+func (m *Manager) StorageQuota(ctx context.Context, id string) (int64, error) {
+	// Tokens can be issued for subjects that are not users
+	if _, err := uuid.Parse(id); err != nil {
+		return 0, ErrUserNotFound
+	}
+
+	var quota int64
+	err := m.pool.QueryRow(ctx, `SELECT storage_quota FROM users WHERE id = $1`, id).Scan(&quota)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrUserNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get storage quota: %w", err)
+	}
+
+	return quota, nil
+}
@@ -0,0 +1,156 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	// ErrInvalidUsername is returned when a username chosen by a new user has
+	// characters other than letters, digits, dots, dashes, underscores and @, or
+	// is not 3 to 64 characters long
+	ErrInvalidUsername = errors.New("username must be 3 to 64 letters, digits or . _ - @")
+
+	// ErrNotPendingApproval is returned when approving a user who did not
+	// register themselves or is already approved
+	ErrNotPendingApproval = errors.New("user is not awaiting approval")
+)
+
+// usernamePattern matches the usernames new users can choose
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._@-]{3,64}$`)
+
+// RegisterRequest represents a new user signing up themselves
+type RegisterRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Email    string `json:"email"`
+	FullName string `json:"full_name"`
+}
+
+// initRegistrationSchema adds the flag of users who signed up and wait for an
+// admin
+func (m *Manager) initRegistrationSchema(ctx context.Context) error {
+	_, err := m.pool.Exec(ctx, `
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS pending_approval BOOLEAN NOT NULL DEFAULT FALSE;
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to add pending_approval column: %w", err)
+	}
+
+	return nil
+}
+
+// Register creates an inactive account with the user role for someone signing
+// up. An admin activates it with ApproveUser. The email address is not stored,
+// since nothing proves it belongs to whoever signed up; it is set once the
+// link of EmailChangeToken sent to it is opened.
+func (m *Manager) Register(ctx context.Context, req RegisterRequest) (*User, error) {
+	u, err := newSignup(req)
+	if err != nil {
+		return nil, err
+	}
+	u.Email = ""
+	u.Role = RoleUser
+	u.PendingApproval = true
+
+	tx, err := m.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := insertSignup(ctx, tx, u); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit user: %w", err)
+	}
+	return u, nil
+}
+
+// ApproveUser activates the account of a user who signed up
+func (m *Manager) ApproveUser(ctx context.Context, id string) (*User, error) {
+	result, err := m.pool.Exec(ctx, `
+		UPDATE users SET active = TRUE, pending_approval = FALSE
+		WHERE id = $1 AND pending_approval
+	`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to approve user: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		if _, err := m.GetUser(ctx, id); err != nil {
+			return nil, err
+		}
+		return nil, ErrNotPendingApproval
+	}
+
+	return m.GetUser(ctx, id)
+}
+
+// newSignup validates what a new user chose and returns the user without a role
+func newSignup(req RegisterRequest) (*User, error) {
+	username := strings.TrimSpace(req.Username)
+	if !usernamePattern.MatchString(username) {
+		return nil, ErrInvalidUsername
+	}
+	if err := validatePassword(req.Password); err != nil {
+		return nil, err
+	}
+	email, err := normalizeEmail(req.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	passwordHash, err := hashPassword(req.Password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	return &User{
+		ID:           uuid.New().String(),
+		Username:     username,
+		Email:        email,
+		FullName:     strings.TrimSpace(req.FullName),
+		PasswordHash: passwordHash,
+		CreatedAt:    time.Now(),
+	}, nil
+}
+
+// insertSignup stores a new user, refusing usernames and email addresses in use
+func insertSignup(ctx context.Context, tx pgx.Tx, u *User) error {
+	var taken string
+	err := tx.QueryRow(ctx, `
+		SELECT CASE WHEN LOWER(username) = LOWER($1) THEN 'username' ELSE 'email' END
+		FROM users WHERE LOWER(username) = LOWER($1) OR ($2 <> '' AND LOWER(email) = LOWER($2))
+		LIMIT 1
+	`, u.Username, u.Email).Scan(&taken)
+	switch {
+	case err == nil && taken == "username":
+		return ErrUserAlreadyExists
+	case err == nil:
+		return ErrEmailTaken
+	case !errors.Is(err, pgx.ErrNoRows):
+		return fmt.Errorf("failed to check username: %w", err)
+	}
+
+	// Users without an address get NULL, which the unique constraint allows
+	// more than once
+	err = tx.QueryRow(ctx, `
+		INSERT INTO users (id, username, password_hash, email, full_name, role, created_at, active, pending_approval)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8, $9)
+		RETURNING created_at
+	`, u.ID, u.Username, u.PasswordHash, u.Email, u.FullName, u.Role, u.CreatedAt, !u.PendingApproval, u.PendingApproval).Scan(&u.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert user: %w", err)
+	}
+
+	u.Active = !u.PendingApproval
+	return nil
+}
@@ -0,0 +1,93 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRegisterAwaitsApproval(t *testing.T) {
+	m := newTestManager(t)
+	ctx := context.Background()
+
+	u, err := m.Register(ctx, signup("alice", "alice@example.com"))
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if u.Role != RoleUser || u.Active || !u.PendingApproval {
+		t.Errorf("expected an inactive user awaiting approval, got %+v", u)
+	}
+	if _, err := m.Register(ctx, signup("ALICE", "")); !errors.Is(err, ErrUserAlreadyExists) {
+		t.Errorf("expected ErrUserAlreadyExists, got %v", err)
+	}
+	if _, err := m.Register(ctx, signup("a", "")); !errors.Is(err, ErrInvalidUsername) {
+		t.Errorf("expected ErrInvalidUsername, got %v", err)
+	}
+
+	approved, err := m.ApproveUser(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("ApproveUser: %v", err)
+	}
+	if !approved.Active || approved.PendingApproval {
+		t.Errorf("expected an active user, got %+v", approved)
+	}
+	if _, err := m.ApproveUser(ctx, u.ID); !errors.Is(err, ErrNotPendingApproval) {
+		t.Errorf("expected approving twice to fail, got %v", err)
+	}
+
+	// Accounts made by admins were never waiting
+	bob := createTestUser(t, m, "bob", "bob@example.com", RoleUser)
+	if _, err := m.ApproveUser(ctx, bob.ID); !errors.Is(err, ErrNotPendingApproval) {
+		t.Errorf("expected ErrNotPendingApproval, got %v", err)
+	}
+	if _, err := m.ApproveUser(ctx, "00000000-0000-0000-0000-000000000000"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func TestRegisterEmailNeedsVerification(t *testing.T) {
+	m := newTestManager(t)
+	m.SetLinkKey([]byte("test key"))
+	ctx := context.Background()
+
+	u, err := m.Register(ctx, signup("mallory", "alice@example.com"))
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if u.Email != "" {
+		t.Fatalf("expected the address not to be stored, got %q", u.Email)
+	}
+	if _, err := m.ApproveUser(ctx, u.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// The identity provider's verified address does not lead to the sign-up
+	identity := ExternalIdentity{
+		Issuer: "https://id.example.com", Subject: "alice",
+		Username: "alice", Email: "alice@example.com", EmailVerified: true,
+	}
+	linked, err := m.ProvisionExternalUser(ctx, identity)
+	if err != nil {
+		t.Fatalf("ProvisionExternalUser: %v", err)
+	}
+	if linked.ID == u.ID {
+		t.Fatal("expected an unverified sign-up not to be linked")
+	}
+
+	// Opening the link sent to the address sets it
+	bob, err := m.Register(ctx, signup("bob", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, _, err := m.EmailChangeToken(ctx, bob.ID, "bob@example.com")
+	if err != nil {
+		t.Fatalf("EmailChangeToken: %v", err)
+	}
+	verified, err := m.VerifyEmail(ctx, token)
+	if err != nil {
+		t.Fatalf("VerifyEmail: %v", err)
+	}
+	if verified.Email != "bob@example.com" {
+		t.Errorf("expected bob@example.com, got %q", verified.Email)
+	}
+}
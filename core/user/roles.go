@@ -0,0 +1,301 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"pixie/auth"
+)
+
+var (
+	// ErrRoleNotFound is returned when a role does not exist
+	ErrRoleNotFound = errors.New("role not found")
+
+	// ErrRoleExists is returned when a role with the same name already exists
+	ErrRoleExists = errors.New("role already exists")
+
+	// ErrRoleInUse is returned when a role still assigned to users is deleted
+	ErrRoleInUse = errors.New("role is assigned to users")
+
+	// ErrInvalidRole is returned when a role has an invalid name or permission,
+	// or a built-in role is changed
+	ErrInvalidRole = errors.New("invalid role")
+)
+
+// RoleViewer is a read-only role, for accounts that may only look at photos
+const RoleViewer Role = "viewer"
+
+// roleNamePattern restricts the names of custom roles
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+
+// builtinRoles are the permissions of the roles that always exist
+var builtinRoles = map[Role][]string{
+	RoleAdmin: auth.Scopes,
+	RoleUser: {
+		auth.ScopePhotosRead,
+		auth.ScopePhotosWrite,
+		auth.ScopeAlbumsRead,
+		auth.ScopeAlbumsWrite,
+		auth.ScopeAlbumsShare,
+	},
+	RoleViewer: {
+		auth.ScopePhotosRead,
+		auth.ScopeAlbumsRead,
+	},
+}
+
+// builtinRoleDescriptions describe the built-in roles in role lists
+var builtinRoleDescriptions = map[Role]string{
+	RoleAdmin:  "Full access, including users and plugins",
+	RoleUser:   "Upload and organize photos, and delete their own",
+	RoleViewer: "View photos and albums only",
+}
+
+// RoleDefinition describes a role and the scopes it grants
+type RoleDefinition struct {
+	Name        Role       `json:"name"`
+	Description string     `json:"description"`
+	Permissions []string   `json:"permissions"`
+	Builtin     bool       `json:"builtin"`
+	RequireMFA  bool       `json:"require_mfa"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+}
+
+// CreateRoleRequest represents a request to create a custom role
+type CreateRoleRequest struct {
+	Name        Role     `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// UpdateRoleRequest represents a request to update a custom role
+type UpdateRoleRequest struct {
+	Description *string   `json:"description"`
+	Permissions *[]string `json:"permissions"`
+}
+
+// initRoleSchema creates the table of custom roles
+func (m *Manager) initRoleSchema(ctx context.Context) error {
+	_, err := m.pool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS roles (
+			name TEXT PRIMARY KEY,
+			description TEXT NOT NULL DEFAULT '',
+			permissions TEXT[] NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create roles table: %w", err)
+	}
+
+	return nil
+}
+
+// IsBuiltinRole reports whether a role is one of the roles that always exist
+func IsBuiltinRole(role Role) bool {
+	_, ok := builtinRoles[role]
+	return ok
+}
+
+// ListRoles returns the built-in roles followed by the custom roles by name
+func (m *Manager) ListRoles(ctx context.Context) ([]RoleDefinition, error) {
+	roles := []RoleDefinition{}
+	for _, name := range []Role{RoleAdmin, RoleUser, RoleViewer} {
+		roles = append(roles, builtinRole(name))
+	}
+
+	rows, err := m.pool.Query(ctx, `
+		SELECT name, description, permissions, created_at FROM roles ORDER BY name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query roles: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var role RoleDefinition
+		if err := rows.Scan(&role.Name, &role.Description, &role.Permissions, &role.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan role: %w", err)
+		}
+		roles = append(roles, role)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating roles: %w", err)
+	}
+
+	for i := range roles {
+		if roles[i].RequireMFA, err = m.MFARequired(ctx, roles[i].Name); err != nil {
+			return nil, err
+		}
+	}
+
+	return roles, nil
+}
+
+// GetRole returns a built-in or custom role
+func (m *Manager) GetRole(ctx context.Context, name Role) (*RoleDefinition, error) {
+	if IsBuiltinRole(name) {
+		role := builtinRole(name)
+		return &role, nil
+	}
+
+	var role RoleDefinition
+	err := m.pool.QueryRow(ctx, `
+		SELECT name, description, permissions, created_at FROM roles WHERE name = $1
+	`, name).Scan(&role.Name, &role.Description, &role.Permissions, &role.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrRoleNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get role: %w", err)
+	}
+
+	return &role, nil
+}
+
+// CreateRole creates a custom role. Its name may not be that of a built-in role.
+func (m *Manager) CreateRole(ctx context.Context, req CreateRoleRequest) (*RoleDefinition, error) {
+	if !roleNamePattern.MatchString(string(req.Name)) {
+		return nil, fmt.Errorf("%w: name must be lowercase letters, digits, - or _", ErrInvalidRole)
+	}
+	if IsBuiltinRole(req.Name) {
+		return nil, ErrRoleExists
+	}
+	permissions, err := validatePermissions(req.Permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	role := RoleDefinition{
+		Name:        req.Name,
+		Description: req.Description,
+		Permissions: permissions,
+	}
+	err = m.pool.QueryRow(ctx, `
+		INSERT INTO roles (name, description, permissions)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO NOTHING
+		RETURNING created_at
+	`, role.Name, role.Description, role.Permissions).Scan(&role.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrRoleExists
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create role: %w", err)
+	}
+
+	return &role, nil
+}
+
+// UpdateRole updates a custom role. Users with the role are granted the new
+// permissions with their next request.
+func (m *Manager) UpdateRole(ctx context.Context, name Role, req UpdateRoleRequest) (*RoleDefinition, error) {
+	if IsBuiltinRole(name) {
+		return nil, fmt.Errorf("%w: built-in roles cannot be changed", ErrInvalidRole)
+	}
+
+	role, err := m.GetRole(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Description != nil {
+		role.Description = *req.Description
+	}
+	if req.Permissions != nil {
+		permissions, err := validatePermissions(*req.Permissions)
+		if err != nil {
+			return nil, err
+		}
+		role.Permissions = permissions
+	}
+
+	_, err = m.pool.Exec(ctx, `
+		UPDATE roles SET description = $1, permissions = $2 WHERE name = $3
+	`, role.Description, role.Permissions, role.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update role: %w", err)
+	}
+
+	return role, nil
+}
+
+// DeleteRole deletes a custom role that is not assigned to any user
+func (m *Manager) DeleteRole(ctx context.Context, name Role) error {
+	if IsBuiltinRole(name) {
+		return fmt.Errorf("%w: built-in roles cannot be deleted", ErrInvalidRole)
+	}
+
+	result, err := m.pool.Exec(ctx, `
+		DELETE FROM roles
+		WHERE name = $1 AND NOT EXISTS (SELECT 1 FROM users WHERE role = $1)
+	`, name)
+	if err != nil {
+		return fmt.Errorf("failed to delete role: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		if _, err := m.GetRole(ctx, name); err != nil {
+			return err
+		}
+		return ErrRoleInUse
+	}
+
+	if _, err := m.pool.Exec(ctx, `DELETE FROM role_mfa WHERE role = $1`, name); err != nil {
+		return fmt.Errorf("failed to delete MFA policy: %w", err)
+	}
+
+	return nil
+}
+
+// ValidateRole returns ErrRoleNotFound unless a role can be assigned to users
+func (m *Manager) ValidateRole(ctx context.Context, name Role) error {
+	_, err := m.GetRole(ctx, name)
+	return err
+}
+
+// RolePermissions returns the scopes a role grants. Users without a role are
+// treated as having the user role, and roles that no longer exist grant
+// nothing.
+func (m *Manager) RolePermissions(ctx context.Context, role string) ([]string, error) {
+	if role == "" {
+		role = string(RoleUser)
+	}
+
+	def, err := m.GetRole(ctx, Role(role))
+	if errors.Is(err, ErrRoleNotFound) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return def.Permissions, nil
+}
+
+// builtinRole returns the definition of a built-in role
+func builtinRole(name Role) RoleDefinition {
+	return RoleDefinition{
+		Name:        name,
+		Description: builtinRoleDescriptions[name],
+		Permissions: builtinRoles[name],
+		Builtin:     true,
+	}
+}
+
+// validatePermissions checks that permissions are known scopes and returns them
+// with aliases expanded
+func validatePermissions(permissions []string) ([]string, error) {
+	for _, p := range permissions {
+		if !auth.IsScope(p) {
+			return nil, fmt.Errorf("%w: unknown permission %s", ErrInvalidRole, p)
+		}
+	}
+
+	return auth.ExpandScopes(permissions), nil
+}
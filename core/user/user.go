@@ -7,6 +7,7 @@ import (
 	"log"
 	"time"
 
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"golang.org/x/crypto/bcrypt"
@@ -34,6 +35,8 @@ const (
 	RoleUser Role = "user"
 )
 
+// The viewer role and custom roles are defined in roles.go
+
 // User represents a user in the system
 type User struct {
 	ID           string     `json:"id"`
@@ -45,6 +48,18 @@ type User struct {
 	CreatedAt    time.Time  `json:"created_at"`
 	LastLogin    *time.Time `json:"last_login,omitempty"`
 	Active       bool       `json:"active"`
+	LockedUntil  *time.Time `json:"locked_until,omitempty"`
+
+	// MustChangePassword is set for accounts with a one-time password
+	MustChangePassword bool `json:"must_change_password,omitempty"`
+
+	// PendingApproval is set for users who signed up and are not active until
+	// an admin approves them
+	PendingApproval bool `json:"pending_approval,omitempty"`
+
+	// StorageQuota is the most bytes of originals and derivatives the user can
+	// store; 0 means no limit
+	StorageQuota int64 `json:"storage_quota"`
 }
 
 // CreateUserRequest represents a request to create a new user
@@ -54,6 +69,9 @@ type CreateUserRequest struct {
 	Email    string `json:"email"`
 	FullName string `json:"full_name"`
 	Role     Role   `json:"role"`
+
+	// StorageQuota is in bytes; 0 means no limit
+	StorageQuota int64 `json:"storage_quota"`
 }
 
 // UpdateUserRequest represents a request to update an existing user
@@ -63,17 +81,32 @@ type UpdateUserRequest struct {
 	Password *string `json:"password"`
 	Role     *Role   `json:"role"`
 	Active   *bool   `json:"active"`
+
+	// StorageQuota is in bytes; 0 removes the limit
+	StorageQuota *int64 `json:"storage_quota"`
 }
 
 // Manager provides user management functionality
 type Manager struct {
 	pool *pgxpool.Pool
+
+	// mfaIssuer and webauthn configure second factors, see ConfigureMFA
+	mfaIssuer string
+	webauthn  *webauthn.WebAuthn
+
+	// lockout decides when accounts are locked, see SetLockoutPolicy
+	lockout LockoutPolicy
+
+	// linkKey signs password reset and email verification links, see SetLinkKey
+	linkKey []byte
 }
 
 // NewManager creates a new user manager
 func NewManager(pool *pgxpool.Pool) *Manager {
 	return &Manager{
-		pool: pool,
+		pool:      pool,
+		mfaIssuer: "Pixie",
+		lockout:   DefaultLockoutPolicy,
 	}
 }
 
@@ -97,6 +130,46 @@ func (m *Manager) InitSchema(ctx context.Context) error {
 		return fmt.Errorf("failed to create users table: %w", err)
 	}
 
+	// Failed logins lock accounts for a while
+	if err := m.initLockoutSchema(ctx); err != nil {
+		return err
+	}
+
+	// One-time passwords must be changed at the next login
+	if err := m.initPasswordSchema(ctx); err != nil {
+		return err
+	}
+
+	// Users who signed up wait for an admin
+	if err := m.initRegistrationSchema(ctx); err != nil {
+		return err
+	}
+
+	// Storage quotas
+	if err := m.initQuotaSchema(ctx); err != nil {
+		return err
+	}
+
+	// Invites to create an account with a role
+	if err := m.initInviteSchema(ctx); err != nil {
+		return err
+	}
+
+	// Users created by an identity provider are linked to it
+	if err := m.initIdentitySchema(ctx); err != nil {
+		return err
+	}
+
+	// Roles other than the built-in ones
+	if err := m.initRoleSchema(ctx); err != nil {
+		return err
+	}
+
+	// Second factors and the roles that require them
+	if err := m.initMFASchema(ctx); err != nil {
+		return err
+	}
+
 	// Check if there are any users
 	var userCount int
 	err = m.pool.QueryRow(ctx, "SELECT COUNT(*) FROM users").Scan(&userCount)
@@ -104,26 +177,11 @@ func (m *Manager) InitSchema(ctx context.Context) error {
 		return fmt.Errorf("failed to count users: %w", err)
 	}
 
-	// Create default admin user if there are no users
+	// Create the first admin, with a random password, if there are no users
 	if userCount == 0 {
-		adminID := uuid.New().String()
-		passwordHash, err := hashPassword("admin123")
-		if err != nil {
-			return fmt.Errorf("failed to hash password: %w", err)
+		if err := m.createInitialAdmin(ctx); err != nil {
+			return err
 		}
-
-		_, err = m.pool.Exec(ctx, `
-			INSERT INTO users (id, username, password_hash, email, full_name, role)
-			VALUES ($1, $2, $3, $4, $5, $6)
-		`, adminID, "admin", passwordHash, "admin@example.com", "Administrator", RoleAdmin)
-		if err != nil {
-			return fmt.Errorf("failed to create admin user: %w", err)
-		}
-
-		log.Println("===================================================================")
-		log.Println("Created default admin user: username=admin, password=admin123")
-		log.Println("Make sure to change this password after your first login!")
-		log.Println("===================================================================")
 	}
 
 	return nil
@@ -154,6 +212,12 @@ func (m *Manager) CreateUser(ctx context.Context, req CreateUserRequest) (*User,
 	if req.Role == "" {
 		req.Role = RoleUser
 	}
+	if err := m.ValidateRole(ctx, req.Role); err != nil {
+		return nil, err
+	}
+	if req.StorageQuota < 0 {
+		return nil, ErrInvalidQuota
+	}
 
 	// Create the user
 	user := &User{
@@ -165,13 +229,14 @@ func (m *Manager) CreateUser(ctx context.Context, req CreateUserRequest) (*User,
 		Role:         req.Role,
 		CreatedAt:    time.Now(),
 		Active:       true,
+		StorageQuota: req.StorageQuota,
 	}
 
 	// Insert the user into the database
 	_, err = m.pool.Exec(ctx, `
-		INSERT INTO users (id, username, password_hash, email, full_name, role, created_at, active)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-	`, user.ID, user.Username, user.PasswordHash, user.Email, user.FullName, user.Role, user.CreatedAt, user.Active)
+		INSERT INTO users (id, username, password_hash, email, full_name, role, created_at, active, storage_quota)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, user.ID, user.Username, user.PasswordHash, user.Email, user.FullName, user.Role, user.CreatedAt, user.Active, user.StorageQuota)
 	if err != nil {
 		return nil, fmt.Errorf("failed to insert user: %w", err)
 	}
@@ -183,11 +248,11 @@ func (m *Manager) CreateUser(ctx context.Context, req CreateUserRequest) (*User,
 func (m *Manager) GetUser(ctx context.Context, id string) (*User, error) {
 	var user User
 	err := m.pool.QueryRow(ctx, `
-		SELECT id, username, password_hash, email, full_name, role, created_at, last_login, active
+		SELECT id, username, password_hash, COALESCE(email, ''), COALESCE(full_name, ''), role, created_at, last_login, active, locked_until, must_change_password, pending_approval, storage_quota
 		FROM users WHERE id = $1
 	`, id).Scan(
 		&user.ID, &user.Username, &user.PasswordHash, &user.Email, &user.FullName,
-		&user.Role, &user.CreatedAt, &user.LastLogin, &user.Active,
+		&user.Role, &user.CreatedAt, &user.LastLogin, &user.Active, &user.LockedUntil, &user.MustChangePassword, &user.PendingApproval, &user.StorageQuota,
 	)
 	if err != nil {
 		return nil, ErrUserNotFound
@@ -200,11 +265,11 @@ func (m *Manager) GetUser(ctx context.Context, id string) (*User, error) {
 func (m *Manager) GetUserByUsername(ctx context.Context, username string) (*User, error) {
 	var user User
 	err := m.pool.QueryRow(ctx, `
-		SELECT id, username, password_hash, email, full_name, role, created_at, last_login, active
+		SELECT id, username, password_hash, COALESCE(email, ''), COALESCE(full_name, ''), role, created_at, last_login, active, locked_until, must_change_password, pending_approval, storage_quota
 		FROM users WHERE username = $1
 	`, username).Scan(
 		&user.ID, &user.Username, &user.PasswordHash, &user.Email, &user.FullName,
-		&user.Role, &user.CreatedAt, &user.LastLogin, &user.Active,
+		&user.Role, &user.CreatedAt, &user.LastLogin, &user.Active, &user.LockedUntil, &user.MustChangePassword, &user.PendingApproval, &user.StorageQuota,
 	)
 	if err != nil {
 		return nil, ErrUserNotFound
@@ -216,7 +281,7 @@ func (m *Manager) GetUserByUsername(ctx context.Context, username string) (*User
 // ListUsers retrieves all users
 func (m *Manager) ListUsers(ctx context.Context) ([]*User, error) {
 	rows, err := m.pool.Query(ctx, `
-		SELECT id, username, password_hash, email, full_name, role, created_at, last_login, active
+		SELECT id, username, password_hash, COALESCE(email, ''), COALESCE(full_name, ''), role, created_at, last_login, active, locked_until, must_change_password, pending_approval, storage_quota
 		FROM users
 		ORDER BY username
 	`)
@@ -230,7 +295,7 @@ func (m *Manager) ListUsers(ctx context.Context) ([]*User, error) {
 		var user User
 		err := rows.Scan(
 			&user.ID, &user.Username, &user.PasswordHash, &user.Email, &user.FullName,
-			&user.Role, &user.CreatedAt, &user.LastLogin, &user.Active,
+			&user.Role, &user.CreatedAt, &user.LastLogin, &user.Active, &user.LockedUntil, &user.MustChangePassword, &user.PendingApproval, &user.StorageQuota,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan user: %w", err)
@@ -261,11 +326,24 @@ func (m *Manager) UpdateUser(ctx context.Context, id string, req UpdateUserReque
 		user.FullName = *req.FullName
 	}
 	if req.Role != nil {
+		if err := m.ValidateRole(ctx, *req.Role); err != nil {
+			return nil, err
+		}
 		user.Role = *req.Role
 	}
 	if req.Active != nil {
 		user.Active = *req.Active
 	}
+	if req.StorageQuota != nil {
+		if *req.StorageQuota < 0 {
+			return nil, ErrInvalidQuota
+		}
+		user.StorageQuota = *req.StorageQuota
+	}
+	// Activating a user who signed up approves them
+	if user.Active {
+		user.PendingApproval = false
+	}
 	if req.Password != nil {
 		passwordHash, err := hashPassword(*req.Password)
 		if err != nil {
@@ -277,9 +355,10 @@ func (m *Manager) UpdateUser(ctx context.Context, id string, req UpdateUserReque
 	// Update the user in the database
 	_, err = m.pool.Exec(ctx, `
 		UPDATE users
-		SET email = $1, full_name = $2, role = $3, active = $4, password_hash = $5
+		SET email = $1, full_name = $2, role = $3, active = $4, password_hash = $5,
+			pending_approval = pending_approval AND NOT $4, storage_quota = $7
 		WHERE id = $6
-	`, user.Email, user.FullName, user.Role, user.Active, user.PasswordHash, user.ID)
+	`, user.Email, user.FullName, user.Role, user.Active, user.PasswordHash, user.ID, user.StorageQuota)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update user: %w", err)
 	}
@@ -312,6 +391,11 @@ func (m *Manager) Authenticate(ctx context.Context, username, password string) (
 		return nil, ErrInvalidCredentials
 	}
 
+	// A locked account is not even checked, so guesses cannot continue
+	if err := lockedError(user.LockedUntil); err != nil {
+		return nil, err
+	}
+
 	// Log password details for debugging
 	log.Printf("Attempting to verify password for user: %s", username)
 	log.Printf("Stored password hash length: %d", len(user.PasswordHash))
@@ -320,6 +404,9 @@ func (m *Manager) Authenticate(ctx context.Context, username, password string) (
 	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
 	if err != nil {
 		log.Printf("Password verification failed: %v", err)
+		if err := m.recordLoginFailure(ctx, user.ID); err != nil {
+			return nil, err
+		}
 		return nil, ErrInvalidCredentials
 	}
 	
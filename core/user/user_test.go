@@ -0,0 +1,76 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// newTestManager returns a manager with its schema in a fresh Postgres schema
+// of the database in PIXIE_TEST_DATABASE_URL, and skips the test without one
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	url := os.Getenv("PIXIE_TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("PIXIE_TEST_DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+
+	conn, err := pgx.Connect(ctx, url)
+	if err != nil {
+		t.Fatalf("failed to connect to the test database: %v", err)
+	}
+	defer conn.Close(ctx)
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if _, err := conn.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ConnConfig.RuntimeParams["search_path"] = schema
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		pool.Close()
+		conn, err := pgx.Connect(ctx, url)
+		if err != nil {
+			t.Logf("failed to drop %s: %v", schema, err)
+			return
+		}
+		defer conn.Close(ctx)
+		if _, err := conn.Exec(ctx, "DROP SCHEMA "+schema+" CASCADE"); err != nil {
+			t.Logf("failed to drop %s: %v", schema, err)
+		}
+	})
+
+	m := NewManager(pool)
+	if err := m.InitSchema(ctx); err != nil {
+		t.Fatalf("InitSchema: %v", err)
+	}
+	return m
+}
+
+// createTestUser creates an active user with a password
+func createTestUser(t *testing.T, m *Manager, username, email string, role Role) *User {
+	t.Helper()
+	u, err := m.CreateUser(context.Background(), CreateUserRequest{
+		Username: username,
+		Password: "correct horse battery",
+		Email:    email,
+		Role:     role,
+	})
+	if err != nil {
+		t.Fatalf("CreateUser %s: %v", username, err)
+	}
+	return u
+}
@@ -4,14 +4,18 @@
  * Utility to generate a JWT token for API testing
  * 
  * Usage:
- *   node generate-token.js [subject]
+ *   PIXIE_TOKEN=<admin token or API key> node generate-token.js [subject]
  * 
  * Where:
  *   subject - Optional user ID (defaults to "test-user")
+ *
+ * Only admins may generate tokens. For scripts, prefer an API key created with
+ * POST /api/auth/keys.
  */
 
 // Configuration
 const API_BASE = process.env.API_BASE || 'http://localhost:8080';
+const PIXIE_TOKEN = process.env.PIXIE_TOKEN || '';
 
 // Use the built-in fetch API for Node.js (requires Node.js 18+)
 const generateToken = async (subject) => {
@@ -21,7 +25,8 @@ const generateToken = async (subject) => {
     const response = await fetch(`${API_BASE}/api/auth/token`, {
       method: 'POST',
       headers: {
-        'Content-Type': 'application/json'
+        'Content-Type': 'application/json',
+        'Authorization': `Bearer ${PIXIE_TOKEN}`
       },
       body: JSON.stringify({
         subject: subject
@@ -1,32 +1,53 @@
 import { useState, lazy, Suspense, useEffect } from 'react';
-import { Photo } from './api';
+import { Photo, logout, refreshSession, hasScope } from './api';
 import LoginForm from './components/LoginForm';
 import Gallery from './components/Gallery';
 import UploadButton from './components/UploadButton';
 import Header from './components/Header';
-import SideNav from './components/SideNav';
+import SideNav, { View } from './components/SideNav';
 import { SearchProvider, useSearch } from './context/SearchContext';
 import { AlbumsProvider } from './context/AlbumsContext';
 import AlbumsPage from './components/pages/AlbumsPage';
 import TrashPage from './components/pages/TrashPage';
 import AdminPage from './components/pages/AdminPage';
+import AccountPage from './components/pages/AccountPage';
+import SecurityPage from './components/pages/SecurityPage';
+import PluginPage from './components/pages/PluginPage';
+import { PluginUIExtension, getPluginUIExtensions, pluginView } from './api/plugins';
+import { readOIDCRedirect } from './api/users';
+import { AccountLink, readAccountLink } from './api/account';
 
 // Lazy load the Lightbox component to reduce initial bundle size
 const Lightbox = lazy(() => import('./components/Lightbox'));
 
 function AppContent() {
   const [authenticated, setAuthenticated] = useState(false);
+  const [loginError, setLoginError] = useState<string | null>(null);
+  const [accountLink, setAccountLink] = useState<AccountLink | null>(null);
+  const [oidcLoginToken, setOIDCLoginToken] = useState<string | null>(null);
   
-  // Clear any existing tokens and force login when app starts
+  // Continue a single sign-on on the login page, or resume the previous session
+  // if its refresh token is still valid. Links from account email are opened on
+  // the login page.
   useEffect(() => {
-    // Remove any existing token to force authentication
-    localStorage.removeItem('token');
-    setAuthenticated(false);
+    const link = readAccountLink();
+    if (link) {
+      setAccountLink(link);
+      return;
+    }
+    const sso = readOIDCRedirect();
+    if (sso.loginToken || sso.error) {
+      setOIDCLoginToken(sso.loginToken);
+      setLoginError(sso.error);
+      return;
+    }
+    refreshSession().then(setAuthenticated);
   }, []);
   const [selectedPhoto, setSelectedPhoto] = useState<Photo | null>(null);
   const [newPhoto, setNewPhoto] = useState<Photo | null>(null);
   const [sidebarOpen, setSidebarOpen] = useState(false);
-  const [activeView, setActiveView] = useState<'photos' | 'albums' | 'trash' | 'admin'>('photos');
+  const [activeView, setActiveView] = useState<View>('photos');
+  const [pluginExtensions, setPluginExtensions] = useState<PluginUIExtension[]>([]);
   // Use this to trigger a refresh when a photo is trashed
   const [galleryRefreshTrigger, setGalleryRefreshTrigger] = useState<number>(0);
   const { searchQuery, setSearchQuery } = useSearch();
@@ -54,9 +75,9 @@ function AppContent() {
     }
   };
 
-  const handleLogout = () => {
-    // Clear the token from localStorage
-    localStorage.removeItem('token');
+  const handleLogout = async () => {
+    // End the session on the server and clear the tokens
+    await logout();
     setAuthenticated(false);
   };
 
@@ -64,7 +85,7 @@ function AppContent() {
     setSidebarOpen(!sidebarOpen);
   };
 
-  const handleNavigation = (view: 'photos' | 'albums' | 'trash' | 'admin') => {
+  const handleNavigation = (view: View) => {
     setActiveView(view);
     if (window.innerWidth < 1024) { // On mobile
       setSidebarOpen(false);
@@ -88,8 +109,19 @@ function AppContent() {
     return () => window.removeEventListener('resize', handleResize);
   }, []);
 
+  // Load the pages contributed by plugins once logged in
+  useEffect(() => {
+    if (!authenticated) {
+      setPluginExtensions([]);
+      return;
+    }
+    getPluginUIExtensions()
+      .then(setPluginExtensions)
+      .catch((error) => console.error('Failed to load plugin extensions:', error));
+  }, [authenticated]);
+
   if (!authenticated) {
-    return <LoginForm onLoginSuccess={() => setAuthenticated(true)} />;
+    return <LoginForm onLoginSuccess={() => setAuthenticated(true)} initialError={loginError} accountLink={accountLink} oidcLoginToken={oidcLoginToken} />;
   }
 
   return (
@@ -107,6 +139,7 @@ function AppContent() {
         onClose={() => setSidebarOpen(false)}
         activeView={activeView}
         onNavigate={handleNavigation}
+        pluginExtensions={pluginExtensions}
       />
       
       {/* Main Content */}
@@ -118,7 +151,7 @@ function AppContent() {
                 <h2 className="text-2xl font-medium text-gray-800">
                   {searchQuery ? `Search results for "${searchQuery}"` : "My Photos"}
                 </h2>
-                <UploadButton onUploadSuccess={handleUploadSuccess} />
+                {hasScope('photos:write') && <UploadButton onUploadSuccess={handleUploadSuccess} />}
               </div>
               
               <Gallery 
@@ -136,9 +169,22 @@ function AppContent() {
           {activeView === 'trash' && (
             <TrashPage onPhotoClick={handlePhotoClick} />
           )}
+          {activeView === 'account' && (
+            <AccountPage />
+          )}
+          {activeView === 'security' && (
+            <SecurityPage />
+          )}
           {activeView === 'admin' && (
             <AdminPage />
           )}
+          {pluginExtensions.map((extension) =>
+            extension.menu.map((entry, index) =>
+              activeView === pluginView(extension.plugin, index) && (
+                <PluginPage key={activeView} extension={extension} entry={entry} />
+              )
+            )
+          )}
         </div>
       </main>
       
@@ -22,10 +22,20 @@ export interface Photo {
   created_at: string;
   deleted_at?: string;
   status?: string;
+  // The user who uploaded the photo, missing for photos uploaded before owners were recorded
+  owner_id?: string;
   meta?: {
+    // Set by photos processed before plugins had their own namespace
     thumbnails?: {
       [size: string]: string;
     };
+    plugins?: {
+      thumbnailer?: {
+        thumbnails?: {
+          [size: string]: string;
+        };
+      };
+    };
   };
 }
 
@@ -36,6 +46,40 @@ export const getToken = (): string | null => {
   return localStorage.getItem('token');
 };
 
+/**
+ * Check whether the current token grants a scope, such as photos:delete. The
+ * server enforces scopes; this only decides what the UI offers.
+ */
+export const hasScope = (scope: string): boolean => {
+  const scopes: unknown = tokenPayload()?.custom?.scopes;
+  return Array.isArray(scopes) && scopes.includes(scope);
+};
+
+/**
+ * Check whether the current user may trash, restore and delete a photo: their
+ * own photos with photos:write, anyone's with photos:delete
+ */
+export const canChangePhoto = (photo: Photo): boolean => {
+  if (hasScope('photos:delete')) return true;
+  return hasScope('photos:write') && !!photo.owner_id && photo.owner_id === tokenPayload()?.sub;
+};
+
+/**
+ * Parse the payload of the current token, without validating it
+ */
+const tokenPayload = (): { sub?: string; custom?: { scopes?: unknown } } | null => {
+  const token = getToken();
+  if (!token) return null;
+
+  try {
+    const base64 = token.split('.')[1].replace(/-/g, '+').replace(/_/g, '/');
+    return JSON.parse(window.atob(base64));
+  } catch (error) {
+    console.error('Error parsing JWT token:', error);
+    return null;
+  }
+};
+
 /**
  * Set the JWT token in localStorage
  */
@@ -44,10 +88,69 @@ export const setToken = (token: string): void => {
 };
 
 /**
- * Clear the JWT token from localStorage
+ * Store the session of a login or refresh: the access token, the single-use
+ * refresh token and the session ID
+ */
+export const setSession = (session: { token: string; refresh_token: string; session_id: string }): void => {
+  localStorage.setItem('token', session.token);
+  localStorage.setItem('refresh_token', session.refresh_token);
+  localStorage.setItem('session_id', session.session_id);
+};
+
+/**
+ * Clear the JWT token and session from localStorage
  */
 export const clearToken = (): void => {
   localStorage.removeItem('token');
+  localStorage.removeItem('refresh_token');
+  localStorage.removeItem('session_id');
+};
+
+// The refresh in progress, shared so a refresh token is only used once
+let refreshing: Promise<boolean> | null = null;
+
+/**
+ * Exchange the refresh token for a new access token. Resolves to false if the
+ * session has ended.
+ */
+export const refreshSession = (): Promise<boolean> => {
+  if (!refreshing) {
+    refreshing = (async () => {
+      const refreshToken = localStorage.getItem('refresh_token');
+      if (!refreshToken) {
+        return false;
+      }
+      const response = await fetch(`${API_BASE}/api/auth/refresh`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ refresh_token: refreshToken }),
+      });
+      if (!response.ok) {
+        clearToken();
+        return false;
+      }
+      setSession(await response.json());
+      return true;
+    })().finally(() => {
+      refreshing = null;
+    });
+  }
+  return refreshing;
+};
+
+/**
+ * End the current session on the server and forget its tokens
+ */
+export const logout = async (): Promise<void> => {
+  const sessionId = localStorage.getItem('session_id');
+  if (sessionId) {
+    try {
+      await fetchWithAuth(`/api/auth/sessions/${sessionId}`, { method: 'DELETE' });
+    } catch (error) {
+      console.error('Failed to end session:', error);
+    }
+  }
+  clearToken();
 };
 
 /**
@@ -58,22 +161,31 @@ export const isAuthenticated = (): boolean => {
 };
 
 /**
- * Fetch wrapper that adds the Authorization header with the JWT token
+ * Fetch wrapper that adds the Authorization header with the JWT token and
+ * retries once with a refreshed token if it has expired
  */
 export const fetchWithAuth = async (
   url: string,
   options: RequestInit = {}
 ): Promise<Response> => {
-  const token = getToken();
-  const headers = {
-    ...options.headers,
-    ...(token ? { Authorization: `Bearer ${token}` } : {}),
+  const send = () => {
+    const token = getToken();
+    const headers = {
+      ...options.headers,
+      ...(token ? { Authorization: `Bearer ${token}` } : {}),
+    };
+
+    return fetch(`${API_BASE}${url}`, {
+      ...options,
+      headers,
+    });
   };
 
-  return fetch(`${API_BASE}${url}`, {
-    ...options,
-    headers,
-  });
+  const response = await send();
+  if (response.status === 401 && (await refreshSession())) {
+    return send();
+  }
+  return response;
 };
 
 /**
@@ -90,6 +202,11 @@ export const getPhotos = async (): Promise<Photo[]> => {
   return data.photos;
 };
 
+/**
+ * Error of an upload that does not fit the user's storage quota
+ */
+export class QuotaExceededError extends Error {}
+
 /**
  * Upload a photo
  */
@@ -102,6 +219,10 @@ export const uploadPhoto = async (file: File): Promise<Photo> => {
     body: formData,
   });
   
+  // 413 and 507 mean the file does not fit the user's storage quota
+  if (response.status === 413 || response.status === 507) {
+    throw new QuotaExceededError((await response.text()).trim());
+  }
   if (!response.ok) {
     throw new Error(`Failed to upload photo: ${response.statusText}`);
   }
@@ -207,7 +328,8 @@ export const getPhotoUrl = (id: string): string => {
 export const getThumbnailUrl = (photo: Photo, size: number = 512): string => {
   try {
     // Safely check if the photo has thumbnails for the requested size
-    if (photo && photo.meta && photo.meta.thumbnails && photo.meta.thumbnails[size.toString()]) {
+    const thumbnails = photo?.meta?.plugins?.thumbnailer?.thumbnails ?? photo?.meta?.thumbnails;
+    if (thumbnails && thumbnails[size.toString()]) {
       // Return the thumbnail URL - auth header will be added by the fetch interceptor
       return `${API_BASE}/api/photo/${photo.id}?thumbnail=${size}`;
     }
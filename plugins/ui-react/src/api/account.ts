@@ -0,0 +1,146 @@
+import { fetchWithAuth } from '../api';
+import { User } from './users';
+
+// Get API base URL from environment or use default
+const API_BASE = import.meta.env.VITE_API_BASE || '';
+
+/**
+ * Link from an account email: a password reset, an email verification or an
+ * invite
+ */
+export interface AccountLink {
+  type: 'reset_password' | 'verify_email' | 'invite';
+  token: string;
+}
+
+/**
+ * Password reset link created by an admin
+ */
+export interface PasswordReset {
+  reset_url: string;
+  expires_at: string;
+  emailed: boolean;
+}
+
+/**
+ * Throw the error message of a failed response
+ */
+const checkResponse = async (response: Response, action: string): Promise<Response> => {
+  if (!response.ok) {
+    const errorText = await response.text();
+    throw new Error(errorText.trim() || `Failed to ${action}: ${response.statusText}`);
+  }
+  return response;
+};
+
+/**
+ * Send JSON to an endpoint of the current user
+ */
+const sendAuth = async (path: string, method: string, body: unknown, action: string): Promise<any> => {
+  const response = await fetchWithAuth(path, {
+    method,
+    headers: {
+      'Content-Type': 'application/json',
+    },
+    body: JSON.stringify(body),
+  });
+  await checkResponse(response, action);
+  return response.json();
+};
+
+/**
+ * POST JSON to a public endpoint for links from account email
+ */
+const postLink = async (path: string, body: unknown, action: string): Promise<any> => {
+  const response = await fetch(`${API_BASE}/api/auth${path}`, {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+    },
+    body: JSON.stringify(body),
+  });
+  await checkResponse(response, action);
+  return response.json();
+};
+
+/**
+ * Take the token of a link from account email out of the URL fragment
+ */
+export const readAccountLink = (): AccountLink | null => {
+  const params = new URLSearchParams(window.location.hash.slice(1));
+  for (const type of ['reset_password', 'verify_email', 'invite'] as const) {
+    const token = params.get(type);
+    if (token) {
+      // Keep the token out of the history
+      window.history.replaceState(null, '', window.location.pathname + window.location.search);
+      return { type, token };
+    }
+  }
+  return null;
+};
+
+/**
+ * Get the profile of the current user
+ */
+export const getProfile = async (): Promise<User> => {
+  const response = await fetchWithAuth('/api/me');
+  await checkResponse(response, 'load profile');
+  return response.json();
+};
+
+/**
+ * Update the profile of the current user
+ */
+export const updateProfile = (fullName: string): Promise<User> =>
+  sendAuth('/api/me', 'PUT', { full_name: fullName }, 'update profile');
+
+/**
+ * Change the password of the current user, ending their other sessions.
+ * Resolves to the number of sessions ended.
+ */
+export const changePassword = async (currentPassword: string, newPassword: string): Promise<number> => {
+  const data = await sendAuth(
+    '/api/me/password',
+    'POST',
+    { current_password: currentPassword, new_password: newPassword },
+    'change password'
+  );
+  return data.sessions_revoked;
+};
+
+/**
+ * Send a verification link to a new email address of the current user
+ */
+export const requestEmailChange = async (email: string): Promise<string> => {
+  const data = await sendAuth('/api/me/email', 'POST', { email }, 'change email');
+  return data.email;
+};
+
+/**
+ * Confirm a new email address with the token of its verification link
+ */
+export const verifyEmail = async (token: string): Promise<string> => {
+  const data = await postLink('/email/verify', { token }, 'verify email');
+  return data.email;
+};
+
+/**
+ * Choose a new password with the token of a reset link. Resolves to the
+ * username.
+ */
+export const resetPassword = async (token: string, newPassword: string): Promise<string> => {
+  const data = await postLink('/password/reset', { token, new_password: newPassword }, 'reset password');
+  return data.username;
+};
+
+/**
+ * Create a password reset link for a user and email it if they have an
+ * address (admin only)
+ */
+export const sendPasswordReset = async (id: string): Promise<PasswordReset> => {
+  const response = await fetchWithAuth(`/api/users/${id}/password-reset`, {
+    method: 'POST',
+  });
+  await checkResponse(response, 'create reset link');
+  return response.json();
+};
@@ -0,0 +1,159 @@
+import { fetchWithAuth } from '../api';
+import { User, UserRole } from './users';
+
+// Get API base URL from environment or use default
+const API_BASE = import.meta.env.VITE_API_BASE || '';
+
+/**
+ * Invite that lets one person create an account with a role
+ */
+export interface Invite {
+  id: string;
+  role: UserRole;
+  email?: string;
+  created_by?: string;
+  created_at: string;
+  expires_at: string;
+  used_at?: string;
+  used_by?: string;
+}
+
+/**
+ * Invite just created, with the link to send to the invitee
+ */
+export interface CreatedInvite {
+  invite: Invite;
+  invite_url: string;
+  emailed: boolean;
+}
+
+/**
+ * Create invite request
+ */
+export interface CreateInviteRequest {
+  role: UserRole;
+  email?: string;
+  expires_at?: string;
+}
+
+/**
+ * Account chosen by someone signing up or accepting an invite
+ */
+export interface SignupRequest {
+  username: string;
+  password: string;
+  email?: string;
+  full_name?: string;
+}
+
+/**
+ * Throw the error message of a failed response
+ */
+const checkResponse = async (response: Response, action: string): Promise<Response> => {
+  if (!response.ok) {
+    const errorText = await response.text();
+    throw new Error(errorText.trim() || `Failed to ${action}: ${response.statusText}`);
+  }
+  return response;
+};
+
+/**
+ * POST JSON to a public sign-up endpoint
+ */
+const postSignup = async (path: string, body: unknown, action: string): Promise<any> => {
+  const response = await fetch(`${API_BASE}/api/auth${path}`, {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+    },
+    body: JSON.stringify(body),
+  });
+  await checkResponse(response, action);
+  return response.json();
+};
+
+/**
+ * Get the invites that have not expired (admin only)
+ */
+export const getInvites = async (): Promise<Invite[]> => {
+  const response = await fetchWithAuth('/api/invites');
+  await checkResponse(response, 'fetch invites');
+  const data = await response.json();
+  return data.invites;
+};
+
+/**
+ * Create an invite, emailed if it has an address (admin only)
+ */
+export const createInvite = async (request: CreateInviteRequest): Promise<CreatedInvite> => {
+  const response = await fetchWithAuth('/api/invites', {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+    },
+    body: JSON.stringify(request),
+  });
+  await checkResponse(response, 'create invite');
+  return response.json();
+};
+
+/**
+ * Revoke an invite (admin only)
+ */
+export const deleteInvite = async (id: string): Promise<void> => {
+  const response = await fetchWithAuth(`/api/invites/${id}`, {
+    method: 'DELETE',
+  });
+  await checkResponse(response, 'revoke invite');
+};
+
+/**
+ * Activate the account of someone who signed up (admin only)
+ */
+export const approveUser = async (id: string): Promise<User> => {
+  const response = await fetchWithAuth(`/api/users/${id}/approve`, {
+    method: 'POST',
+  });
+  await checkResponse(response, 'approve user');
+  return response.json();
+};
+
+/**
+ * Get whether people can sign up without an invite
+ */
+export const getRegistrationConfig = async (): Promise<boolean> => {
+  const response = await fetch(`${API_BASE}/api/auth/register`);
+  if (!response.ok) {
+    return false;
+  }
+  const data = await response.json();
+  return data.enabled;
+};
+
+/**
+ * Account created by signing up. An email address other than the invited one
+ * is only set once the link sent to it is opened.
+ */
+export interface SignupResult {
+  message: string;
+  verificationSent: boolean;
+}
+
+/**
+ * Create an account with the token of an invite link
+ */
+export const acceptInvite = async (token: string, request: SignupRequest): Promise<SignupResult> => {
+  const data = await postSignup('/invites/accept', { token, ...request }, 'accept invite');
+  return {
+    message: 'Your account was created. Sign in with your new password',
+    verificationSent: data.verification_sent,
+  };
+};
+
+/**
+ * Sign up for an account that an admin has to approve
+ */
+export const register = async (request: SignupRequest): Promise<SignupResult> => {
+  const data = await postSignup('/register', request, 'sign up');
+  return { message: data.message, verificationSent: data.verification_sent };
+};
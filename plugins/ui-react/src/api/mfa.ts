@@ -0,0 +1,324 @@
+import { fetchWithAuth } from '../api';
+import { LoginResponse } from './users';
+
+// Get API base URL from environment or use default
+const API_BASE = import.meta.env.VITE_API_BASE || '';
+
+/**
+ * Challenge returned by a correct password when a second factor is needed
+ */
+export interface MFAChallenge {
+  mfa_required: true;
+  mfa_token: string;
+  methods: ('totp' | 'recovery_code' | 'passkey')[];
+  enrollment_required: boolean;
+  expires_in: number;
+}
+
+/**
+ * Second factors of the current user
+ */
+export interface MFAStatus {
+  totp: boolean;
+  passkeys: number;
+  recovery_codes: number;
+  required: boolean;
+  passkeys_enabled: boolean;
+}
+
+/**
+ * Secret of a pending TOTP enrolment, for authenticator apps
+ */
+export interface TOTPEnrollment {
+  secret: string;
+  url: string;
+}
+
+/**
+ * Registered passkey
+ */
+export interface Passkey {
+  id: string;
+  name: string;
+  created_at: string;
+  last_used_at?: string;
+}
+
+/**
+ * Login response, with the recovery codes of a TOTP set up while logging in
+ */
+export interface MFALoginResponse extends LoginResponse {
+  recovery_codes?: string[];
+}
+
+/**
+ * Challenge returned instead of a session when the password is a one-time
+ * password that must be replaced. Recovery codes of a TOTP set up earlier in
+ * the login are passed on.
+ */
+export interface PasswordChangeChallenge {
+  password_change_required: true;
+  password_token: string;
+  min_password_length: number;
+  expires_in: number;
+  recovery_codes?: string[];
+}
+
+/**
+ * Throw the error message of a failed response
+ */
+const checkResponse = async (response: Response, action: string): Promise<Response> => {
+  if (!response.ok) {
+    const errorText = await response.text();
+    throw new Error(errorText.trim() || `Failed to ${action}: ${response.statusText}`);
+  }
+  return response;
+};
+
+/**
+ * POST JSON to a public login endpoint
+ */
+const postLogin = async (path: string, body: unknown, action: string): Promise<any> => {
+  const response = await fetch(`${API_BASE}/api/auth/login${path}`, {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+    },
+    body: JSON.stringify(body),
+  });
+  await checkResponse(response, action);
+  return response.json();
+};
+
+/**
+ * POST JSON to an endpoint of the current user
+ */
+const postAuth = async (path: string, body: unknown, action: string): Promise<Response> => {
+  const response = await fetchWithAuth(path, {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+    },
+    body: JSON.stringify(body),
+  });
+  return checkResponse(response, action);
+};
+
+/**
+ * Finish a login with a TOTP or recovery code
+ */
+export const completeMFALogin = (mfaToken: string, code: string): Promise<MFALoginResponse | PasswordChangeChallenge> =>
+  postLogin('/mfa', { mfa_token: mfaToken, code }, 'verify code');
+
+/**
+ * Finish a login with a passkey as the second factor
+ */
+export const completeMFALoginWithPasskey = async (mfaToken: string): Promise<MFALoginResponse | PasswordChangeChallenge> => {
+  const { options } = await postLogin('/mfa/passkey', { mfa_token: mfaToken }, 'start passkey');
+  const credential = await getCredential(options);
+  return postLogin('/mfa', { mfa_token: mfaToken, credential }, 'verify passkey');
+};
+
+/**
+ * Finish a login by replacing a one-time password
+ */
+export const completePasswordChange = (passwordToken: string, newPassword: string): Promise<MFALoginResponse> =>
+  postLogin('/password', { password_token: passwordToken, new_password: newPassword }, 'change password');
+
+/**
+ * Start the TOTP enrolment a role requires before logging in
+ */
+export const startMFAEnrollment = (mfaToken: string): Promise<TOTPEnrollment> =>
+  postLogin('/mfa/totp', { mfa_token: mfaToken }, 'start enrollment');
+
+/**
+ * Get whether passkeys are configured on the server
+ */
+export const getPasskeyConfig = async (): Promise<boolean> => {
+  if (!window.PublicKeyCredential) {
+    return false;
+  }
+  const response = await fetch(`${API_BASE}/api/auth/login/passkey`);
+  if (!response.ok) {
+    return false;
+  }
+  const data = await response.json();
+  return data.enabled;
+};
+
+/**
+ * Log in with a passkey, without a username or password
+ */
+export const loginWithPasskey = async (): Promise<LoginResponse | PasswordChangeChallenge> => {
+  const { token, options } = await postLogin('/passkey/options', {}, 'start passkey login');
+  const credential = await getCredential(options);
+  return postLogin('/passkey', { token, credential }, 'log in with passkey');
+};
+
+/**
+ * Get the second factors of the current user
+ */
+export const getMFAStatus = async (): Promise<MFAStatus> => {
+  const response = await fetchWithAuth('/api/auth/mfa');
+  await checkResponse(response, 'fetch MFA status');
+  return response.json();
+};
+
+/**
+ * Start setting up TOTP for the current user
+ */
+export const beginTOTP = async (): Promise<TOTPEnrollment> => {
+  const response = await postAuth('/api/auth/mfa/totp', {}, 'start TOTP setup');
+  return response.json();
+};
+
+/**
+ * Confirm TOTP with a first code. Returns the recovery codes.
+ */
+export const confirmTOTP = async (code: string): Promise<string[]> => {
+  const response = await postAuth('/api/auth/mfa/totp/confirm', { code }, 'confirm TOTP');
+  const data = await response.json();
+  return data.recovery_codes;
+};
+
+/**
+ * Turn off TOTP for the current user
+ */
+export const disableTOTP = async (code: string): Promise<void> => {
+  await postAuth('/api/auth/mfa/totp/disable', { code }, 'disable TOTP');
+};
+
+/**
+ * Replace the recovery codes of the current user
+ */
+export const regenerateRecoveryCodes = async (code: string): Promise<string[]> => {
+  const response = await postAuth('/api/auth/mfa/recovery-codes', { code }, 'regenerate recovery codes');
+  const data = await response.json();
+  return data.recovery_codes;
+};
+
+/**
+ * Get the passkeys of the current user
+ */
+export const getPasskeys = async (): Promise<Passkey[]> => {
+  const response = await fetchWithAuth('/api/auth/passkeys');
+  await checkResponse(response, 'fetch passkeys');
+  const data = await response.json();
+  return data.passkeys;
+};
+
+/**
+ * Register a passkey on this device for the current user
+ */
+export const registerPasskey = async (name: string): Promise<Passkey> => {
+  const optionsResponse = await postAuth('/api/auth/passkeys/options', {}, 'start passkey registration');
+  const { token, options } = await optionsResponse.json();
+  const credential = await createCredential(options);
+  const response = await postAuth('/api/auth/passkeys', { token, name, credential }, 'register passkey');
+  return response.json();
+};
+
+/**
+ * Delete a passkey of the current user
+ */
+export const deletePasskey = async (id: string): Promise<void> => {
+  const response = await fetchWithAuth(`/api/auth/passkeys/${id}`, {
+    method: 'DELETE',
+  });
+  await checkResponse(response, 'delete passkey');
+};
+
+/**
+ * Require a second factor for a role (admin only)
+ */
+export const setRoleMFARequired = async (role: string, required: boolean): Promise<void> => {
+  const response = await fetchWithAuth(`/api/roles/${role}/mfa`, {
+    method: 'PUT',
+    headers: {
+      'Content-Type': 'application/json',
+    },
+    body: JSON.stringify({ required }),
+  });
+  await checkResponse(response, 'update role');
+};
+
+/**
+ * Remove every second factor of a user who lost them (admin only)
+ */
+export const resetUserMFA = async (id: string): Promise<void> => {
+  const response = await fetchWithAuth(`/api/users/${id}/mfa`, {
+    method: 'DELETE',
+  });
+  await checkResponse(response, 'reset MFA');
+};
+
+// The server encodes binary WebAuthn fields as base64url, the browser wants
+// ArrayBuffers
+
+const fromBase64URL = (value: string): ArrayBuffer => {
+  const base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+  const binary = atob(base64.padEnd(base64.length + ((4 - (base64.length % 4)) % 4), '='));
+  return Uint8Array.from(binary, (c) => c.charCodeAt(0)).buffer;
+};
+
+const toBase64URL = (buffer: ArrayBuffer): string => {
+  const binary = String.fromCharCode(...new Uint8Array(buffer));
+  return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+};
+
+const decodeDescriptors = (descriptors?: { id: string }[]) =>
+  descriptors?.map((descriptor) => ({ ...descriptor, id: fromBase64URL(descriptor.id) }));
+
+/**
+ * Create a passkey from the registration options of the server
+ */
+const createCredential = async (options: any) => {
+  const publicKey = {
+    ...options.publicKey,
+    challenge: fromBase64URL(options.publicKey.challenge),
+    user: { ...options.publicKey.user, id: fromBase64URL(options.publicKey.user.id) },
+    excludeCredentials: decodeDescriptors(options.publicKey.excludeCredentials),
+  };
+  const credential = (await navigator.credentials.create({ publicKey })) as PublicKeyCredential | null;
+  if (!credential) {
+    throw new Error('Passkey registration was cancelled');
+  }
+  const response = credential.response as AuthenticatorAttestationResponse;
+  return {
+    id: credential.id,
+    rawId: toBase64URL(credential.rawId),
+    type: credential.type,
+    response: {
+      clientDataJSON: toBase64URL(response.clientDataJSON),
+      attestationObject: toBase64URL(response.attestationObject),
+      transports: response.getTransports?.() ?? [],
+    },
+  };
+};
+
+/**
+ * Sign the login options of the server with a passkey
+ */
+const getCredential = async (options: any) => {
+  const publicKey = {
+    ...options.publicKey,
+    challenge: fromBase64URL(options.publicKey.challenge),
+    allowCredentials: decodeDescriptors(options.publicKey.allowCredentials),
+  };
+  const credential = (await navigator.credentials.get({ publicKey })) as PublicKeyCredential | null;
+  if (!credential) {
+    throw new Error('Passkey login was cancelled');
+  }
+  const response = credential.response as AuthenticatorAssertionResponse;
+  return {
+    id: credential.id,
+    rawId: toBase64URL(credential.rawId),
+    type: credential.type,
+    response: {
+      clientDataJSON: toBase64URL(response.clientDataJSON),
+      authenticatorData: toBase64URL(response.authenticatorData),
+      signature: toBase64URL(response.signature),
+      userHandle: response.userHandle ? toBase64URL(response.userHandle) : undefined,
+    },
+  };
+};
@@ -0,0 +1,92 @@
+import { fetchWithAuth } from '../api';
+
+/**
+ * Navigation entry contributed by a plugin
+ */
+export interface PluginMenuEntry {
+  label: string;
+  page: string;
+  admin?: boolean;
+}
+
+/**
+ * UI extensions contributed by a plugin
+ */
+export interface PluginUIExtension {
+  plugin: string;
+  base_url: string;
+  menu: PluginMenuEntry[];
+}
+
+/**
+ * Identifies a plugin page in the navigation, e.g. "plugin:faces:0"
+ */
+export type PluginView = `plugin:${string}:${number}`;
+
+/**
+ * Build the view identifier of a plugin menu entry
+ */
+export const pluginView = (plugin: string, index: number): PluginView => `plugin:${plugin}:${index}`;
+
+/**
+ * Get the UI extensions visible to the current user
+ */
+export const getPluginUIExtensions = async (): Promise<PluginUIExtension[]> => {
+  const response = await fetchWithAuth('/api/plugins');
+
+  if (!response.ok) {
+    throw new Error(`Failed to fetch plugin extensions: ${response.statusText}`);
+  }
+
+  const data = await response.json();
+  return data.plugins;
+};
+
+/**
+ * Request a plugin page sends to the web UI to call one of its routes
+ */
+export interface PluginBridgeRequest {
+  type: 'pixie:request';
+  id: string | number;
+  method?: string;
+  path: string;
+  body?: string;
+  contentType?: string;
+}
+
+/**
+ * Response the web UI sends back to a plugin page
+ */
+export interface PluginBridgeResponse {
+  type: 'pixie:response';
+  id: string | number;
+  status?: number;
+  contentType?: string | null;
+  body?: string;
+  error?: string;
+}
+
+/**
+ * Call a route of plugin as the current user. The path is resolved below
+ * /api/plugins/<plugin>/, so a plugin page can only reach its own routes.
+ */
+export const pluginRequest = async (plugin: string, request: PluginBridgeRequest): Promise<PluginBridgeResponse> => {
+  const url = new URL(request.path.replace(/^\/*/, '/'), 'http://plugin');
+  const headers: Record<string, string> = {};
+  if (request.contentType) {
+    headers['Content-Type'] = request.contentType;
+  }
+
+  const response = await fetchWithAuth(`/api/plugins/${encodeURIComponent(plugin)}${url.pathname}${url.search}`, {
+    method: request.method || 'GET',
+    headers,
+    body: request.body,
+  });
+  return {
+    type: 'pixie:response',
+    id: request.id,
+    status: response.status,
+    contentType: response.headers.get('Content-Type'),
+    body: await response.text(),
+  };
+};
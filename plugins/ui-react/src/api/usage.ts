@@ -0,0 +1,75 @@
+import { fetchWithAuth } from '../api';
+
+/**
+ * Amount of stored data
+ */
+export interface UsageCount {
+  bytes: number;
+  objects: number;
+}
+
+/**
+ * Storage used by an owner; the trashed photos are part of the originals
+ */
+export interface StorageUsage {
+  owner_id: string;
+  originals: UsageCount;
+  derivatives: UsageCount;
+  total: UsageCount;
+  trashed: UsageCount;
+  updated_at?: string;
+}
+
+/**
+ * Storage used by one owner in the admin report; quota 0 means no limit
+ */
+export interface UsageReportEntry {
+  owner_id: string;
+  username?: string;
+  quota: number;
+  usage: StorageUsage;
+}
+
+/**
+ * Storage used by every owner
+ */
+export interface UsageReport {
+  owners: UsageReportEntry[];
+  total: UsageCount;
+}
+
+/**
+ * Get the storage used by the current user and their quota (0 means no limit)
+ */
+export const getMyUsage = async (): Promise<{ usage: StorageUsage; quota: number }> => {
+  const response = await fetchWithAuth('/api/me/usage');
+  if (!response.ok) {
+    throw new Error(`Failed to fetch storage usage: ${response.statusText}`);
+  }
+  return response.json();
+};
+
+/**
+ * Get the storage used by every owner (admin only)
+ */
+export const getUsageReport = async (): Promise<UsageReport> => {
+  const response = await fetchWithAuth('/api/usage');
+  if (!response.ok) {
+    throw new Error(`Failed to fetch storage usage: ${response.statusText}`);
+  }
+  return response.json();
+};
+
+/**
+ * Format a number of bytes for people
+ */
+export const formatBytes = (bytes: number): string => {
+  const units = ['B', 'KB', 'MB', 'GB', 'TB'];
+  let value = bytes;
+  let unit = 0;
+  while (value >= 1024 && unit < units.length - 1) {
+    value /= 1024;
+    unit++;
+  }
+  return `${unit === 0 ? value : value.toFixed(1)} ${units[unit]}`;
+};
@@ -1,12 +1,25 @@
 import { fetchWithAuth } from '../api';
+import { MFAChallenge, PasswordChangeChallenge } from './mfa';
 
 // Get API base URL from environment or use default
 const API_BASE = import.meta.env.VITE_API_BASE || '';
 
 /**
- * User role type
+ * User role type: a built-in role (admin, user or viewer) or a custom one
  */
-export type UserRole = 'admin' | 'user';
+export type UserRole = string;
+
+/**
+ * Role and the scopes it grants
+ */
+export interface RoleDefinition {
+  name: UserRole;
+  description: string;
+  permissions: string[];
+  builtin: boolean;
+  require_mfa: boolean;
+  created_at?: string;
+}
 
 /**
  * User interface
@@ -20,6 +33,9 @@ export interface User {
   created_at: string;
   last_login?: string;
   active: boolean;
+  locked_until?: string;
+  pending_approval?: boolean;
+  storage_quota: number;
 }
 
 /**
@@ -31,6 +47,7 @@ export interface CreateUserRequest {
   email?: string;
   full_name?: string;
   role: UserRole;
+  storage_quota?: number;
 }
 
 /**
@@ -42,6 +59,7 @@ export interface UpdateUserRequest {
   password?: string;
   role?: UserRole;
   active?: boolean;
+  storage_quota?: number;
 }
 
 /**
@@ -57,13 +75,18 @@ export interface LoginRequest {
  */
 export interface LoginResponse {
   token: string;
+  refresh_token: string;
+  session_id: string;
+  expires_in: number;
   user: User;
 }
 
 /**
- * Login with username and password
+ * Login with username and password. Accounts with a second factor or a
+ * one-time password get a challenge to finish the login with instead of a
+ * session.
  */
-export const login = async (username: string, password: string): Promise<LoginResponse> => {
+export const login = async (username: string, password: string): Promise<LoginResponse | MFAChallenge | PasswordChangeChallenge> => {
   try {
     const response = await fetch(`${API_BASE}/api/auth/login`, {
       method: 'POST',
@@ -92,6 +115,67 @@ export const login = async (username: string, password: string): Promise<LoginRe
   }
 };
 
+/**
+ * Single sign-on configuration
+ */
+export interface OIDCConfig {
+  enabled: boolean;
+  name: string;
+}
+
+/**
+ * Get whether single sign-on with an identity provider is available
+ */
+export const getOIDCConfig = async (): Promise<OIDCConfig> => {
+  const response = await fetch(`${API_BASE}/api/auth/oidc/config`);
+  if (!response.ok) {
+    return { enabled: false, name: '' };
+  }
+  return response.json();
+};
+
+/**
+ * Start single sign-on; the browser returns to the current page afterwards
+ */
+export const startOIDCLogin = (): void => {
+  const redirect = window.location.pathname + window.location.search;
+  window.location.href = `${API_BASE}/api/auth/oidc/login?redirect=${encodeURIComponent(redirect)}`;
+};
+
+/**
+ * Take the login token or error of a finished single sign-on from the URL
+ * fragment
+ */
+export const readOIDCRedirect = (): { loginToken: string | null; error: string | null } => {
+  const params = new URLSearchParams(window.location.hash.slice(1));
+  const loginToken = params.get('login_token');
+  const error = params.get('oidc_error');
+  if (loginToken || error) {
+    // Keep the token out of the history
+    window.history.replaceState(null, '', window.location.pathname + window.location.search);
+  }
+  return { loginToken, error };
+};
+
+/**
+ * Continue a single sign-on with its login token. Like a password login, it
+ * may ask for a second factor or a new password before starting a session.
+ */
+export const completeOIDCLogin = async (loginToken: string): Promise<LoginResponse | MFAChallenge | PasswordChangeChallenge> => {
+  const response = await fetch(`${API_BASE}/api/auth/oidc/complete`, {
+    method: 'POST',
+    headers: {
+      'Content-Type': 'application/json',
+    },
+    body: JSON.stringify({ login_token: loginToken }),
+  });
+  if (!response.ok) {
+    const errorText = await response.text();
+    throw new Error(errorText.trim() || `Single sign-on failed: ${response.statusText}`);
+  }
+  return response.json();
+};
+
 /**
  * Get all users (admin only)
  */
@@ -169,3 +253,30 @@ export const deleteUser = async (id: string): Promise<void> => {
     throw new Error(`Failed to delete user: ${response.statusText}`);
   }
 };
+
+/**
+ * Lift the lock of an account after too many failed logins (admin only)
+ */
+export const unlockUser = async (id: string): Promise<void> => {
+  const response = await fetchWithAuth(`/api/users/${id}/unlock`, {
+    method: 'POST',
+  });
+
+  if (!response.ok) {
+    throw new Error(`Failed to unlock user: ${response.statusText}`);
+  }
+};
+
+/**
+ * Get the roles users can be given (admin only)
+ */
+export const getRoles = async (): Promise<RoleDefinition[]> => {
+  const response = await fetchWithAuth('/api/roles');
+
+  if (!response.ok) {
+    throw new Error(`Failed to fetch roles: ${response.statusText}`);
+  }
+
+  const data = await response.json();
+  return data.roles;
+};
@@ -1,5 +1,5 @@
 import { useState, useEffect } from 'react';
-import { Photo, getPhotoUrl, trashPhoto } from '../api';
+import { Photo, getPhotoUrl, trashPhoto, canChangePhoto } from '../api';
 import AuthenticatedImage from './AuthenticatedImage';
 
 interface LightboxProps {
@@ -79,54 +79,56 @@ const Lightbox = ({ photo, onClose, onDelete, onTrash }: LightboxProps) => {
                 {new Date(photo.created_at).toLocaleString()}
               </p>
             </div>
-            <div className="flex space-x-3">
-              <button
-                className="text-white bg-red-600 hover:bg-red-700 px-3 py-1 rounded-md text-sm flex items-center transition-colors"
-                onClick={async (e) => {
-                  e.stopPropagation();
-                  if (!photo || isDeleting) return;
+            {canChangePhoto(photo) && (
+              <div className="flex space-x-3">
+                <button
+                  className="text-white bg-red-600 hover:bg-red-700 px-3 py-1 rounded-md text-sm flex items-center transition-colors"
+                  onClick={async (e) => {
+                    e.stopPropagation();
+                    if (!photo || isDeleting) return;
 
-                  if (window.confirm("Move this photo to trash?")) {
-                    setIsDeleting(true);
-                    setError(null);
+                    if (window.confirm("Move this photo to trash?")) {
+                      setIsDeleting(true);
+                      setError(null);
                     
-                    try {
-                      await trashPhoto(photo.id);
-                      if (onDelete) {
-                        onDelete(photo.id);
+                      try {
+                        await trashPhoto(photo.id);
+                        if (onDelete) {
+                          onDelete(photo.id);
+                        }
+                        // Trigger refresh of the Gallery component
+                        if (onTrash) {
+                          onTrash();
+                        }
+                        onClose();
+                      } catch (err) {
+                        console.error("Failed to move photo to trash:", err);
+                        setError("Failed to move photo to trash. Please try again.");
+                        setIsDeleting(false);
                       }
-                      // Trigger refresh of the Gallery component
-                      if (onTrash) {
-                        onTrash();
-                      }
-                      onClose();
-                    } catch (err) {
-                      console.error("Failed to move photo to trash:", err);
-                      setError("Failed to move photo to trash. Please try again.");
-                      setIsDeleting(false);
                     }
-                  }
-                }}
-                disabled={isDeleting}
-              >
-                {isDeleting ? (
-                  <span className="flex items-center">
-                    <svg className="animate-spin -ml-1 mr-2 h-4 w-4" xmlns="http://www.w3.org/2000/svg" fill="none" viewBox="0 0 24 24">
-                      <circle className="opacity-25" cx="12" cy="12" r="10" stroke="currentColor" strokeWidth="4"></circle>
-                      <path className="opacity-75" fill="currentColor" d="M4 12a8 8 0 018-8V0C5.373 0 0 5.373 0 12h4zm2 5.291A7.962 7.962 0 014 12H0c0 3.042 1.135 5.824 3 7.938l3-2.647z"></path>
-                    </svg>
-                    Moving to trash...
-                  </span>
-                ) : (
-                  <span className="flex items-center">
-                    <svg xmlns="http://www.w3.org/2000/svg" className="h-4 w-4 mr-1" fill="none" viewBox="0 0 24 24" stroke="currentColor">
-                      <path strokeLinecap="round" strokeLinejoin="round" strokeWidth={2} d="M19 7l-.867 12.142A2 2 0 0116.138 21H7.862a2 2 0 01-1.995-1.858L5 7m5 4v6m4-6v6m1-10V4a1 1 0 00-1-1h-4a1 1 0 00-1 1v3M4 7h16" />
-                    </svg>
-                    Move to Trash
-                  </span>
-                )}
-              </button>
-            </div>
+                  }}
+                  disabled={isDeleting}
+                >
+                  {isDeleting ? (
+                    <span className="flex items-center">
+                      <svg className="animate-spin -ml-1 mr-2 h-4 w-4" xmlns="http://www.w3.org/2000/svg" fill="none" viewBox="0 0 24 24">
+                        <circle className="opacity-25" cx="12" cy="12" r="10" stroke="currentColor" strokeWidth="4"></circle>
+                        <path className="opacity-75" fill="currentColor" d="M4 12a8 8 0 018-8V0C5.373 0 0 5.373 0 12h4zm2 5.291A7.962 7.962 0 014 12H0c0 3.042 1.135 5.824 3 7.938l3-2.647z"></path>
+                      </svg>
+                      Moving to trash...
+                    </span>
+                  ) : (
+                    <span className="flex items-center">
+                      <svg xmlns="http://www.w3.org/2000/svg" className="h-4 w-4 mr-1" fill="none" viewBox="0 0 24 24" stroke="currentColor">
+                        <path strokeLinecap="round" strokeLinejoin="round" strokeWidth={2} d="M19 7l-.867 12.142A2 2 0 0116.138 21H7.862a2 2 0 01-1.995-1.858L5 7m5 4v6m4-6v6m1-10V4a1 1 0 00-1-1h-4a1 1 0 00-1 1v3M4 7h16" />
+                      </svg>
+                      Move to Trash
+                    </span>
+                  )}
+                </button>
+              </div>
+            )}
           </div>
           {error && (
             <div className="mt-2 text-sm text-red-300 bg-red-900 bg-opacity-50 p-2 rounded">
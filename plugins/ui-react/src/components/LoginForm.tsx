@@ -1,20 +1,111 @@
-import { useState } from 'react';
-import { login as apiLogin } from '../api/users';
-import { setToken } from '../api';
+import { useEffect, useState } from 'react';
+import { login as apiLogin, completeOIDCLogin, getOIDCConfig, startOIDCLogin, LoginResponse, OIDCConfig } from '../api/users';
+import {
+  MFAChallenge,
+  MFALoginResponse,
+  PasswordChangeChallenge,
+  TOTPEnrollment,
+  completeMFALogin,
+  completeMFALoginWithPasskey,
+  completePasswordChange,
+  getPasskeyConfig,
+  loginWithPasskey,
+  startMFAEnrollment,
+} from '../api/mfa';
+import { AccountLink, resetPassword, verifyEmail } from '../api/account';
+import { acceptInvite, getRegistrationConfig, register } from '../api/invites';
+import { setSession, setToken } from '../api';
 
 interface LoginFormProps {
   onLoginSuccess: () => void;
+  initialError?: string | null;
+  accountLink?: AccountLink | null;
+  oidcLoginToken?: string | null;
 }
 
-const LoginForm = ({ onLoginSuccess }: LoginFormProps) => {
+const LoginForm = ({ onLoginSuccess, initialError = null, accountLink = null, oidcLoginToken = null }: LoginFormProps) => {
   const [username, setUsername] = useState('');
   const [password, setPassword] = useState('');
   const [loading, setLoading] = useState(false);
   const [error, setError] = useState<string | null>(null);
   const [showToken, setShowToken] = useState(false);
   const [tokenValue, setTokenValue] = useState('');
-  const [recreatingAdmin, setRecreatingAdmin] = useState(false);
-  const [recreateSuccess, setRecreateSuccess] = useState<string | null>(null);
+  const [oidc, setOIDC] = useState<OIDCConfig | null>(null);
+  const [passkeysEnabled, setPasskeysEnabled] = useState(false);
+  const [challenge, setChallenge] = useState<MFAChallenge | null>(null);
+  const [enrollment, setEnrollment] = useState<TOTPEnrollment | null>(null);
+  const [code, setCode] = useState('');
+  const [recoveryCodes, setRecoveryCodes] = useState<string[] | null>(null);
+  const [passwordChange, setPasswordChange] = useState<PasswordChangeChallenge | null>(null);
+  const [newPassword, setNewPassword] = useState('');
+  const [confirmPassword, setConfirmPassword] = useState('');
+  const [resetToken, setResetToken] = useState<string | null>(null);
+  const [notice, setNotice] = useState<string | null>(null);
+  const [registrationEnabled, setRegistrationEnabled] = useState(false);
+  const [signup, setSignup] = useState<{ inviteToken: string | null } | null>(null);
+  const [email, setEmail] = useState('');
+  const [fullName, setFullName] = useState('');
+
+  // Offer single sign-on if an identity provider is configured
+  useEffect(() => {
+    getOIDCConfig().then(setOIDC).catch(() => setOIDC(null));
+  }, []);
+
+  // Offer passkeys if the server and the browser support them
+  useEffect(() => {
+    getPasskeyConfig().then(setPasskeysEnabled).catch(() => setPasskeysEnabled(false));
+  }, []);
+
+  // Offer sign-up if anyone can register
+  useEffect(() => {
+    getRegistrationConfig().then(setRegistrationEnabled).catch(() => setRegistrationEnabled(false));
+  }, []);
+
+  // Confirm a new email address, ask for the new password of a reset link, or
+  // let an invitee choose their account
+  useEffect(() => {
+    if (accountLink?.type === 'verify_email') {
+      verifyEmail(accountLink.token)
+        .then((email) => setNotice(`Your email address is now ${email}.`))
+        .catch((err) => setError(err instanceof Error ? err.message : 'Failed to verify email address'));
+    } else if (accountLink?.type === 'reset_password') {
+      setResetToken(accountLink.token);
+    } else if (accountLink?.type === 'invite') {
+      setSignup({ inviteToken: accountLink.token });
+    }
+  }, [accountLink]);
+
+  // Show why a single sign-on failed
+  useEffect(() => {
+    if (initialError) {
+      setError(initialError);
+    }
+  }, [initialError]);
+
+  // Continue a single sign-on like a login with a password
+  useEffect(() => {
+    if (!oidcLoginToken) {
+      return;
+    }
+    setLoading(true);
+    completeOIDCLogin(oidcLoginToken)
+      .then(continueLogin)
+      .catch((err) => setError(err instanceof Error ? err.message : 'Single sign-on failed. Please try again.'))
+      .finally(() => setLoading(false));
+  }, [oidcLoginToken]);
+
+  // Ask for the second factor if the first step needs one, or finish the login
+  const continueLogin = async (response: LoginResponse | MFAChallenge | PasswordChangeChallenge) => {
+    if ('mfa_required' in response) {
+      setChallenge(response);
+      setPassword('');
+      if (response.enrollment_required) {
+        setEnrollment(await startMFAEnrollment(response.mfa_token));
+      }
+      return;
+    }
+    finishLogin(response);
+  };
 
   const handleSubmit = async (e: React.FormEvent) => {
     e.preventDefault();
@@ -22,10 +113,7 @@ const LoginForm = ({ onLoginSuccess }: LoginFormProps) => {
     setError(null);
 
     try {
-      const response = await apiLogin(username, password);
-      console.log("Login successful:", response);
-      setToken(response.token);
-      onLoginSuccess();
+      await continueLogin(await apiLogin(username, password));
     } catch (err) {
       console.error('Login failed:', err);
       // Display more detailed error information for debugging
@@ -39,47 +127,186 @@ const LoginForm = ({ onLoginSuccess }: LoginFormProps) => {
     }
   };
 
-  const handleTokenLogin = (e: React.FormEvent) => {
-    e.preventDefault();
-    if (tokenValue.trim()) {
-      setToken(tokenValue.trim());
-      onLoginSuccess();
-    } else {
-      setError('Please enter a valid token');
+  // Keep the session; recovery codes of a TOTP set up while logging in are
+  // shown once before continuing. A one-time password is replaced first.
+  const finishLogin = (response: MFALoginResponse | PasswordChangeChallenge) => {
+    if ('password_change_required' in response) {
+      setPasswordChange(response);
+      setChallenge(null);
+      setEnrollment(null);
+      setPassword('');
+      return;
+    }
+    setSession(response);
+    if (response.recovery_codes?.length) {
+      setRecoveryCodes(response.recovery_codes);
+      return;
     }
+    onLoginSuccess();
+  };
+
+  // Start over when a challenge expired or ran out of attempts
+  const resetChallenge = () => {
+    setChallenge(null);
+    setEnrollment(null);
+    setCode('');
   };
 
-  const handleRecreateAdmin = async () => {
-    setRecreatingAdmin(true);
+  const handleCodeSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    if (!challenge) {
+      return;
+    }
+    setLoading(true);
     setError(null);
-    setRecreateSuccess(null);
-    
+
     try {
-      const API_BASE = import.meta.env.VITE_API_BASE || '';
-      const response = await fetch(`${API_BASE}/api/auth/recreate-admin`, {
-        method: 'POST',
-      });
-      
-      if (!response.ok) {
-        const errorText = await response.text();
-        throw new Error(`Failed to recreate admin: ${response.status} ${response.statusText} - ${errorText}`);
+      finishLogin(await completeMFALogin(challenge.mfa_token, code.trim()));
+    } catch (err) {
+      console.error('Second factor failed:', err);
+      setCode('');
+      setError(err instanceof Error ? err.message : 'Invalid code. Please try again.');
+      if (err instanceof Error && err.message.includes('start again')) {
+        resetChallenge();
       }
-      
-      await response.json(); // Parse response but we're using our own message
-      setRecreateSuccess("Admin user recreated successfully. Default credentials have been set.");
-      
-      // Pre-fill the credentials
-      setUsername('admin');
-      setPassword('admin123');
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  const handlePasskeyMFA = async () => {
+    if (!challenge) {
+      return;
+    }
+    setLoading(true);
+    setError(null);
+
+    try {
+      finishLogin(await completeMFALoginWithPasskey(challenge.mfa_token));
     } catch (err) {
-      console.error('Failed to recreate admin:', err);
-      if (err instanceof Error) {
-        setError(`Failed to recreate admin: ${err.message}`);
-      } else {
-        setError('Failed to recreate admin user. Please try again.');
+      console.error('Passkey failed:', err);
+      setError(err instanceof Error ? err.message : 'Passkey failed. Please try again.');
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  const handlePasskeyLogin = async () => {
+    setLoading(true);
+    setError(null);
+
+    try {
+      finishLogin(await loginWithPasskey());
+    } catch (err) {
+      console.error('Passkey login failed:', err);
+      setError(err instanceof Error ? err.message : 'Passkey login failed. Please try again.');
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  const handlePasswordChange = async (e: React.FormEvent) => {
+    e.preventDefault();
+    if (!passwordChange) {
+      return;
+    }
+    if (newPassword !== confirmPassword) {
+      setError('The passwords do not match');
+      return;
+    }
+    setLoading(true);
+    setError(null);
+
+    try {
+      const response = await completePasswordChange(passwordChange.password_token, newPassword);
+      setPasswordChange(null);
+      setNewPassword('');
+      setConfirmPassword('');
+      finishLogin({ ...response, recovery_codes: passwordChange.recovery_codes });
+    } catch (err) {
+      console.error('Password change failed:', err);
+      setError(err instanceof Error ? err.message : 'Failed to change password. Please try again.');
+      if (err instanceof Error && err.message.includes('start again')) {
+        setPasswordChange(null);
       }
     } finally {
-      setRecreatingAdmin(false);
+      setLoading(false);
+    }
+  };
+
+  const handleReset = async (e: React.FormEvent) => {
+    e.preventDefault();
+    if (!resetToken) {
+      return;
+    }
+    if (newPassword !== confirmPassword) {
+      setError('The passwords do not match');
+      return;
+    }
+    setLoading(true);
+    setError(null);
+
+    try {
+      setUsername(await resetPassword(resetToken, newPassword));
+      setResetToken(null);
+      setNewPassword('');
+      setConfirmPassword('');
+      setNotice('Your password was changed. Sign in with the new password.');
+    } catch (err) {
+      console.error('Password reset failed:', err);
+      setError(err instanceof Error ? err.message : 'Failed to reset password. Please try again.');
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  const handleSignup = async (e: React.FormEvent) => {
+    e.preventDefault();
+    if (!signup) {
+      return;
+    }
+    if (newPassword !== confirmPassword) {
+      setError('The passwords do not match');
+      return;
+    }
+    setLoading(true);
+    setError(null);
+
+    const request = {
+      username: username.trim(),
+      password: newPassword,
+      email: email.trim(),
+      full_name: fullName.trim(),
+    };
+    try {
+      const result = signup.inviteToken
+        ? await acceptInvite(signup.inviteToken, request)
+        : await register(request);
+      setNotice(
+        result.verificationSent
+          ? `${result.message}. Open the link we sent to ${request.email} to confirm your email address.`
+          : `${result.message}.`,
+      );
+      setSignup(null);
+      setNewPassword('');
+      setConfirmPassword('');
+      setEmail('');
+      setFullName('');
+    } catch (err) {
+      console.error('Sign-up failed:', err);
+      setError(err instanceof Error ? err.message : 'Failed to create account. Please try again.');
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  const handleTokenLogin = (e: React.FormEvent) => {
+    e.preventDefault();
+    if (tokenValue.trim()) {
+      setToken(tokenValue.trim());
+      onLoginSuccess();
+    } else {
+      setError('Please enter a valid token');
     }
   };
 
@@ -105,35 +332,328 @@ const LoginForm = ({ onLoginSuccess }: LoginFormProps) => {
           </div>
         )}
 
-        {/* Success message */}
-        {recreateSuccess && (
-          <div className="bg-green-100 border border-green-400 text-green-700 px-4 py-3 rounded mb-4" role="alert">
-            <p>{recreateSuccess}</p>
+        {notice && (
+          <div className="bg-green-100 border border-green-400 text-green-700 px-4 py-3 rounded mb-4" role="status">
+            <p>{notice}</p>
+          </div>
+        )}
+
+        {/* Single sign-on */}
+        {oidc?.enabled && (
+          <div className="mb-6">
+            <button
+              type="button"
+              onClick={startOIDCLogin}
+              className="w-full py-2 px-6 border border-blue-600 rounded-md text-blue-600 font-medium hover:bg-blue-50 focus:outline-none focus:ring-2 focus:ring-blue-500"
+            >
+              Sign in with {oidc.name}
+            </button>
+            <div className="flex items-center mt-6">
+              <div className="flex-grow border-t border-gray-200"></div>
+              <span className="mx-3 text-xs text-gray-500">or</span>
+              <div className="flex-grow border-t border-gray-200"></div>
+            </div>
           </div>
         )}
 
-        {/* Recreate Admin Button */}
-        <div className="mb-4">
-          <button
-            onClick={handleRecreateAdmin}
-            disabled={recreatingAdmin}
-            className="w-full py-2 px-3 border border-gray-300 rounded-md text-sm text-gray-700 hover:bg-gray-100 focus:outline-none focus:ring-2 focus:ring-blue-500 focus:border-blue-500"
-          >
-            {recreatingAdmin ? (
-              <span className="flex items-center justify-center">
-                <svg className="animate-spin -ml-1 mr-2 h-4 w-4 text-gray-700" xmlns="http://www.w3.org/2000/svg" fill="none" viewBox="0 0 24 24">
-                  <circle className="opacity-25" cx="12" cy="12" r="10" stroke="currentColor" strokeWidth="4"></circle>
-                  <path className="opacity-75" fill="currentColor" d="M4 12a8 8 0 018-8V0C5.373 0 0 5.373 0 12h4zm2 5.291A7.962 7.962 0 014 12H0c0 3.042 1.135 5.824 3 7.938l3-2.647z"></path>
-                </svg>
-                Recreating admin...
-              </span>
+        {recoveryCodes ? (
+          <div>
+            <h2 className="text-lg font-medium text-gray-800 mb-2">Save your recovery codes</h2>
+            <p className="text-sm text-gray-600 mb-4">
+              Each code signs you in once if you lose your authenticator. They will not be shown again.
+            </p>
+            <ul className="grid grid-cols-2 gap-2 font-mono text-sm bg-gray-50 border rounded-md p-4 mb-6">
+              {recoveryCodes.map((recoveryCode) => (
+                <li key={recoveryCode}>{recoveryCode}</li>
+              ))}
+            </ul>
+            <button
+              type="button"
+              onClick={onLoginSuccess}
+              className="bg-blue-600 hover:bg-blue-700 text-white font-medium py-2 px-6 rounded-md focus:outline-none focus:ring-2 focus:ring-blue-500 focus:ring-opacity-50 w-full transition-colors"
+            >
+              I have saved them
+            </button>
+          </div>
+        ) : signup ? (
+          <form onSubmit={handleSignup}>
+            <p className="mb-4 text-sm text-gray-600">
+              {signup.inviteToken
+                ? 'You were invited to Pixie. Choose a username and a password of at least 8 characters.'
+                : 'Choose a username and a password of at least 8 characters. An admin has to approve your account before you can sign in.'}
+            </p>
+
+            <div className="mb-4">
+              <label htmlFor="signup-username" className="block text-gray-700 text-sm font-medium mb-2">
+                Username
+              </label>
+              <input
+                type="text"
+                id="signup-username"
+                value={username}
+                onChange={(e) => setUsername(e.target.value)}
+                autoComplete="username"
+                pattern="[A-Za-z0-9._@\-]{3,64}"
+                title="3 to 64 letters, digits or . _ - @"
+                autoFocus
+                className="shadow-sm appearance-none border rounded-md w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-blue-500 focus:border-transparent"
+                required
+              />
+            </div>
+
+            <div className="mb-4">
+              <label htmlFor="signup-full-name" className="block text-gray-700 text-sm font-medium mb-2">
+                Full name
+              </label>
+              <input
+                type="text"
+                id="signup-full-name"
+                value={fullName}
+                onChange={(e) => setFullName(e.target.value)}
+                autoComplete="name"
+                className="shadow-sm appearance-none border rounded-md w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-blue-500 focus:border-transparent"
+              />
+            </div>
+
+            <div className="mb-4">
+              <label htmlFor="signup-email" className="block text-gray-700 text-sm font-medium mb-2">
+                Email
+              </label>
+              <input
+                type="email"
+                id="signup-email"
+                value={email}
+                onChange={(e) => setEmail(e.target.value)}
+                autoComplete="email"
+                placeholder={signup.inviteToken ? 'Leave empty to use the invited address' : ''}
+                className="shadow-sm appearance-none border rounded-md w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-blue-500 focus:border-transparent"
+              />
+            </div>
+
+            <div className="mb-4">
+              <label htmlFor="signup-password" className="block text-gray-700 text-sm font-medium mb-2">
+                Password
+              </label>
+              <input
+                type="password"
+                id="signup-password"
+                value={newPassword}
+                onChange={(e) => setNewPassword(e.target.value)}
+                autoComplete="new-password"
+                minLength={8}
+                className="shadow-sm appearance-none border rounded-md w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-blue-500 focus:border-transparent"
+                required
+              />
+            </div>
+
+            <div className="mb-6">
+              <label htmlFor="signup-confirm-password" className="block text-gray-700 text-sm font-medium mb-2">
+                Confirm password
+              </label>
+              <input
+                type="password"
+                id="signup-confirm-password"
+                value={confirmPassword}
+                onChange={(e) => setConfirmPassword(e.target.value)}
+                autoComplete="new-password"
+                className="shadow-sm appearance-none border rounded-md w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-blue-500 focus:border-transparent"
+                required
+              />
+            </div>
+
+            <button
+              type="submit"
+              className="bg-blue-600 hover:bg-blue-700 text-white font-medium py-2 px-6 rounded-md focus:outline-none focus:ring-2 focus:ring-blue-500 focus:ring-opacity-50 w-full transition-colors"
+              disabled={loading}
+            >
+              {loading ? 'Creating account...' : 'Create Account'}
+            </button>
+
+            <div className="mt-6 text-center">
+              <button
+                type="button"
+                className="text-blue-600 hover:text-blue-800 text-sm"
+                onClick={() => setSignup(null)}
+              >
+                Back to username/password login
+              </button>
+            </div>
+          </form>
+        ) : resetToken ? (
+          <form onSubmit={handleReset}>
+            <p className="mb-4 text-sm text-gray-600">Choose a new password of at least 8 characters.</p>
+
+            <div className="mb-4">
+              <label htmlFor="reset-password" className="block text-gray-700 text-sm font-medium mb-2">
+                New password
+              </label>
+              <input
+                type="password"
+                id="reset-password"
+                value={newPassword}
+                onChange={(e) => setNewPassword(e.target.value)}
+                autoComplete="new-password"
+                minLength={8}
+                autoFocus
+                className="shadow-sm appearance-none border rounded-md w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-blue-500 focus:border-transparent"
+                required
+              />
+            </div>
+
+            <div className="mb-6">
+              <label htmlFor="reset-confirm-password" className="block text-gray-700 text-sm font-medium mb-2">
+                Confirm new password
+              </label>
+              <input
+                type="password"
+                id="reset-confirm-password"
+                value={confirmPassword}
+                onChange={(e) => setConfirmPassword(e.target.value)}
+                autoComplete="new-password"
+                className="shadow-sm appearance-none border rounded-md w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-blue-500 focus:border-transparent"
+                required
+              />
+            </div>
+
+            <button
+              type="submit"
+              className="bg-blue-600 hover:bg-blue-700 text-white font-medium py-2 px-6 rounded-md focus:outline-none focus:ring-2 focus:ring-blue-500 focus:ring-opacity-50 w-full transition-colors"
+              disabled={loading}
+            >
+              {loading ? 'Saving...' : 'Reset Password'}
+            </button>
+
+            <div className="mt-6 text-center">
+              <button
+                type="button"
+                className="text-blue-600 hover:text-blue-800 text-sm"
+                onClick={() => setResetToken(null)}
+              >
+                Back to username/password login
+              </button>
+            </div>
+          </form>
+        ) : passwordChange ? (
+          <form onSubmit={handlePasswordChange}>
+            <p className="mb-4 text-sm text-gray-600">
+              Your password is a one-time password. Choose a new one of at least {passwordChange.min_password_length} characters to continue.
+            </p>
+
+            <div className="mb-4">
+              <label htmlFor="new-password" className="block text-gray-700 text-sm font-medium mb-2">
+                New password
+              </label>
+              <input
+                type="password"
+                id="new-password"
+                value={newPassword}
+                onChange={(e) => setNewPassword(e.target.value)}
+                autoComplete="new-password"
+                minLength={passwordChange.min_password_length}
+                autoFocus
+                className="shadow-sm appearance-none border rounded-md w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-blue-500 focus:border-transparent"
+                required
+              />
+            </div>
+
+            <div className="mb-6">
+              <label htmlFor="confirm-password" className="block text-gray-700 text-sm font-medium mb-2">
+                Confirm new password
+              </label>
+              <input
+                type="password"
+                id="confirm-password"
+                value={confirmPassword}
+                onChange={(e) => setConfirmPassword(e.target.value)}
+                autoComplete="new-password"
+                className="shadow-sm appearance-none border rounded-md w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-blue-500 focus:border-transparent"
+                required
+              />
+            </div>
+
+            <button
+              type="submit"
+              className="bg-blue-600 hover:bg-blue-700 text-white font-medium py-2 px-6 rounded-md focus:outline-none focus:ring-2 focus:ring-blue-500 focus:ring-opacity-50 w-full transition-colors"
+              disabled={loading}
+            >
+              {loading ? 'Saving...' : 'Set Password'}
+            </button>
+
+            <div className="mt-6 text-center">
+              <button
+                type="button"
+                className="text-blue-600 hover:text-blue-800 text-sm"
+                onClick={() => setPasswordChange(null)}
+              >
+                Back to username/password login
+              </button>
+            </div>
+          </form>
+        ) : challenge ? (
+          <form onSubmit={handleCodeSubmit}>
+            {enrollment ? (
+              <div className="mb-4 text-sm text-gray-600">
+                <p className="mb-2">
+                  Your account requires two-factor authentication. Add this key to your authenticator app, then enter the code it shows.
+                </p>
+                <p className="font-mono text-gray-800 bg-gray-50 border rounded-md p-2 break-all">{enrollment.secret}</p>
+                <a href={enrollment.url} className="text-blue-600 hover:text-blue-800 text-xs">
+                  Open in authenticator app
+                </a>
+              </div>
             ) : (
-              'Reset Admin Account (if login fails)'
+              <p className="mb-4 text-sm text-gray-600">
+                Enter the code from your authenticator app{challenge.methods.includes('recovery_code') ? ', or a recovery code' : ''}.
+              </p>
+            )}
+
+            <div className="mb-6">
+              <label htmlFor="code" className="block text-gray-700 text-sm font-medium mb-2">
+                Code
+              </label>
+              <input
+                type="text"
+                id="code"
+                value={code}
+                onChange={(e) => setCode(e.target.value)}
+                autoComplete="one-time-code"
+                autoFocus
+                className="shadow-sm appearance-none border rounded-md w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-blue-500 focus:border-transparent"
+                placeholder="123456"
+                required
+              />
+            </div>
+
+            <button
+              type="submit"
+              className="bg-blue-600 hover:bg-blue-700 text-white font-medium py-2 px-6 rounded-md focus:outline-none focus:ring-2 focus:ring-blue-500 focus:ring-opacity-50 w-full transition-colors"
+              disabled={loading}
+            >
+              {loading ? 'Verifying...' : 'Verify'}
+            </button>
+
+            {challenge.methods.includes('passkey') && passkeysEnabled && (
+              <button
+                type="button"
+                onClick={handlePasskeyMFA}
+                disabled={loading}
+                className="mt-3 w-full py-2 px-6 border border-blue-600 rounded-md text-blue-600 font-medium hover:bg-blue-50 focus:outline-none focus:ring-2 focus:ring-blue-500"
+              >
+                Use a passkey instead
+              </button>
             )}
-          </button>
-        </div>
 
-        {!showToken ? (
+            <div className="mt-6 text-center">
+              <button
+                type="button"
+                className="text-blue-600 hover:text-blue-800 text-sm"
+                onClick={resetChallenge}
+              >
+                Back to username/password login
+              </button>
+            </div>
+          </form>
+        ) : !showToken ? (
           <form onSubmit={handleSubmit}>
             <div className="mb-4">
               <label htmlFor="username" className="block text-gray-700 text-sm font-medium mb-2">
@@ -185,6 +705,17 @@ const LoginForm = ({ onLoginSuccess }: LoginFormProps) => {
               </button>
             </div>
 
+            {passkeysEnabled && (
+              <button
+                type="button"
+                onClick={handlePasskeyLogin}
+                disabled={loading}
+                className="mt-3 w-full py-2 px-6 border border-blue-600 rounded-md text-blue-600 font-medium hover:bg-blue-50 focus:outline-none focus:ring-2 focus:ring-blue-500"
+              >
+                Sign in with a passkey
+              </button>
+            )}
+
             <div className="mt-6 text-center">
               <button
                 type="button"
@@ -194,6 +725,22 @@ const LoginForm = ({ onLoginSuccess }: LoginFormProps) => {
                 Sign in with JWT token instead
               </button>
             </div>
+
+            {registrationEnabled && (
+              <div className="mt-2 text-center">
+                <button
+                  type="button"
+                  className="text-blue-600 hover:text-blue-800 text-sm"
+                  onClick={() => {
+                    setError(null);
+                    setNotice(null);
+                    setSignup({ inviteToken: null });
+                  }}
+                >
+                  Create an account
+                </button>
+              </div>
+            )}
           </form>
         ) : (
           <form onSubmit={handleTokenLogin}>
@@ -1,32 +1,20 @@
-import { useState, useEffect } from 'react';
+import { hasScope } from '../api';
+import { PluginUIExtension, PluginView, pluginView } from '../api/plugins';
+
+export type View = 'photos' | 'albums' | 'trash' | 'account' | 'security' | 'admin' | PluginView;
 
 interface SideNavProps {
   isOpen: boolean;
   onClose: () => void;
-  activeView: 'photos' | 'albums' | 'trash' | 'admin';
-  onNavigate: (view: 'photos' | 'albums' | 'trash' | 'admin') => void;
+  activeView: View;
+  onNavigate: (view: View) => void;
+  pluginExtensions: PluginUIExtension[];
 }
 
-const SideNav = ({ isOpen, onClose, activeView, onNavigate }: SideNavProps) => {
-  const [isAdmin, setIsAdmin] = useState(false);
+const SideNav = ({ isOpen, onClose, activeView, onNavigate, pluginExtensions }: SideNavProps) => {
+  // Only users who may manage users see the admin page
+  const isAdmin = hasScope('users:admin');
 
-  // Check if user is admin by looking at the JWT token's custom claims
-  useEffect(() => {
-    const token = localStorage.getItem('token');
-    if (token) {
-      try {
-        // Parse the JWT token (without validation)
-        const base64Url = token.split('.')[1];
-        const base64 = base64Url.replace(/-/g, '+').replace(/_/g, '/');
-        const payload = JSON.parse(window.atob(base64));
-        
-        // Check if user has admin role
-        setIsAdmin(payload.custom && payload.custom.role === 'admin');
-      } catch (error) {
-        console.error('Error parsing JWT token:', error);
-      }
-    }
-  }, []);
   return (
     <>
       {/* Backdrop for mobile */}
@@ -163,6 +151,70 @@ const SideNav = ({ isOpen, onClose, activeView, onNavigate }: SideNavProps) => {
                 Trash
               </a>
             </li>
+            <li>
+              <a
+                href="#"
+                className={`flex items-center px-4 py-3 text-gray-700 hover:bg-blue-50 transition-colors border-l-4 ${
+                  activeView === 'account' ? 'border-blue-600 bg-blue-50' : 'border-transparent'
+                }`}
+                onClick={(e) => {
+                  e.preventDefault();
+                  onNavigate('account');
+                }}
+              >
+                <svg xmlns="http://www.w3.org/2000/svg" className={`h-5 w-5 mr-3 ${
+                  activeView === 'account' ? 'text-blue-600' : 'text-gray-500'
+                }`} viewBox="0 0 20 20" fill="currentColor">
+                  <path fillRule="evenodd" d="M10 9a3 3 0 100-6 3 3 0 000 6zm-7 9a7 7 0 1114 0H3z" clipRule="evenodd" />
+                </svg>
+                Account
+              </a>
+            </li>
+            <li>
+              <a
+                href="#"
+                className={`flex items-center px-4 py-3 text-gray-700 hover:bg-blue-50 transition-colors border-l-4 ${
+                  activeView === 'security' ? 'border-blue-600 bg-blue-50' : 'border-transparent'
+                }`}
+                onClick={(e) => {
+                  e.preventDefault();
+                  onNavigate('security');
+                }}
+              >
+                <svg xmlns="http://www.w3.org/2000/svg" className={`h-5 w-5 mr-3 ${
+                  activeView === 'security' ? 'text-blue-600' : 'text-gray-500'
+                }`} viewBox="0 0 20 20" fill="currentColor">
+                  <path fillRule="evenodd" d="M2.166 4.999A11.954 11.954 0 0010 1.944 11.954 11.954 0 0017.834 5c.11.65.166 1.32.166 2.001 0 5.225-3.34 9.67-8 11.317C5.34 16.67 2 12.225 2 7c0-.682.057-1.35.166-2.001zm11.541 3.708a1 1 0 00-1.414-1.414L9 10.586 7.707 9.293a1 1 0 00-1.414 1.414l2 2a1 1 0 001.414 0l4-4z" clipRule="evenodd" />
+                </svg>
+                Security
+              </a>
+            </li>
+            {pluginExtensions.map((extension) =>
+              extension.menu.map((entry, index) => {
+                const view = pluginView(extension.plugin, index);
+                return (
+                  <li key={view}>
+                    <a
+                      href="#"
+                      className={`flex items-center px-4 py-3 text-gray-700 hover:bg-blue-50 transition-colors border-l-4 ${
+                        activeView === view ? 'border-blue-600 bg-blue-50' : 'border-transparent'
+                      }`}
+                      onClick={(e) => {
+                        e.preventDefault();
+                        onNavigate(view);
+                      }}
+                    >
+                      <svg xmlns="http://www.w3.org/2000/svg" className={`h-5 w-5 mr-3 ${
+                        activeView === view ? 'text-blue-600' : 'text-gray-500'
+                      }`} viewBox="0 0 20 20" fill="currentColor">
+                        <path d="M10 3.5a1.5 1.5 0 013 0V4a1 1 0 001 1h3a1 1 0 011 1v3a1 1 0 01-1 1h-.5a1.5 1.5 0 000 3h.5a1 1 0 011 1v3a1 1 0 01-1 1h-3a1 1 0 01-1-1v-.5a1.5 1.5 0 00-3 0v.5a1 1 0 01-1 1H6a1 1 0 01-1-1v-3a1 1 0 00-1-1h-.5a1.5 1.5 0 010-3H4a1 1 0 001-1V6a1 1 0 011-1h3a1 1 0 001-1v-.5z" />
+                      </svg>
+                      {entry.label}
+                    </a>
+                  </li>
+                );
+              })
+            )}
           </ul>
         </nav>
 
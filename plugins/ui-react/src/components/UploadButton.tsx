@@ -1,5 +1,5 @@
 import { useState, useRef, useEffect } from 'react';
-import { uploadPhoto, Photo } from '../api';
+import { uploadPhoto, Photo, QuotaExceededError } from '../api';
 
 interface UploadButtonProps {
   onUploadSuccess: (photo: Photo) => void;
@@ -92,7 +92,11 @@ const UploadButton = ({ onUploadSuccess }: UploadButtonProps) => {
         
         onUploadSuccess(uploadedPhoto);
       } catch (err) {
-        setError('Failed to upload photo. Please try again.');
+        setError(
+          err instanceof QuotaExceededError
+            ? `${file.name} was not uploaded: ${err.message}. Empty the trash or ask an admin for more space.`
+            : 'Failed to upload photo. Please try again.'
+        );
         console.error('Upload error:', err);
         setUploading(false);
         setUploadProgress(0);
@@ -0,0 +1,241 @@
+import { useEffect, useState } from 'react';
+import { User } from '../../api/users';
+import { changePassword, getProfile, requestEmailChange, updateProfile } from '../../api/account';
+import { StorageUsage, formatBytes, getMyUsage } from '../../api/usage';
+
+const AccountPage = () => {
+  const [profile, setProfile] = useState<User | null>(null);
+  const [fullName, setFullName] = useState('');
+  const [email, setEmail] = useState('');
+  const [currentPassword, setCurrentPassword] = useState('');
+  const [newPassword, setNewPassword] = useState('');
+  const [confirmPassword, setConfirmPassword] = useState('');
+  const [message, setMessage] = useState<string | null>(null);
+  const [error, setError] = useState<string | null>(null);
+  const [busy, setBusy] = useState(false);
+  const [storage, setStorage] = useState<{ usage: StorageUsage; quota: number } | null>(null);
+
+  // Load the profile of the current user
+  useEffect(() => {
+    getProfile()
+      .then((user) => {
+        setProfile(user);
+        setFullName(user.full_name || '');
+      })
+      .catch((err) => setError(err instanceof Error ? err.message : 'Failed to load profile'));
+  }, []);
+
+  // Load the storage the user uses
+  useEffect(() => {
+    getMyUsage()
+      .then(setStorage)
+      .catch((err) => console.error('Failed to fetch storage usage:', err));
+  }, []);
+
+  // Run an action, showing its error or success message
+  const run = async (action: () => Promise<string>) => {
+    setBusy(true);
+    setError(null);
+    setMessage(null);
+    try {
+      setMessage(await action());
+    } catch (err) {
+      setError(err instanceof Error ? err.message : 'Request failed');
+    } finally {
+      setBusy(false);
+    }
+  };
+
+  const handleProfile = (e: React.FormEvent) => {
+    e.preventDefault();
+    run(async () => {
+      setProfile(await updateProfile(fullName.trim()));
+      return 'Profile saved.';
+    });
+  };
+
+  const handleEmail = (e: React.FormEvent) => {
+    e.preventDefault();
+    run(async () => {
+      const address = await requestEmailChange(email.trim());
+      setEmail('');
+      return `We sent a link to ${address}. Your email address changes once you open it.`;
+    });
+  };
+
+  const handlePassword = (e: React.FormEvent) => {
+    e.preventDefault();
+    if (newPassword !== confirmPassword) {
+      setError('The passwords do not match');
+      return;
+    }
+    run(async () => {
+      const ended = await changePassword(currentPassword, newPassword);
+      setCurrentPassword('');
+      setNewPassword('');
+      setConfirmPassword('');
+      return ended > 0
+        ? `Password changed. You were signed out on ${ended} other ${ended === 1 ? 'device' : 'devices'}.`
+        : 'Password changed.';
+    });
+  };
+
+  const inputClass =
+    'shadow-sm appearance-none border rounded-md w-full py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-blue-500 focus:border-transparent';
+  const labelClass = 'block text-gray-700 text-sm font-medium mb-2';
+  const buttonClass =
+    'bg-blue-600 hover:bg-blue-700 text-white font-medium py-2 px-4 rounded-md disabled:opacity-50 transition-colors';
+
+  return (
+    <div className="max-w-2xl">
+      <h2 className="text-2xl font-medium text-gray-800 mb-6">Account</h2>
+
+      {error && (
+        <div className="bg-red-100 border border-red-400 text-red-700 px-4 py-3 rounded mb-4" role="alert">
+          <p>{error}</p>
+        </div>
+      )}
+
+      {message && (
+        <div className="bg-green-100 border border-green-400 text-green-700 px-4 py-3 rounded mb-4" role="status">
+          <p>{message}</p>
+        </div>
+      )}
+
+      <form onSubmit={handleProfile} className="bg-white rounded-lg shadow p-6 mb-6">
+        <h3 className="text-lg font-medium text-gray-800 mb-4">Profile</h3>
+        <p className="text-sm text-gray-600 mb-4">
+          Signed in as <span className="font-medium">{profile?.username}</span>
+          {profile && ` (${profile.role})`}
+        </p>
+        <div className="mb-4">
+          <label htmlFor="full-name" className={labelClass}>
+            Full name
+          </label>
+          <input
+            type="text"
+            id="full-name"
+            value={fullName}
+            onChange={(e) => setFullName(e.target.value)}
+            className={inputClass}
+          />
+        </div>
+        <button type="submit" className={buttonClass} disabled={busy || !profile}>
+          Save
+        </button>
+      </form>
+
+      {storage && (
+        <div className="bg-white rounded-lg shadow p-6 mb-6">
+          <h3 className="text-lg font-medium text-gray-800 mb-2">Storage</h3>
+          <p className="text-sm text-gray-600 mb-4">
+            {formatBytes(storage.usage.total.bytes)} used
+            {storage.quota > 0 ? ` of ${formatBytes(storage.quota)}` : ', no limit'}
+          </p>
+          {storage.quota > 0 && (
+            <div className="w-full bg-gray-200 rounded-full h-2 mb-4">
+              <div
+                className={`h-2 rounded-full ${storage.usage.total.bytes >= storage.quota ? 'bg-red-500' : 'bg-blue-600'}`}
+                style={{ width: `${Math.min(100, (storage.usage.total.bytes / storage.quota) * 100)}%` }}
+              ></div>
+            </div>
+          )}
+          <dl className="grid grid-cols-3 gap-4 text-sm">
+            <div>
+              <dt className="text-gray-500">Originals</dt>
+              <dd className="text-gray-800">
+                {formatBytes(storage.usage.originals.bytes)} in {storage.usage.originals.objects} files
+              </dd>
+            </div>
+            <div>
+              <dt className="text-gray-500">Thumbnails and other derivatives</dt>
+              <dd className="text-gray-800">
+                {formatBytes(storage.usage.derivatives.bytes)} in {storage.usage.derivatives.objects} files
+              </dd>
+            </div>
+            <div>
+              <dt className="text-gray-500">In the trash</dt>
+              <dd className="text-gray-800">
+                {formatBytes(storage.usage.trashed.bytes)} in {storage.usage.trashed.objects} photos
+              </dd>
+            </div>
+          </dl>
+        </div>
+      )}
+
+      <form onSubmit={handleEmail} className="bg-white rounded-lg shadow p-6 mb-6">
+        <h3 className="text-lg font-medium text-gray-800 mb-2">Email address</h3>
+        <p className="text-sm text-gray-600 mb-4">
+          {profile?.email ? <>Currently {profile.email}.</> : 'No email address set.'} A new address must be confirmed
+          with the link we send to it.
+        </p>
+        <div className="flex gap-2">
+          <input
+            type="email"
+            value={email}
+            onChange={(e) => setEmail(e.target.value)}
+            className={inputClass}
+            placeholder="New email address"
+            required
+          />
+          <button type="submit" className={buttonClass} disabled={busy}>
+            Send link
+          </button>
+        </div>
+      </form>
+
+      <form onSubmit={handlePassword} className="bg-white rounded-lg shadow p-6">
+        <h3 className="text-lg font-medium text-gray-800 mb-2">Password</h3>
+        <p className="text-sm text-gray-600 mb-4">Changing your password signs you out on your other devices.</p>
+        <div className="mb-4">
+          <label htmlFor="current-password" className={labelClass}>
+            Current password
+          </label>
+          <input
+            type="password"
+            id="current-password"
+            value={currentPassword}
+            onChange={(e) => setCurrentPassword(e.target.value)}
+            autoComplete="current-password"
+            className={inputClass}
+            required
+          />
+        </div>
+        <div className="mb-4">
+          <label htmlFor="account-new-password" className={labelClass}>
+            New password
+          </label>
+          <input
+            type="password"
+            id="account-new-password"
+            value={newPassword}
+            onChange={(e) => setNewPassword(e.target.value)}
+            autoComplete="new-password"
+            minLength={8}
+            className={inputClass}
+            required
+          />
+        </div>
+        <div className="mb-4">
+          <label htmlFor="account-confirm-password" className={labelClass}>
+            Confirm new password
+          </label>
+          <input
+            type="password"
+            id="account-confirm-password"
+            value={confirmPassword}
+            onChange={(e) => setConfirmPassword(e.target.value)}
+            autoComplete="new-password"
+            className={inputClass}
+            required
+          />
+        </div>
+        <button type="submit" className={buttonClass} disabled={busy}>
+          Change password
+        </button>
+      </form>
+    </div>
+  );
+};
+
+export default AccountPage;
@@ -1,20 +1,64 @@
 import { useState, useEffect } from 'react';
-import { User, getUsers, createUser, updateUser, deleteUser } from '../../api/users';
+import { User, UserRole, RoleDefinition, getUsers, createUser, updateUser, deleteUser, getRoles, unlockUser } from '../../api/users';
+import { resetUserMFA, setRoleMFARequired } from '../../api/mfa';
+import { sendPasswordReset } from '../../api/account';
+import { Invite, approveUser, createInvite, deleteInvite, getInvites } from '../../api/invites';
+import { UsageReport, formatBytes, getUsageReport } from '../../api/usage';
+
+// Quotas are entered in GB
+const GB = 1024 * 1024 * 1024;
 
 const AdminPage = () => {
-  const [activeTab, setActiveTab] = useState<'users' | 'settings'>('users');
+  const [activeTab, setActiveTab] = useState<'users' | 'invites' | 'storage' | 'settings'>('users');
   const [users, setUsers] = useState<User[]>([]);
   const [loading, setLoading] = useState(true);
   const [error, setError] = useState<string | null>(null);
   const [userFormOpen, setUserFormOpen] = useState(false);
   const [selectedUser, setSelectedUser] = useState<User | null>(null);
   const [formMode, setFormMode] = useState<'create' | 'edit'>('create');
+  const [roles, setRoles] = useState<RoleDefinition[]>([]);
+  const [invites, setInvites] = useState<Invite[]>([]);
+  const [inviteRole, setInviteRole] = useState<UserRole>('user');
+  const [inviteEmail, setInviteEmail] = useState('');
+  const [inviteDays, setInviteDays] = useState(7);
+  const [usageReport, setUsageReport] = useState<UsageReport | null>(null);
 
   // Load users on component mount
   useEffect(() => {
     fetchUsers();
   }, []);
 
+  // Load the roles and whether they require a second factor
+  useEffect(() => {
+    getRoles()
+      .then(setRoles)
+      .catch((err) => console.error('Failed to fetch roles:', err));
+  }, []);
+
+  // Load the invites when their tab is opened
+  useEffect(() => {
+    if (activeTab === 'invites') {
+      getInvites()
+        .then(setInvites)
+        .catch((err) => {
+          console.error('Failed to fetch invites:', err);
+          setError('Failed to load invites. Please try again.');
+        });
+    }
+  }, [activeTab]);
+
+  // Load the storage report when its tab is opened
+  useEffect(() => {
+    if (activeTab === 'storage') {
+      getUsageReport()
+        .then(setUsageReport)
+        .catch((err) => {
+          console.error('Failed to fetch storage usage:', err);
+          setError('Failed to load storage usage. Please try again.');
+        });
+    }
+  }, [activeTab]);
+
   const fetchUsers = async () => {
     try {
       setLoading(true);
@@ -56,6 +100,105 @@ const AdminPage = () => {
     }
   };
 
+  const handleUnlockUser = async (user: User) => {
+    try {
+      await unlockUser(user.id);
+      setUsers(users.map(u => u.id === user.id ? { ...u, locked_until: undefined } : u));
+    } catch (err) {
+      console.error('Failed to unlock user:', err);
+      setError('Failed to unlock user. Please try again.');
+    }
+  };
+
+  const handleResetPassword = async (user: User) => {
+    if (!window.confirm(`Send ${user.username} a link to choose a new password?`)) {
+      return;
+    }
+
+    try {
+      const reset = await sendPasswordReset(user.id);
+      // Users without email get the link some other way
+      const expires = new Date(reset.expires_at).toLocaleString();
+      if (reset.emailed) {
+        window.alert(`A reset link was emailed to ${user.email}. It expires at ${expires}.`);
+      } else {
+        window.prompt(`No email could be sent. Give ${user.username} this link, which expires at ${expires}:`, reset.reset_url);
+      }
+    } catch (err) {
+      console.error('Failed to create reset link:', err);
+      setError('Failed to create a password reset link. Please try again.');
+    }
+  };
+
+  const handleApproveUser = async (user: User) => {
+    try {
+      const approved = await approveUser(user.id);
+      setUsers(users.map(u => u.id === user.id ? approved : u));
+    } catch (err) {
+      console.error('Failed to approve user:', err);
+      setError('Failed to approve user. Please try again.');
+    }
+  };
+
+  const handleCreateInvite = async (e: React.FormEvent) => {
+    e.preventDefault();
+    try {
+      const created = await createInvite({
+        role: inviteRole,
+        email: inviteEmail.trim() || undefined,
+        expires_at: new Date(Date.now() + inviteDays * 24 * 60 * 60 * 1000).toISOString(),
+      });
+      setInvites([created.invite, ...invites]);
+      setInviteEmail('');
+      // Invites without email are handed out some other way
+      if (created.emailed) {
+        window.alert(`The invite was emailed to ${created.invite.email}.`);
+      } else {
+        window.prompt('Give the invitee this link, which works once:', created.invite_url);
+      }
+    } catch (err) {
+      console.error('Failed to create invite:', err);
+      setError(err instanceof Error ? err.message : 'Failed to create invite. Please try again.');
+    }
+  };
+
+  const handleDeleteInvite = async (invite: Invite) => {
+    if (!invite.used_at && !window.confirm('Revoke this invite? Its link will stop working.')) {
+      return;
+    }
+
+    try {
+      await deleteInvite(invite.id);
+      setInvites(invites.filter(i => i.id !== invite.id));
+    } catch (err) {
+      console.error('Failed to delete invite:', err);
+      setError('Failed to delete invite. Please try again.');
+    }
+  };
+
+  const handleResetMFA = async (user: User) => {
+    if (!window.confirm(`Remove every second factor of ${user.username}? They will log in with their password only.`)) {
+      return;
+    }
+
+    try {
+      await resetUserMFA(user.id);
+    } catch (err) {
+      console.error('Failed to reset MFA:', err);
+      setError('Failed to reset two-factor authentication. Please try again.');
+    }
+  };
+
+  const handleRoleMFA = async (role: RoleDefinition, required: boolean) => {
+    try {
+      await setRoleMFARequired(role.name, required);
+      setRoles(roles.map(r => r.name === role.name ? { ...r, require_mfa: required } : r));
+    } catch (err) {
+      console.error('Failed to update role:', err);
+      setError('Failed to update the role. Please try again.');
+    }
+  };
+
   if (loading) {
     return (
       <div className="flex justify-center items-center h-64">
@@ -83,6 +226,26 @@ const AdminPage = () => {
           >
             Users
           </button>
+          <button
+            onClick={() => setActiveTab('invites')}
+            className={`${
+              activeTab === 'invites'
+                ? 'border-blue-500 text-blue-600'
+                : 'border-transparent text-gray-500 hover:text-gray-700 hover:border-gray-300'
+            } whitespace-nowrap py-3 px-6 border-b-2 font-medium text-sm`}
+          >
+            Invites
+          </button>
+          <button
+            onClick={() => setActiveTab('storage')}
+            className={`${
+              activeTab === 'storage'
+                ? 'border-blue-500 text-blue-600'
+                : 'border-transparent text-gray-500 hover:text-gray-700 hover:border-gray-300'
+            } whitespace-nowrap py-3 px-6 border-b-2 font-medium text-sm`}
+          >
+            Storage
+          </button>
           <button
             onClick={() => setActiveTab('settings')}
             className={`${
@@ -188,6 +351,16 @@ const AdminPage = () => {
                       }`}>
                         {user.active ? 'Active' : 'Inactive'}
                       </span>
+                      {user.pending_approval && (
+                        <span className="ml-2 px-2 inline-flex text-xs leading-5 font-semibold rounded-full bg-blue-100 text-blue-800">
+                          Pending
+                        </span>
+                      )}
+                      {isLocked(user) && (
+                        <span className="ml-2 px-2 inline-flex text-xs leading-5 font-semibold rounded-full bg-yellow-100 text-yellow-800">
+                          Locked
+                        </span>
+                      )}
                     </td>
                     <td className="px-6 py-4 whitespace-nowrap text-sm text-gray-500">
                       {user.last_login ? new Date(user.last_login).toLocaleString() : 'Never'}
@@ -199,6 +372,34 @@ const AdminPage = () => {
                       >
                         Edit
                       </button>
+                      {user.pending_approval && (
+                        <button
+                          onClick={() => handleApproveUser(user)}
+                          className="text-green-600 hover:text-green-900 mr-3"
+                        >
+                          Approve
+                        </button>
+                      )}
+                      {isLocked(user) && (
+                        <button
+                          onClick={() => handleUnlockUser(user)}
+                          className="text-blue-600 hover:text-blue-900 mr-3"
+                        >
+                          Unlock
+                        </button>
+                      )}
+                      <button
+                        onClick={() => handleResetPassword(user)}
+                        className="text-blue-600 hover:text-blue-900 mr-3"
+                      >
+                        Reset Password
+                      </button>
+                      <button
+                        onClick={() => handleResetMFA(user)}
+                        className="text-blue-600 hover:text-blue-900 mr-3"
+                      >
+                        Reset MFA
+                      </button>
                       <button
                         onClick={() => handleDeleteUser(user.id)}
                         className="text-red-600 hover:text-red-900"
@@ -222,6 +423,193 @@ const AdminPage = () => {
         </div>
       )}
 
+      {/* Invites Tab */}
+      {activeTab === 'invites' && (
+        <div>
+          <h3 className="text-lg font-medium mb-4">Invites</h3>
+          <form onSubmit={handleCreateInvite} className="bg-white shadow-md rounded-lg p-6 mb-6">
+            <p className="text-sm text-gray-600 mb-4">
+              An invite link lets one person create an account with the chosen role. It is emailed if you give an
+              address.
+            </p>
+            <div className="grid grid-cols-1 md:grid-cols-4 gap-4 items-end">
+              <div>
+                <label htmlFor="invite-role" className="block text-sm font-medium text-gray-700 mb-1">
+                  Role
+                </label>
+                <select
+                  id="invite-role"
+                  value={inviteRole}
+                  onChange={(e) => setInviteRole(e.target.value)}
+                  className="w-full px-3 py-2 border border-gray-300 rounded-md shadow-sm focus:outline-none focus:ring-blue-500 focus:border-blue-500"
+                >
+                  {roles.map((role) => (
+                    <option key={role.name} value={role.name}>
+                      {role.name}
+                    </option>
+                  ))}
+                </select>
+              </div>
+              <div>
+                <label htmlFor="invite-email" className="block text-sm font-medium text-gray-700 mb-1">
+                  Email (optional)
+                </label>
+                <input
+                  type="email"
+                  id="invite-email"
+                  value={inviteEmail}
+                  onChange={(e) => setInviteEmail(e.target.value)}
+                  className="w-full px-3 py-2 border border-gray-300 rounded-md shadow-sm focus:outline-none focus:ring-blue-500 focus:border-blue-500"
+                />
+              </div>
+              <div>
+                <label htmlFor="invite-expiry" className="block text-sm font-medium text-gray-700 mb-1">
+                  Expires after
+                </label>
+                <select
+                  id="invite-expiry"
+                  value={inviteDays}
+                  onChange={(e) => setInviteDays(Number(e.target.value))}
+                  className="w-full px-3 py-2 border border-gray-300 rounded-md shadow-sm focus:outline-none focus:ring-blue-500 focus:border-blue-500"
+                >
+                  <option value={1}>1 day</option>
+                  <option value={7}>7 days</option>
+                  <option value={30}>30 days</option>
+                </select>
+              </div>
+              <button
+                type="submit"
+                className="bg-blue-600 hover:bg-blue-700 text-white py-2 px-4 rounded-md transition-colors"
+              >
+                Create Invite
+              </button>
+            </div>
+          </form>
+
+          <div className="bg-white shadow-md rounded-lg overflow-hidden">
+            <table className="min-w-full divide-y divide-gray-200">
+              <thead className="bg-gray-50">
+                <tr>
+                  <th scope="col" className="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">
+                    Role
+                  </th>
+                  <th scope="col" className="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">
+                    Email
+                  </th>
+                  <th scope="col" className="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">
+                    Created
+                  </th>
+                  <th scope="col" className="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">
+                    Status
+                  </th>
+                  <th scope="col" className="px-6 py-3 text-right text-xs font-medium text-gray-500 uppercase tracking-wider">
+                    Actions
+                  </th>
+                </tr>
+              </thead>
+              <tbody className="bg-white divide-y divide-gray-200">
+                {invites.map((invite) => (
+                  <tr key={invite.id} className="hover:bg-gray-50">
+                    <td className="px-6 py-4 whitespace-nowrap text-sm text-gray-900">{invite.role}</td>
+                    <td className="px-6 py-4 whitespace-nowrap text-sm text-gray-500">{invite.email || '-'}</td>
+                    <td className="px-6 py-4 whitespace-nowrap text-sm text-gray-500">
+                      {new Date(invite.created_at).toLocaleString()}
+                    </td>
+                    <td className="px-6 py-4 whitespace-nowrap text-sm text-gray-500">
+                      {invite.used_at
+                        ? `Used ${new Date(invite.used_at).toLocaleString()}`
+                        : `Expires ${new Date(invite.expires_at).toLocaleString()}`}
+                    </td>
+                    <td className="px-6 py-4 whitespace-nowrap text-right text-sm font-medium">
+                      <button
+                        onClick={() => handleDeleteInvite(invite)}
+                        className="text-red-600 hover:text-red-900"
+                      >
+                        {invite.used_at ? 'Remove' : 'Revoke'}
+                      </button>
+                    </td>
+                  </tr>
+                ))}
+                {invites.length === 0 && (
+                  <tr>
+                    <td colSpan={5} className="px-6 py-4 text-center text-sm text-gray-500">
+                      No invites
+                    </td>
+                  </tr>
+                )}
+              </tbody>
+            </table>
+          </div>
+        </div>
+      )}
+
+      {/* Storage Tab */}
+      {activeTab === 'storage' && (
+        <div>
+          <div className="flex justify-between mb-4">
+            <h3 className="text-lg font-medium">Storage Usage</h3>
+            {usageReport && (
+              <span className="text-sm text-gray-600">
+                {formatBytes(usageReport.total.bytes)} in {usageReport.total.objects} files
+              </span>
+            )}
+          </div>
+          <div className="bg-white shadow-md rounded-lg overflow-hidden">
+            <table className="min-w-full divide-y divide-gray-200">
+              <thead className="bg-gray-50">
+                <tr>
+                  <th scope="col" className="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">
+                    Owner
+                  </th>
+                  <th scope="col" className="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">
+                    Originals
+                  </th>
+                  <th scope="col" className="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">
+                    Derivatives
+                  </th>
+                  <th scope="col" className="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">
+                    In Trash
+                  </th>
+                  <th scope="col" className="px-6 py-3 text-left text-xs font-medium text-gray-500 uppercase tracking-wider">
+                    Used / Quota
+                  </th>
+                </tr>
+              </thead>
+              <tbody className="bg-white divide-y divide-gray-200">
+                {usageReport?.owners.map((entry) => (
+                  <tr key={entry.owner_id} className="hover:bg-gray-50">
+                    <td className="px-6 py-4 whitespace-nowrap text-sm font-medium text-gray-900">
+                      {entry.username || <span className="font-mono text-gray-500">{entry.owner_id}</span>}
+                    </td>
+                    <td className="px-6 py-4 whitespace-nowrap text-sm text-gray-500">
+                      {formatBytes(entry.usage.originals.bytes)} ({entry.usage.originals.objects})
+                    </td>
+                    <td className="px-6 py-4 whitespace-nowrap text-sm text-gray-500">
+                      {formatBytes(entry.usage.derivatives.bytes)} ({entry.usage.derivatives.objects})
+                    </td>
+                    <td className="px-6 py-4 whitespace-nowrap text-sm text-gray-500">
+                      {formatBytes(entry.usage.trashed.bytes)} ({entry.usage.trashed.objects})
+                    </td>
+                    <td className={`px-6 py-4 whitespace-nowrap text-sm ${
+                      entry.quota > 0 && entry.usage.total.bytes >= entry.quota ? 'text-red-600 font-medium' : 'text-gray-500'
+                    }`}>
+                      {formatBytes(entry.usage.total.bytes)} / {entry.quota > 0 ? formatBytes(entry.quota) : 'no limit'}
+                    </td>
+                  </tr>
+                ))}
+                {usageReport?.owners.length === 0 && (
+                  <tr>
+                    <td colSpan={5} className="px-6 py-4 text-center text-sm text-gray-500">
+                      Nothing stored yet
+                    </td>
+                  </tr>
+                )}
+              </tbody>
+            </table>
+          </div>
+        </div>
+      )}
+
       {/* Settings Tab */}
       {activeTab === 'settings' && (
         <div>
@@ -255,6 +643,26 @@ const AdminPage = () => {
               </div>
             </div>
 
+            <div className="mb-4">
+              <h4 className="text-md font-medium mb-2">Two-Factor Authentication</h4>
+              <div className="bg-gray-50 p-4 rounded-md">
+                <p className="text-sm text-gray-500 mb-3">
+                  Users with a role that requires it must set up an authenticator app or passkey before they can log in.
+                </p>
+                {roles.map((role) => (
+                  <label key={role.name} className="flex items-center text-sm text-gray-700 mb-1">
+                    <input
+                      type="checkbox"
+                      className="mr-2"
+                      checked={role.require_mfa}
+                      onChange={(e) => handleRoleMFA(role, e.target.checked)}
+                    />
+                    Require for {role.name}
+                  </label>
+                ))}
+              </div>
+            </div>
+
             <div className="mb-4">
               <h4 className="text-md font-medium mb-2">Plugin Management</h4>
               <div className="bg-gray-50 p-4 rounded-md">
@@ -279,6 +687,9 @@ const AdminPage = () => {
   );
 };
 
+// isLocked reports whether failed logins have locked a user out for now
+const isLocked = (user: User) => !!user.locked_until && new Date(user.locked_until) > new Date();
+
 // User Form Modal Component
 interface UserFormModalProps {
   user: User | null;
@@ -292,16 +703,28 @@ const UserFormModal = ({ user, mode, onClose, onSuccess }: UserFormModalProps) =
   const [password, setPassword] = useState('');
   const [email, setEmail] = useState(user?.email || '');
   const [fullName, setFullName] = useState(user?.full_name || '');
-  const [role, setRole] = useState<'admin' | 'user'>(user?.role || 'user');
+  const [role, setRole] = useState<UserRole>(user?.role || 'user');
+  const [roles, setRoles] = useState<RoleDefinition[]>([]);
   const [active, setActive] = useState(user?.active !== undefined ? user.active : true);
+  const [quotaGB, setQuotaGB] = useState(user?.storage_quota ? String(user.storage_quota / GB) : '');
   const [loading, setLoading] = useState(false);
   const [error, setError] = useState<string | null>(null);
 
+  // Load the built-in and custom roles to choose from
+  useEffect(() => {
+    getRoles()
+      .then(setRoles)
+      .catch((err) => console.error('Failed to fetch roles:', err));
+  }, []);
+
   const handleSubmit = async (e: React.FormEvent) => {
     e.preventDefault();
     setLoading(true);
     setError(null);
 
+    // An empty quota means no limit
+    const storageQuota = Math.round((parseFloat(quotaGB) || 0) * GB);
+
     try {
       if (mode === 'create') {
         // Create new user
@@ -310,7 +733,8 @@ const UserFormModal = ({ user, mode, onClose, onSuccess }: UserFormModalProps) =
           password,
           email,
           full_name: fullName,
-          role
+          role,
+          storage_quota: storageQuota
         });
         onSuccess(newUser);
       } else if (mode === 'edit' && user) {
@@ -320,7 +744,8 @@ const UserFormModal = ({ user, mode, onClose, onSuccess }: UserFormModalProps) =
           full_name: fullName || undefined,
           password: password || undefined,
           role,
-          active
+          active,
+          storage_quota: storageQuota
         });
         onSuccess(updatedUser);
       }
@@ -408,14 +833,41 @@ const UserFormModal = ({ user, mode, onClose, onSuccess }: UserFormModalProps) =
             <select
               id="role"
               value={role}
-              onChange={(e) => setRole(e.target.value as 'admin' | 'user')}
+              onChange={(e) => setRole(e.target.value)}
               className="w-full px-3 py-2 border border-gray-300 rounded-md focus:outline-none focus:ring-blue-500 focus:border-blue-500"
             >
-              <option value="user">User</option>
-              <option value="admin">Admin</option>
+              {roles.length === 0 ? (
+                <>
+                  <option value="user">User</option>
+                  <option value="viewer">Viewer</option>
+                  <option value="admin">Admin</option>
+                </>
+              ) : (
+                roles.map((r) => (
+                  <option key={r.name} value={r.name} title={r.permissions.join(', ')}>
+                    {r.name}{r.description ? ` - ${r.description}` : ''}
+                  </option>
+                ))
+              )}
             </select>
           </div>
 
+          <div className="mb-4">
+            <label className="block text-sm font-medium text-gray-700 mb-1" htmlFor="storage-quota">
+              Storage quota (GB)
+            </label>
+            <input
+              type="number"
+              id="storage-quota"
+              min="0"
+              step="any"
+              value={quotaGB}
+              onChange={(e) => setQuotaGB(e.target.value)}
+              className="w-full px-3 py-2 border border-gray-300 rounded-md focus:outline-none focus:ring-blue-500 focus:border-blue-500"
+              placeholder="No limit"
+            />
+          </div>
+
           {mode === 'edit' && (
             <div className="mb-4">
               <label className="flex items-center">
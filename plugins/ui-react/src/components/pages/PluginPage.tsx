@@ -0,0 +1,57 @@
+import { useEffect, useRef } from 'react';
+import {
+  PluginUIExtension,
+  PluginMenuEntry,
+  PluginBridgeRequest,
+  PluginBridgeResponse,
+  pluginRequest,
+} from '../../api/plugins';
+
+interface PluginPageProps {
+  extension: PluginUIExtension;
+  entry: PluginMenuEntry;
+}
+
+// Plugin pages run in a sandboxed frame with an opaque origin, so they cannot
+// read the token stored by this app. They call their routes by posting a
+// pixie:request message, which this page sends with the user's token.
+const PluginPage = ({ extension, entry }: PluginPageProps) => {
+  const frame = useRef<HTMLIFrameElement>(null);
+
+  useEffect(() => {
+    const onMessage = async (event: MessageEvent) => {
+      const request = event.data as PluginBridgeRequest;
+      if (event.source !== frame.current?.contentWindow || request?.type !== 'pixie:request' || typeof request.path !== 'string') {
+        return;
+      }
+
+      let response: PluginBridgeResponse;
+      try {
+        response = await pluginRequest(extension.plugin, request);
+      } catch (error) {
+        response = { type: 'pixie:response', id: request.id, error: String(error) };
+      }
+      // The frame's origin is opaque, so it cannot be named as the target
+      frame.current?.contentWindow?.postMessage(response, '*');
+    };
+
+    window.addEventListener('message', onMessage);
+    return () => window.removeEventListener('message', onMessage);
+  }, [extension.plugin]);
+
+  return (
+    <div className="flex flex-col" style={{ height: 'calc(100vh - 108px)' }}>
+      <h2 className="text-2xl font-medium text-gray-800 mb-6">{entry.label}</h2>
+      <iframe
+        ref={frame}
+        key={`${extension.plugin}/${entry.page}`}
+        src={`${extension.base_url}${entry.page}`}
+        title={entry.label}
+        sandbox="allow-scripts allow-forms"
+        className="flex-1 w-full border-0 bg-white rounded shadow"
+      />
+    </div>
+  );
+};
+
+export default PluginPage;
@@ -0,0 +1,238 @@
+import { useEffect, useState } from 'react';
+import {
+  MFAStatus,
+  Passkey,
+  TOTPEnrollment,
+  beginTOTP,
+  confirmTOTP,
+  deletePasskey,
+  disableTOTP,
+  getMFAStatus,
+  getPasskeys,
+  regenerateRecoveryCodes,
+  registerPasskey,
+} from '../../api/mfa';
+
+const SecurityPage = () => {
+  const [status, setStatus] = useState<MFAStatus | null>(null);
+  const [passkeys, setPasskeys] = useState<Passkey[]>([]);
+  const [enrollment, setEnrollment] = useState<TOTPEnrollment | null>(null);
+  const [code, setCode] = useState('');
+  const [recoveryCodes, setRecoveryCodes] = useState<string[] | null>(null);
+  const [passkeyName, setPasskeyName] = useState('');
+  const [error, setError] = useState<string | null>(null);
+  const [busy, setBusy] = useState(false);
+
+  // Load the second factors of the current user
+  const fetchStatus = async () => {
+    try {
+      const mfaStatus = await getMFAStatus();
+      setStatus(mfaStatus);
+      setPasskeys(mfaStatus.passkeys_enabled ? await getPasskeys() : []);
+      setError(null);
+    } catch (err) {
+      setError(err instanceof Error ? err.message : 'Failed to load security settings');
+    }
+  };
+
+  useEffect(() => {
+    fetchStatus();
+  }, []);
+
+  // Run an action, showing its error and reloading the status afterwards
+  const run = async (action: () => Promise<void>) => {
+    setBusy(true);
+    setError(null);
+    try {
+      await action();
+      await fetchStatus();
+    } catch (err) {
+      setError(err instanceof Error ? err.message : 'Request failed');
+    } finally {
+      setBusy(false);
+    }
+  };
+
+  const handleConfirm = (e: React.FormEvent) => {
+    e.preventDefault();
+    run(async () => {
+      setRecoveryCodes(await confirmTOTP(code.trim()));
+      setEnrollment(null);
+      setCode('');
+    });
+  };
+
+  const handleDisable = () => {
+    run(async () => {
+      await disableTOTP(code.trim());
+      setCode('');
+    });
+  };
+
+  const handleRegenerate = () => {
+    run(async () => {
+      setRecoveryCodes(await regenerateRecoveryCodes(code.trim()));
+      setCode('');
+    });
+  };
+
+  const handleAddPasskey = () => {
+    run(async () => {
+      await registerPasskey(passkeyName.trim() || 'Passkey');
+      setPasskeyName('');
+    });
+  };
+
+  const handleDeletePasskey = (passkey: Passkey) => {
+    if (!window.confirm(`Delete the passkey "${passkey.name}"?`)) {
+      return;
+    }
+    run(() => deletePasskey(passkey.id));
+  };
+
+  const inputClass =
+    'shadow-sm appearance-none border rounded-md py-2 px-3 text-gray-700 leading-tight focus:outline-none focus:ring-2 focus:ring-blue-500 focus:border-transparent';
+  const buttonClass =
+    'bg-blue-600 hover:bg-blue-700 text-white font-medium py-2 px-4 rounded-md disabled:opacity-50 transition-colors';
+  const secondaryButtonClass =
+    'py-2 px-4 border border-gray-300 rounded-md text-sm text-gray-700 hover:bg-gray-100 disabled:opacity-50';
+
+  return (
+    <div className="max-w-2xl">
+      <h2 className="text-2xl font-medium text-gray-800 mb-6">Security</h2>
+
+      {error && (
+        <div className="bg-red-100 border border-red-400 text-red-700 px-4 py-3 rounded mb-4" role="alert">
+          <p>{error}</p>
+        </div>
+      )}
+
+      {status?.required && !status.totp && status.passkeys === 0 && (
+        <div className="bg-yellow-100 border border-yellow-400 text-yellow-800 px-4 py-3 rounded mb-4">
+          <p>Your role requires two-factor authentication. Set it up before your next login.</p>
+        </div>
+      )}
+
+      {recoveryCodes && (
+        <div className="bg-white rounded-lg shadow p-6 mb-6">
+          <h3 className="text-lg font-medium text-gray-800 mb-2">Recovery codes</h3>
+          <p className="text-sm text-gray-600 mb-4">
+            Each code signs you in once if you lose your authenticator. They will not be shown again.
+          </p>
+          <ul className="grid grid-cols-2 gap-2 font-mono text-sm bg-gray-50 border rounded-md p-4 mb-4">
+            {recoveryCodes.map((recoveryCode) => (
+              <li key={recoveryCode}>{recoveryCode}</li>
+            ))}
+          </ul>
+          <button type="button" className={secondaryButtonClass} onClick={() => setRecoveryCodes(null)}>
+            I have saved them
+          </button>
+        </div>
+      )}
+
+      <div className="bg-white rounded-lg shadow p-6 mb-6">
+        <h3 className="text-lg font-medium text-gray-800 mb-2">Authenticator app</h3>
+        {!status ? (
+          <p className="text-sm text-gray-500">Loading...</p>
+        ) : status.totp ? (
+          <>
+            <p className="text-sm text-gray-600 mb-4">
+              Enabled. {status.recovery_codes} recovery codes left. Enter a current code to turn it off or get new recovery codes.
+            </p>
+            <div className="flex flex-wrap gap-2">
+              <input
+                type="text"
+                value={code}
+                onChange={(e) => setCode(e.target.value)}
+                autoComplete="one-time-code"
+                className={inputClass}
+                placeholder="Code"
+              />
+              <button type="button" className={secondaryButtonClass} onClick={handleRegenerate} disabled={busy || !code}>
+                New recovery codes
+              </button>
+              <button type="button" className={secondaryButtonClass} onClick={handleDisable} disabled={busy || !code}>
+                Turn off
+              </button>
+            </div>
+          </>
+        ) : enrollment ? (
+          <form onSubmit={handleConfirm}>
+            <p className="text-sm text-gray-600 mb-2">Add this key to your authenticator app, then enter the code it shows.</p>
+            <p className="font-mono text-gray-800 bg-gray-50 border rounded-md p-2 mb-1 break-all">{enrollment.secret}</p>
+            <a href={enrollment.url} className="text-blue-600 hover:text-blue-800 text-xs">
+              Open in authenticator app
+            </a>
+            <div className="flex gap-2 mt-4">
+              <input
+                type="text"
+                value={code}
+                onChange={(e) => setCode(e.target.value)}
+                autoComplete="one-time-code"
+                className={inputClass}
+                placeholder="123456"
+                required
+              />
+              <button type="submit" className={buttonClass} disabled={busy}>
+                Confirm
+              </button>
+            </div>
+          </form>
+        ) : (
+          <>
+            <p className="text-sm text-gray-600 mb-4">Ask for a code from an authenticator app after your password.</p>
+            <button type="button" className={buttonClass} onClick={() => run(async () => setEnrollment(await beginTOTP()))} disabled={busy}>
+              Set up
+            </button>
+          </>
+        )}
+      </div>
+
+      {status?.passkeys_enabled && (
+        <div className="bg-white rounded-lg shadow p-6">
+          <h3 className="text-lg font-medium text-gray-800 mb-2">Passkeys</h3>
+          <p className="text-sm text-gray-600 mb-4">
+            Sign in with your device instead of a password, or use it as a second factor.
+          </p>
+          {passkeys.length > 0 && (
+            <ul className="divide-y border rounded-md mb-4">
+              {passkeys.map((passkey) => (
+                <li key={passkey.id} className="flex items-center justify-between px-4 py-2 text-sm">
+                  <div>
+                    <p className="text-gray-800">{passkey.name}</p>
+                    <p className="text-xs text-gray-500">
+                      Added {new Date(passkey.created_at).toLocaleDateString()}
+                      {passkey.last_used_at && `, last used ${new Date(passkey.last_used_at).toLocaleDateString()}`}
+                    </p>
+                  </div>
+                  <button
+                    type="button"
+                    className="text-red-600 hover:text-red-800"
+                    onClick={() => handleDeletePasskey(passkey)}
+                    disabled={busy}
+                  >
+                    Delete
+                  </button>
+                </li>
+              ))}
+            </ul>
+          )}
+          <div className="flex gap-2">
+            <input
+              type="text"
+              value={passkeyName}
+              onChange={(e) => setPasskeyName(e.target.value)}
+              className={inputClass}
+              placeholder="Name, e.g. Laptop"
+            />
+            <button type="button" className={buttonClass} onClick={handleAddPasskey} disabled={busy}>
+              Add passkey
+            </button>
+          </div>
+        </div>
+      )}
+    </div>
+  );
+};
+
+export default SecurityPage;
@@ -1,5 +1,5 @@
 import { useState, useEffect } from 'react';
-import { Photo, getTrash, restorePhoto, permanentlyDeletePhoto, emptyTrash, getThumbnailUrl } from '../../api';
+import { Photo, getTrash, restorePhoto, permanentlyDeletePhoto, emptyTrash, getThumbnailUrl, hasScope, canChangePhoto } from '../../api';
 import AuthenticatedImage from '../AuthenticatedImage';
 
 interface TrashPageProps {
@@ -13,6 +13,9 @@ const TrashPage = ({ onPhotoClick }: TrashPageProps) => {
   const [isEmptyingTrash, setIsEmptyingTrash] = useState(false);
   const [dateGroups, setDateGroups] = useState<{[key: string]: Photo[]}>({});
 
+  // Only offer what the user's role allows
+  const canEmpty = hasScope('photos:delete');
+
   // Fetch all trashed photos
   const fetchTrash = async () => {
     try {
@@ -132,7 +135,7 @@ const TrashPage = ({ onPhotoClick }: TrashPageProps) => {
     <div>
       <div className="flex justify-between items-center mb-6">
         <h2 className="text-2xl font-medium text-gray-800">Trash</h2>
-        {trashedPhotos.length > 0 && (
+        {trashedPhotos.length > 0 && canEmpty && (
           <button
             onClick={handleEmptyTrash}
             disabled={isEmptyingTrash}
@@ -202,24 +205,28 @@ const TrashPage = ({ onPhotoClick }: TrashPageProps) => {
                       {/* Hover overlay with file info and actions */}
                       <div className="absolute inset-0 p-2 flex flex-col justify-between opacity-0 group-hover:opacity-100 transition-opacity text-white z-20">
                         <div className="flex justify-end space-x-2">
-                          <button 
-                            onClick={(e) => handleRestore(photo, e)}
-                            className="p-2 bg-green-600 bg-opacity-80 rounded-full hover:bg-opacity-100 transition-opacity"
-                            title="Restore photo"
-                          >
-                            <svg xmlns="http://www.w3.org/2000/svg" className="h-4 w-4" fill="none" viewBox="0 0 24 24" stroke="currentColor">
-                              <path strokeLinecap="round" strokeLinejoin="round" strokeWidth={2} d="M3 10h10a8 8 0 018 8v2M3 10l6 6m-6-6l6-6" />
-                            </svg>
-                          </button>
-                          <button 
-                            onClick={(e) => handleDelete(photo, e)}
-                            className="p-2 bg-red-600 bg-opacity-80 rounded-full hover:bg-opacity-100 transition-opacity"
-                            title="Delete permanently"
-                          >
-                            <svg xmlns="http://www.w3.org/2000/svg" className="h-4 w-4" fill="none" viewBox="0 0 24 24" stroke="currentColor">
-                              <path strokeLinecap="round" strokeLinejoin="round" strokeWidth={2} d="M19 7l-.867 12.142A2 2 0 0116.138 21H7.862a2 2 0 01-1.995-1.858L5 7m5 4v6m4-6v6m1-10V4a1 1 0 00-1-1h-4a1 1 0 00-1 1v3M4 7h16" />
-                            </svg>
-                          </button>
+                          {canChangePhoto(photo) && (
+                            <button 
+                              onClick={(e) => handleRestore(photo, e)}
+                              className="p-2 bg-green-600 bg-opacity-80 rounded-full hover:bg-opacity-100 transition-opacity"
+                              title="Restore photo"
+                            >
+                              <svg xmlns="http://www.w3.org/2000/svg" className="h-4 w-4" fill="none" viewBox="0 0 24 24" stroke="currentColor">
+                                <path strokeLinecap="round" strokeLinejoin="round" strokeWidth={2} d="M3 10h10a8 8 0 018 8v2M3 10l6 6m-6-6l6-6" />
+                              </svg>
+                            </button>
+                          )}
+                          {canChangePhoto(photo) && (
+                            <button 
+                              onClick={(e) => handleDelete(photo, e)}
+                              className="p-2 bg-red-600 bg-opacity-80 rounded-full hover:bg-opacity-100 transition-opacity"
+                              title="Delete permanently"
+                            >
+                              <svg xmlns="http://www.w3.org/2000/svg" className="h-4 w-4" fill="none" viewBox="0 0 24 24" stroke="currentColor">
+                                <path strokeLinecap="round" strokeLinejoin="round" strokeWidth={2} d="M19 7l-.867 12.142A2 2 0 0116.138 21H7.862a2 2 0 01-1.995-1.858L5 7m5 4v6m4-6v6m1-10V4a1 1 0 00-1-1h-4a1 1 0 00-1 1v3M4 7h16" />
+                              </svg>
+                            </button>
+                          )}
                         </div>
                         <div>
                           <p className="text-sm truncate">{photo.filename}</p>
@@ -0,0 +1,662 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: plugin/v1/plugin.proto
+
+package pluginv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	PhotoPlugin_ProcessPhoto_FullMethodName  = "/plugin.v1.PhotoPlugin/ProcessPhoto"
+	PhotoPlugin_Search_FullMethodName        = "/plugin.v1.PhotoPlugin/Search"
+	PhotoPlugin_ValidateToken_FullMethodName = "/plugin.v1.PhotoPlugin/ValidateToken"
+	PhotoPlugin_Configure_FullMethodName     = "/plugin.v1.PhotoPlugin/Configure"
+	PhotoPlugin_HandleHTTP_FullMethodName    = "/plugin.v1.PhotoPlugin/HandleHTTP"
+)
+
+// PhotoPluginClient is the client API for PhotoPlugin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type PhotoPluginClient interface {
+	ProcessPhoto(ctx context.Context, in *Photo, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResult, error)
+	ValidateToken(ctx context.Context, in *ValidateTokenRequest, opts ...grpc.CallOption) (*ValidateTokenResponse, error)
+	Configure(ctx context.Context, in *ConfigureRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	HandleHTTP(ctx context.Context, in *HTTPRequest, opts ...grpc.CallOption) (*HTTPResponse, error)
+}
+
+type photoPluginClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPhotoPluginClient(cc grpc.ClientConnInterface) PhotoPluginClient {
+	return &photoPluginClient{cc}
+}
+
+func (c *photoPluginClient) ProcessPhoto(ctx context.Context, in *Photo, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, PhotoPlugin_ProcessPhoto_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *photoPluginClient) Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResult, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SearchResult)
+	err := c.cc.Invoke(ctx, PhotoPlugin_Search_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *photoPluginClient) ValidateToken(ctx context.Context, in *ValidateTokenRequest, opts ...grpc.CallOption) (*ValidateTokenResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ValidateTokenResponse)
+	err := c.cc.Invoke(ctx, PhotoPlugin_ValidateToken_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *photoPluginClient) Configure(ctx context.Context, in *ConfigureRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, PhotoPlugin_Configure_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *photoPluginClient) HandleHTTP(ctx context.Context, in *HTTPRequest, opts ...grpc.CallOption) (*HTTPResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(HTTPResponse)
+	err := c.cc.Invoke(ctx, PhotoPlugin_HandleHTTP_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PhotoPluginServer is the server API for PhotoPlugin service.
+// All implementations should embed UnimplementedPhotoPluginServer
+// for forward compatibility.
+type PhotoPluginServer interface {
+	ProcessPhoto(context.Context, *Photo) (*emptypb.Empty, error)
+	Search(context.Context, *SearchRequest) (*SearchResult, error)
+	ValidateToken(context.Context, *ValidateTokenRequest) (*ValidateTokenResponse, error)
+	Configure(context.Context, *ConfigureRequest) (*emptypb.Empty, error)
+	HandleHTTP(context.Context, *HTTPRequest) (*HTTPResponse, error)
+}
+
+// UnimplementedPhotoPluginServer should be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedPhotoPluginServer struct{}
+
+func (UnimplementedPhotoPluginServer) ProcessPhoto(context.Context, *Photo) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ProcessPhoto not implemented")
+}
+func (UnimplementedPhotoPluginServer) Search(context.Context, *SearchRequest) (*SearchResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Search not implemented")
+}
+func (UnimplementedPhotoPluginServer) ValidateToken(context.Context, *ValidateTokenRequest) (*ValidateTokenResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ValidateToken not implemented")
+}
+func (UnimplementedPhotoPluginServer) Configure(context.Context, *ConfigureRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Configure not implemented")
+}
+func (UnimplementedPhotoPluginServer) HandleHTTP(context.Context, *HTTPRequest) (*HTTPResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method HandleHTTP not implemented")
+}
+func (UnimplementedPhotoPluginServer) testEmbeddedByValue() {}
+
+// UnsafePhotoPluginServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PhotoPluginServer will
+// result in compilation errors.
+type UnsafePhotoPluginServer interface {
+	mustEmbedUnimplementedPhotoPluginServer()
+}
+
+func RegisterPhotoPluginServer(s grpc.ServiceRegistrar, srv PhotoPluginServer) {
+	// If the following call pancis, it indicates UnimplementedPhotoPluginServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&PhotoPlugin_ServiceDesc, srv)
+}
+
+func _PhotoPlugin_ProcessPhoto_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Photo)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PhotoPluginServer).ProcessPhoto(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PhotoPlugin_ProcessPhoto_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PhotoPluginServer).ProcessPhoto(ctx, req.(*Photo))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PhotoPlugin_Search_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PhotoPluginServer).Search(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PhotoPlugin_Search_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PhotoPluginServer).Search(ctx, req.(*SearchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PhotoPlugin_ValidateToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ValidateTokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PhotoPluginServer).ValidateToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PhotoPlugin_ValidateToken_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PhotoPluginServer).ValidateToken(ctx, req.(*ValidateTokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PhotoPlugin_Configure_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ConfigureRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PhotoPluginServer).Configure(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PhotoPlugin_Configure_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PhotoPluginServer).Configure(ctx, req.(*ConfigureRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PhotoPlugin_HandleHTTP_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HTTPRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PhotoPluginServer).HandleHTTP(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PhotoPlugin_HandleHTTP_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PhotoPluginServer).HandleHTTP(ctx, req.(*HTTPRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// PhotoPlugin_ServiceDesc is the grpc.ServiceDesc for PhotoPlugin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var PhotoPlugin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "plugin.v1.PhotoPlugin",
+	HandlerType: (*PhotoPluginServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ProcessPhoto",
+			Handler:    _PhotoPlugin_ProcessPhoto_Handler,
+		},
+		{
+			MethodName: "Search",
+			Handler:    _PhotoPlugin_Search_Handler,
+		},
+		{
+			MethodName: "ValidateToken",
+			Handler:    _PhotoPlugin_ValidateToken_Handler,
+		},
+		{
+			MethodName: "Configure",
+			Handler:    _PhotoPlugin_Configure_Handler,
+		},
+		{
+			MethodName: "HandleHTTP",
+			Handler:    _PhotoPlugin_HandleHTTP_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "plugin/v1/plugin.proto",
+}
+
+const (
+	HostServices_ReadOriginal_FullMethodName    = "/plugin.v1.HostServices/ReadOriginal"
+	HostServices_WriteDerivative_FullMethodName = "/plugin.v1.HostServices/WriteDerivative"
+	HostServices_PatchMetadata_FullMethodName   = "/plugin.v1.HostServices/PatchMetadata"
+	HostServices_EmitEvent_FullMethodName       = "/plugin.v1.HostServices/EmitEvent"
+	HostServices_Log_FullMethodName             = "/plugin.v1.HostServices/Log"
+)
+
+// HostServicesClient is the client API for HostServices service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type HostServicesClient interface {
+	ReadOriginal(ctx context.Context, in *ReadOriginalRequest, opts ...grpc.CallOption) (*ReadOriginalResponse, error)
+	WriteDerivative(ctx context.Context, in *WriteDerivativeRequest, opts ...grpc.CallOption) (*WriteDerivativeResponse, error)
+	PatchMetadata(ctx context.Context, in *PatchMetadataRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	EmitEvent(ctx context.Context, in *EmitEventRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	Log(ctx context.Context, in *LogRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+}
+
+type hostServicesClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewHostServicesClient(cc grpc.ClientConnInterface) HostServicesClient {
+	return &hostServicesClient{cc}
+}
+
+func (c *hostServicesClient) ReadOriginal(ctx context.Context, in *ReadOriginalRequest, opts ...grpc.CallOption) (*ReadOriginalResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ReadOriginalResponse)
+	err := c.cc.Invoke(ctx, HostServices_ReadOriginal_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hostServicesClient) WriteDerivative(ctx context.Context, in *WriteDerivativeRequest, opts ...grpc.CallOption) (*WriteDerivativeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(WriteDerivativeResponse)
+	err := c.cc.Invoke(ctx, HostServices_WriteDerivative_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hostServicesClient) PatchMetadata(ctx context.Context, in *PatchMetadataRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, HostServices_PatchMetadata_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hostServicesClient) EmitEvent(ctx context.Context, in *EmitEventRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, HostServices_EmitEvent_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *hostServicesClient) Log(ctx context.Context, in *LogRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, HostServices_Log_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// HostServicesServer is the server API for HostServices service.
+// All implementations should embed UnimplementedHostServicesServer
+// for forward compatibility.
+type HostServicesServer interface {
+	ReadOriginal(context.Context, *ReadOriginalRequest) (*ReadOriginalResponse, error)
+	WriteDerivative(context.Context, *WriteDerivativeRequest) (*WriteDerivativeResponse, error)
+	PatchMetadata(context.Context, *PatchMetadataRequest) (*emptypb.Empty, error)
+	EmitEvent(context.Context, *EmitEventRequest) (*emptypb.Empty, error)
+	Log(context.Context, *LogRequest) (*emptypb.Empty, error)
+}
+
+// UnimplementedHostServicesServer should be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedHostServicesServer struct{}
+
+func (UnimplementedHostServicesServer) ReadOriginal(context.Context, *ReadOriginalRequest) (*ReadOriginalResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReadOriginal not implemented")
+}
+func (UnimplementedHostServicesServer) WriteDerivative(context.Context, *WriteDerivativeRequest) (*WriteDerivativeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method WriteDerivative not implemented")
+}
+func (UnimplementedHostServicesServer) PatchMetadata(context.Context, *PatchMetadataRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PatchMetadata not implemented")
+}
+func (UnimplementedHostServicesServer) EmitEvent(context.Context, *EmitEventRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EmitEvent not implemented")
+}
+func (UnimplementedHostServicesServer) Log(context.Context, *LogRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Log not implemented")
+}
+func (UnimplementedHostServicesServer) testEmbeddedByValue() {}
+
+// UnsafeHostServicesServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to HostServicesServer will
+// result in compilation errors.
+type UnsafeHostServicesServer interface {
+	mustEmbedUnimplementedHostServicesServer()
+}
+
+func RegisterHostServicesServer(s grpc.ServiceRegistrar, srv HostServicesServer) {
+	// If the following call pancis, it indicates UnimplementedHostServicesServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&HostServices_ServiceDesc, srv)
+}
+
+func _HostServices_ReadOriginal_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReadOriginalRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HostServicesServer).ReadOriginal(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HostServices_ReadOriginal_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HostServicesServer).ReadOriginal(ctx, req.(*ReadOriginalRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HostServices_WriteDerivative_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WriteDerivativeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HostServicesServer).WriteDerivative(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HostServices_WriteDerivative_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HostServicesServer).WriteDerivative(ctx, req.(*WriteDerivativeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HostServices_PatchMetadata_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PatchMetadataRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HostServicesServer).PatchMetadata(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HostServices_PatchMetadata_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HostServicesServer).PatchMetadata(ctx, req.(*PatchMetadataRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HostServices_EmitEvent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EmitEventRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HostServicesServer).EmitEvent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HostServices_EmitEvent_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HostServicesServer).EmitEvent(ctx, req.(*EmitEventRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HostServices_Log_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LogRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HostServicesServer).Log(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HostServices_Log_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HostServicesServer).Log(ctx, req.(*LogRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// HostServices_ServiceDesc is the grpc.ServiceDesc for HostServices service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var HostServices_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "plugin.v1.HostServices",
+	HandlerType: (*HostServicesServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ReadOriginal",
+			Handler:    _HostServices_ReadOriginal_Handler,
+		},
+		{
+			MethodName: "WriteDerivative",
+			Handler:    _HostServices_WriteDerivative_Handler,
+		},
+		{
+			MethodName: "PatchMetadata",
+			Handler:    _HostServices_PatchMetadata_Handler,
+		},
+		{
+			MethodName: "EmitEvent",
+			Handler:    _HostServices_EmitEvent_Handler,
+		},
+		{
+			MethodName: "Log",
+			Handler:    _HostServices_Log_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "plugin/v1/plugin.proto",
+}
+
+const (
+	PluginRegistry_Register_FullMethodName   = "/plugin.v1.PluginRegistry/Register"
+	PluginRegistry_Unregister_FullMethodName = "/plugin.v1.PluginRegistry/Unregister"
+)
+
+// PluginRegistryClient is the client API for PluginRegistry service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type PluginRegistryClient interface {
+	Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*RegisterResponse, error)
+	Unregister(ctx context.Context, in *UnregisterRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+}
+
+type pluginRegistryClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPluginRegistryClient(cc grpc.ClientConnInterface) PluginRegistryClient {
+	return &pluginRegistryClient{cc}
+}
+
+func (c *pluginRegistryClient) Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*RegisterResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RegisterResponse)
+	err := c.cc.Invoke(ctx, PluginRegistry_Register_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *pluginRegistryClient) Unregister(ctx context.Context, in *UnregisterRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, PluginRegistry_Unregister_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PluginRegistryServer is the server API for PluginRegistry service.
+// All implementations should embed UnimplementedPluginRegistryServer
+// for forward compatibility.
+type PluginRegistryServer interface {
+	Register(context.Context, *RegisterRequest) (*RegisterResponse, error)
+	Unregister(context.Context, *UnregisterRequest) (*emptypb.Empty, error)
+}
+
+// UnimplementedPluginRegistryServer should be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedPluginRegistryServer struct{}
+
+func (UnimplementedPluginRegistryServer) Register(context.Context, *RegisterRequest) (*RegisterResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Register not implemented")
+}
+func (UnimplementedPluginRegistryServer) Unregister(context.Context, *UnregisterRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Unregister not implemented")
+}
+func (UnimplementedPluginRegistryServer) testEmbeddedByValue() {}
+
+// UnsafePluginRegistryServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PluginRegistryServer will
+// result in compilation errors.
+type UnsafePluginRegistryServer interface {
+	mustEmbedUnimplementedPluginRegistryServer()
+}
+
+func RegisterPluginRegistryServer(s grpc.ServiceRegistrar, srv PluginRegistryServer) {
+	// If the following call pancis, it indicates UnimplementedPluginRegistryServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&PluginRegistry_ServiceDesc, srv)
+}
+
+func _PluginRegistry_Register_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginRegistryServer).Register(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PluginRegistry_Register_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginRegistryServer).Register(ctx, req.(*RegisterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _PluginRegistry_Unregister_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnregisterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PluginRegistryServer).Unregister(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PluginRegistry_Unregister_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PluginRegistryServer).Unregister(ctx, req.(*UnregisterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// PluginRegistry_ServiceDesc is the grpc.ServiceDesc for PluginRegistry service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var PluginRegistry_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "plugin.v1.PluginRegistry",
+	HandlerType: (*PluginRegistryServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Register",
+			Handler:    _PluginRegistry_Register_Handler,
+		},
+		{
+			MethodName: "Unregister",
+			Handler:    _PluginRegistry_Unregister_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "plugin/v1/plugin.proto",
+}
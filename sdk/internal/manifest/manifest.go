@@ -0,0 +1,54 @@
+// Package manifest reads the parts of a plugin.yaml the SDK needs. Core does the
+// full validation when the plugin is loaded or registers.
+package manifest
+
+import (
+	"errors"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Manifest is a parsed plugin.yaml
+type Manifest struct {
+	Name        string   `yaml:"name"`
+	Permissions []string `yaml:"permissions"`
+	Settings    struct {
+		Properties map[string]struct {
+			Default interface{} `yaml:"default"`
+		} `yaml:"properties"`
+	} `yaml:"settings"`
+}
+
+// Parse parses a plugin.yaml
+func Parse(data []byte) (*Manifest, error) {
+	var m Manifest
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	if m.Name == "" {
+		return nil, errors.New("manifest has no name")
+	}
+	return &m, nil
+}
+
+// Defaults returns the default value of every setting in the manifest's schema
+func (m *Manifest) Defaults() map[string]interface{} {
+	defaults := make(map[string]interface{})
+	for name, property := range m.Settings.Properties {
+		if property.Default != nil {
+			defaults[name] = property.Default
+		}
+	}
+	return defaults
+}
+
+// Allows reports whether the manifest requests a permission
+func (m *Manifest) Allows(permission string) bool {
+	for _, p := range m.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package plugin
+
+import (
+	"context"
+	"sync"
+)
+
+// Photo events core delivers to plugins that subscribe to them in their manifest
+const (
+	EventPhotoUploaded = "photo.uploaded"
+	EventPhotoDeleted  = "photo.deleted"
+)
+
+// EventHandler handles a photo event
+type EventHandler func(ctx context.Context, photo *Photo) error
+
+// Events routes the photo events core delivers to handlers by subject. Embed it
+// in a plugin and register handlers in Init; events without a handler are ignored.
+type Events struct {
+	mu       sync.RWMutex
+	handlers map[string]EventHandler
+}
+
+// On registers the handler for an event subject
+func (e *Events) On(subject string, fn EventHandler) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.handlers == nil {
+		e.handlers = make(map[string]EventHandler)
+	}
+	e.handlers[subject] = fn
+}
+
+// OnPhotoUploaded registers the handler for photo.uploaded
+func (e *Events) OnPhotoUploaded(fn EventHandler) {
+	e.On(EventPhotoUploaded, fn)
+}
+
+// OnPhotoDeleted registers the handler for photo.deleted
+func (e *Events) OnPhotoDeleted(fn EventHandler) {
+	e.On(EventPhotoDeleted, fn)
+}
+
+// ProcessPhoto implements Processor
+func (e *Events) ProcessPhoto(ctx context.Context, photo *Photo) error {
+	e.mu.RLock()
+	fn := e.handlers[photo.Event]
+	e.mu.RUnlock()
+
+	if fn == nil {
+		return nil
+	}
+	return fn(ctx, photo)
+}
@@ -0,0 +1,122 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+
+	pluginv1 "pixie/sdk/internal/gen/plugin/v1"
+)
+
+// MaxFileSize is the largest original or derivative core exchanges with plugins
+const MaxFileSize = 64 << 20
+
+// maxMessageSize leaves room for the rest of a message around a file
+const maxMessageSize = MaxFileSize + 1<<20
+
+// ErrNoHost is returned by Core.Host when core did not pass host services
+var ErrNoHost = errors.New("host services are not available; is the plugin running under core?")
+
+// HostClient calls the host services core exposes to plugins. Each call needs
+// the matching permission in the plugin's manifest.
+type HostClient struct {
+	conn   *grpc.ClientConn
+	client pluginv1.HostServicesClient
+
+	mu    sync.RWMutex
+	token string
+}
+
+// dialHost connects to the host services at addr using the plugin's token
+func dialHost(addr, token string, creds credentials.TransportCredentials) (*HostClient, error) {
+	conn, err := grpc.Dial(addr,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultCallOptions(
+			grpc.MaxCallRecvMsgSize(maxMessageSize),
+			grpc.MaxCallSendMsgSize(maxMessageSize),
+		),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to host services: %w", err)
+	}
+	return &HostClient{conn: conn, client: pluginv1.NewHostServicesClient(conn), token: token}, nil
+}
+
+// Close closes the connection to core
+func (c *HostClient) Close() error {
+	return c.conn.Close()
+}
+
+// setToken replaces the plugin's token after registering with core again
+func (c *HostClient) setToken(token string) {
+	c.mu.Lock()
+	c.token = token
+	c.mu.Unlock()
+}
+
+// authorize adds the plugin's token to the metadata of a host service call
+func (c *HostClient) authorize(ctx context.Context) context.Context {
+	c.mu.RLock()
+	token := c.token
+	c.mu.RUnlock()
+
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+}
+
+// ReadOriginal returns the original file of a photo and its MIME type.
+// Requires the photos.read permission.
+func (c *HostClient) ReadOriginal(ctx context.Context, photoID string) ([]byte, string, error) {
+	out, err := c.client.ReadOriginal(c.authorize(ctx), &pluginv1.ReadOriginalRequest{PhotoId: photoID})
+	if err != nil {
+		return nil, "", err
+	}
+	return out.Data, out.Mime, nil
+}
+
+// WriteDerivative stores a file derived from a photo and returns its storage key.
+// Requires the derivatives.write permission.
+func (c *HostClient) WriteDerivative(ctx context.Context, photoID, name, mime string, data []byte) (string, error) {
+	req := &pluginv1.WriteDerivativeRequest{PhotoId: photoID, Name: name, Mime: mime, Data: data}
+	out, err := c.client.WriteDerivative(c.authorize(ctx), req)
+	if err != nil {
+		return "", err
+	}
+	return out.Key, nil
+}
+
+// PatchMetadata merges patch into the plugin's metadata namespace of a photo.
+// Requires the metadata.write permission.
+func (c *HostClient) PatchMetadata(ctx context.Context, photoID string, patch map[string]interface{}) error {
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata patch: %w", err)
+	}
+	req := &pluginv1.PatchMetadataRequest{PhotoId: photoID, PatchJson: string(data)}
+	_, err = c.client.PatchMetadata(c.authorize(ctx), req)
+	return err
+}
+
+// EmitEvent publishes data as JSON on plugin.<plugin>.<name>.
+// Requires the events.emit permission.
+func (c *HostClient) EmitEvent(ctx context.Context, name string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	req := &pluginv1.EmitEventRequest{Name: name, DataJson: string(payload)}
+	_, err = c.client.EmitEvent(c.authorize(ctx), req)
+	return err
+}
+
+// log writes a record to core's log
+func (c *HostClient) log(ctx context.Context, level, message, attrs string) error {
+	req := &pluginv1.LogRequest{Level: level, Message: message, AttrsJson: attrs}
+	_, err := c.client.Log(c.authorize(ctx), req)
+	return err
+}
@@ -0,0 +1,95 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	pluginv1 "pixie/sdk/internal/gen/plugin/v1"
+)
+
+// userKey is the context key of the User calling a plugin route
+type userKey struct{}
+
+// User is the authenticated caller of a plugin route
+type User struct {
+	ID     string
+	Claims map[string]interface{}
+}
+
+// IsAdmin reports whether the user has the admin role
+func (u User) IsAdmin() bool {
+	role, _ := u.Claims["role"].(string)
+	return role == "admin"
+}
+
+// UserFromContext returns the caller of a plugin route. Core authenticates every
+// request before forwarding it, so it is always set inside an http.Handler.
+func UserFromContext(ctx context.Context) (User, bool) {
+	user, ok := ctx.Value(userKey{}).(User)
+	return user, ok
+}
+
+// serveHTTP runs a proxied request through handler and records the response
+func serveHTTP(ctx context.Context, handler http.Handler, in *pluginv1.HTTPRequest) (*pluginv1.HTTPResponse, error) {
+	user := User{ID: in.UserId, Claims: map[string]interface{}{}}
+	if in.ClaimsJson != "" && in.ClaimsJson != "null" {
+		if err := json.Unmarshal([]byte(in.ClaimsJson), &user.Claims); err != nil {
+			return nil, err
+		}
+	}
+
+	target := &url.URL{Path: in.Path, RawQuery: in.Query}
+	req, err := http.NewRequestWithContext(context.WithValue(ctx, userKey{}, user), in.Method, target.String(), bytes.NewReader(in.Body))
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range in.Headers {
+		req.Header.Set(key, value)
+	}
+	req.RequestURI = target.RequestURI()
+
+	w := &responseRecorder{header: make(http.Header)}
+	handler.ServeHTTP(w, req)
+
+	if w.header.Get("Content-Type") == "" && w.body.Len() > 0 {
+		w.header.Set("Content-Type", http.DetectContentType(w.body.Bytes()))
+	}
+	headers := make(map[string]string, len(w.header))
+	for key := range w.header {
+		headers[key] = w.header.Get(key)
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return &pluginv1.HTTPResponse{Status: int32(w.status), Headers: headers, Body: w.body.Bytes()}, nil
+}
+
+// responseRecorder is the http.ResponseWriter passed to plugin handlers
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+// Header implements http.ResponseWriter
+func (w *responseRecorder) Header() http.Header {
+	return w.header
+}
+
+// WriteHeader implements http.ResponseWriter
+func (w *responseRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// Write implements http.ResponseWriter
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(data)
+}
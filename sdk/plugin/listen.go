@@ -0,0 +1,100 @@
+package plugin
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// listen opens the listener core asked for and prints the handshake core waits for.
+// Core passes a private Unix socket in PIXIE_PLUGIN_SOCKET; otherwise the plugin
+// listens on a TCP port, with mTLS if core passed certificates.
+func listen(port int, out io.Writer) (net.Listener, []grpc.ServerOption, error) {
+	if socket := os.Getenv("PIXIE_PLUGIN_SOCKET"); socket != "" {
+		lis, err := net.Listen("unix", socket)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to listen on %s: %w", socket, err)
+		}
+		fmt.Fprintf(out, "SOCKET=%s\n", socket)
+		return lis, nil, nil
+	}
+
+	var opts []grpc.ServerOption
+	if certFile := os.Getenv("PIXIE_PLUGIN_TLS_CERT"); certFile != "" {
+		config, err := serverTLSConfig(certFile, os.Getenv("PIXIE_PLUGIN_TLS_KEY"), os.Getenv("PIXIE_PLUGIN_TLS_CA"))
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(config)))
+	}
+
+	lis, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	fmt.Fprintf(out, "PORT=%d\n", lis.Addr().(*net.TCPAddr).Port)
+	return lis, opts, nil
+}
+
+// coreServerName is the name in the certificate core serves remote plugins with
+const coreServerName = "pixie-core"
+
+// listenRemote listens on port on all interfaces for a plugin that registers
+// with core. Both directions use mTLS with the certificate from "pixie-core
+// plugin cert", passed in PIXIE_PLUGIN_TLS_CERT, PIXIE_PLUGIN_TLS_KEY and
+// PIXIE_PLUGIN_TLS_CA. It returns the credentials to call core with.
+func listenRemote(port int) (net.Listener, []grpc.ServerOption, credentials.TransportCredentials, error) {
+	certFile, keyFile, caFile := os.Getenv("PIXIE_PLUGIN_TLS_CERT"), os.Getenv("PIXIE_PLUGIN_TLS_KEY"), os.Getenv("PIXIE_PLUGIN_TLS_CA")
+	if certFile == "" || keyFile == "" || caFile == "" {
+		return nil, nil, nil, errors.New("PIXIE_PLUGIN_TLS_CERT, PIXIE_PLUGIN_TLS_KEY and PIXIE_PLUGIN_TLS_CA must be set to register with core")
+	}
+	config, err := serverTLSConfig(certFile, keyFile, caFile)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Core only accepts the certificate for the plugin's own name
+	clientConfig := &tls.Config{
+		Certificates: config.Certificates,
+		RootCAs:      config.ClientCAs,
+		ServerName:   coreServerName,
+		MinVersion:   tls.VersionTLS13,
+	}
+
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	return lis, []grpc.ServerOption{grpc.Creds(credentials.NewTLS(config))}, credentials.NewTLS(clientConfig), nil
+}
+
+// serverTLSConfig only accepts clients with a certificate issued by core's CA
+func serverTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TLS CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, errors.New("no certificates found in TLS CA")
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS13,
+	}, nil
+}
@@ -0,0 +1,127 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// logTimeout bounds sending a single record to core
+const logTimeout = 2 * time.Second
+
+// coreHandler is a slog.Handler that sends records to core's log through the
+// host services. Records are written to stderr when core cannot be reached.
+type coreHandler struct {
+	host     *HostClient
+	fallback slog.Handler
+	attrs    []slog.Attr
+	prefix   string
+}
+
+// newLogger returns a logger writing to core, or to stderr without host services
+func newLogger(host *HostClient) *slog.Logger {
+	fallback := slog.NewTextHandler(os.Stderr, nil)
+	if host == nil {
+		return slog.New(fallback)
+	}
+	return slog.New(&coreHandler{host: host, fallback: fallback})
+}
+
+// Enabled implements slog.Handler
+func (h *coreHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= slog.LevelInfo
+}
+
+// Handle implements slog.Handler
+func (h *coreHandler) Handle(ctx context.Context, r slog.Record) error {
+	attrs := make(map[string]interface{})
+	for _, a := range h.attrs {
+		addAttr(attrs, "", a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		addAttr(attrs, h.prefix, a)
+		return true
+	})
+
+	var data []byte
+	if len(attrs) > 0 {
+		var err error
+		if data, err = json.Marshal(attrs); err != nil {
+			return h.fallback.Handle(ctx, r)
+		}
+	}
+
+	// The record may outlive the request that logged it
+	sendCtx, cancel := context.WithTimeout(context.Background(), logTimeout)
+	defer cancel()
+	if err := h.host.log(sendCtx, levelName(r.Level), r.Message, string(data)); err != nil {
+		return h.fallback.Handle(ctx, r)
+	}
+	return nil
+}
+
+// WithAttrs implements slog.Handler
+func (h *coreHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.fallback = h.fallback.WithAttrs(attrs)
+	clone.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, a := range attrs {
+		a.Key = h.prefix + a.Key
+		clone.attrs = append(clone.attrs, a)
+	}
+	return &clone
+}
+
+// WithGroup implements slog.Handler
+func (h *coreHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	clone := *h
+	clone.fallback = h.fallback.WithGroup(name)
+	clone.prefix = h.prefix + name + "."
+	return &clone
+}
+
+// addAttr adds an attribute to a JSON object, flattening groups into dotted keys
+func addAttr(attrs map[string]interface{}, prefix string, a slog.Attr) {
+	value := a.Value.Resolve()
+	if value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, member := range value.Group() {
+			addAttr(attrs, prefix, member)
+		}
+		return
+	}
+	if a.Key == "" {
+		return
+	}
+
+	switch v := value.Any().(type) {
+	case error:
+		attrs[prefix+a.Key] = v.Error()
+	case fmt.Stringer:
+		attrs[prefix+a.Key] = v.String()
+	default:
+		attrs[prefix+a.Key] = v
+	}
+}
+
+// levelName returns the level name core expects
+func levelName(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return "error"
+	case level >= slog.LevelWarn:
+		return "warn"
+	case level >= slog.LevelInfo:
+		return "info"
+	default:
+		return "debug"
+	}
+}
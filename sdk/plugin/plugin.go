@@ -0,0 +1,435 @@
+// Package plugin is the SDK for writing Pixie plugins in Go. A plugin is a value
+// implementing one or more of the interfaces in this package, passed to Serve:
+//
+//	type tagger struct {
+//		plugin.Events
+//	}
+//
+//	func (t *tagger) Init(ctx context.Context, core *plugin.Core) error {
+//		host, err := core.Host()
+//		if err != nil {
+//			return err
+//		}
+//		t.OnPhotoUploaded(func(ctx context.Context, photo *plugin.Photo) error {
+//			return host.PatchMetadata(ctx, photo.ID, map[string]interface{}{"tagged": true})
+//		})
+//		return nil
+//	}
+//
+//	func main() {
+//		plugin.Serve(&tagger{})
+//	}
+//
+// Serve performs the handshake with core, serves the health service, loads the
+// plugin's settings, sends its logs to core and, when PIXIE_CORE_ADDR is set,
+// registers the plugin with core over mTLS. Routes declared in the
+// manifest are served by implementing http.Handler.
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	pluginv1 "pixie/sdk/internal/gen/plugin/v1"
+	"pixie/sdk/internal/manifest"
+)
+
+// Photo is a photo delivered with an event
+type Photo struct {
+	ID    string
+	S3Key string
+	Mime  string
+
+	// Event is the subject of the event being delivered, e.g. photo.uploaded
+	Event string
+}
+
+// Initializer is implemented by plugins that set up state before serving
+type Initializer interface {
+	Init(ctx context.Context, core *Core) error
+}
+
+// Processor is implemented by plugins with the process capability. Embed Events
+// to route events to a handler per subject.
+type Processor interface {
+	ProcessPhoto(ctx context.Context, photo *Photo) error
+}
+
+// Searcher is implemented by plugins with the search capability
+type Searcher interface {
+	Search(ctx context.Context, query string) ([]string, error)
+}
+
+// Authenticator is implemented by plugins with the auth capability. An error
+// rejects the token; the returned user's claims become custom claims in core.
+type Authenticator interface {
+	ValidateToken(ctx context.Context, token string) (User, error)
+}
+
+// Configurer is implemented by plugins that react to new settings. Core.Settings
+// already returns the new settings when Configure is called.
+type Configurer interface {
+	Configure(ctx context.Context, core *Core) error
+}
+
+// Core is the plugin's connection to core
+type Core struct {
+	// Name is the plugin's name
+	Name string
+
+	// Logger writes structured records to core's log
+	Logger *slog.Logger
+
+	host     *HostClient
+	defaults map[string]interface{}
+
+	// creds secure the connection to core, which is local unless the plugin registered
+	creds credentials.TransportCredentials
+
+	mu       sync.RWMutex
+	settings json.RawMessage
+}
+
+// Host returns the client for the host services
+func (c *Core) Host() (*HostClient, error) {
+	if c.host == nil {
+		return nil, ErrNoHost
+	}
+	return c.host, nil
+}
+
+// Settings decodes the plugin's current settings into v. Settings missing from
+// core are filled in from the defaults of the manifest passed to WithManifest.
+func (c *Core) Settings(v interface{}) error {
+	c.mu.RLock()
+	data := c.settings
+	c.mu.RUnlock()
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to decode settings: %w", err)
+	}
+	return nil
+}
+
+// setSettings replaces the settings with JSON from core
+func (c *Core) setSettings(data string) error {
+	settings, err := mergeSettings(c.defaults, data)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.settings = settings
+	c.mu.Unlock()
+	return nil
+}
+
+// connect connects to the host services, if core passed them
+func (c *Core) connect(addr, token string) error {
+	if addr != "" && token != "" {
+		creds := c.creds
+		if creds == nil {
+			creds = insecure.NewCredentials()
+		}
+		host, err := dialHost(addr, token, creds)
+		if err != nil {
+			return err
+		}
+		c.host = host
+	}
+	c.Logger = newLogger(c.host)
+	return nil
+}
+
+// Option configures Serve and Run
+type Option func(*options)
+
+type options struct {
+	manifest []byte
+	out      io.Writer
+}
+
+// WithManifest passes the plugin's plugin.yaml, usually embedded with go:embed.
+// It is required to register with core and provides the settings defaults.
+func WithManifest(data []byte) Option {
+	return func(o *options) {
+		o.manifest = data
+	}
+}
+
+// Serve runs a plugin until it receives SIGINT or SIGTERM and exits if it fails
+func Serve(impl interface{}, opts ...Option) {
+	port := flag.Int("port", 0, "The server port")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := Run(ctx, impl, *port, opts...); err != nil {
+		log.Fatalf("Plugin failed: %v", err)
+	}
+}
+
+// Run runs a plugin until ctx is cancelled. Core starts plugins with a port of
+// 0; a plugin registering over the network listens on port on all interfaces.
+func Run(ctx context.Context, impl interface{}, port int, opts ...Option) error {
+	o := options{out: os.Stdout}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	core := &Core{Name: os.Getenv("PIXIE_PLUGIN_NAME")}
+	if o.manifest != nil {
+		m, err := manifest.Parse(o.manifest)
+		if err != nil {
+			return err
+		}
+		core.defaults = m.Defaults()
+		if core.Name == "" {
+			core.Name = m.Name
+		}
+	}
+
+	srv := &server{impl: impl, core: core, ready: make(chan struct{})}
+	coreAddr := os.Getenv("PIXIE_CORE_ADDR")
+
+	// Plugins started by core get everything from the environment and are ready
+	// before the handshake
+	var lis net.Listener
+	var serverOpts []grpc.ServerOption
+	var err error
+	if coreAddr == "" {
+		if err := srv.init(ctx, os.Getenv("PIXIE_HOST_ADDR"), os.Getenv("PIXIE_HOST_TOKEN"), os.Getenv("PIXIE_PLUGIN_SETTINGS")); err != nil {
+			return err
+		}
+		lis, serverOpts, err = listen(port, o.out)
+	} else {
+		lis, serverOpts, core.creds, err = listenRemote(port)
+	}
+	if err != nil {
+		return err
+	}
+
+	s, healthServer := newGRPCServer(srv, serverOpts)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.Serve(lis)
+	}()
+
+	// Plugins in their own container register first, which returns their token
+	// and settings. Calls from core wait until the plugin is initialized.
+	var reg *registration
+	if coreAddr != "" {
+		address := os.Getenv("PIXIE_PLUGIN_ADDRESS")
+		if address == "" {
+			address = fmt.Sprintf(":%d", lis.Addr().(*net.TCPAddr).Port)
+		}
+		if reg, err = newRegistration(coreAddr, os.Getenv("PIXIE_REGISTRATION_SECRET"), address, o.manifest, core.creds); err != nil {
+			s.Stop()
+			return err
+		}
+		resp, err := reg.register(ctx)
+		if err != nil {
+			s.Stop()
+			return fmt.Errorf("failed to register with core: %w", err)
+		}
+		if err := srv.init(ctx, coreAddr, resp.HostToken, resp.SettingsJson); err != nil {
+			reg.unregister(core.Name)
+			s.Stop()
+			return err
+		}
+		core.Logger.Info("Registered with core", "core", coreAddr, "address", address)
+
+		go reg.keepRegistered(ctx, time.Duration(resp.RefreshSeconds)*time.Second, func(resp *pluginv1.RegisterResponse) {
+			if core.host != nil {
+				core.host.setToken(resp.HostToken)
+			}
+		})
+	}
+
+	core.Logger.Info("Plugin started", "address", lis.Addr().String())
+
+	select {
+	case <-ctx.Done():
+	case err := <-errc:
+		return fmt.Errorf("failed to serve: %w", err)
+	}
+
+	if reg != nil {
+		reg.unregister(core.Name)
+	}
+	healthServer.Shutdown()
+	s.GracefulStop()
+	return srv.close()
+}
+
+// newGRPCServer creates the gRPC server core connects to
+func newGRPCServer(srv *server, opts []grpc.ServerOption) (*grpc.Server, *health.Server) {
+	s := grpc.NewServer(append(opts,
+		grpc.UnaryInterceptor(srv.recover),
+		grpc.MaxRecvMsgSize(maxMessageSize),
+		grpc.MaxSendMsgSize(maxMessageSize),
+	)...)
+
+	healthServer := health.NewServer()
+	grpc_health_v1.RegisterHealthServer(s, healthServer)
+	healthServer.SetServingStatus("", grpc_health_v1.HealthCheckResponse_SERVING)
+
+	pluginv1.RegisterPhotoPluginServer(s, srv)
+	return s, healthServer
+}
+
+// server adapts a plugin implementation to the PhotoPlugin service
+type server struct {
+	impl  interface{}
+	core  *Core
+	ready chan struct{}
+}
+
+// init connects to core, loads the settings and initializes the plugin
+func (s *server) init(ctx context.Context, hostAddr, hostToken, settings string) error {
+	if err := s.core.setSettings(settings); err != nil {
+		return err
+	}
+	if err := s.core.connect(hostAddr, hostToken); err != nil {
+		return err
+	}
+	if i, ok := s.impl.(Initializer); ok {
+		if err := i.Init(ctx, s.core); err != nil {
+			return fmt.Errorf("failed to initialize plugin: %w", err)
+		}
+	}
+	close(s.ready)
+	return nil
+}
+
+// close shuts down the plugin and its connection to core
+func (s *server) close() error {
+	var err error
+	if c, ok := s.impl.(io.Closer); ok {
+		err = c.Close()
+	}
+	if s.core.host != nil {
+		s.core.host.Close()
+	}
+	return err
+}
+
+// wait blocks calls from core until the plugin is initialized
+func (s *server) wait(ctx context.Context) error {
+	select {
+	case <-s.ready:
+		return nil
+	case <-ctx.Done():
+		return status.Error(codes.Unavailable, "plugin is starting")
+	}
+}
+
+// recover turns a panic in a handler into an error instead of crashing the plugin.
+// Health checks are answered while the plugin is still initializing.
+func (s *server) recover(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic in %s: %v\n%s", info.FullMethod, r, debug.Stack())
+			err = status.Errorf(codes.Internal, "plugin panicked: %v", r)
+		}
+	}()
+	if strings.HasPrefix(info.FullMethod, "/"+pluginv1.PhotoPlugin_ServiceDesc.ServiceName+"/") {
+		if err := s.wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return handler(ctx, req)
+}
+
+// ProcessPhoto implements pluginv1.PhotoPluginServer
+func (s *server) ProcessPhoto(ctx context.Context, in *pluginv1.Photo) (*emptypb.Empty, error) {
+	p, ok := s.impl.(Processor)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "plugin does not process photos")
+	}
+	photo := &Photo{ID: in.Id, S3Key: in.S3Key, Mime: in.Mime, Event: in.Event}
+	if err := p.ProcessPhoto(ctx, photo); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// Search implements pluginv1.PhotoPluginServer
+func (s *server) Search(ctx context.Context, in *pluginv1.SearchRequest) (*pluginv1.SearchResult, error) {
+	p, ok := s.impl.(Searcher)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "plugin does not search")
+	}
+	ids, err := p.Search(ctx, in.Query)
+	if err != nil {
+		return nil, err
+	}
+	return &pluginv1.SearchResult{Ids: ids}, nil
+}
+
+// ValidateToken implements pluginv1.PhotoPluginServer
+func (s *server) ValidateToken(ctx context.Context, in *pluginv1.ValidateTokenRequest) (*pluginv1.ValidateTokenResponse, error) {
+	p, ok := s.impl.(Authenticator)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "plugin does not validate tokens")
+	}
+	user, err := p.ValidateToken(ctx, in.Token)
+	if err != nil {
+		return &pluginv1.ValidateTokenResponse{Error: err.Error()}, nil
+	}
+
+	resp := &pluginv1.ValidateTokenResponse{Ok: true, UserId: user.ID}
+	if len(user.Claims) > 0 {
+		claims, err := json.Marshal(user.Claims)
+		if err != nil {
+			return nil, err
+		}
+		resp.ClaimsJson = string(claims)
+	}
+	return resp, nil
+}
+
+// Configure implements pluginv1.PhotoPluginServer
+func (s *server) Configure(ctx context.Context, in *pluginv1.ConfigureRequest) (*emptypb.Empty, error) {
+	if err := s.core.setSettings(in.SettingsJson); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if p, ok := s.impl.(Configurer); ok {
+		if err := p.Configure(ctx, s.core); err != nil {
+			return nil, err
+		}
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// HandleHTTP implements pluginv1.PhotoPluginServer
+func (s *server) HandleHTTP(ctx context.Context, in *pluginv1.HTTPRequest) (*pluginv1.HTTPResponse, error) {
+	h, ok := s.impl.(http.Handler)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "plugin does not serve HTTP routes")
+	}
+	return serveHTTP(ctx, h, in)
+}
@@ -0,0 +1,247 @@
+package plugin_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"pixie/sdk/plugin"
+	"pixie/sdk/plugintest"
+)
+
+const testManifest = `
+name: tagger
+permissions:
+  - photos.read
+  - metadata.write
+settings:
+  type: object
+  properties:
+    tag:
+      type: string
+      default: sunny
+    limit:
+      type: integer
+      default: 10
+`
+
+// tagger tags uploaded photos with the tag setting
+type tagger struct {
+	plugin.Events
+
+	core *plugin.Core
+}
+
+type taggerSettings struct {
+	Tag   string `json:"tag"`
+	Limit int    `json:"limit"`
+}
+
+func (t *tagger) Init(ctx context.Context, core *plugin.Core) error {
+	t.core = core
+	host, err := core.Host()
+	if err != nil {
+		return err
+	}
+	t.OnPhotoUploaded(func(ctx context.Context, photo *plugin.Photo) error {
+		if _, _, err := host.ReadOriginal(ctx, photo.ID); err != nil {
+			return err
+		}
+		var s taggerSettings
+		if err := core.Settings(&s); err != nil {
+			return err
+		}
+		core.Logger.Info("Tagged photo", "photo", photo.ID, "tag", s.Tag)
+		return host.PatchMetadata(ctx, photo.ID, map[string]interface{}{"tag": s.Tag})
+	})
+	return nil
+}
+
+func (t *tagger) Search(ctx context.Context, query string) ([]string, error) {
+	if query == "" {
+		return nil, errors.New("empty query")
+	}
+	return []string{"photo-" + query}, nil
+}
+
+func (t *tagger) ValidateToken(ctx context.Context, token string) (plugin.User, error) {
+	if token != "secret" {
+		return plugin.User{}, errors.New("unknown token")
+	}
+	return plugin.User{ID: "user-1", Claims: map[string]interface{}{"role": "admin"}}, nil
+}
+
+func (t *tagger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, _ := plugin.UserFromContext(r.Context())
+	if !user.IsAdmin() {
+		http.Error(w, "Admin only", http.StatusForbidden)
+		return
+	}
+	var s taggerSettings
+	t.core.Settings(&s)
+	fmt.Fprintf(w, "%s %s %s %d", r.Method, r.URL.Path, user.ID, s.Limit)
+}
+
+func newTagger(t *testing.T, opts ...plugintest.Option) *plugintest.Harness {
+	return plugintest.New(t, &tagger{}, append([]plugintest.Option{plugintest.WithManifest([]byte(testManifest))}, opts...)...)
+}
+
+func TestEvents(t *testing.T) {
+	h := newTagger(t)
+	h.Host.AddPhoto("p1", "image/png", []byte("png"))
+
+	if err := h.Emit(plugin.EventPhotoUploaded, &plugin.Photo{ID: "p1"}); err != nil {
+		t.Fatalf("Emit: %v", err)
+	}
+	if tag := h.Host.Metadata("p1")["tag"]; tag != "sunny" {
+		t.Errorf("Expected the default tag, got %v", tag)
+	}
+
+	// Events without a handler are ignored
+	if err := h.Emit(plugin.EventPhotoDeleted, &plugin.Photo{ID: "p1"}); err != nil {
+		t.Errorf("Expected unhandled event to be ignored, got %v", err)
+	}
+
+	// Errors from the host services reach the plugin
+	if err := h.Emit(plugin.EventPhotoUploaded, &plugin.Photo{ID: "missing"}); err == nil {
+		t.Error("Expected an error for a missing photo")
+	}
+
+	var tagged []plugintest.LogRecord
+	for _, record := range h.Host.Logs() {
+		if record.Message == "Tagged photo" {
+			tagged = append(tagged, record)
+		}
+	}
+	if len(tagged) != 1 || tagged[0].Level != "info" || tagged[0].Attrs["photo"] != "p1" {
+		t.Errorf("Unexpected logs: %+v", tagged)
+	}
+}
+
+func TestSettings(t *testing.T) {
+	h := newTagger(t, plugintest.WithSettings(map[string]interface{}{"tag": "cloudy"}))
+	h.Host.AddPhoto("p1", "image/png", []byte("png"))
+
+	if err := h.Emit(plugin.EventPhotoUploaded, &plugin.Photo{ID: "p1"}); err != nil {
+		t.Fatal(err)
+	}
+	if tag := h.Host.Metadata("p1")["tag"]; tag != "cloudy" {
+		t.Errorf("Expected the saved tag, got %v", tag)
+	}
+
+	if err := h.Configure(map[string]interface{}{"tag": "rainy"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Emit(plugin.EventPhotoUploaded, &plugin.Photo{ID: "p1"}); err != nil {
+		t.Fatal(err)
+	}
+	if tag := h.Host.Metadata("p1")["tag"]; tag != "rainy" {
+		t.Errorf("Expected the configured tag, got %v", tag)
+	}
+}
+
+func TestSearchAndAuth(t *testing.T) {
+	h := newTagger(t)
+
+	ids, err := h.Search("cat")
+	if err != nil || len(ids) != 1 || ids[0] != "photo-cat" {
+		t.Errorf("Unexpected search result %v, %v", ids, err)
+	}
+	if _, err := h.Search(""); err == nil {
+		t.Error("Expected search error")
+	}
+
+	user, err := h.ValidateToken("secret")
+	if err != nil || user.ID != "user-1" || !user.IsAdmin() {
+		t.Errorf("Unexpected user %+v, %v", user, err)
+	}
+	if _, err := h.ValidateToken("wrong"); err == nil || err.Error() != "unknown token" {
+		t.Errorf("Expected the plugin's error, got %v", err)
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	h := newTagger(t)
+
+	admin := plugin.User{ID: "user-1", Claims: map[string]interface{}{"role": "admin"}}
+	resp, err := h.Do(httptest.NewRequest("POST", "/stats?x=1", strings.NewReader("{}")), admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "POST /stats user-1 10" {
+		t.Errorf("Unexpected response %d %q", resp.StatusCode, body)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Expected a detected content type, got %q", ct)
+	}
+
+	resp, err = h.Do(httptest.NewRequest("GET", "/stats", nil), plugin.User{ID: "user-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403, got %d", resp.StatusCode)
+	}
+}
+
+// noop implements no capabilities
+type noop struct{}
+
+func TestUnimplemented(t *testing.T) {
+	h := plugintest.New(t, noop{})
+
+	if err := h.ProcessPhoto(&plugin.Photo{ID: "p1"}); err == nil {
+		t.Error("Expected ProcessPhoto to be unimplemented")
+	}
+	if _, err := h.Do(httptest.NewRequest("GET", "/", nil), plugin.User{}); err == nil {
+		t.Error("Expected HandleHTTP to be unimplemented")
+	}
+}
+
+// writer writes derivatives it has no permission for
+type writer struct {
+	plugin.Events
+}
+
+func (w *writer) Init(ctx context.Context, core *plugin.Core) error {
+	host, err := core.Host()
+	if err != nil {
+		return err
+	}
+	w.OnPhotoUploaded(func(ctx context.Context, photo *plugin.Photo) error {
+		_, err := host.WriteDerivative(ctx, photo.ID, "thumb.jpg", "image/jpeg", []byte("jpg"))
+		return err
+	})
+	return nil
+}
+
+func TestPermissions(t *testing.T) {
+	h := plugintest.New(t, &writer{}, plugintest.WithManifest([]byte("name: writer\npermissions: [photos.read]\n")))
+	h.Host.AddPhoto("p1", "image/png", []byte("png"))
+
+	err := h.Emit(plugin.EventPhotoUploaded, &plugin.Photo{ID: "p1"})
+	if err == nil || !strings.Contains(err.Error(), "derivatives.write") {
+		t.Errorf("Expected a permission error, got %v", err)
+	}
+	if _, ok := h.Host.Derivative("p1", "thumb.jpg"); ok {
+		t.Error("Expected no derivative")
+	}
+}
+
+func TestRegisterRequiresCertificate(t *testing.T) {
+	t.Setenv("PIXIE_CORE_ADDR", "127.0.0.1:1")
+	t.Setenv("PIXIE_REGISTRATION_SECRET", "s3cret")
+	t.Setenv("PIXIE_PLUGIN_TLS_CERT", "")
+
+	// Plugins never register with core or serve it without mTLS
+	err := plugin.Run(context.Background(), &tagger{}, 0, plugin.WithManifest([]byte(testManifest)))
+	if err == nil || !strings.Contains(err.Error(), "PIXIE_PLUGIN_TLS_CERT") {
+		t.Errorf("expected registration without a certificate to fail, got %v", err)
+	}
+}
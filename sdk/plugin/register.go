@@ -0,0 +1,120 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+
+	pluginv1 "pixie/sdk/internal/gen/plugin/v1"
+)
+
+// registration keeps a plugin registered with core when it runs in its own container
+type registration struct {
+	conn     *grpc.ClientConn
+	client   pluginv1.PluginRegistryClient
+	secret   string
+	address  string
+	manifest []byte
+}
+
+// newRegistration connects to the plugin registry of core at coreAddr with creds.
+// address is where core connects back to the plugin; a missing host means the
+// plugin's IP.
+func newRegistration(coreAddr, secret, address string, manifest []byte, creds credentials.TransportCredentials) (*registration, error) {
+	if secret == "" {
+		return nil, errors.New("PIXIE_REGISTRATION_SECRET must be set to register with core")
+	}
+	if len(manifest) == 0 {
+		return nil, errors.New("remote plugins must be served with WithManifest")
+	}
+
+	conn, err := grpc.Dial(coreAddr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to core: %w", err)
+	}
+
+	return &registration{
+		conn:     conn,
+		client:   pluginv1.NewPluginRegistryClient(conn),
+		secret:   secret,
+		address:  address,
+		manifest: manifest,
+	}, nil
+}
+
+// authorize adds the registration secret to the metadata of a registry call
+func (r *registration) authorize(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+r.secret)
+}
+
+// register registers the plugin, retrying until core accepts it or ctx is cancelled
+func (r *registration) register(ctx context.Context) (*pluginv1.RegisterResponse, error) {
+	backoff := 1 * time.Second
+	for {
+		resp, err := r.registerOnce(ctx)
+		if err == nil {
+			return resp, nil
+		}
+
+		log.Printf("Failed to register with core, retrying in %s: %v", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// registerOnce makes a single registration attempt
+func (r *registration) registerOnce(ctx context.Context) (*pluginv1.RegisterResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req := &pluginv1.RegisterRequest{ManifestYaml: string(r.manifest), Address: r.address}
+	return r.client.Register(r.authorize(ctx), req)
+}
+
+// keepRegistered registers again as often as core asks, so the plugin is added back
+// after core restarts. onRefresh is called with every response.
+func (r *registration) keepRegistered(ctx context.Context, refresh time.Duration, onRefresh func(*pluginv1.RegisterResponse)) {
+	if refresh <= 0 {
+		refresh = 30 * time.Second
+	}
+
+	ticker := time.NewTicker(refresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			resp, err := r.registerOnce(ctx)
+			if err != nil {
+				log.Printf("Failed to refresh registration with core: %v", err)
+				continue
+			}
+			onRefresh(resp)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// unregister tells core the plugin is shutting down and closes the connection
+func (r *registration) unregister(name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := r.client.Unregister(r.authorize(ctx), &pluginv1.UnregisterRequest{Name: name}); err != nil {
+		log.Printf("Failed to unregister from core: %v", err)
+	}
+	r.conn.Close()
+}
@@ -0,0 +1,24 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// mergeSettings overlays settings JSON from core on the manifest defaults
+func mergeSettings(defaults map[string]interface{}, data string) (json.RawMessage, error) {
+	merged := make(map[string]interface{}, len(defaults))
+	for k, v := range defaults {
+		merged[k] = v
+	}
+	if data != "" {
+		var settings map[string]interface{}
+		if err := json.Unmarshal([]byte(data), &settings); err != nil {
+			return nil, fmt.Errorf("invalid settings: %w", err)
+		}
+		for k, v := range settings {
+			merged[k] = v
+		}
+	}
+	return json.Marshal(merged)
+}
@@ -0,0 +1,316 @@
+// Package plugintest runs a plugin against a fake core, so plugins written with
+// the SDK can be tested without core, a database or a bucket:
+//
+//	func TestTagger(t *testing.T) {
+//		h := plugintest.New(t, &tagger{}, plugintest.WithManifest(manifestYAML))
+//		h.Host.AddPhoto(id, "image/png", data)
+//		if err := h.Emit(plugin.EventPhotoUploaded, &plugin.Photo{ID: id}); err != nil {
+//			t.Fatal(err)
+//		}
+//		// inspect h.Host.Metadata(id), h.Host.Derivative(id, name), ...
+//	}
+//
+// The plugin is served by plugin.Run over a Unix socket exactly as under core.
+// The harness sets the plugin's environment with t.Setenv, so tests using it
+// cannot run in parallel.
+package plugintest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	pluginv1 "pixie/sdk/internal/gen/plugin/v1"
+	"pixie/sdk/internal/manifest"
+	"pixie/sdk/plugin"
+)
+
+// callTimeout bounds a single call to the plugin
+const callTimeout = 30 * time.Second
+
+// Option configures a Harness
+type Option func(*config)
+
+type config struct {
+	manifest []byte
+	settings map[string]interface{}
+}
+
+// WithManifest passes the plugin's plugin.yaml. The fake host then only allows
+// the permissions it requests, and its setting defaults apply.
+func WithManifest(data []byte) Option {
+	return func(c *config) {
+		c.manifest = data
+	}
+}
+
+// WithSettings sets the settings the plugin starts with, as if saved in core
+func WithSettings(settings map[string]interface{}) Option {
+	return func(c *config) {
+		c.settings = settings
+	}
+}
+
+// Harness is a running plugin connected to a fake core
+type Harness struct {
+	// Host fakes the host services the plugin calls
+	Host *Host
+
+	conn   *grpc.ClientConn
+	client pluginv1.PhotoPluginClient
+}
+
+// New starts impl with plugin.Run and stops it when the test ends. It fails the
+// test if the plugin does not start.
+func New(t testing.TB, impl interface{}, opts ...Option) *Harness {
+	t.Helper()
+
+	var c config
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	host := &Host{
+		plugin:      "test",
+		token:       randomToken(t),
+		photos:      make(map[string]*photo),
+		derivatives: make(map[string][]byte),
+	}
+	var runOpts []plugin.Option
+	if c.manifest != nil {
+		m, err := manifest.Parse(c.manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		host.plugin = m.Name
+		host.manifest = m
+		runOpts = append(runOpts, plugin.WithManifest(c.manifest))
+	}
+
+	// Serve the fake host services
+	hostLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostServer := grpc.NewServer()
+	pluginv1.RegisterHostServicesServer(hostServer, host)
+	go hostServer.Serve(hostLis)
+	t.Cleanup(hostServer.Stop)
+
+	// Pass what core passes to a plugin it starts. Socket paths are limited to
+	// about 100 bytes, so the directory is not the test's (long) TempDir.
+	dir, err := os.MkdirTemp("", "plugintest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "plugin.sock")
+
+	settings, err := json.Marshal(c.settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.settings == nil {
+		settings = nil
+	}
+	for key, value := range map[string]string{
+		"PIXIE_PLUGIN_NAME":     host.plugin,
+		"PIXIE_PLUGIN_SOCKET":   socket,
+		"PIXIE_PLUGIN_SETTINGS": string(settings),
+		"PIXIE_HOST_ADDR":       hostLis.Addr().String(),
+		"PIXIE_HOST_TOKEN":      host.token,
+		"PIXIE_CORE_ADDR":       "",
+		"PIXIE_PLUGIN_TLS_CERT": "",
+	} {
+		t.Setenv(key, value)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- plugin.Run(ctx, impl, 0, runOpts...)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("plugin failed to stop: %v", err)
+		}
+	})
+
+	conn, err := waitForPlugin(socket, done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return &Harness{Host: host, conn: conn, client: pluginv1.NewPhotoPluginClient(conn)}
+}
+
+// waitForPlugin connects to the plugin once it passes its health check
+func waitForPlugin(socket string, done <-chan error) (*grpc.ClientConn, error) {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		select {
+		case err := <-done:
+			if err == nil {
+				err = errors.New("plugin stopped before serving")
+			}
+			return nil, err
+		default:
+		}
+
+		if _, err := os.Stat(socket); err == nil {
+			conn, err := grpc.Dial("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				return nil, err
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			resp, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+			cancel()
+			if err == nil && resp.Status == grpc_health_v1.HealthCheckResponse_SERVING {
+				return conn, nil
+			}
+			conn.Close()
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil, errors.New("plugin did not start within 10s")
+}
+
+// randomToken returns a token for the fake host services
+func randomToken(t testing.TB) string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// callContext returns the context for a single call to the plugin
+func callContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), callTimeout)
+}
+
+// ProcessPhoto calls ProcessPhoto on the plugin
+func (h *Harness) ProcessPhoto(photo *plugin.Photo) error {
+	ctx, cancel := callContext()
+	defer cancel()
+	_, err := h.client.ProcessPhoto(ctx, &pluginv1.Photo{Id: photo.ID, S3Key: photo.S3Key, Mime: photo.Mime, Event: photo.Event})
+	return err
+}
+
+// Emit delivers a photo event to the plugin like core's dispatcher
+func (h *Harness) Emit(subject string, photo *plugin.Photo) error {
+	event := *photo
+	event.Event = subject
+	return h.ProcessPhoto(&event)
+}
+
+// Search asks the plugin for photos matching query
+func (h *Harness) Search(query string) ([]string, error) {
+	ctx, cancel := callContext()
+	defer cancel()
+	out, err := h.client.Search(ctx, &pluginv1.SearchRequest{Query: query})
+	if err != nil {
+		return nil, err
+	}
+	return out.Ids, nil
+}
+
+// ValidateToken asks the plugin to validate a bearer token
+func (h *Harness) ValidateToken(token string) (plugin.User, error) {
+	ctx, cancel := callContext()
+	defer cancel()
+	out, err := h.client.ValidateToken(ctx, &pluginv1.ValidateTokenRequest{Token: token})
+	if err != nil {
+		return plugin.User{}, err
+	}
+	if !out.Ok {
+		return plugin.User{}, errors.New(out.Error)
+	}
+
+	user := plugin.User{ID: out.UserId, Claims: map[string]interface{}{}}
+	if out.ClaimsJson != "" {
+		if err := json.Unmarshal([]byte(out.ClaimsJson), &user.Claims); err != nil {
+			return plugin.User{}, err
+		}
+	}
+	return user, nil
+}
+
+// Configure pushes new settings to the plugin
+func (h *Harness) Configure(settings map[string]interface{}) error {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := callContext()
+	defer cancel()
+	_, err = h.client.Configure(ctx, &pluginv1.ConfigureRequest{SettingsJson: string(data)})
+	return err
+}
+
+// Do sends a request to one of the plugin's routes as user, like core's proxy.
+// The request's path is relative to /api/plugins/<name>.
+func (h *Harness) Do(req *http.Request, user plugin.User) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	headers := make(map[string]string, len(req.Header))
+	for key, values := range req.Header {
+		headers[key] = strings.Join(values, ", ")
+	}
+	claims, err := json.Marshal(user.Claims)
+	if err != nil {
+		return nil, err
+	}
+
+	in := &pluginv1.HTTPRequest{
+		Method:     req.Method,
+		Path:       req.URL.Path,
+		Query:      req.URL.RawQuery,
+		Headers:    headers,
+		Body:       body,
+		UserId:     user.ID,
+		ClaimsJson: string(claims),
+	}
+	ctx, cancel := callContext()
+	defer cancel()
+	out, err := h.client.HandleHTTP(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &http.Response{
+		StatusCode:    int(out.Status),
+		Status:        http.StatusText(int(out.Status)),
+		Header:        make(http.Header),
+		Body:          io.NopCloser(bytes.NewReader(out.Body)),
+		ContentLength: int64(len(out.Body)),
+		Request:       req,
+	}
+	for key, value := range out.Headers {
+		resp.Header.Set(key, value)
+	}
+	return resp, nil
+}
@@ -0,0 +1,225 @@
+package plugintest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	pluginv1 "pixie/sdk/internal/gen/plugin/v1"
+	"pixie/sdk/internal/manifest"
+)
+
+// Event is an event a plugin emitted through the host services
+type Event struct {
+	Subject string
+	Data    json.RawMessage
+}
+
+// LogRecord is a record a plugin sent to core's log
+type LogRecord struct {
+	Level   string
+	Message string
+	Attrs   map[string]interface{}
+}
+
+// photo is a photo held by the fake host
+type photo struct {
+	data     []byte
+	mime     string
+	metadata map[string]interface{}
+}
+
+// Host fakes the host services of core in memory. Like core it scopes
+// derivatives, metadata and events to the plugin and, when the harness has a
+// manifest, only allows the permissions it requests.
+type Host struct {
+	plugin   string
+	token    string
+	manifest *manifest.Manifest
+
+	mu          sync.Mutex
+	photos      map[string]*photo
+	derivatives map[string][]byte
+	events      []Event
+	logs        []LogRecord
+}
+
+// AddPhoto adds a photo the plugin can read
+func (h *Host) AddPhoto(id, mime string, data []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.photos[id] = &photo{data: data, mime: mime, metadata: map[string]interface{}{}}
+}
+
+// Derivative returns a file the plugin stored for a photo
+func (h *Host) Derivative(photoID, name string) ([]byte, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	data, ok := h.derivatives[derivativeKey(h.plugin, photoID, name)]
+	return data, ok
+}
+
+// Metadata returns the plugin's metadata namespace of a photo
+func (h *Host) Metadata(photoID string) map[string]interface{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	p, ok := h.photos[photoID]
+	if !ok {
+		return nil
+	}
+
+	// Copy so the caller can inspect it while the plugin keeps running
+	data, _ := json.Marshal(p.metadata)
+	var metadata map[string]interface{}
+	json.Unmarshal(data, &metadata)
+	return metadata
+}
+
+// Events returns the events the plugin emitted
+func (h *Host) Events() []Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]Event(nil), h.events...)
+}
+
+// Logs returns the records the plugin sent to core's log
+func (h *Host) Logs() []LogRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]LogRecord(nil), h.logs...)
+}
+
+// authorize checks the plugin's token and, with a manifest, its permissions
+func (h *Host) authorize(ctx context.Context, permission string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("authorization"); len(values) == 0 || values[0] != "Bearer "+h.token {
+		return status.Error(codes.Unauthenticated, "invalid plugin token")
+	}
+	if permission != "" && h.manifest != nil && !h.manifest.Allows(permission) {
+		return status.Errorf(codes.PermissionDenied, "plugin %s does not have the %s permission", h.plugin, permission)
+	}
+	return nil
+}
+
+// ReadOriginal implements pluginv1.HostServicesServer
+func (h *Host) ReadOriginal(ctx context.Context, in *pluginv1.ReadOriginalRequest) (*pluginv1.ReadOriginalResponse, error) {
+	if err := h.authorize(ctx, "photos.read"); err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	p, ok := h.photos[in.PhotoId]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "photo not found")
+	}
+	return &pluginv1.ReadOriginalResponse{Data: p.data, Mime: p.mime}, nil
+}
+
+// WriteDerivative implements pluginv1.HostServicesServer
+func (h *Host) WriteDerivative(ctx context.Context, in *pluginv1.WriteDerivativeRequest) (*pluginv1.WriteDerivativeResponse, error) {
+	if err := h.authorize(ctx, "derivatives.write"); err != nil {
+		return nil, err
+	}
+	if in.Name == "" || in.Name == "." || in.Name == ".." || strings.ContainsAny(in.Name, `/\`) {
+		return nil, status.Error(codes.InvalidArgument, "invalid derivative name")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.photos[in.PhotoId]; !ok {
+		return nil, status.Error(codes.NotFound, "photo not found")
+	}
+	key := derivativeKey(h.plugin, in.PhotoId, in.Name)
+	h.derivatives[key] = in.Data
+	return &pluginv1.WriteDerivativeResponse{Key: key}, nil
+}
+
+// PatchMetadata implements pluginv1.HostServicesServer
+func (h *Host) PatchMetadata(ctx context.Context, in *pluginv1.PatchMetadataRequest) (*emptypb.Empty, error) {
+	if err := h.authorize(ctx, "metadata.write"); err != nil {
+		return nil, err
+	}
+	var patch map[string]interface{}
+	if err := json.Unmarshal([]byte(in.PatchJson), &patch); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "patch must be a JSON object")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	p, ok := h.photos[in.PhotoId]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "photo not found")
+	}
+	mergePatch(p.metadata, patch)
+	return &emptypb.Empty{}, nil
+}
+
+// EmitEvent implements pluginv1.HostServicesServer
+func (h *Host) EmitEvent(ctx context.Context, in *pluginv1.EmitEventRequest) (*emptypb.Empty, error) {
+	if err := h.authorize(ctx, "events.emit"); err != nil {
+		return nil, err
+	}
+	data := json.RawMessage(in.DataJson)
+	if len(data) == 0 {
+		data = json.RawMessage("{}")
+	}
+	if !json.Valid(data) {
+		return nil, status.Error(codes.InvalidArgument, "event data must be JSON")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.events = append(h.events, Event{Subject: "plugin." + h.plugin + "." + in.Name, Data: data})
+	return &emptypb.Empty{}, nil
+}
+
+// Log implements pluginv1.HostServicesServer
+func (h *Host) Log(ctx context.Context, in *pluginv1.LogRequest) (*emptypb.Empty, error) {
+	if err := h.authorize(ctx, ""); err != nil {
+		return nil, err
+	}
+	record := LogRecord{Level: in.Level, Message: in.Message}
+	if in.AttrsJson != "" {
+		if err := json.Unmarshal([]byte(in.AttrsJson), &record.Attrs); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "log attributes must be JSON")
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.logs = append(h.logs, record)
+	return &emptypb.Empty{}, nil
+}
+
+// derivativeKey returns the storage key core uses for a derivative
+func derivativeKey(plugin, photoID, name string) string {
+	return fmt.Sprintf("derivatives/%s/%s/%s", plugin, photoID, name)
+}
+
+// mergePatch applies a JSON merge patch (RFC 7386) to target
+func mergePatch(target, patch map[string]interface{}) {
+	for key, value := range patch {
+		if value == nil {
+			delete(target, key)
+			continue
+		}
+		if nested, ok := value.(map[string]interface{}); ok {
+			existing, ok := target[key].(map[string]interface{})
+			if !ok {
+				existing = make(map[string]interface{})
+				target[key] = existing
+			}
+			mergePatch(existing, nested)
+			continue
+		}
+		target[key] = value
+	}
+}